@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAPIKeyCooldown is how long an exhausted API key is skipped when api_key_cooldown is not set
+const DefaultAPIKeyCooldown = 30 * time.Minute
+
+// quotaFailurePatterns are error substrings produced when a key has run out of quota
+var quotaFailurePatterns = []string{
+	"余额不足",
+	"额度不足",
+	"insufficient",
+	"quota",
+}
+
+// IsKeyFailure reports whether an error means the API key can't be used, either because it is
+// invalid or because its quota is exhausted; such errors rotate to the next configured key
+func IsKeyFailure(message string) bool {
+	if IsAuthFailure(message) {
+		return true
+	}
+	lower := strings.ToLower(message)
+	for _, pattern := range quotaFailurePatterns {
+		if strings.Contains(lower, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeysExhaustedError is returned when every configured API key failed or is cooling down
+type KeysExhaustedError struct {
+	Failures []string // One entry per key tried, e.g. "#2: API error (status 401): ..."
+	RetryAt  time.Time
+}
+
+func (e *KeysExhaustedError) Error() string {
+	if len(e.Failures) == 0 {
+		return fmt.Sprintf("所有API密钥均不可用，最早 %s 恢复", e.RetryAt.Format("15:04:05"))
+	}
+	return fmt.Sprintf("所有API密钥均不可用: %s", strings.Join(e.Failures, "; "))
+}
+
+// apiKeys returns the configured keys, dyu_api_key first, without blanks or duplicates
+func (c *Config) apiKeys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range append([]string{c.DyuAPIKey}, c.DyuAPIKeys...) {
+		key = strings.TrimSpace(key)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// apiKeyCooldown returns how long an exhausted key is skipped
+func apiKeyCooldown(config *Config) time.Duration {
+	if config.APIKeyCooldown > 0 {
+		return time.Duration(config.APIKeyCooldown) * time.Second
+	}
+	return DefaultAPIKeyCooldown
+}
+
+// apiKeyPool holds the API keys of a client and rotates away from exhausted ones
+type apiKeyPool struct {
+	mu        sync.Mutex
+	keys      []string
+	current   int
+	exhausted map[string]time.Time // key -> end of its cooldown
+	cooldown  time.Duration
+}
+
+// newAPIKeyPool creates a pool with the given keys
+func newAPIKeyPool(keys []string, cooldown time.Duration) *apiKeyPool {
+	pool := &apiKeyPool{}
+	pool.set(keys, cooldown)
+	return pool
+}
+
+// set replaces the keys, keeping the cooldowns of keys that are still configured
+func (p *apiKeyPool) set(keys []string, cooldown time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	exhausted := make(map[string]time.Time)
+	for _, key := range keys {
+		if until, ok := p.exhausted[key]; ok {
+			exhausted[key] = until
+		}
+	}
+	p.keys = keys
+	p.exhausted = exhausted
+	p.cooldown = cooldown
+	if p.current >= len(keys) {
+		p.current = 0
+	}
+}
+
+// size returns the number of configured keys
+func (p *apiKeyPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// active returns the key currently in use, or "" when none is configured
+func (p *apiKeyPool) active() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) == 0 {
+		return ""
+	}
+	return p.keys[p.current]
+}
+
+// byFingerprint returns the configured key with the given fingerprint, falling back to the active key
+// Tasks are polled with the key they were submitted with, even while it is cooling down
+func (p *apiKeyPool) byFingerprint(fingerprint string) string {
+	if fingerprint != "" {
+		p.mu.Lock()
+		for _, key := range p.keys {
+			if apiKeyFingerprint(key) == fingerprint {
+				p.mu.Unlock()
+				return key
+			}
+		}
+		p.mu.Unlock()
+	}
+	return p.active()
+}
+
+// next returns the first key, starting from the active one, that is not cooling down and makes it active
+// When all keys are cooling down, ok is false and retryAt is the end of the earliest cooldown
+func (p *apiKeyPool) next() (index int, key string, retryAt time.Time, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(p.keys); i++ {
+		index = (p.current + i) % len(p.keys)
+		until, exhausted := p.exhausted[p.keys[index]]
+		if !exhausted || now.After(until) {
+			delete(p.exhausted, p.keys[index])
+			p.current = index
+			return index, p.keys[index], time.Time{}, true
+		}
+		if retryAt.IsZero() || until.Before(retryAt) {
+			retryAt = until
+		}
+	}
+	return 0, "", retryAt, false
+}
+
+// markExhausted puts a key on cooldown
+func (p *apiKeyPool) markExhausted(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exhausted[key] = time.Now().Add(p.cooldown)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAPIKeyPoolRotation checks rotation past exhausted keys and the end of their cooldown
+func TestAPIKeyPoolRotation(t *testing.T) {
+	config := &Config{DyuAPIKey: "key-a", DyuAPIKeys: []string{"key-b", " key-a ", "", "key-c"}}
+	keys := config.apiKeys()
+	if len(keys) != 3 || keys[0] != "key-a" || keys[2] != "key-c" {
+		t.Fatalf("apiKeys() = %v", keys)
+	}
+
+	pool := newAPIKeyPool(keys, time.Hour)
+	if index, key, _, ok := pool.next(); !ok || index != 0 || key != "key-a" {
+		t.Fatalf("first key: %d %q %v", index, key, ok)
+	}
+
+	pool.markExhausted("key-a")
+	if index, key, _, ok := pool.next(); !ok || index != 1 || key != "key-b" {
+		t.Fatalf("expected rotation to key-b, got %d %q %v", index, key, ok)
+	}
+	if pool.active() != "key-b" {
+		t.Errorf("active key = %q, want key-b", pool.active())
+	}
+
+	pool.markExhausted("key-b")
+	pool.markExhausted("key-c")
+	if _, _, retryAt, ok := pool.next(); ok || retryAt.IsZero() {
+		t.Fatalf("expected all keys exhausted, got ok=%v retryAt=%v", ok, retryAt)
+	}
+
+	// Tasks keep being polled with the key they were submitted with
+	if key := pool.byFingerprint(apiKeyFingerprint("key-a")); key != "key-a" {
+		t.Errorf("byFingerprint = %q, want key-a", key)
+	}
+
+	// Cooldowns survive a config reload but end after the cooldown period
+	pool.set(keys, time.Hour)
+	pool.mu.Lock()
+	pool.exhausted["key-c"] = time.Now().Add(-time.Second)
+	pool.mu.Unlock()
+	if _, key, _, ok := pool.next(); !ok || key != "key-c" {
+		t.Errorf("expected key-c after its cooldown, got %q %v", key, ok)
+	}
+}
+
+// TestIsKeyFailure verifies which errors rotate to the next key
+func TestIsKeyFailure(t *testing.T) {
+	cases := map[string]bool{
+		`API error (status 401): {"error":{"message":"无效的令牌"}}`:              true,
+		`API error (status 403): {"error":{"message":"用户余额不足"}}`:             true,
+		`API error (status 429): {"error":{"message":"insufficient_quota"}}`: true,
+		`API error (status 400): {"error":{"message":"prompt rejected"}}`:    false,
+		"failed to send request: connection refused":                         false,
+	}
+	for message, want := range cases {
+		if got := IsKeyFailure(message); got != want {
+			t.Errorf("IsKeyFailure(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
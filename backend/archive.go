@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// archiveSlugLength is the maximum number of prompt characters in the name of an archived video
+const archiveSlugLength = 40
+
+// archiveManifestName is the name of the manifest written at the end of an archive
+const archiveManifestName = "manifest.json"
+
+// ArchiveEntry is a task of an archive request in the manifest
+type ArchiveEntry struct {
+	ID     int64  `json:"id"`
+	File   string `json:"file,omitempty"`   // Name in the archive, for included videos
+	Reason string `json:"reason,omitempty"` // Why the video is missing
+}
+
+// ArchiveManifest lists the videos included in an archive and the tasks whose video is missing
+type ArchiveManifest struct {
+	Included []ArchiveEntry `json:"included"`
+	Missing  []ArchiveEntry `json:"missing"`
+}
+
+// promptSlug turns a prompt into a short file name part: letters and digits (any script) are kept,
+// everything else collapses into single dashes
+func promptSlug(prompt string) string {
+	var b strings.Builder
+	length := 0
+	dash := false
+	for _, r := range strings.ToLower(prompt) {
+		if length >= archiveSlugLength {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+				length++
+			}
+			b.WriteRune(r)
+			length++
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// archiveEntryName returns the name of the video of a task in an archive: its ID and a prompt slug
+func archiveEntryName(task *Task) string {
+	if slug := promptSlug(task.Prompt); slug != "" {
+		return fmt.Sprintf("%d_%s.mp4", task.ID, slug)
+	}
+	return fmt.Sprintf("%d.mp4", task.ID)
+}
+
+// videoDownloadName returns the file name a task's video is downloaded as: its creation date and
+// a prompt slug, and the same name reduced to ASCII for clients that ignore filename*
+func videoDownloadName(task *Task) (string, string) {
+	date := task.CreatedAt.Format(taskDateLayout)
+	name := func(slug string) string {
+		if slug == "" {
+			return fmt.Sprintf("%s_%d.mp4", date, task.ID)
+		}
+		return fmt.Sprintf("%s_%s.mp4", date, slug)
+	}
+	ascii := promptSlug(strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return ' '
+		}
+		return r
+	}, task.Prompt))
+	return name(promptSlug(task.Prompt)), name(ascii)
+}
+
+// attachmentDisposition returns a Content-Disposition header downloading as name, with an ASCII
+// fallback for browsers that don't support the RFC 5987 encoding
+func attachmentDisposition(name, fallback string) string {
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, url.PathEscape(name))
+}
+
+// handleVideoArchive handles POST /api/videos/archive
+// Streams a zip of the videos of the given tasks, copying file by file so neither the archive nor
+// a video is held in memory; tasks without a video are listed in manifest.json instead of failing
+// the download
+func handleVideoArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ids, ok := decodeBulkTaskIDs(w, r)
+	if !ok {
+		return
+	}
+
+	tasks, err := GetTasksByIds(ids)
+	if err != nil {
+		log.Printf("Failed to get tasks for archive: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create archive")
+		return
+	}
+	byID := make(map[int64]*Task, len(tasks))
+	for i := range tasks {
+		byID[tasks[i].ID] = &tasks[i]
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="videos-%s.zip"`, time.Now().Format(taskDateLayout)))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	manifest := ArchiveManifest{Included: []ArchiveEntry{}, Missing: []ArchiveEntry{}}
+	for _, id := range ids {
+		task := byID[id]
+		if task == nil {
+			manifest.Missing = append(manifest.Missing, ArchiveEntry{ID: id, Reason: "task not found"})
+			continue
+		}
+		if task.LocalPath == "" {
+			manifest.Missing = append(manifest.Missing, ArchiveEntry{ID: id, Reason: "no local video, status " + task.Status})
+			continue
+		}
+
+		name := archiveEntryName(task)
+		added, err := addArchiveFile(archive, name, ResolveVideoPath(task.LocalPath))
+		if err != nil {
+			// The response is broken once an entry is partially written, nothing more can be sent
+			log.Printf("Failed to archive video of task %d: %v", id, err)
+			return
+		}
+		if !added {
+			manifest.Missing = append(manifest.Missing, ArchiveEntry{ID: id, Reason: "video file not found"})
+			continue
+		}
+		manifest.Included = append(manifest.Included, ArchiveEntry{ID: id, File: name})
+	}
+
+	entry, err := archive.Create(archiveManifestName)
+	if err != nil {
+		log.Printf("Failed to write archive manifest: %v", err)
+		return
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		log.Printf("Failed to write archive manifest: %v", err)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to finish archive: %v", err)
+	}
+}
+
+// addArchiveFile copies the file at path into the archive under name, stored without compression
+// since videos don't compress; returns false without writing anything when the file can't be opened
+func addArchiveFile(archive *zip.Writer, name, path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return false, nil
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return false, nil
+	}
+	header.Name = name
+	header.Method = zip.Store
+
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(entry, file); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptSlug(t *testing.T) {
+	cases := map[string]string{
+		"A cat, on the moon!":     "a-cat-on-the-moon",
+		"  @{ch_1} 跳舞的猫 ":         "ch-1-跳舞的猫",
+		"!!!":                     "",
+		strings.Repeat("ab ", 30): strings.Repeat("ab-", 13) + "a",
+	}
+	for prompt, want := range cases {
+		if got := promptSlug(prompt); got != want {
+			t.Errorf("promptSlug(%q) = %q, want %q", prompt, got, want)
+		}
+	}
+}
+
+// TestVideoDownloadName downloads a video by file name and by task ID and checks the attachment name
+func TestVideoDownloadName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "download.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "跳舞的猫 dancing cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	DB.Exec("UPDATE tasks SET status = ?, local_path = ?, created_at = ? WHERE id = ?", StatusCompleted, "sora-2_video_1.mp4", time.Date(2026, 10, 16, 9, 0, 0, 0, time.Local), created.ID)
+	os.WriteFile(filepath.Join(OutputDirectory(), "sora-2_video_1.mp4"), []byte("mp4"), 0644)
+
+	want := `attachment; filename="2026-10-16_dancing-cat.mp4"; filename*=UTF-8''2026-10-16_` +
+		url.PathEscape("跳舞的猫-dancing-cat") + ".mp4"
+	for _, path := range []string{"/api/videos/sora-2_video_1.mp4?download=1", fmt.Sprintf("/api/videos/%d?download=1", created.ID), fmt.Sprintf("/api/tasks/%d/video?download=1", created.ID)} {
+		rec := httptest.NewRecorder()
+		handler := handleVideos
+		if strings.HasPrefix(path, "/api/tasks/") {
+			handler = handleTaskByID
+		}
+		handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "mp4" {
+			t.Fatalf("%s: status %d, body %q", path, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("%s: Content-Disposition = %q, want %q", path, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleVideos(rec, httptest.NewRequest(http.MethodGet, "/api/videos/sora-2_video_1.mp4", nil))
+	if got := rec.Header().Get("Content-Disposition"); got != "" {
+		t.Errorf("Content-Disposition = %q without download=1", got)
+	}
+}
+
+// TestVideoArchive checks the archived videos and the manifest listing the tasks without a video
+func TestVideoArchive(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "archive.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	var ids []int64
+	for i, localPath := range []string{"one.mp4", "", "gone.mp4"} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: fmt.Sprintf("clip %d", i), Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET local_path = ? WHERE id = ?", localPath, created.ID)
+		ids = append(ids, created.ID)
+	}
+	video := bytes.Repeat([]byte("mp4"), 1000)
+	os.WriteFile(filepath.Join(OutputDirectory(), "one.mp4"), video, 0644)
+
+	body := fmt.Sprintf(`{"ids":[%d,%d,%d,9999]}`, ids[0], ids[1], ids[2])
+	rec := httptest.NewRecorder()
+	handleVideoArchive(rec, httptest.NewRequest(http.MethodPost, "/api/videos/archive", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("archive failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		rc, _ := file.Open()
+		files[file.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	name := fmt.Sprintf("%d_clip-0.mp4", ids[0])
+	if !bytes.Equal(files[name], video) {
+		t.Errorf("%s missing or corrupted, archive has %d files", name, len(files))
+	}
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(files[archiveManifestName], &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if len(manifest.Included) != 1 || manifest.Included[0].File != name {
+		t.Errorf("included = %+v", manifest.Included)
+	}
+	var missing []int64
+	for _, entry := range manifest.Missing {
+		missing = append(missing, entry.ID)
+	}
+	if fmt.Sprint(missing) != fmt.Sprint([]int64{ids[1], ids[2], 9999}) {
+		t.Errorf("missing = %+v", manifest.Missing)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requestToken returns the API token presented by a request, from an Authorization: Bearer or an
+// X-Api-Token header, or from the token query parameter of the file and event endpoints
+func requestToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if token := r.Header.Get("X-Api-Token"); token != "" {
+		return strings.TrimSpace(token)
+	}
+	if tokenQueryAllowed(r) {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// tokenQueryAllowed reports whether a request may pass the token as a query parameter: reads of
+// videos, thumbnails and character pictures, which <video> and <img> tags load without headers,
+// and the event stream, which EventSource opens without headers
+func tokenQueryAllowed(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	path := r.URL.Path
+	if path == "/api/events" {
+		return true
+	}
+	for _, prefix := range []string{"/api/videos/", "/api/thumbnails/", "/api/character-pictures/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return strings.HasPrefix(path, "/api/tasks/") && strings.HasSuffix(path, "/video")
+}
+
+// authorized reports whether a request may use the API
+// Without auth_token every request is allowed; character source videos are always served, the
+// provider downloads them without the token and their generated names are not guessable
+func authorized(config *Config, r *http.Request) bool {
+	if config == nil || config.AuthToken == "" || strings.HasPrefix(r.URL.Path, "/api/character-sources/") {
+		return true
+	}
+	token := requestToken(r)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.AuthToken)) == 1
+}
+
+// handleAuth answers a request without a valid token with 401
+// Returns whether the request is left for the handler to serve
+func handleAuth(w http.ResponseWriter, r *http.Request) bool {
+	if authorized(CurrentConfig(), r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="videogen"`)
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+	return false
+}
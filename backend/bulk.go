@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// MaxBulkTaskIDs is the maximum number of tasks a single bulk request may address
+const MaxBulkTaskIDs = 500
+
+// MaxBatchPrompts is the maximum number of prompts of a single batch creation
+const MaxBatchPrompts = 100
+
+// uniqueTaskIDs removes duplicate IDs while keeping the request order
+func uniqueTaskIDs(ids []int64) []int64 {
+	var unique []int64
+	seen := make(map[int64]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// handleBulkUpdateTasks handles POST /api/tasks/bulk-update
+// Stars, tags and re-prioritizes many tasks at once, returning a result per ID
+func handleBulkUpdateTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req BulkUpdateTasksRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.IDs = uniqueTaskIDs(req.IDs)
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, "ids is required")
+		return
+	}
+	if len(req.IDs) > MaxBulkTaskIDs {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d tasks can be updated at once", MaxBulkTaskIDs))
+		return
+	}
+	if req.SetStarred == nil && len(req.AddTags) == 0 && len(req.RemoveTags) == 0 && req.Priority == nil {
+		writeError(w, http.StatusBadRequest, "No update operation given")
+		return
+	}
+
+	var err error
+	if req.AddTags, err = normalizeTaskTags(req.AddTags); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.RemoveTags, err = normalizeTaskTags(req.RemoveTags); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Priority != nil {
+		if err := validateTaskPriority(*req.Priority); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	results, err := BulkUpdateTasks(&req)
+	if err != nil {
+		log.Printf("Failed to bulk update tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to update tasks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// decodeBulkTaskIDs reads the ids of a bulk request, writing a 400 response when they are missing,
+// not numeric or too many
+func decodeBulkTaskIDs(w http.ResponseWriter, r *http.Request) ([]int64, bool) {
+	var req BulkTaskIDsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body, ids must be numeric task IDs")
+		return nil, false
+	}
+
+	ids := uniqueTaskIDs(req.IDs)
+	if len(ids) == 0 {
+		writeError(w, http.StatusBadRequest, "ids is required")
+		return nil, false
+	}
+	if len(ids) > MaxBulkTaskIDs {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d tasks can be addressed at once", MaxBulkTaskIDs))
+		return nil, false
+	}
+	return ids, true
+}
+
+// handleBulkDeleteTasks handles POST /api/tasks-bulk-delete
+// Deletes the rows in one transaction, then the video files; a file that can't be removed is
+// logged and doesn't fail its task or the others
+func handleBulkDeleteTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ids, ok := decodeBulkTaskIDs(w, r)
+	if !ok {
+		return
+	}
+
+	results, localPaths, err := BulkDeleteTasks(ids)
+	if err != nil {
+		log.Printf("Failed to bulk delete tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to delete tasks")
+		return
+	}
+
+	for _, localPath := range localPaths {
+		if err := DeleteVideoFile(localPath); err != nil {
+			log.Printf("Warning: failed to delete video file: %v", err)
+		}
+	}
+
+	deleted := 0
+	for _, result := range results {
+		if result.Success {
+			deleted++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": deleted, "results": results})
+}
+
+// handleBulkRetryTasks handles POST /api/tasks-bulk-retry
+// Resets the selected tasks to pending, reporting the ones skipped with the reason; files left by
+// their previous run are deleted
+func handleBulkRetryTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ids, ok := decodeBulkTaskIDs(w, r)
+	if !ok {
+		return
+	}
+
+	reset, skipped, localPaths, err := RetryTasks(ids)
+	if err != nil {
+		log.Printf("Failed to bulk retry tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to retry tasks")
+		return
+	}
+	deleteRetryLeftovers(localPaths)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reset": reset, "skipped": skipped})
+}
+
+// handleBatchCreateTasks handles POST /api/tasks/batch
+// Creates one task per prompt with the shared options, all in one transaction; each prompt gets a
+// result with its task ID or the reason it was rejected
+func handleBatchCreateTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req BatchCreateTasksRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Prompts) == 0 {
+		writeError(w, http.StatusBadRequest, "prompts is required")
+		return
+	}
+	if len(req.Prompts) > MaxBatchPrompts {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d prompts can be created at once", MaxBatchPrompts))
+		return
+	}
+	if err := validateTaskOptions(req.Duration, req.Orientation); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateTaskPriority(req.Priority); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateTaskModel(CurrentConfig(), req.Model); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	template := CreateTaskRequest{
+		Duration:    req.Duration,
+		Orientation: req.Orientation,
+		Model:       req.Model,
+		NoDecorate:  req.NoDecorate,
+		Priority:    req.Priority,
+		BatchID:     newBatchID(),
+	}
+	if template.Duration == "" {
+		template.Duration = Duration10s
+	}
+	if template.Orientation == "" {
+		template.Orientation = OrientationLandscape
+	}
+	if template.Model == "" {
+		template.Model = ModelSora2
+	}
+	if req.ScheduledAt != nil {
+		scheduledAt := req.ScheduledAt.Local()
+		template.ScheduledAt = &scheduledAt
+	}
+
+	characters, err := GetAllCharacters()
+	if err != nil {
+		log.Printf("Warning: Failed to get characters for reference conversion: %v", err)
+		// Continue without conversion if we can't get characters
+	}
+	implicit := CurrentConfig().ImplicitCharacterReferences
+
+	results := make([]BatchCreateResult, len(req.Prompts))
+	var valid []int
+	var taskReqs []*CreateTaskRequest
+	var characterIDs [][]int64
+	for i, prompt := range req.Prompts {
+		results[i] = BatchCreateResult{Index: i, Prompt: prompt}
+		if strings.TrimSpace(prompt) == "" {
+			results[i].Error = "Prompt is required"
+			continue
+		}
+
+		taskReq := template
+		taskReq.Prompt = prompt
+		taskReq.OriginalPrompt = prompt
+		var used []int64
+		if characters != nil {
+			var err error
+			taskReq.Prompt, used, results[i].Warnings, err = ResolveCharacterReferences(prompt, characters, implicit)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+		}
+		valid = append(valid, i)
+		taskReqs = append(taskReqs, &taskReq)
+		characterIDs = append(characterIDs, used)
+	}
+	if len(taskReqs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "No valid prompt given", "results": results})
+		return
+	}
+
+	tasks, err := CreateTasks(taskReqs, characterIDs)
+	if err != nil {
+		log.Printf("Failed to create batch tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create tasks")
+		return
+	}
+	for j, task := range tasks {
+		results[valid[j]].ID = task.ID
+	}
+
+	var warnings []string
+	if warning := BatchSpaceWarning(len(tasks)); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"batch_id": template.BatchID,
+		"created":  len(tasks),
+		"results":  results,
+		"warnings": warnings,
+	})
+}
@@ -1,33 +1,303 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
+// characterReferencePattern returns a pattern matching the custom names of the completed characters
+// with an API ID, and the character of each name
+// Longer names come first so a name never matches inside a longer one (小明 in 小明明), and names
+// starting or ending with an ASCII letter or digit only match on word boundaries (Max in Maximum)
+func characterReferencePattern(characters []Character) (*regexp.Regexp, map[string]Character) {
+	byName := make(map[string]Character)
+	var names []string
+	for _, char := range characters {
+		if char.CustomName == "" || char.ApiCharacterID == "" || char.Status != StatusCompleted {
+			continue
+		}
+		if _, ok := byName[char.CustomName]; !ok {
+			byName[char.CustomName] = char
+			names = append(names, char.CustomName)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(names, func(i, j int) bool { return len([]rune(names[i])) > len([]rune(names[j])) })
+
+	alternatives := make([]string, len(names))
+	for i, name := range names {
+		alternative := regexp.QuoteMeta(name)
+		if isASCIIWordByte(name[0]) {
+			alternative = `\b` + alternative
+		}
+		if isASCIIWordByte(name[len(name)-1]) {
+			alternative += `\b`
+		}
+		alternatives[i] = alternative
+	}
+	return regexp.MustCompile(strings.Join(alternatives, "|")), byName
+}
+
+// isASCIIWordByte reports whether b is a character of \w
+func isASCIIWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
 // ConvertCharacterReferences converts custom character names in a prompt to @{api_character_id} format
 // For each character, if the custom_name appears in the prompt, it is replaced with @{api_character_id}
-// Only completed characters are used for conversion
+// Only completed characters are used for conversion; names are replaced in a single pass, see
+// characterReferencePattern for how overlapping names are matched
 // Returns the converted prompt string
 // **Feature: character-creation, Property 5: Custom name to API character ID conversion**
 // **Validates: Requirements 4.3**
 func ConvertCharacterReferences(prompt string, characters []Character) string {
-	result := prompt
+	pattern, byName := characterReferencePattern(characters)
+	if pattern == nil {
+		return prompt
+	}
+	return pattern.ReplaceAllStringFunc(prompt, func(name string) string {
+		return "@{" + byName[name].ApiCharacterID + "}"
+	})
+}
+
+// FindCharacterReferences returns the completed characters whose custom name appears in the prompt
+// Uses the same matching rule as ConvertCharacterReferences, so it must run on the unconverted prompt
+func FindCharacterReferences(prompt string, characters []Character) []Character {
+	pattern, _ := characterReferencePattern(characters)
+	if pattern == nil {
+		return nil
+	}
+	found := make(map[string]bool)
+	for _, name := range pattern.FindAllString(prompt, -1) {
+		found[name] = true
+	}
+	var matched []Character
 	for _, char := range characters {
-		// Only use completed characters for reference conversion
-		if char.CustomName != "" && char.ApiCharacterID != "" && char.Status == StatusCompleted {
-			// Replace custom name with @{api_character_id} format
-			result = strings.ReplaceAll(result, char.CustomName, "@{"+char.ApiCharacterID+"}")
+		if char.ApiCharacterID != "" && char.Status == StatusCompleted && found[char.CustomName] {
+			matched = append(matched, char)
+			delete(found, char.CustomName)
+		}
+	}
+	return matched
+}
+
+// rawCharacterIDPattern matches provider character IDs written directly in a prompt, e.g. @{char_abc123}
+var rawCharacterIDPattern = regexp.MustCompile(`@\{([^{}\s]+)\}`)
+
+// rawCharacterIDPrefix matches a raw character ID at the start of a text
+var rawCharacterIDPrefix = regexp.MustCompile(`^` + rawCharacterIDPattern.String())
+
+// FindRawCharacterIDs returns the distinct provider character IDs written as @{id} in the prompt
+func FindRawCharacterIDs(prompt string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, match := range rawCharacterIDPattern.FindAllStringSubmatch(prompt, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			ids = append(ids, match[1])
+		}
+	}
+	return ids
+}
+
+// UnknownCharacterError lists the @name references of a prompt that match no completed character
+type UnknownCharacterError struct {
+	Names []string
+}
+
+func (e *UnknownCharacterError) Error() string {
+	return "unknown character references: @" + strings.Join(e.Names, ", @") +
+		" (only completed characters can be referenced, write \\@ for a literal at-sign)"
+}
+
+// explicitCharacterReference resolves the reference following an @ in a prompt: a quoted name as in
+// @"multi word name", otherwise the longest custom name or username of a completed character starting
+// the text, ASCII names ending on a word boundary
+// Returns the referenced character, the name as written, the length consumed and whether text holds
+// a reference at all; the character is nil when the name is unknown
+func explicitCharacterReference(text string, characters []Character) (*Character, string, int, bool) {
+	completed := func(char *Character) bool {
+		return char.ApiCharacterID != "" && char.Status == StatusCompleted
+	}
+
+	if strings.HasPrefix(text, `"`) {
+		end := strings.IndexByte(text[1:], '"')
+		if end <= 0 {
+			return nil, "", 0, false
+		}
+		name := text[1 : end+1]
+		for i := range characters {
+			if completed(&characters[i]) && (characters[i].CustomName == name || characters[i].Username == name) {
+				return &characters[i], name, end + 2, true
+			}
+		}
+		return nil, name, end + 2, true
+	}
+
+	var best *Character
+	bestLen := 0
+	for i := range characters {
+		char := &characters[i]
+		if !completed(char) {
+			continue
+		}
+		for _, name := range []string{char.CustomName, char.Username} {
+			if name == "" || len(name) <= bestLen || !strings.HasPrefix(text, name) {
+				continue
+			}
+			if isASCIIWordByte(name[len(name)-1]) && len(text) > len(name) && isASCIIWordByte(text[len(name)]) {
+				continue
+			}
+			best, bestLen = char, len(name)
+		}
+	}
+	if best != nil {
+		return best, text[:bestLen], bestLen, true
+	}
+
+	// Unknown names run to the next space or punctuation, a lone @ is plain text
+	end := strings.IndexFunc(text, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) })
+	if end < 0 {
+		end = len(text)
+	}
+	if end == 0 {
+		return nil, "", 0, false
+	}
+	return nil, text[:end], end, true
+}
+
+// ResolveCharacterReferences converts character references in the prompt and collects the referenced characters
+// @name and @"multi word name" are converted to @{api_character_id}, and with implicit every
+// occurrence of a custom name is converted too, as before the @name syntax existed; \@ writes a
+// literal at-sign and an @ right after a letter or digit, as in an email address, is plain text
+// Raw @{id} references already in the prompt are linked to the local character with that API ID,
+// unknown IDs are reported in warnings since they are likely typos
+// Returns the converted prompt, the IDs of the referenced local characters and the warnings, or an
+// *UnknownCharacterError when @name references match no completed character
+func ResolveCharacterReferences(prompt string, characters []Character, implicit bool) (string, []int64, []string, error) {
+	var usedIDs []int64
+	var warnings []string
+	var unknown []string
+	seen := make(map[int64]bool)
+	use := func(id int64) {
+		if !seen[id] {
+			seen[id] = true
+			usedIDs = append(usedIDs, id)
+		}
+	}
+
+	// Plain text between references goes through implicit conversion when enabled
+	var out, plain strings.Builder
+	flush := func() {
+		text := plain.String()
+		plain.Reset()
+		if implicit {
+			for _, char := range FindCharacterReferences(text, characters) {
+				use(char.ID)
+			}
+			text = ConvertCharacterReferences(text, characters)
+		}
+		out.WriteString(text)
+	}
+
+	for i := 0; i < len(prompt); {
+		if strings.HasPrefix(prompt[i:], `\@`) {
+			plain.WriteByte('@')
+			i += 2
+			continue
+		}
+		if prompt[i] != '@' || (i > 0 && isASCIIWordByte(prompt[i-1])) {
+			plain.WriteByte(prompt[i])
+			i++
+			continue
+		}
+
+		if match := rawCharacterIDPrefix.FindStringSubmatch(prompt[i:]); match != nil {
+			found := false
+			for _, char := range characters {
+				if char.ApiCharacterID == match[1] {
+					use(char.ID)
+					found = true
+					break
+				}
+			}
+			if !found {
+				warnings = append(warnings, fmt.Sprintf("character ID @{%s} is not known locally, check for typos", match[1]))
+			}
+			flush()
+			out.WriteString(match[0])
+			i += len(match[0])
+			continue
+		}
+
+		char, name, n, ok := explicitCharacterReference(prompt[i+1:], characters)
+		if !ok {
+			plain.WriteByte('@')
+			i++
+			continue
+		}
+		if char == nil {
+			if !slices.Contains(unknown, name) {
+				unknown = append(unknown, name)
+			}
+		} else {
+			use(char.ID)
+		}
+		flush()
+		if char != nil {
+			out.WriteString("@{" + char.ApiCharacterID + "}")
+		} else {
+			out.WriteString(prompt[i : i+1+n])
+		}
+		i += 1 + n
+	}
+	flush()
+
+	if len(unknown) > 0 {
+		return "", nil, nil, &UnknownCharacterError{Names: unknown}
+	}
+	return out.String(), usedIDs, warnings, nil
+}
+
+// characterReferenced reports whether a stored prompt references the character, by custom name
+// or, once converted, as @{api_character_id}
+func characterReferenced(prompt string, char Character) bool {
+	return (char.CustomName != "" && strings.Contains(prompt, char.CustomName)) ||
+		(char.ApiCharacterID != "" && strings.Contains(prompt, "@{"+char.ApiCharacterID+"}"))
+}
+
+// attachCharacterUsage sets usage_count on the characters from the prompts of the queued tasks
+// The prompts are read once for the whole list, a failure leaves the counts at 0
+func attachCharacterUsage(characters []Character) {
+	prompts, err := GetActiveTaskPrompts()
+	if err != nil {
+		log.Printf("Failed to get task prompts for character usage: %v", err)
+		return
+	}
+	for i := range characters {
+		for _, prompt := range prompts {
+			if characterReferenced(prompt, characters[i]) {
+				characters[i].UsageCount++
+			}
 		}
 	}
-	return result
 }
 
 // ValidateCustomName validates that the custom name is between 1 and 10 characters
@@ -115,34 +385,46 @@ func ValidateSourceValue(sourceType, sourceValue string) error {
 
 // GetTaskByTaskID retrieves a task by its VectorEngine task_id
 func GetTaskByTaskID(taskID string) (*Task, error) {
-	task := &Task{}
-	var imageURL, videoURL, localPath, dbTaskID sql.NullString
-
-	err := DB.QueryRow(`
-		SELECT id, task_id, prompt, image_url, duration, orientation, status, progress, video_url, local_path, created_at, updated_at
-		FROM tasks WHERE task_id = ?`, taskID).Scan(
-		&task.ID, &dbTaskID, &task.Prompt, &imageURL, &task.Duration, &task.Orientation,
-		&task.Status, &task.Progress, &videoURL, &localPath, &task.CreatedAt, &task.UpdatedAt)
+	task, err := scanTask(ReadDB.QueryRow(`SELECT `+taskColumns+taskImageColumns+` FROM tasks WHERE task_id = ?`, taskID), true)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get task by task_id: %w", err)
 	}
-
-	task.TaskID = dbTaskID.String
-	task.ImageURL = imageURL.String
-	task.VideoURL = videoURL.String
-	task.LocalPath = localPath.String
-
 	return task, nil
 }
 
+// taskVideoDuration returns the length in seconds of the video of a task: the probed duration when
+// known, the downloaded file's otherwise, falling back to the requested duration
+func taskVideoDuration(task *Task) (float64, bool) {
+	if task.DurationSeconds > 0 {
+		return task.DurationSeconds, true
+	}
+	if task.LocalPath != "" {
+		duration, err := VideoDuration(ResolveVideoPath(task.LocalPath))
+		if err == nil {
+			return duration, true
+		}
+		log.Printf("[Character] 无法读取源视频时长: %v", err)
+	}
+	if requested, err := time.ParseDuration(task.Duration); err == nil && requested > 0 {
+		return requested.Seconds(), true
+	}
+	return 0, false
+}
+
 // handleCreateCharacter handles POST /api/characters
 // Validates request fields, calls Sora2 Character Training API,
 // and stores character in database with status='pending'
 // Requirements: 1.1, 1.5, 2.1, 3.1
 func handleCreateCharacter(w http.ResponseWriter, r *http.Request) {
+	// A video file is uploaded as multipart/form-data
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		handleCreateCharacterUpload(w, r)
+		return
+	}
+
 	// Read request body
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -205,11 +487,19 @@ func handleCreateCharacter(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "Task must be completed to create character")
 			return
 		}
+
+		// Check the window against the length of the source video, so training doesn't fail upstream
+		if duration, ok := taskVideoDuration(task); ok {
+			if err := ValidateTimestampsWithin(req.Timestamps, duration); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
 	}
 
 	// Call Sora2 Character Training API (Requirements 1.5, 2.1)
-	client := NewVectorEngineClient(appConfig.DyuAPIKey)
-	sora2Resp, err := client.CreateCharacterSora2(req.SourceType, req.SourceValue, req.Timestamps)
+	client := NewConfiguredClient(CurrentConfig())
+	sora2Resp, err := client.CreateCharacterSora2(r.Context(), req.SourceType, req.SourceValue, req.Timestamps)
 	if err != nil {
 		log.Printf("[Character] API错误: %v", err)
 		errMsg := err.Error()
@@ -244,10 +534,137 @@ func handleCreateCharacter(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, savedChar)
 }
 
+// ImportCharacterRequest represents the request body for POST /api/characters/import-id
+type ImportCharacterRequest struct {
+	ApiCharacterID string `json:"api_character_id"`
+	CustomName     string `json:"custom_name"`
+	Description    string `json:"description,omitempty"`
+	Username       string `json:"username,omitempty"`
+}
+
+// handleImportCharacter handles POST /api/characters/import-id
+// Creates a completed local character for an existing provider character ID so it takes part
+// in name-based conversion; username and avatar are fetched from the provider when available
+func handleImportCharacter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req ImportCharacterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.ApiCharacterID = strings.TrimSpace(req.ApiCharacterID)
+	if req.ApiCharacterID == "" || strings.ContainsAny(req.ApiCharacterID, "{}@ \t\n") {
+		writeError(w, http.StatusBadRequest, "Invalid character ID")
+		return
+	}
+	if err := ValidateCustomName(req.CustomName); err != nil {
+		writeError(w, http.StatusBadRequest, "Custom name must be 1-10 characters")
+		return
+	}
+
+	existing, err := GetCharacterByAPIID(req.ApiCharacterID)
+	if err != nil {
+		log.Printf("Failed to look up character: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to import character")
+		return
+	}
+	if existing != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Character ID is already imported as %s", existing.CustomName))
+		return
+	}
+
+	// Best effort: fill in username and avatar from the provider
+	username := req.Username
+	avatarURL := ""
+	client := NewConfiguredClient(CurrentConfig())
+	if sora2Resp, err := client.QueryCharacterStatus(r.Context(), req.ApiCharacterID); err != nil {
+		log.Printf("[Character] 导入角色 %s 时查询失败: %v", req.ApiCharacterID, err)
+	} else {
+		if username == "" {
+			username = sora2Resp.Username
+		}
+		avatarURL = sora2Resp.AvatarURL
+	}
+
+	char, err := CreateCharacter(&Character{
+		ApiCharacterID: req.ApiCharacterID,
+		Username:       username,
+		CustomName:     req.CustomName,
+		Description:    req.Description,
+		SourceType:     SourceTypeImport,
+		SourceValue:    req.ApiCharacterID,
+		Status:         StatusCompleted,
+		Progress:       100,
+	})
+	if err != nil {
+		log.Printf("[Character] 导入失败: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to import character")
+		return
+	}
+	if avatarURL != "" {
+		if err := UpdateCharacterStatus(char.ID, char.Status, char.Progress, char.ApiCharacterID, username, avatarURL, ""); err != nil {
+			log.Printf("[Character] 更新头像失败: %v", err)
+		} else {
+			char.AvatarURL = avatarURL
+		}
+	}
+
+	log.Printf("[Character] 导入成功: %s (%s)", char.CustomName, char.ApiCharacterID)
+	writeJSON(w, http.StatusCreated, char)
+}
+
 // handleGetAllCharacters handles GET /api/characters
 // Returns all characters from database with new fields (Requirements 5.1, 5.2)
+// Optional sort=last_used|name|created, default is pinned first then most recently used
+// q matches custom_name and username, status takes a comma separated list; with limit/offset the
+// response is a page with the total number of matches
 func handleGetAllCharacters(w http.ResponseWriter, r *http.Request) {
-	characters, err := GetAllCharacters()
+	query := r.URL.Query()
+	filter := CharacterFilter{Query: query.Get("q"), Sort: query.Get("sort")}
+	if _, ok := characterOrderBy[filter.Sort]; !ok {
+		writeError(w, http.StatusBadRequest, "sort must be one of: last_used, name, created")
+		return
+	}
+	if status := query.Get("status"); status != "" {
+		filter.Statuses = strings.Split(status, ",")
+	}
+
+	// Without limit the whole list is returned, as before pagination existed
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		characters, total, err := GetCharactersFiltered(filter, limit, offset)
+		if err != nil {
+			log.Printf("Failed to get characters: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to get characters")
+			return
+		}
+		if characters == nil {
+			characters = []Character{}
+		}
+		attachCharacterUsage(characters)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"characters": characters,
+			"total":      total,
+			"limit":      limit,
+			"offset":     offset,
+		})
+		return
+	}
+
+	characters, _, err := GetCharactersFiltered(filter, 0, 0)
 	if err != nil {
 		log.Printf("Failed to get characters: %v", err)
 		writeError(w, http.StatusInternalServerError, "Failed to get characters")
@@ -257,6 +674,7 @@ func handleGetAllCharacters(w http.ResponseWriter, r *http.Request) {
 	if characters == nil {
 		characters = []Character{}
 	}
+	attachCharacterUsage(characters)
 
 	writeJSON(w, http.StatusOK, CharacterListResponse{Characters: characters})
 }
@@ -289,10 +707,13 @@ func handleGetCharacterStatus(w http.ResponseWriter, r *http.Request, id int64)
 	}
 
 	// If character is already completed or failed, return current status without querying API
+	// The task processor also polls unfinished characters, so this mostly serves the saved status
 	if char.Status == StatusCompleted || char.Status == StatusFailed {
 		writeJSON(w, http.StatusOK, CharacterStatusResponse{
 			ID:             char.ID,
 			ApiCharacterID: char.ApiCharacterID,
+			Username:       char.Username,
+			AvatarURL:      char.AvatarURL,
 			Status:         char.Status,
 			Progress:       char.Progress,
 			FailReason:     char.FailReason,
@@ -306,14 +727,28 @@ func handleGetCharacterStatus(w http.ResponseWriter, r *http.Request, id int64)
 		return
 	}
 
-	client := NewVectorEngineClient(appConfig.DyuAPIKey)
-	sora2Resp, err := client.QueryCharacterStatus(char.ApiCharacterID)
+	status, _, err := refreshCharacterStatus(r.Context(), NewConfiguredClient(CurrentConfig()), char)
 	if err != nil {
 		log.Printf("[Character] 查询状态失败: %v", err)
 		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to query character status: %v", err))
 		return
 	}
 
+	// Return current status to frontend
+	writeJSON(w, http.StatusOK, status)
+}
+
+// characterPictureURLPrefix is the path serving the character pictures saved locally
+const characterPictureURLPrefix = "/api/character-pictures/"
+
+// refreshCharacterStatus queries the provider for the training status of a character and saves any change
+// Returns the current status and whether it differed from the local one
+func refreshCharacterStatus(ctx context.Context, client *VectorEngineClient, char *Character) (CharacterStatusResponse, bool, error) {
+	sora2Resp, err := client.QueryCharacterStatus(ctx, char.ApiCharacterID)
+	if err != nil {
+		return CharacterStatusResponse{}, false, err
+	}
+
 	// Map Sora2 status to our status
 	newStatus := char.Status
 	newProgress := sora2Resp.Progress
@@ -330,22 +765,35 @@ func handleGetCharacterStatus(w http.ResponseWriter, r *http.Request, id int64)
 		newStatus = StatusCompleted
 		newProgress = 100
 		log.Printf("[Character] 训练完成: %s (@%s)", char.CustomName, newUsername)
+		// Provider avatar links expire, keep a local copy once training completes
+		if char.Status != StatusCompleted && newAvatarURL != "" && !strings.HasPrefix(newAvatarURL, characterPictureURLPrefix) {
+			filename, err := client.DownloadCharacterPicture(ctx, newAvatarURL, char.ApiCharacterID)
+			if err != nil {
+				log.Printf("[Character] 下载头像失败: %s - %v", char.CustomName, err)
+			} else {
+				newAvatarURL = characterPictureURLPrefix + url.PathEscape(filename)
+			}
+		}
 	case "failed", "failure", "error":
 		newStatus = StatusFailed
 		log.Printf("[Character] 训练失败: %s - %s", char.CustomName, newFailReason)
 	}
 
 	// Update local database with new status/progress (Requirements 3.3, 3.4)
-	if newStatus != char.Status || newProgress != char.Progress || newFailReason != char.FailReason || newUsername != char.Username || newAvatarURL != char.AvatarURL {
+	changed := newStatus != char.Status || newProgress != char.Progress || newFailReason != char.FailReason || newUsername != char.Username || newAvatarURL != char.AvatarURL
+	if changed {
 		err = UpdateCharacterStatus(char.ID, newStatus, newProgress, char.ApiCharacterID, newUsername, newAvatarURL, newFailReason)
 		if err != nil {
 			log.Printf("[Character] 更新状态失败: %v", err)
 			// Continue to return the status even if update fails
 		}
 	}
+	// The provider no longer needs an uploaded source video once training is over
+	if char.SourceType == SourceTypeUpload && (newStatus == StatusCompleted || newStatus == StatusFailed) {
+		removeCharacterSource(char.SourceValue)
+	}
 
-	// Return current status to frontend
-	writeJSON(w, http.StatusOK, CharacterStatusResponse{
+	return CharacterStatusResponse{
 		ID:             char.ID,
 		ApiCharacterID: char.ApiCharacterID,
 		Username:       newUsername,
@@ -353,13 +801,36 @@ func handleGetCharacterStatus(w http.ResponseWriter, r *http.Request, id int64)
 		Status:         newStatus,
 		Progress:       newProgress,
 		FailReason:     newFailReason,
-	})
+	}, changed, nil
 }
 
 // handleDeleteCharacter handles DELETE /api/characters/:id
 // Removes character from database (Requirements 5.3)
-// Note: No longer needs to clean up character pictures (removed in new schema)
+// Also removes the avatar saved locally when training completed and any uploaded source video
+// Answers 409 with the affected task IDs while queued tasks reference the character, unless force=true
 func handleDeleteCharacter(w http.ResponseWriter, r *http.Request, id int64) {
+	char, err := GetCharacter(id)
+	if err != nil {
+		log.Printf("Failed to get character: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get character")
+		return
+	}
+	if char != nil && r.URL.Query().Get("force") != "true" {
+		taskIDs, err := GetTasksReferencingCharacter(char)
+		if err != nil {
+			log.Printf("Failed to get tasks referencing character %d: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "Failed to check character usage")
+			return
+		}
+		if len(taskIDs) > 0 {
+			writeJSON(w, http.StatusConflict, CharacterInUseResponse{
+				Error:   fmt.Sprintf("Character is referenced by %d pending or processing tasks, pass force=true to delete it anyway", len(taskIDs)),
+				TaskIDs: taskIDs,
+			})
+			return
+		}
+	}
+
 	if err := DeleteCharacter(id); err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			writeError(w, http.StatusNotFound, "Character not found")
@@ -369,6 +840,15 @@ func handleDeleteCharacter(w http.ResponseWriter, r *http.Request, id int64) {
 		writeError(w, http.StatusInternalServerError, "Failed to delete character")
 		return
 	}
+	if char != nil && char.SourceType == SourceTypeUpload {
+		removeCharacterSource(char.SourceValue)
+	}
+	if char != nil && strings.HasPrefix(char.AvatarURL, characterPictureURLPrefix) {
+		filename, _ := url.PathUnescape(strings.TrimPrefix(char.AvatarURL, characterPictureURLPrefix))
+		if err := DeleteCharacterPicture(filepath.Base(filename)); err != nil {
+			log.Printf("[Character] 删除头像失败: %v", err)
+		}
+	}
 
 	writeJSON(w, http.StatusOK, DeleteCharacterResponse{
 		Success: true,
@@ -376,6 +856,79 @@ func handleDeleteCharacter(w http.ResponseWriter, r *http.Request, id int64) {
 	})
 }
 
+// handlePinCharacter handles POST /api/characters/:id/pin
+// Toggles the pinned flag and returns the updated character
+func handlePinCharacter(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := ToggleCharacterPinned(id); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, "Character not found")
+			return
+		}
+		log.Printf("Failed to toggle character pin: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to pin character")
+		return
+	}
+
+	char, err := GetCharacter(id)
+	if err != nil || char == nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get character")
+		return
+	}
+	writeJSON(w, http.StatusOK, char)
+}
+
+// handleUpdateCharacter handles PUT /api/characters/:id
+// Renames a character or changes its description; the trained character upstream is unaffected
+func handleUpdateCharacter(w http.ResponseWriter, r *http.Request, id int64) {
+	var req UpdateCharacterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	char, err := GetCharacter(id)
+	if err != nil {
+		log.Printf("Failed to get character: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get character")
+		return
+	}
+	if char == nil {
+		writeError(w, http.StatusNotFound, "Character not found")
+		return
+	}
+
+	if req.CustomName != nil {
+		char.CustomName = strings.TrimSpace(*req.CustomName)
+		if err := ValidateCustomName(char.CustomName); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Description != nil {
+		char.Description = *req.Description
+		if err := ValidateDescription(char.Description); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	updated, err := UpdateCharacterDetails(id, char.CustomName, char.Description)
+	if errors.Is(err, errCharacterNameTaken) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Another character is already named %s", char.CustomName))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update character %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to update character")
+		return
+	}
+	if !updated {
+		writeError(w, http.StatusNotFound, "Character not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, char)
+}
+
 // handleCharacters handles GET and POST requests to /api/characters
 func handleCharacters(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -388,7 +941,7 @@ func handleCharacters(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleCharacterByID handles requests to /api/characters/:id and /api/characters/:id/status
+// handleCharacterByID handles requests to /api/characters/:id, /api/characters/:id/status and /api/characters/:id/pin
 func handleCharacterByID(w http.ResponseWriter, r *http.Request) {
 	// Extract path after /api/characters/
 	path := strings.TrimPrefix(r.URL.Path, "/api/characters/")
@@ -397,10 +950,9 @@ func handleCharacterByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if this is a status request: /api/characters/:id/status
+	// Sub-resources are addressed as /api/characters/:id/<action>
 	parts := strings.Split(path, "/")
 	idStr := parts[0]
-	isStatusRequest := len(parts) > 1 && parts[1] == "status"
 
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
@@ -408,18 +960,32 @@ func handleCharacterByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if isStatusRequest {
-		// Handle GET /api/characters/:id/status
-		if r.Method != http.MethodGet {
-			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
-			return
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "status":
+			// Handle GET /api/characters/:id/status
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			handleGetCharacterStatus(w, r, id)
+		case "pin":
+			// Handle POST /api/characters/:id/pin
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			handlePinCharacter(w, r, id)
+		default:
+			writeError(w, http.StatusNotFound, "Not found")
 		}
-		handleGetCharacterStatus(w, r, id)
 		return
 	}
 
-	// Handle DELETE /api/characters/:id
+	// Handle PUT and DELETE /api/characters/:id
 	switch r.Method {
+	case http.MethodPut:
+		handleUpdateCharacter(w, r, id)
 	case http.MethodDelete:
 		handleDeleteCharacter(w, r, id)
 	default:
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestResolveCharacterReferences covers name conversion together with raw @{id} references
+func TestResolveCharacterReferences(t *testing.T) {
+	characters := []Character{
+		{ID: 1, CustomName: "小明", ApiCharacterID: "char_aaa", Status: StatusCompleted},
+		{ID: 2, CustomName: "Bob", ApiCharacterID: "char_bbb", Status: StatusCompleted},
+		{ID: 3, CustomName: "Eve", ApiCharacterID: "char_ccc", Status: StatusPending},
+		{ID: 4, CustomName: "Old Joe", Username: "joe.sora", ApiCharacterID: "char_ddd", Status: StatusCompleted},
+	}
+
+	prompt, used, warnings, err := ResolveCharacterReferences("@小明 meets @{char_bbb} and @{char_zzz}", characters, false)
+
+	if err != nil {
+		t.Fatalf("ResolveCharacterReferences failed: %v", err)
+	}
+	if want := "@{char_aaa} meets @{char_bbb} and @{char_zzz}"; prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(used, want) {
+		t.Errorf("used = %v, want %v", used, want)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning for the unknown ID, got %v", warnings)
+	}
+
+	// A raw ID of a character still training is linked but not converted by name
+	_, used, warnings, _ = ResolveCharacterReferences("@{char_ccc} @{char_ccc}", characters, false)
+	if want := []int64{3}; !reflect.DeepEqual(used, want) || len(warnings) != 0 {
+		t.Errorf("used = %v, warnings = %v", used, warnings)
+	}
+
+	tests := []struct {
+		prompt   string
+		implicit bool
+		want     string
+	}{
+		{"小明 meets Bob", false, "小明 meets Bob"},
+		{"小明 meets Bob", true, "@{char_aaa} meets @{char_bbb}"},
+		{"@小明在跑步, @Bob's dog", false, "@{char_aaa}在跑步, @{char_bbb}'s dog"},
+		{`@"Old Joe" and @joe.sora`, false, "@{char_ddd} and @{char_ddd}"},
+		{`mail bob@example.com, \@小明 @ noon`, false, "mail bob@example.com, @小明 @ noon"},
+	}
+	for _, tt := range tests {
+		got, _, _, err := ResolveCharacterReferences(tt.prompt, characters, tt.implicit)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveCharacterReferences(%q, implicit %v) = %q, %v, want %q", tt.prompt, tt.implicit, got, err, tt.want)
+		}
+	}
+
+	// Unknown names and characters still training can't be referenced
+	_, _, _, err = ResolveCharacterReferences(`@Eve meets @Bobby and @"No One", @Eve`, characters, true)
+	var unknownErr *UnknownCharacterError
+	if !errors.As(err, &unknownErr) || !reflect.DeepEqual(unknownErr.Names, []string{"Eve", "Bobby", "No One"}) {
+		t.Errorf("unknown references: %v", err)
+	}
+}
+
+// TestConvertCharacterReferences covers overlapping names, ASCII names inside longer words and names
+// containing regex metacharacters
+func TestConvertCharacterReferences(t *testing.T) {
+	character := func(name, id string) Character {
+		return Character{CustomName: name, ApiCharacterID: id, Status: StatusCompleted}
+	}
+	tests := []struct {
+		name       string
+		prompt     string
+		characters []Character
+		want       string
+	}{
+		{"exact match", "小明在跑步", []Character{character("小明", "char_a")}, "@{char_a}在跑步"},
+		{"shorter CJK name first", "小明明和小明", []Character{character("小明", "char_a"), character("小明明", "char_b")},
+			"@{char_b}和@{char_a}"},
+		{"longer CJK name first", "小明明和小明", []Character{character("小明明", "char_b"), character("小明", "char_a")},
+			"@{char_b}和@{char_a}"},
+		{"ASCII substring", "Max reaches the Maximum", []Character{character("Max", "char_m")}, "@{char_m} reaches the Maximum"},
+		{"ASCII name with punctuation", "Max, MaxX and Max.", []Character{character("Max", "char_m")}, "@{char_m}, MaxX and @{char_m}."},
+		{"ASCII name between CJK", "让Max跑", []Character{character("Max", "char_m")}, "让@{char_m}跑"},
+		{"overlapping ASCII names", "Ann and Ann Lee", []Character{character("Ann", "char_1"), character("Ann Lee", "char_2")},
+			"@{char_1} and @{char_2}"},
+		{"regex metacharacters", "R2.D2 and R2xD2 meet (Bob)", []Character{character("R2.D2", "char_r"), character("(Bob)", "char_b")},
+			"@{char_r} and R2xD2 meet @{char_b}"},
+		{"name inside a converted ID", "char and Alice", []Character{character("Alice", "char_x"), character("char", "char_c")},
+			"@{char_c} and @{char_x}"},
+		{"characters still training", "小明", []Character{{CustomName: "小明", ApiCharacterID: "char_a", Status: StatusProcessing}}, "小明"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConvertCharacterReferences(tt.prompt, tt.characters); got != tt.want {
+				t.Errorf("ConvertCharacterReferences(%q) = %q, want %q", tt.prompt, got, tt.want)
+			}
+		})
+	}
+
+	found := FindCharacterReferences("小明明", []Character{character("小明", "char_a"), character("小明明", "char_b")})
+	if len(found) != 1 || found[0].ApiCharacterID != "char_b" {
+		t.Errorf("FindCharacterReferences matched %v, want only 小明明", found)
+	}
+}
+
+// TestGetCharactersFiltered pages through characters filtered by name and status
+func TestGetCharactersFiltered(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "characters.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	for i, char := range []Character{
+		{CustomName: "Alice", Username: "alice.sora", Status: StatusCompleted},
+		{CustomName: "Bob", Username: "bob_100%", Status: StatusCompleted},
+		{CustomName: "Alicia", Status: StatusFailed},
+		{CustomName: "Carol", Username: "ali.carol", Status: StatusCompleted},
+	} {
+		char.SourceType, char.SourceValue = "url", fmt.Sprintf("https://example.com/%d.mp4", i)
+		if _, err := CreateCharacter(&char); err != nil {
+			t.Fatalf("CreateCharacter failed: %v", err)
+		}
+	}
+
+	names := func(characters []Character) []string {
+		var names []string
+		for _, char := range characters {
+			names = append(names, char.CustomName)
+		}
+		return names
+	}
+
+	filter := CharacterFilter{Query: "ali", Statuses: []string{StatusCompleted}, Sort: CharacterSortName}
+	page, total, err := GetCharactersFiltered(filter, 1, 1)
+	if err != nil {
+		t.Fatalf("GetCharactersFiltered failed: %v", err)
+	}
+	if total != 2 || !reflect.DeepEqual(names(page), []string{"Carol"}) {
+		t.Errorf("page = %v of %d, want [Carol] of 2", names(page), total)
+	}
+
+	// LIKE wildcards in the query match literally
+	if all, _, _ := GetCharactersFiltered(CharacterFilter{Query: "0%"}, 0, 0); !reflect.DeepEqual(names(all), []string{"Bob"}) {
+		t.Errorf("q=0%% matched %v", names(all))
+	}
+
+	rec := httptest.NewRecorder()
+	handleCharacters(rec, httptest.NewRequest(http.MethodGet, "/api/characters?status=failed", nil))
+	var resp map[string]json.RawMessage
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if _, paged := resp["total"]; rec.Code != http.StatusOK || paged || len(resp["characters"]) == 0 {
+		t.Errorf("without limit: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestUpdateCharacter renames a character and checks duplicate names are rejected and the
+// training state is kept
+func TestUpdateCharacter(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "characters.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	var ids []int64
+	for _, name := range []string{"Alcie", "Bob"} {
+		char, err := CreateCharacter(&Character{CustomName: name, ApiCharacterID: "char_" + name, SourceType: "url",
+			SourceValue: "https://example.com/" + name + ".mp4", Status: StatusCompleted, Progress: 100})
+		if err != nil {
+			t.Fatalf("CreateCharacter failed: %v", err)
+		}
+		ids = append(ids, char.ID)
+	}
+
+	put := func(id int64, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleCharacterByID(rec, httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/characters/%d", id), strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := put(ids[0], `{"custom_name": "Bob"}`); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate name: status %d, want 409", rec.Code)
+	}
+	if rec := put(ids[0], `{"custom_name": "a name far too long"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid name: status %d, want 400", rec.Code)
+	}
+	if rec := put(9999, `{"custom_name": "Eve"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown character: status %d, want 404", rec.Code)
+	}
+
+	rec := put(ids[0], `{"custom_name": "Alice", "description": "the fixed one"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	char, _ := GetCharacter(ids[0])
+	if char.CustomName != "Alice" || char.Description != "the fixed one" {
+		t.Errorf("character after rename: %q, %q", char.CustomName, char.Description)
+	}
+	if char.ApiCharacterID != "char_Alcie" || char.Status != StatusCompleted || char.Progress != 100 {
+		t.Errorf("rename changed the training state: %+v", char)
+	}
+	// Renaming to its own name is not a conflict
+	if rec := put(ids[0], `{"custom_name": "Alice"}`); rec.Code != http.StatusOK {
+		t.Errorf("same name: status %d, want 200", rec.Code)
+	}
+}
+
+// TestDeleteReferencedCharacter checks queued tasks referencing a character by name or ID are
+// counted and block its deletion unless forced
+func TestDeleteReferencedCharacter(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "characters.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	char, _ := CreateCharacter(&Character{CustomName: "Alice", ApiCharacterID: "char_alice", SourceType: "url", Status: StatusCompleted})
+	var taskIDs []int64
+	for _, prompt := range []string{"Alice walks", "@{char_alice} runs", "Bob sits", "Alice sleeps"} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: prompt, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		taskIDs = append(taskIDs, task.ID)
+	}
+	DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusProcessing, taskIDs[1])
+	DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusCompleted, taskIDs[3])
+
+	rec := httptest.NewRecorder()
+	handleGetAllCharacters(rec, httptest.NewRequest(http.MethodGet, "/api/characters", nil))
+	var list CharacterListResponse
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Characters) != 1 || list.Characters[0].UsageCount != 2 {
+		t.Errorf("usage_count: %s", rec.Body.String())
+	}
+
+	del := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleCharacterByID(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/characters/%d%s", char.ID, query), nil))
+		return rec
+	}
+	rec = del("")
+	var inUse CharacterInUseResponse
+	json.Unmarshal(rec.Body.Bytes(), &inUse)
+	if rec.Code != http.StatusConflict || !slices.Equal(inUse.TaskIDs, taskIDs[:2]) {
+		t.Fatalf("delete of a referenced character: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if rec = del("?force=true"); rec.Code != http.StatusOK {
+		t.Errorf("forced delete: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if existing, _ := GetCharacter(char.ID); existing != nil {
+		t.Errorf("character still exists after a forced delete")
+	}
+}
+
+// TestCreateCharacterTimestampsWithinTask checks windows past the end of the source task's video
+// are rejected before training, using the probed length over the requested one
+func TestCreateCharacterTimestampsWithinTask(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"角色创建": {polls: []fakePoll{{"completed", 100, ""}}},
+	})
+	newTestProcessor(t, server)
+	CurrentConfig().DyuBaseURL = server.URL
+
+	source := func(taskID string, durationSeconds float64) {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "source " + taskID, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, task_id = ?, duration_seconds = ? WHERE id = ?",
+			StatusCompleted, taskID, durationSeconds, task.ID)
+	}
+	source("video_requested", 0)
+	source("video_probed", 8.5)
+
+	create := func(taskID, timestamps string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(CreateCharacterRequest{CustomName: "Alice", Description: "a", SourceType: "task", SourceValue: taskID, Timestamps: timestamps})
+		rec := httptest.NewRecorder()
+		handleCreateCharacter(rec, httptest.NewRequest(http.MethodPost, "/api/characters", strings.NewReader(string(body))))
+		return rec
+	}
+	for _, tc := range []struct {
+		taskID, timestamps string
+		status             int
+	}{
+		{"video_requested", "9,11", http.StatusBadRequest},
+		{"video_requested", "8,10", http.StatusCreated},
+		{"video_probed", "7,9", http.StatusBadRequest},
+		{"video_probed", "6,8", http.StatusCreated},
+	} {
+		rec := create(tc.taskID, tc.timestamps)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d, body %s", tc.taskID, tc.timestamps, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+	if rec := create("video_probed", "7,9"); !strings.Contains(rec.Body.String(), "8.50s") {
+		t.Errorf("the error doesn't give the video length: %s", rec.Body.String())
+	}
+}
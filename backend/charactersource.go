@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxCharacterSourceBytes is the largest video accepted to train a character from an upload
+	MaxCharacterSourceBytes = 200 << 20
+	// SourceTypeUpload marks characters trained from an uploaded video, served to the provider
+	// from public_base_url until training finishes
+	SourceTypeUpload = "upload"
+)
+
+// characterSourcePattern matches the generated names of uploaded character source videos
+var characterSourcePattern = regexp.MustCompile(`^[0-9a-f]{16}\.mp4$`)
+
+// CharacterSourceDirectory returns the directory where uploaded character source videos are stored
+func CharacterSourceDirectory() string {
+	return filepath.Join(OutputDirectory(), "character-sources")
+}
+
+// removeCharacterSource deletes the uploaded source video of a character, once it's no longer needed
+func removeCharacterSource(name string) {
+	if !characterSourcePattern.MatchString(name) {
+		return
+	}
+	if err := os.Remove(filepath.Join(CharacterSourceDirectory(), name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[Character] 删除源视频失败: %v", err)
+	}
+}
+
+// storeCharacterSource saves an uploaded video under a generated name
+// Returns the name and the path of the stored file
+func storeCharacterSource(file io.Reader) (string, string, error) {
+	if err := os.MkdirAll(CharacterSourceDirectory(), 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create character source directory: %w", err)
+	}
+	name := newBatchID() + ".mp4"
+	path := filepath.Join(CharacterSourceDirectory(), name)
+	out, err := os.Create(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create character source: %w", err)
+	}
+	_, err = io.Copy(out, file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", "", fmt.Errorf("failed to write character source: %w", err)
+	}
+	return name, path, nil
+}
+
+// handleCreateCharacterUpload handles POST /api/characters sent as multipart/form-data
+// The video in the file field is stored under output/character-sources and passed to the training
+// API as a URL under public_base_url, the provider downloads it from this server; custom_name,
+// description and timestamps are form fields
+func handleCreateCharacterUpload(w http.ResponseWriter, r *http.Request) {
+	config := CurrentConfig()
+	if config.PublicBaseURL == "" {
+		writeError(w, http.StatusBadRequest, "public_base_url must be set for the provider to download uploaded videos")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxCharacterSourceBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("A video of at most %d MB is required in the file field", MaxCharacterSourceBytes>>20))
+		return
+	}
+	defer file.Close()
+	if header.Size > MaxCharacterSourceBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Video exceeds %d MB", MaxCharacterSourceBytes>>20))
+		return
+	}
+
+	req := CreateCharacterRequest{
+		CustomName:  r.FormValue("custom_name"),
+		Description: r.FormValue("description"),
+		SourceType:  SourceTypeUpload,
+		Timestamps:  r.FormValue("timestamps"),
+	}
+	if err := ValidateCustomName(req.CustomName); err != nil {
+		writeError(w, http.StatusBadRequest, "Custom name must be 1-10 characters")
+		return
+	}
+	if err := ValidateDescription(req.Description); err != nil {
+		writeError(w, http.StatusBadRequest, "Description must be 1-500 characters")
+		return
+	}
+	if err := ValidateTimestamps(req.Timestamps); err != nil {
+		writeError(w, http.StatusBadRequest, "Timestamp range must be 1-3 seconds")
+		return
+	}
+
+	// The type is sniffed from the content, the provider expects MP4
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	if contentType := http.DetectContentType(sniff[:n]); contentType != "video/mp4" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported video type %s, use MP4", contentType))
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read the video")
+		return
+	}
+
+	name, path, err := storeCharacterSource(file)
+	if err != nil {
+		log.Printf("[Character] 保存源视频失败: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to store the video")
+		return
+	}
+	req.SourceValue = name
+
+	if duration, err := VideoDuration(path); err != nil {
+		log.Printf("[Character] 无法读取源视频时长: %v", err)
+	} else if err := ValidateTimestampsWithin(req.Timestamps, duration); err != nil {
+		removeCharacterSource(name)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sourceURL := strings.TrimRight(config.PublicBaseURL, "/") + "/api/character-sources/" + name
+	client := NewConfiguredClient(config)
+	sora2Resp, err := client.CreateCharacterSora2(r.Context(), "url", sourceURL, req.Timestamps)
+	if err != nil {
+		removeCharacterSource(name)
+		log.Printf("[Character] API错误: %v", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("创建角色失败: %v", err))
+		return
+	}
+
+	savedChar, err := CreateCharacter(&Character{
+		ApiCharacterID: sora2Resp.ID,
+		CustomName:     req.CustomName,
+		Description:    req.Description,
+		SourceType:     req.SourceType,
+		SourceValue:    req.SourceValue,
+		Timestamps:     req.Timestamps,
+		Status:         StatusPending,
+	})
+	if err != nil {
+		log.Printf("[Character] 保存失败: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to save character")
+		return
+	}
+
+	log.Printf("[Character] 创建成功: %s (ID: %d, 上传视频 %s)", savedChar.CustomName, savedChar.ID, name)
+	writeJSON(w, http.StatusCreated, savedChar)
+}
+
+// handleCharacterSources handles GET /api/character-sources/:name
+// Serves the uploaded video of a character while the provider trains it; the file is deleted once
+// training completes or fails, and the generated name is not guessable
+func handleCharacterSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/character-sources/")
+	if !characterSourcePattern.MatchString(name) {
+		writeError(w, http.StatusNotFound, "Video not found")
+		return
+	}
+	path := filepath.Join(CharacterSourceDirectory(), name)
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, "Video not found")
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeFile(w, r, path)
+}
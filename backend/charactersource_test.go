@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCreateCharacterFromUpload trains a character from an uploaded video, checks the video is
+// served to the provider while training and deleted once training completes
+func TestCreateCharacterFromUpload(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"角色创建": {polls: []fakePoll{{"processing", 50, ""}, {"completed", 100, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	config := CurrentConfig()
+	config.DyuBaseURL = server.URL
+
+	// An MP4 whose compatible brands let it be sniffed as video/mp4
+	video := append([]byte{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p', 'i', 's', 'o', 'm', 0, 0, 2, 0,
+		'i', 's', 'o', 'm', 'm', 'p', '4', '1'}, make([]byte, 1000)...)
+
+	upload := func(content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("custom_name", "Alice")
+		form.WriteField("description", "from local footage")
+		form.WriteField("timestamps", "0,2")
+		part, _ := form.CreateFormFile("file", "alice.mp4")
+		part.Write(content)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/characters", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		handleCreateCharacter(rec, req)
+		return rec
+	}
+
+	if rec := upload(video); rec.Code != http.StatusBadRequest {
+		t.Errorf("without public_base_url: status %d, want 400", rec.Code)
+	}
+	config.PublicBaseURL = "https://videogen.example.com/"
+	if rec := upload([]byte("not a video")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text upload: status %d, want 415", rec.Code)
+	}
+
+	rec := upload(video)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var char Character
+	json.Unmarshal(rec.Body.Bytes(), &char)
+	if char.SourceType != SourceTypeUpload || char.ApiCharacterID == "" {
+		t.Fatalf("character: %+v", char)
+	}
+	source := filepath.Join(CharacterSourceDirectory(), char.SourceValue)
+
+	rec = httptest.NewRecorder()
+	handleCharacterSources(rec, httptest.NewRequest(http.MethodGet, "/api/character-sources/"+char.SourceValue, nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), video) {
+		t.Errorf("serving the source: status %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	tick(p)
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source removed while training: %v", err)
+	}
+	tick(p)
+	if trained, _ := GetCharacter(char.ID); trained.Status != StatusCompleted {
+		t.Fatalf("status %q after training, want completed", trained.Status)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source kept after training: %v", err)
+	}
+}
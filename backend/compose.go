@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxComposeInputs bounds the number of clips of a compose request
+	MaxComposeInputs = 20
+	// composeProgressInterval is how often the progress of a compose task is saved
+	composeProgressInterval = 2 * time.Second
+)
+
+// ComposeRequest represents the request body for POST /api/compose
+type ComposeRequest struct {
+	TaskIDs []int64 `json:"task_ids"`         // Completed tasks whose videos are joined, in order
+	Prompt  string  `json:"prompt,omitempty"` // Prompt of the compose task, the input prompts by default
+}
+
+// ComposeInputError is an input of a compose request that can't be used, with the reason
+type ComposeInputError struct {
+	ID    int64  `json:"id"`
+	Error string `json:"error"`
+}
+
+// ComposeErrorResponse is returned when inputs of a compose request can't be used
+type ComposeErrorResponse struct {
+	Error  string              `json:"error"`
+	Inputs []ComposeInputError `json:"inputs"`
+}
+
+// composeInput is a validated input of a compose request
+type composeInput struct {
+	task Task
+	path string
+	info *VideoInfo
+}
+
+// checkComposeInputs loads and probes the inputs of a compose request
+// Returns the inputs, or the list of inputs that can't be used
+func checkComposeInputs(ids []int64) ([]composeInput, []ComposeInputError, error) {
+	tasks, err := GetTasksByIds(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[int64]Task, len(tasks))
+	for _, task := range tasks {
+		byID[task.ID] = task
+	}
+
+	var inputs []composeInput
+	var errs []ComposeInputError
+	for _, id := range ids {
+		task, ok := byID[id]
+		if !ok {
+			errs = append(errs, ComposeInputError{ID: id, Error: "task not found"})
+			continue
+		}
+		if task.Status != StatusCompleted || task.LocalPath == "" {
+			errs = append(errs, ComposeInputError{ID: id, Error: "task has no downloaded video"})
+			continue
+		}
+		path := ResolveVideoPath(task.LocalPath)
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, ComposeInputError{ID: id, Error: "video file is missing"})
+			continue
+		}
+		info, err := ProbeVideo(path)
+		if err != nil || info.Width == 0 || info.Height == 0 {
+			errs = append(errs, ComposeInputError{ID: id, Error: fmt.Sprintf("failed to read video: %v", err)})
+			continue
+		}
+		if len(inputs) > 0 && info.Orientation() != inputs[0].info.Orientation() {
+			errs = append(errs, ComposeInputError{ID: id, Error: fmt.Sprintf("orientation %s differs from %s of task %d",
+				info.Orientation(), inputs[0].info.Orientation(), inputs[0].task.ID)})
+			continue
+		}
+		inputs = append(inputs, composeInput{task: task, path: path, info: info})
+	}
+	return inputs, errs, nil
+}
+
+// handleCompose handles POST /api/compose
+// Joins the videos of completed tasks into a new task of model compose, processing until the
+// video is written so clients follow it like any other task
+func handleCompose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.TaskIDs) < 2 || len(req.TaskIDs) > MaxComposeInputs {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("task_ids must list between 2 and %d tasks", MaxComposeInputs))
+		return
+	}
+	if !FFmpegAvailable() {
+		writeError(w, http.StatusServiceUnavailable, "ffmpeg/ffprobe not found in PATH")
+		return
+	}
+
+	inputs, inputErrs, err := checkComposeInputs(req.TaskIDs)
+	if err != nil {
+		log.Printf("Failed to get compose inputs: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+	if len(inputErrs) > 0 {
+		writeJSON(w, http.StatusBadRequest, ComposeErrorResponse{Error: "Some tasks can't be composed", Inputs: inputErrs})
+		return
+	}
+
+	prompt := strings.TrimSpace(req.Prompt)
+	if prompt == "" {
+		prompts := make([]string, len(inputs))
+		for i, input := range inputs {
+			prompts[i] = input.task.OriginalPrompt
+			if prompts[i] == "" {
+				prompts[i] = input.task.Prompt
+			}
+		}
+		prompt = strings.Join(prompts, "\n")
+	}
+	tasks := make([]Task, len(inputs))
+	for i, input := range inputs {
+		tasks[i] = input.task
+	}
+	task, err := CreateComposeTask(tasks, prompt)
+	if err != nil {
+		log.Printf("Failed to create compose task: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create task")
+		return
+	}
+	RecordTaskEvent(task.ID, HistorySubmitted, fmt.Sprintf("compose of tasks %v", req.TaskIDs))
+	PublishTaskUpdate(task)
+
+	go composeTask(task, inputs)
+
+	writeJSON(w, http.StatusAccepted, task)
+}
+
+// composeTask writes the video of a compose task and completes or fails it
+func composeTask(task *Task, inputs []composeInput) {
+	if err := EnsureOutputDirectory(); err != nil {
+		failComposeTask(task, err)
+		return
+	}
+	filename := GenerateVideoFilename(fmt.Sprintf("compose_%d", task.ID))
+	dst := filepath.Join(OutputDirectory(), filename)
+
+	// Clips are re-encoded to the size of the first one when their sizes differ
+	first := inputs[0].info
+	reencode := false
+	srcs := make([]string, len(inputs))
+	var total float64
+	for i, input := range inputs {
+		srcs[i] = input.path
+		total += input.info.DurationSeconds
+		reencode = reencode || input.info.Width != first.Width || input.info.Height != first.Height
+	}
+	log.Printf("[Compose] 任务 %d 开始合成 %d 个视频 (重新编码: %v)", task.ID, len(inputs), reencode)
+
+	var saved time.Time
+	err := ConcatVideos(srcs, dst, first.Width, first.Height, reencode, total, func(percent int) {
+		if percent == task.Progress || time.Since(saved) < composeProgressInterval {
+			return
+		}
+		saved = time.Now()
+		task.Progress = percent
+		if err := SetTaskProgress(task.ID, percent); err != nil {
+			log.Printf("Failed to save compose progress of task %d: %v", task.ID, err)
+			return
+		}
+		PublishTaskUpdate(task)
+	})
+	if err != nil {
+		os.Remove(dst)
+		failComposeTask(task, err)
+		return
+	}
+
+	task.Status = StatusCompleted
+	task.Progress = 100
+	task.LocalPath = filename
+	if err := probeTaskMetadata(task); err != nil {
+		log.Printf("Warning: failed to probe video of task %d: %v", task.ID, err)
+	}
+	if err := generateTaskThumbnail(CurrentConfig(), task); err != nil {
+		log.Printf("Failed to generate thumbnail for task %d: %v", task.ID, err)
+	}
+	if err := UpdateTask(task); err != nil {
+		log.Printf("Failed to update compose task %d: %v", task.ID, err)
+		return
+	}
+	log.Printf("[Compose] 任务 %d 合成完成: %s", task.ID, filename)
+	RecordTaskEvent(task.ID, HistoryCompleted, "")
+	PublishTaskUpdate(task)
+}
+
+// failComposeTask marks a compose task failed with the error
+func failComposeTask(task *Task, err error) {
+	log.Printf("[Compose] 任务 %d 合成失败: %v", task.ID, err)
+	task.Status = StatusFailed
+	task.FailReason = err.Error()
+	if err := UpdateTask(task); err != nil {
+		log.Printf("Failed to update compose task %d: %v", task.ID, err)
+		return
+	}
+	RecordTaskEvent(task.ID, HistoryFailed, task.FailReason)
+	PublishTaskUpdate(task)
+}
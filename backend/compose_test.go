@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProbeTools puts ffmpeg and ffprobe scripts first in PATH: ffprobe prints the file it probes,
+// so test videos hold their own ffprobe output, and ffmpeg logs its arguments to the returned file
+// and writes a 1280x720 video to its last argument
+func fakeProbeTools(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsLog := filepath.Join(dir, "ffmpeg.log")
+	scripts := map[string]string{
+		"ffprobe": "#!/bin/sh\nfor last; do :; done\ncat \"$last\"\n",
+		"ffmpeg": "#!/bin/sh\necho \"$*\" >> " + argsLog + "\nfor last; do :; done\n" +
+			"printf 'out_time_us=5000000\\nprogress=end\\n'\n" +
+			"printf '" + probeJSON(1280, 720, 20) + "' > \"$last\"\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("failed to write fake %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsLog
+}
+
+// probeJSON is the ffprobe output of a video
+func probeJSON(width, height int, duration float64) string {
+	return fmt.Sprintf(`{"streams":[{"width":%d,"height":%d}],"format":{"duration":"%g"}}`, width, height, duration)
+}
+
+// TestCompose joins two clips of different sizes and checks the inputs that can't be composed are
+// listed with their reason
+func TestCompose(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "compose.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{})
+	argsLog := fakeProbeTools(t)
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	video := func(status, localPath, probe string) int64 {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "scene " + localPath, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, local_path = ? WHERE id = ?", status, localPath, task.ID)
+		if probe != "" {
+			os.WriteFile(filepath.Join(OutputDirectory(), localPath), []byte(probe), 0644)
+		}
+		return task.ID
+	}
+	first := video(StatusCompleted, "a.mp4", probeJSON(1280, 720, 10))
+	second := video(StatusCompleted, "b.mp4", probeJSON(1920, 1080, 10))
+	portrait := video(StatusCompleted, "c.mp4", probeJSON(720, 1280, 10))
+	pending := video(StatusPending, "", "")
+	missing := video(StatusCompleted, "gone.mp4", "")
+
+	compose := func(ids ...int64) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ComposeRequest{TaskIDs: ids})
+		rec := httptest.NewRecorder()
+		handleCompose(rec, httptest.NewRequest(http.MethodPost, "/api/compose", strings.NewReader(string(body))))
+		return rec
+	}
+
+	rec := compose(first, portrait, pending, missing, 9999)
+	var errResp ComposeErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &errResp)
+	if rec.Code != http.StatusBadRequest || len(errResp.Inputs) != 4 {
+		t.Fatalf("invalid inputs: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	for i, id := range []int64{portrait, pending, missing, 9999} {
+		if errResp.Inputs[i].ID != id {
+			t.Errorf("input error %d is for task %d, want %d", i, errResp.Inputs[i].ID, id)
+		}
+	}
+
+	rec = compose(first, second)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("compose: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var created Task
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.Model != ModelCompose || created.Status != StatusProcessing {
+		t.Errorf("compose task model %q, status %q", created.Model, created.Status)
+	}
+	if pendingTasks, _ := GetPendingTasks(); len(pendingTasks) != 1 || pendingTasks[0].ID != pending {
+		t.Errorf("the processor would pick up the compose task: %v", pendingTasks)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	task, _ := GetTask(created.ID)
+	for task.Status == StatusProcessing && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		task, _ = GetTask(created.ID)
+	}
+	if task.Status != StatusCompleted || task.Progress != 100 || task.Width != 1280 || task.DurationSeconds != 20 {
+		t.Fatalf("compose task: %+v", task)
+	}
+	if _, err := os.Stat(filepath.Join(OutputDirectory(), task.LocalPath)); err != nil {
+		t.Errorf("composed video missing: %v", err)
+	}
+	args, _ := os.ReadFile(argsLog)
+	if !strings.Contains(string(args), "-f concat") || !strings.Contains(string(args), "scale=1280:720") {
+		t.Errorf("clips of different sizes were not re-encoded: %s", args)
+	}
+}
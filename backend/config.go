@@ -1,29 +1,152 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 )
 
 // Config holds the application configuration
 type Config struct {
 	DyuAPIKey string `json:"dyu_api_key"`
-	Port      int    `json:"port,omitempty"`
+	// DyuAPIKeys are additional keys, used in turn when a key fails with an authentication or quota error
+	DyuAPIKeys []string `json:"dyu_api_keys,omitempty"`
+	// APIKeyCooldown is how long in seconds a failed key is skipped (default 1800)
+	APIKeyCooldown int `json:"api_key_cooldown,omitempty"`
+	// DyuBaseURL is the Dyu API endpoint, e.g. a relay proxying it (default https://api.dyuapi.com)
+	// A path is kept, https://relay.example.com/dyu sends tasks to https://relay.example.com/dyu/v1/videos
+	DyuBaseURL string `json:"dyu_base_url,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	// PublicBaseURL is the address the provider reaches this server at, e.g. https://videogen.example.com;
+	// videos uploaded to train characters are served from it until training finishes
+	PublicBaseURL string `json:"public_base_url,omitempty"`
+	// TLSCertFile and TLSKeyFile serve the UI and API over HTTPS when both are set, browsers only
+	// allow the clipboard and some media APIs on https pages outside localhost
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
+	// SelfSigned serves HTTPS with a certificate for localhost generated on first run, for HTTPS
+	// without providing a certificate
+	SelfSigned bool `json:"self_signed,omitempty"`
+	// AllowedOrigins are the browser origins allowed to call the API, e.g. ["https://videogen.example.com"];
+	// other origins are rejected, empty allows any origin for local single-user use
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	// AuthToken, when set, must be presented by every API request in an Authorization: Bearer or an
+	// X-Api-Token header; video and picture files also accept it as a token query parameter
+	AuthToken string `json:"auth_token,omitempty"`
+	// ProxyURL routes API requests and downloads through a proxy (http://, https:// or socks5://),
+	// the HTTP_PROXY/HTTPS_PROXY environment variables are used when empty
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// RequeueAuthFailures re-queues tasks that failed with authentication errors when the API key changes
+	RequeueAuthFailures bool `json:"requeue_auth_failures,omitempty"`
+	// PromptPrefix and PromptSuffix are added to every prompt at submission time
+	PromptPrefix string `json:"prompt_prefix,omitempty"`
+	PromptSuffix string `json:"prompt_suffix,omitempty"`
+	// ImplicitCharacterReferences converts every occurrence of a character's custom name in prompts,
+	// not only the explicit @name references, as before the @name syntax existed
+	ImplicitCharacterReferences bool `json:"implicit_character_references,omitempty"`
+	// OutputDir is where downloaded videos are saved, ~ and relative paths are resolved at startup (default "output")
+	OutputDir string `json:"output_dir,omitempty"`
+	// RequestTimeout bounds each API call in seconds (default 60), video downloads are not limited
+	RequestTimeout int `json:"request_timeout,omitempty"`
+	// PollInterval is the interval in seconds between task status polls (default 3)
+	PollInterval int `json:"poll_interval,omitempty"`
+	// ReconcileLookbackHours limits reconciliation with the provider to tasks and characters
+	// updated within this many hours (default 48)
+	ReconcileLookbackHours int `json:"reconcile_lookback_hours,omitempty"`
+	// FailedTaskRetentionDays and CompletedTaskRetentionDays are how long failed (and cancelled) and
+	// completed tasks are kept after their last update before the daily sweep deletes them with their
+	// videos; starred tasks are always kept, 0 keeps tasks forever
+	FailedTaskRetentionDays    int `json:"failed_task_retention_days,omitempty"`
+	CompletedTaskRetentionDays int `json:"completed_task_retention_days,omitempty"`
+	// MinFreeSpaceMB pauses video downloads while the output volume has less free space, 0 disables the check
+	// It is also kept free when a video is downloaded, a video that doesn't fit fails its task
+	MinFreeSpaceMB int `json:"min_free_space_mb,omitempty"`
+	// DownloadThreads is the number of parallel range requests per video download, 0 picks 8
+	// (4 under 10 MB) and 1 always downloads in a single stream
+	DownloadThreads int `json:"download_threads,omitempty"`
+	// DownloadChunkMinMB is the smallest chunk of a parallel download (default 1), smaller videos
+	// are downloaded in a single stream
+	DownloadChunkMinMB int `json:"download_chunk_min_mb,omitempty"`
+	// DownloadWorkers is the number of videos downloaded concurrently (default 2), applied at startup
+	DownloadWorkers int `json:"download_workers,omitempty"`
+	// DownloadRateLimitKbps caps the bandwidth of each video download in kilobits per second, 0 is unlimited
+	DownloadRateLimitKbps int `json:"download_rate_limit_kbps,omitempty"`
+	// MaxConcurrentTasks limits the number of tasks processing at the provider at once, 0 means unlimited
+	MaxConcurrentTasks int `json:"max_concurrent_tasks"`
+	// StrictOrientation fails completed tasks whose video orientation differs from the requested one
+	StrictOrientation bool `json:"strict_orientation,omitempty"`
+	// MaxRetries is the number of times a failed submission is retried before the task is marked failed
+	MaxRetries int `json:"max_retries"`
+	// StuckAfterMinutes is how long a processing task may go without progress before the watchdog
+	// fails it, or requeues it when AutoRetryStuck is set; 0 disables the watchdog
+	StuckAfterMinutes int  `json:"stuck_after_minutes"`
+	AutoRetryStuck    bool `json:"auto_retry_stuck,omitempty"`
+	// MaxTaskCount is the largest count of videos a single create request may ask for (default 10)
+	MaxTaskCount int `json:"max_task_count,omitempty"`
+	// WebhookURL receives task_completed/task_failed events, plus progress events at WebhookMilestones (e.g. [25, 50, 75])
+	WebhookURL        string `json:"webhook_url,omitempty"`
+	WebhookMilestones []int  `json:"webhook_milestones,omitempty"`
+	// PostDownloadCommand is run after each video download, e.g. ["python", "upload.py"]
+	// Task details are passed as VIDEOGEN_* environment variables
+	PostDownloadCommand []string `json:"post_download_command,omitempty"`
+	// PostDownloadTimeout is the command timeout in seconds (default 300)
+	PostDownloadTimeout int `json:"post_download_timeout,omitempty"`
+	// PostDownloadStrict fails the task when the command exits with an error instead of only flagging it
+	PostDownloadStrict bool `json:"post_download_strict,omitempty"`
+	// MaxImageDimension is the longest edge in pixels of submitted images, larger ones are downscaled
+	// and re-encoded as JPEG (default 2048)
+	MaxImageDimension int `json:"max_image_dimension,omitempty"`
+	// FFmpegPath is the ffmpeg binary used for thumbnails (default "ffmpeg" from PATH)
+	// Thumbnails are not generated when it can't be found
+	FFmpegPath string `json:"ffmpeg_path,omitempty"`
+	// ModelFallbacks are the upstream models tried in turn when a model has no available channel,
+	// e.g. {"sora2-landscape-test": ["sora2-landscape", "sora2-landscape-backup"]}
+	// Models without a chain fall back from a -test model to its plain name
+	ModelFallbacks map[string][]string `json:"model_fallbacks,omitempty"`
+	// Providers enables or disables the video providers (sora, veo3) and sets their own base URL and
+	// API keys, e.g. {"veo3": {"base_url": "https://relay.example.com", "api_keys": ["sk-..."]}}
+	Providers map[string]ProviderConfig `json:"providers,omitempty"`
 }
 
+const (
+	// DefaultMaxConcurrentTasks is the default limit of in-flight generation tasks
+	DefaultMaxConcurrentTasks = 4
+	// DefaultMaxRetries is the default number of submission retries
+	DefaultMaxRetries = 3
+	// DefaultStuckAfterMinutes is the default time without progress before a processing task is stuck
+	DefaultStuckAfterMinutes = 120
+	// DefaultMaxTaskCount is the default limit of videos created by one request
+	DefaultMaxTaskCount = 10
+)
+
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		DyuAPIKey: "",
-		Port:      8080,
+		DyuAPIKey:          "",
+		DyuBaseURL:         DyuAPIBaseURL,
+		Port:               8080,
+		MaxConcurrentTasks: DefaultMaxConcurrentTasks,
+		MaxRetries:         DefaultMaxRetries,
+		StuckAfterMinutes:  DefaultStuckAfterMinutes,
 	}
 }
 
-// LoadConfig loads configuration from config.json file
+// ConfigPath is the configuration file, set with --config
+var ConfigPath = "config.json"
+
+// LoadConfig loads configuration from the ConfigPath file
 // If the file doesn't exist, it creates a default one
 func LoadConfig() (*Config, error) {
-	configPath := "config.json"
+	configPath := ConfigPath
 
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -41,8 +164,9 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
+	// Start from the defaults so fields missing from the file keep their default values
+	config := DefaultConfig()
+	if err := json.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
@@ -50,20 +174,563 @@ func LoadConfig() (*Config, error) {
 	if config.Port == 0 {
 		config.Port = 8080
 	}
+	if strings.TrimSpace(config.DyuBaseURL) == "" {
+		config.DyuBaseURL = DyuAPIBaseURL
+	}
+	if config.MaxConcurrentTasks < 0 {
+		config.MaxConcurrentTasks = 0
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.StuckAfterMinutes < 0 {
+		config.StuckAfterMinutes = 0
+	}
 
-	return &config, nil
+	return config, nil
 }
 
-// SaveConfig saves configuration to config.json file
+// SaveConfig saves configuration to the ConfigPath file
 func SaveConfig(config *Config) error {
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile("config.json", data, 0644); err != nil {
+	if err := os.WriteFile(ConfigPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
 	return nil
 }
+
+var (
+	// configMu guards appConfig, which PUT /api/config replaces at runtime
+	configMu sync.RWMutex
+	// configUpdateMu serializes configuration updates
+	configUpdateMu sync.Mutex
+	// listenPort is the port the server was started on, changing it requires a restart
+	listenPort int
+	// portOverride is the port set with --port, it takes precedence over the configured port and
+	// isn't saved to the configuration file
+	portOverride int
+	// listenTLS is the TLS setup the server was started with, see tlsSettings
+	listenTLS string
+)
+
+// serverPort returns the port to listen on, --port when set or the configured port
+func serverPort(config *Config) int {
+	if portOverride != 0 {
+		return portOverride
+	}
+	return config.Port
+}
+
+// CurrentConfig returns the configuration in effect
+// The returned value is shared and must not be modified, updates replace it as a whole
+func CurrentConfig() *Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return appConfig
+}
+
+// setCurrentConfig replaces the configuration in effect
+func setCurrentConfig(config *Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	appConfig = config
+}
+
+// maskSecret hides all but the last 4 characters of a secret
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// unmaskKey returns the current key whose masked form is key, or key itself when it isn't masked
+func unmaskKey(key string, current *Config) string {
+	key = strings.TrimSpace(key)
+	if !strings.HasPrefix(key, "****") {
+		return key
+	}
+	for _, existing := range current.apiKeys() {
+		if maskSecret(existing) == key {
+			return existing
+		}
+	}
+	return key
+}
+
+// unmaskProviderKeys replaces the masked keys sent back by a client with the matching current keys
+// of the provider
+func unmaskProviderKeys(keys, current []string) []string {
+	unmasked := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(key, "****") {
+			for _, existing := range current {
+				if maskSecret(existing) == key {
+					key = existing
+					break
+				}
+			}
+		}
+		unmasked = append(unmasked, key)
+	}
+	return unmasked
+}
+
+// validateConfig checks the values accepted by PUT /api/config
+func validateConfig(config *Config) error {
+	if config.Port < 1 || config.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if config.APIKeyCooldown < 0 {
+		return fmt.Errorf("api_key_cooldown must not be negative")
+	}
+	if config.RequestTimeout < 0 {
+		return fmt.Errorf("request_timeout must not be negative")
+	}
+	if config.PollInterval < 0 {
+		return fmt.Errorf("poll_interval must not be negative")
+	}
+	if config.ReconcileLookbackHours < 0 {
+		return fmt.Errorf("reconcile_lookback_hours must not be negative")
+	}
+	if config.FailedTaskRetentionDays < 0 {
+		return fmt.Errorf("failed_task_retention_days must not be negative")
+	}
+	if config.CompletedTaskRetentionDays < 0 {
+		return fmt.Errorf("completed_task_retention_days must not be negative")
+	}
+	if config.MaxConcurrentTasks < 0 {
+		return fmt.Errorf("max_concurrent_tasks must not be negative")
+	}
+	if config.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	if config.StuckAfterMinutes < 0 {
+		return fmt.Errorf("stuck_after_minutes must not be negative")
+	}
+	if config.MaxTaskCount < 0 {
+		return fmt.Errorf("max_task_count must not be negative")
+	}
+	if config.MaxImageDimension < 0 {
+		return fmt.Errorf("max_image_dimension must not be negative")
+	}
+	if config.MinFreeSpaceMB < 0 {
+		return fmt.Errorf("min_free_space_mb must not be negative")
+	}
+	if config.PostDownloadTimeout < 0 {
+		return fmt.Errorf("post_download_timeout must not be negative")
+	}
+	if err := validateDownloadOptions(config); err != nil {
+		return err
+	}
+	if config.DyuBaseURL != "" {
+		if err := validateBaseURL(config.DyuBaseURL); err != nil {
+			return fmt.Errorf("dyu_base_url %v", err)
+		}
+	}
+	if err := validateTLSOptions(config); err != nil {
+		return err
+	}
+	if strings.HasPrefix(config.AuthToken, "****") {
+		return fmt.Errorf("auth_token must not start with ****")
+	}
+	if err := validateAllowedOrigins(config.AllowedOrigins); err != nil {
+		return err
+	}
+	if config.ProxyURL != "" {
+		if _, err := parseProxyURL(config.ProxyURL); err != nil {
+			return err
+		}
+	}
+	if config.WebhookURL != "" {
+		u, err := url.Parse(config.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook_url must be an http or https URL")
+		}
+	}
+	for model, chain := range config.ModelFallbacks {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("model_fallbacks must not have an empty model")
+		}
+		seen := map[string]bool{model: true}
+		for _, fallback := range chain {
+			if strings.TrimSpace(fallback) == "" {
+				return fmt.Errorf("model_fallbacks of %s must not contain an empty model", model)
+			}
+			if seen[fallback] {
+				return fmt.Errorf("model_fallbacks of %s lists %s twice", model, fallback)
+			}
+			seen[fallback] = true
+		}
+	}
+	return validateProviders(config.Providers)
+}
+
+// ConfigResponse represents the response of GET and PUT /api/config
+type ConfigResponse struct {
+	*Config
+	// RestartRequired is set when a saved change (port or proxy_url) only applies after a restart
+	RestartRequired bool `json:"restart_required"`
+	// Requeued is the number of tasks failed with authentication errors that a changed API key re-queued
+	Requeued int64 `json:"requeued"`
+	// RequeueAvailable is the number of such tasks left failed because requeue_auth_failures is off,
+	// POST /api/tasks-requeue-auth re-queues them
+	RequeueAvailable int64 `json:"requeue_available"`
+}
+
+// maskProxyURL hides the password of a proxy_url with credentials like maskSecret
+func maskProxyURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return raw
+	}
+	// Redacted replaces the password with xxxxx, url.UserPassword would escape the stars
+	return strings.Replace(u.Redacted(), ":xxxxx@", ":"+maskSecret(password)+"@", 1)
+}
+
+// configResponse returns the configuration with the API keys and other secrets masked
+// webhook_url is masked whole, webhook URLs like Slack's carry their token in the path
+func configResponse(config *Config) ConfigResponse {
+	masked := *config
+	masked.DyuAPIKey = maskSecret(config.DyuAPIKey)
+	masked.AuthToken = maskSecret(config.AuthToken)
+	masked.WebhookURL = maskSecret(config.WebhookURL)
+	masked.ProxyURL = maskProxyURL(config.ProxyURL)
+	masked.DyuAPIKeys = make([]string, len(config.DyuAPIKeys))
+	for i, key := range config.DyuAPIKeys {
+		masked.DyuAPIKeys[i] = maskSecret(key)
+	}
+	if config.Providers != nil {
+		masked.Providers = make(map[string]ProviderConfig, len(config.Providers))
+		for name, provider := range config.Providers {
+			keys := make([]string, len(provider.APIKeys))
+			for i, key := range provider.APIKeys {
+				keys[i] = maskSecret(key)
+			}
+			provider.APIKeys = keys
+			masked.Providers[name] = provider
+		}
+	}
+	return ConfigResponse{Config: &masked, RestartRequired: serverPort(config) != listenPort ||
+		strings.TrimSpace(config.ProxyURL) != activeProxyURL || tlsSettings(config) != listenTLS}
+}
+
+// handleConfig handles GET and PUT /api/config
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, configResponse(CurrentConfig()))
+	case http.MethodPut:
+		handleUpdateConfig(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ConfigUpdateRequest represents the partial body of PUT /api/config
+// Command and binary paths (post_download_command, ffmpeg_path) and the TLS files are left out, they
+// can only be changed in config.json: the API may be reachable by other hosts and web pages
+type ConfigUpdateRequest struct {
+	DyuAPIKey                   *string                   `json:"dyu_api_key,omitempty"`
+	DyuAPIKeys                  *[]string                 `json:"dyu_api_keys,omitempty"`
+	APIKeyCooldown              *int                      `json:"api_key_cooldown,omitempty"`
+	DyuBaseURL                  *string                   `json:"dyu_base_url,omitempty"`
+	Port                        *int                      `json:"port,omitempty"`
+	PublicBaseURL               *string                   `json:"public_base_url,omitempty"`
+	SelfSigned                  *bool                     `json:"self_signed,omitempty"`
+	AllowedOrigins              *[]string                 `json:"allowed_origins,omitempty"`
+	AuthToken                   *string                   `json:"auth_token,omitempty"`
+	ProxyURL                    *string                   `json:"proxy_url,omitempty"`
+	RequeueAuthFailures         *bool                     `json:"requeue_auth_failures,omitempty"`
+	PromptPrefix                *string                   `json:"prompt_prefix,omitempty"`
+	PromptSuffix                *string                   `json:"prompt_suffix,omitempty"`
+	ImplicitCharacterReferences *bool                     `json:"implicit_character_references,omitempty"`
+	OutputDir                   *string                   `json:"output_dir,omitempty"`
+	RequestTimeout              *int                      `json:"request_timeout,omitempty"`
+	PollInterval                *int                      `json:"poll_interval,omitempty"`
+	ReconcileLookbackHours      *int                      `json:"reconcile_lookback_hours,omitempty"`
+	FailedTaskRetentionDays     *int                      `json:"failed_task_retention_days,omitempty"`
+	CompletedTaskRetentionDays  *int                      `json:"completed_task_retention_days,omitempty"`
+	MinFreeSpaceMB              *int                      `json:"min_free_space_mb,omitempty"`
+	DownloadThreads             *int                      `json:"download_threads,omitempty"`
+	DownloadChunkMinMB          *int                      `json:"download_chunk_min_mb,omitempty"`
+	DownloadWorkers             *int                      `json:"download_workers,omitempty"`
+	DownloadRateLimitKbps       *int                      `json:"download_rate_limit_kbps,omitempty"`
+	MaxConcurrentTasks          *int                      `json:"max_concurrent_tasks,omitempty"`
+	StrictOrientation           *bool                     `json:"strict_orientation,omitempty"`
+	MaxRetries                  *int                      `json:"max_retries,omitempty"`
+	StuckAfterMinutes           *int                      `json:"stuck_after_minutes,omitempty"`
+	AutoRetryStuck              *bool                     `json:"auto_retry_stuck,omitempty"`
+	MaxTaskCount                *int                      `json:"max_task_count,omitempty"`
+	WebhookURL                  *string                   `json:"webhook_url,omitempty"`
+	WebhookMilestones           *[]int                    `json:"webhook_milestones,omitempty"`
+	PostDownloadTimeout         *int                      `json:"post_download_timeout,omitempty"`
+	PostDownloadStrict          *bool                     `json:"post_download_strict,omitempty"`
+	MaxImageDimension           *int                      `json:"max_image_dimension,omitempty"`
+	ModelFallbacks              map[string][]string       `json:"model_fallbacks,omitempty"`
+	Providers                   map[string]ProviderConfig `json:"providers,omitempty"`
+}
+
+// setField sets *dst to *value when the field was present in the request
+func setField[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// apply copies the fields present in the request to config
+// model_fallbacks and providers entries are added to or replace the current ones by name
+func (req *ConfigUpdateRequest) apply(config *Config) {
+	setField(&config.DyuAPIKey, req.DyuAPIKey)
+	setField(&config.DyuAPIKeys, req.DyuAPIKeys)
+	setField(&config.APIKeyCooldown, req.APIKeyCooldown)
+	setField(&config.DyuBaseURL, req.DyuBaseURL)
+	setField(&config.Port, req.Port)
+	setField(&config.PublicBaseURL, req.PublicBaseURL)
+	setField(&config.SelfSigned, req.SelfSigned)
+	setField(&config.AllowedOrigins, req.AllowedOrigins)
+	setField(&config.AuthToken, req.AuthToken)
+	setField(&config.ProxyURL, req.ProxyURL)
+	setField(&config.RequeueAuthFailures, req.RequeueAuthFailures)
+	setField(&config.PromptPrefix, req.PromptPrefix)
+	setField(&config.PromptSuffix, req.PromptSuffix)
+	setField(&config.ImplicitCharacterReferences, req.ImplicitCharacterReferences)
+	setField(&config.OutputDir, req.OutputDir)
+	setField(&config.RequestTimeout, req.RequestTimeout)
+	setField(&config.PollInterval, req.PollInterval)
+	setField(&config.ReconcileLookbackHours, req.ReconcileLookbackHours)
+	setField(&config.FailedTaskRetentionDays, req.FailedTaskRetentionDays)
+	setField(&config.CompletedTaskRetentionDays, req.CompletedTaskRetentionDays)
+	setField(&config.MinFreeSpaceMB, req.MinFreeSpaceMB)
+	setField(&config.DownloadThreads, req.DownloadThreads)
+	setField(&config.DownloadChunkMinMB, req.DownloadChunkMinMB)
+	setField(&config.DownloadWorkers, req.DownloadWorkers)
+	setField(&config.DownloadRateLimitKbps, req.DownloadRateLimitKbps)
+	setField(&config.MaxConcurrentTasks, req.MaxConcurrentTasks)
+	setField(&config.StrictOrientation, req.StrictOrientation)
+	setField(&config.MaxRetries, req.MaxRetries)
+	setField(&config.StuckAfterMinutes, req.StuckAfterMinutes)
+	setField(&config.AutoRetryStuck, req.AutoRetryStuck)
+	setField(&config.MaxTaskCount, req.MaxTaskCount)
+	setField(&config.WebhookURL, req.WebhookURL)
+	setField(&config.WebhookMilestones, req.WebhookMilestones)
+	setField(&config.PostDownloadTimeout, req.PostDownloadTimeout)
+	setField(&config.PostDownloadStrict, req.PostDownloadStrict)
+	setField(&config.MaxImageDimension, req.MaxImageDimension)
+	if req.ModelFallbacks != nil {
+		config.ModelFallbacks = maps.Clone(config.ModelFallbacks)
+		if config.ModelFallbacks == nil {
+			config.ModelFallbacks = make(map[string][]string, len(req.ModelFallbacks))
+		}
+		maps.Copy(config.ModelFallbacks, req.ModelFallbacks)
+	}
+	if req.Providers != nil {
+		config.Providers = maps.Clone(config.Providers)
+		if config.Providers == nil {
+			config.Providers = make(map[string]ProviderConfig, len(req.Providers))
+		}
+		maps.Copy(config.Providers, req.Providers)
+	}
+}
+
+// unmaskURL returns current when value is its masked form from GET /api/config, value otherwise
+func unmaskURL(value, current string, mask func(string) string) string {
+	value = strings.TrimSpace(value)
+	if current != "" && value == mask(current) {
+		return current
+	}
+	return value
+}
+
+// handleUpdateConfig handles PUT /api/config
+// Only the fields present in the body are changed; the API key, output_dir and poll_interval apply
+// immediately, port and proxy_url changes are saved and reported with restart_required
+func handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	configUpdateMu.Lock()
+	defer configUpdateMu.Unlock()
+
+	var req ConfigUpdateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	current := CurrentConfig()
+	updated := *current
+	// Don't unmask the keys in place in the slice shared with the current config
+	updated.DyuAPIKeys = slices.Clone(current.DyuAPIKeys)
+	req.apply(&updated)
+
+	// A client sending back the masked keys from GET keeps the current keys
+	updated.DyuAPIKey = unmaskKey(updated.DyuAPIKey, current)
+	for i, key := range updated.DyuAPIKeys {
+		updated.DyuAPIKeys[i] = unmaskKey(key, current)
+	}
+	for name, provider := range req.Providers {
+		provider.APIKeys = unmaskProviderKeys(provider.APIKeys, current.Providers[name].APIKeys)
+		updated.Providers[name] = provider
+	}
+	updated.AuthToken = strings.TrimSpace(updated.AuthToken)
+	if current.AuthToken != "" && updated.AuthToken == maskSecret(current.AuthToken) {
+		updated.AuthToken = current.AuthToken
+	}
+	updated.WebhookURL = unmaskURL(updated.WebhookURL, current.WebhookURL, maskSecret)
+	updated.ProxyURL = unmaskURL(updated.ProxyURL, current.ProxyURL, maskProxyURL)
+	if err := validateConfig(&updated); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	outputDirChanged := updated.OutputDir != current.OutputDir
+	if outputDirChanged {
+		if err := SetupOutputDirectory(updated.OutputDir); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Output directory unusable: %v", err))
+			return
+		}
+	}
+
+	if err := SaveConfig(&updated); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		if outputDirChanged {
+			SetupOutputDirectory(current.OutputDir)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to save config")
+		return
+	}
+
+	setCurrentConfig(&updated)
+	if taskProcessor != nil {
+		taskProcessor.ApplyConfig(&updated)
+	}
+	if outputDirChanged {
+		log.Printf("Videos are now saved to %s", OutputDirectory())
+		if err := RecordOutputDirectory(); err != nil {
+			log.Printf("Warning: failed to record the output directory: %v", err)
+		}
+	}
+	response := configResponse(&updated)
+	if !slices.Equal(updated.apiKeys(), current.apiKeys()) {
+		log.Println("API key updated")
+		requeued, available, err := HandleAPIKeyChange(&updated)
+		if err != nil {
+			log.Printf("Warning: failed to check API key change: %v", err)
+		}
+		response.Requeued = requeued
+		response.RequeueAvailable = available
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// ValidateKeyRequest represents the request body for POST /api/config/validate-key
+type ValidateKeyRequest struct {
+	DyuAPIKey string `json:"dyu_api_key"`
+}
+
+// ValidateKeyResponse represents the response of POST /api/config/validate-key
+type ValidateKeyResponse struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleValidateKey handles POST /api/config/validate-key
+// Checks a candidate API key against the Dyu API without changing the running configuration
+// A masked key returned by GET /api/config checks the matching configured key
+func handleValidateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req ValidateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	key := unmaskKey(req.DyuAPIKey, CurrentConfig())
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "dyu_api_key is required")
+		return
+	}
+
+	valid, upstream, err := ValidateAPIKey(r.Context(), dyuBaseURL(CurrentConfig()), key)
+	if err != nil {
+		log.Printf("API key validation failed: %v", err)
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("Failed to reach the API: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ValidateKeyResponse{Valid: valid, Error: upstream})
+}
+
+// apiKeyFingerprintSetting is the app_settings key storing the fingerprint of the last used API key
+const apiKeyFingerprintSetting = "api_key_fingerprint"
+
+// apiKeyFingerprint returns a short non-reversible identifier of an API key
+func apiKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// apiKeysFingerprint identifies a set of API keys independently of their order
+// A single key keeps the fingerprint recorded before key pools existed
+func apiKeysFingerprint(keys []string) string {
+	return apiKeyFingerprint(strings.Join(slices.Sorted(slices.Values(keys)), "\n"))
+}
+
+// HandleAPIKeyChange compares the configured API keys, dyu_api_key and dyu_api_keys, with the ones
+// recorded previously
+// When the key changed, tasks that failed with authentication errors are re-queued if
+// requeue_auth_failures is enabled, otherwise they are only reported
+// Returns the number of re-queued tasks, and of the ones left to re-queue when it's disabled
+func HandleAPIKeyChange(config *Config) (int64, int64, error) {
+	keys := config.apiKeys()
+	fingerprint := apiKeysFingerprint(keys)
+	previous, err := GetSetting(apiKeyFingerprintSetting)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := SetSetting(apiKeyFingerprintSetting, fingerprint); err != nil {
+		return 0, 0, err
+	}
+
+	// First start or unchanged key
+	if previous == "" || previous == fingerprint || len(keys) == 0 {
+		return 0, 0, nil
+	}
+
+	if !config.RequeueAuthFailures {
+		ids, err := GetAuthFailedTaskIDs()
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(ids) > 0 {
+			log.Printf("API密钥已更改，有 %d 个任务因认证错误失败。可调用 POST /api/tasks-requeue-auth 重新排队，或在config.json中启用 requeue_auth_failures", len(ids))
+		}
+		return 0, int64(len(ids)), nil
+	}
+
+	count, localPaths, err := RequeueAuthFailedTasks()
+	if err != nil {
+		return 0, 0, err
+	}
+	deleteRetryLeftovers(localPaths)
+	if count > 0 {
+		log.Printf("API密钥已更改，已将 %d 个认证失败的任务重新排队", count)
+	}
+	return count, 0, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// corsAllowedMethods and corsAllowedHeaders are answered to every CORS request
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, X-Api-Token"
+)
+
+// normalizeOrigin returns an origin as browsers send it: lowercase scheme://host[:port]
+func normalizeOrigin(origin string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("%q must be an http or https origin like https://videogen.example.com", origin)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// validateAllowedOrigins checks the allowed_origins of a configuration
+func validateAllowedOrigins(origins []string) error {
+	for _, origin := range origins {
+		if _, err := normalizeOrigin(origin); err != nil {
+			return fmt.Errorf("allowed_origins: %v", err)
+		}
+	}
+	return nil
+}
+
+// originAllowed reports whether a request from origin may use the API
+// Requests without an Origin header and same-origin requests (the frontend served by this server
+// sends one with module scripts and POSTs) are always allowed
+func originAllowed(config *Config, r *http.Request, origin string) bool {
+	if origin == "" || config == nil || len(config.AllowedOrigins) == 0 {
+		return true
+	}
+	normalized, err := normalizeOrigin(origin)
+	if err != nil {
+		return false
+	}
+	if u, _ := url.Parse(normalized); strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.ContainsFunc(config.AllowedOrigins, func(allowed string) bool {
+		allowed, err := normalizeOrigin(allowed)
+		return err == nil && allowed == normalized
+	})
+}
+
+// handleCORS sets the CORS headers of a request and answers it when it is a preflight or comes from
+// an origin outside allowed_origins (403)
+// Without allowed_origins any origin is allowed with a wildcard, for local single-user use
+// Returns whether the request is left for the handler to serve
+func handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	config := CurrentConfig()
+	origin := r.Header.Get("Origin")
+	if config == nil || len(config.AllowedOrigins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Add("Vary", "Origin")
+		if !originAllowed(config, r, origin) {
+			writeError(w, http.StatusForbidden, "Origin not allowed")
+			return false
+		}
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+	w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+	return true
+}
@@ -2,332 +2,279 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"path/filepath"
 	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-// DB is the global database connection
+// DB is the global database connection, every write goes through its single connection
 var DB *sql.DB
 
-// InitDB initializes the SQLite database and creates required tables
-func InitDB(dbPath string) error {
-	var err error
-	// Add connection parameters for better concurrency
-	// _busy_timeout: wait up to 5 seconds when database is locked
-	// _journal_mode=WAL: use Write-Ahead Logging for better concurrency
-	// _synchronous=NORMAL: balance between safety and performance
-	connStr := dbPath + "?_busy_timeout=5000&_journal_mode=WAL&_synchronous=NORMAL"
-	DB, err = sql.Open("sqlite", connStr)
+// ReadDB is the pool of read-only connections used by the Get* queries, so listing tasks doesn't
+// queue behind the processor's updates; WAL mode lets them read while DB writes
+// Same as DB for in-memory databases, whose connections would each get their own database
+var ReadDB *sql.DB
+
+const (
+	// ReadPoolSize is the number of connections of the read pool
+	ReadPoolSize = 4
+
+	// dbParams are the connection parameters of the writer
+	// busy_timeout: wait up to 5 seconds when database is locked
+	// journal_mode=WAL: readers and the writer don't block each other
+	// synchronous=NORMAL: balance between safety and performance
+	dbParams = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	// readOnlyParams are the connection parameters of the read pool, every write fails
+	readOnlyParams = "_pragma=busy_timeout(5000)&_pragma=query_only(1)"
+)
+
+// SchemaTooNewError is returned by InitDB when the database was written by a newer version
+type SchemaTooNewError struct {
+	Version   int
+	Supported int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the supported version %d (created by a newer videogen build)", e.Version, e.Supported)
+}
+
+// openPool opens a connection pool with the given connection string and size
+func openPool(connStr string, size int) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", connStr)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-
-	// Configure connection pool for better concurrency
-	DB.SetMaxOpenConns(1) // SQLite only supports one writer at a time
-	DB.SetMaxIdleConns(1)
-	DB.SetConnMaxLifetime(0) // Don't close idle connections
+	db.SetMaxOpenConns(size)
+	db.SetMaxIdleConns(size)
+	db.SetConnMaxLifetime(0) // Don't close idle connections
 
 	// Test the connection
-	if err = DB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
-	}
-
-	// Create tasks table if not exists
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS tasks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		task_id TEXT,
-		prompt TEXT NOT NULL,
-		image_url TEXT,
-		duration TEXT NOT NULL,
-		orientation TEXT NOT NULL,
-		model TEXT DEFAULT 'sora-2',
-		status TEXT DEFAULT 'pending',
-		progress INTEGER DEFAULT 0,
-		video_url TEXT,
-		local_path TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	_, err = DB.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create tasks table: %w", err)
-	}
-
-	// Remove UNIQUE constraint from task_id if it exists (for existing databases)
-	// SQLite doesn't support DROP CONSTRAINT, so we need to recreate the table
-	// For now, just try to drop the index if it exists
-	_, _ = DB.Exec("DROP INDEX IF EXISTS sqlite_autoindex_tasks_1")
-
-	// Add model column if it doesn't exist (for existing databases)
-	_, _ = DB.Exec("ALTER TABLE tasks ADD COLUMN model TEXT DEFAULT 'sora-2'")
-
-	// Add image_url2 column if it doesn't exist (for Veo3 second image)
-	_, _ = DB.Exec("ALTER TABLE tasks ADD COLUMN image_url2 TEXT")
-
-	// Add fail_reason column if it doesn't exist
-	_, _ = DB.Exec("ALTER TABLE tasks ADD COLUMN fail_reason TEXT")
-
-	// Create characters table if not exists (new schema for Sora2 Character Training API)
-	createCharactersTableSQL := `
-	CREATE TABLE IF NOT EXISTS characters (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		api_character_id TEXT,
-		username TEXT,
-		custom_name TEXT NOT NULL,
-		description TEXT,
-		source_type TEXT NOT NULL,
-		source_value TEXT NOT NULL,
-		timestamps TEXT NOT NULL,
-		status TEXT DEFAULT 'pending',
-		progress INTEGER DEFAULT 0,
-		fail_reason TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	_, err = DB.Exec(createCharactersTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create characters table: %w", err)
-	}
-
-	// Migrate old characters table schema to new schema if needed
-	migrateCharactersTable()
-
-	// Add username column if not exists
-	addUsernameColumn()
-
-	// Migration: Remove UNIQUE constraint from task_id
-	migrateTasksTable()
-
-	// Create indexes for better query performance (especially for large datasets)
-	// Index on created_at for faster sorting (ORDER BY created_at DESC)
-	_, _ = DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at DESC)")
-	// Index on status for faster filtering (WHERE status IN ...)
-	_, _ = DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status)")
-	// Composite index for common query pattern (status + created_at)
-	_, _ = DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_status_created ON tasks(status, created_at DESC)")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return db, nil
+}
 
+// openDB opens the writer connection with the given connection string
+// SQLite only supports one writer at a time; reads go through it too until openReadPool
+func openDB(connStr string) error {
+	db, err := openPool(connStr, 1)
+	if err != nil {
+		return err
+	}
+	DB = db
+	ReadDB = db
 	return nil
 }
 
-// migrateTasksTable removes UNIQUE constraint from task_id column
-// SQLite doesn't support ALTER TABLE DROP CONSTRAINT, so we need to recreate the table
-func migrateTasksTable() {
-	// Try to insert two rows with empty task_id to check if UNIQUE constraint exists
-	// If it fails, we need to migrate
-	_, err := DB.Exec("INSERT INTO tasks (task_id, prompt, duration, orientation) VALUES ('', 'test', '10s', 'landscape')")
-	if err == nil {
-		// First insert succeeded, try second
-		_, err2 := DB.Exec("INSERT INTO tasks (task_id, prompt, duration, orientation) VALUES ('', 'test2', '10s', 'landscape')")
-		// Clean up test rows
-		DB.Exec("DELETE FROM tasks WHERE prompt = 'test' OR prompt = 'test2'")
-		if err2 == nil {
-			// No UNIQUE constraint, no migration needed
-			return
-		}
-	} else {
-		// Clean up if first insert somehow succeeded
-		DB.Exec("DELETE FROM tasks WHERE prompt = 'test'")
+// openReadPool opens the read pool on the database file
+// In-memory databases keep reading through the writer connection
+func openReadPool(dbPath string) error {
+	if dbPath == ":memory:" || strings.Contains(dbPath, "mode=memory") {
+		return nil
 	}
-
-	// Need to migrate - recreate table without UNIQUE constraint
-	log.Println("Migrating tasks table to remove UNIQUE constraint on task_id...")
-
-	tx, err := DB.Begin()
+	pool, err := openPool(dbPath+"?"+readOnlyParams, ReadPoolSize)
 	if err != nil {
-		log.Printf("Migration failed to start transaction: %v", err)
-		return
+		return fmt.Errorf("failed to open read pool: %w", err)
 	}
-	defer tx.Rollback()
+	ReadDB = pool
+	return nil
+}
 
-	// Create new table without UNIQUE constraint
-	_, err = tx.Exec(`
-		CREATE TABLE IF NOT EXISTS tasks_new (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			task_id TEXT,
-			prompt TEXT NOT NULL,
-			image_url TEXT,
-			duration TEXT NOT NULL,
-			orientation TEXT NOT NULL,
-			model TEXT DEFAULT 'sora-2',
-			status TEXT DEFAULT 'pending',
-			progress INTEGER DEFAULT 0,
-			video_url TEXT,
-			local_path TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			image_url2 TEXT
-		)`)
-	if err != nil {
-		log.Printf("Migration failed to create new table: %v", err)
-		return
+// GetSchemaVersion returns the schema version recorded in the database
+func GetSchemaVersion() (int, error) {
+	var version int
+	if err := DB.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
 	}
+	return version, nil
+}
 
-	// Copy data - explicitly specify columns to handle column order differences
-	_, err = tx.Exec(`INSERT INTO tasks_new (id, task_id, prompt, image_url, duration, orientation, model, status, progress, video_url, local_path, created_at, updated_at, image_url2)
-		SELECT id, task_id, prompt, image_url, duration, orientation, model, status, progress, video_url, local_path, created_at, updated_at, image_url2 FROM tasks`)
-	if err != nil {
-		log.Printf("Migration failed to copy data: %v", err)
-		return
+// InitDB initializes the SQLite database and creates required tables
+// Returns a *SchemaTooNewError without touching the schema when the database is newer than this build
+func InitDB(dbPath string) error {
+	if err := openDB(dbPath + "?" + dbParams); err != nil {
+		return err
 	}
 
-	// Drop old table
-	_, err = tx.Exec(`DROP TABLE tasks`)
+	// Refuse to run the migrations against a schema from a newer version
+	version, err := GetSchemaVersion()
 	if err != nil {
-		log.Printf("Migration failed to drop old table: %v", err)
-		return
+		return err
+	}
+	if version > SchemaVersion {
+		CloseDB()
+		return &SchemaTooNewError{Version: version, Supported: SchemaVersion}
 	}
 
-	// Rename new table
-	_, err = tx.Exec(`ALTER TABLE tasks_new RENAME TO tasks`)
-	if err != nil {
-		log.Printf("Migration failed to rename table: %v", err)
-		return
+	if err := runMigrations(); err != nil {
+		CloseDB()
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Migration failed to commit: %v", err)
-		return
+	if err := setupTaskSearch(); err != nil {
+		CloseDB()
+		return err
 	}
-	log.Println("Migration completed successfully")
-}
 
-// addUsernameColumn adds the username column to characters table if it doesn't exist
-func addUsernameColumn() {
-	// Check if username column exists
-	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('characters') WHERE name='username'").Scan(&count)
-	if err != nil || count > 0 {
-		// Column already exists or error checking
-	} else {
-		log.Println("Adding username column to characters table...")
-		_, err = DB.Exec("ALTER TABLE characters ADD COLUMN username TEXT")
-		if err != nil {
-			log.Printf("Warning: Failed to add username column: %v", err)
-		} else {
-			log.Println("Username column added successfully")
+	if version < SchemaVersion {
+		if _, err := DB.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+			return fmt.Errorf("failed to set schema version: %w", err)
 		}
 	}
 
-	// Check if avatar_url column exists
-	err = DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('characters') WHERE name='avatar_url'").Scan(&count)
-	if err != nil || count > 0 {
-		// Column already exists or error checking
-		return
+	// Readers are opened once the schema is up to date
+	if err := openReadPool(dbPath); err != nil {
+		CloseDB()
+		return err
 	}
+	return nil
+}
 
-	log.Println("Adding avatar_url column to characters table...")
-	_, err = DB.Exec("ALTER TABLE characters ADD COLUMN avatar_url TEXT")
-	if err != nil {
-		log.Printf("Warning: Failed to add avatar_url column: %v", err)
-		return
+// OpenDBReadOnly opens an existing database without running any migrations
+// Used to browse a database created by a newer version; every write fails
+func OpenDBReadOnly(dbPath string) error {
+	if err := openDB(dbPath + "?" + readOnlyParams); err != nil {
+		return err
+	}
+	if err := openReadPool(dbPath); err != nil {
+		CloseDB()
+		return err
 	}
-	log.Println("Avatar_url column added successfully")
+	return nil
 }
 
-// migrateCharactersTable migrates the characters table from old schema to new schema
-// Old schema: api_id, api_username, profile_picture_url, permalink, from_task_id, local_picture_path
-// New schema: api_character_id, source_type, source_value, status, progress, fail_reason
-func migrateCharactersTable() {
-	// Check if old schema exists by looking for api_id column
-	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('characters') WHERE name='api_id'").Scan(&count)
-	if err != nil || count == 0 {
-		// No old schema, no migration needed
-		return
+// CloseDB closes the read pool and the writer connection
+func CloseDB() error {
+	if ReadDB != nil && ReadDB != DB {
+		ReadDB.Close()
+	}
+	if DB != nil {
+		return DB.Close()
 	}
+	return nil
+}
 
-	log.Println("Migrating characters table to new schema...")
+// CreateTask inserts a new task into the database
+func CreateTask(req *CreateTaskRequest) (*Task, error) {
+	return insertTask(DB, req)
+}
 
+// CreateTasks inserts several tasks and links the characters used by each, in a single transaction
+// characterIDs holds the characters of each request, it may be nil
+func CreateTasks(reqs []*CreateTaskRequest, characterIDs [][]int64) ([]*Task, error) {
 	tx, err := DB.Begin()
 	if err != nil {
-		log.Printf("Characters migration failed to start transaction: %v", err)
-		return
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Create new table with new schema
-	_, err = tx.Exec(`
-		CREATE TABLE IF NOT EXISTS characters_new (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			api_character_id TEXT,
-			custom_name TEXT NOT NULL,
-			description TEXT,
-			source_type TEXT NOT NULL,
-			source_value TEXT NOT NULL,
-			timestamps TEXT NOT NULL,
-			status TEXT DEFAULT 'pending',
-			progress INTEGER DEFAULT 0,
-			fail_reason TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`)
-	if err != nil {
-		log.Printf("Characters migration failed to create new table: %v", err)
-		return
+	now := time.Now()
+	tasks := make([]*Task, 0, len(reqs))
+	for i, req := range reqs {
+		task, err := insertTask(tx, req)
+		if err != nil {
+			return nil, err
+		}
+		if i < len(characterIDs) {
+			if err := linkTaskCharacters(tx, task.ID, characterIDs[i], now); err != nil {
+				return nil, err
+			}
+		}
+		tasks = append(tasks, task)
 	}
 
-	// Copy data from old table to new table
-	// Map old fields to new fields:
-	// - api_id -> api_character_id
-	// - from_task_id -> source_value (with source_type='task')
-	// - Set status='completed' for existing characters (they were already created)
-	// - Set progress=100 for existing characters
-	_, err = tx.Exec(`
-		INSERT INTO characters_new (id, api_character_id, custom_name, description, source_type, source_value, timestamps, status, progress, created_at)
-		SELECT id, api_id, custom_name, description, 'task', from_task_id, timestamps, 'completed', 100, created_at
-		FROM characters`)
-	if err != nil {
-		log.Printf("Characters migration failed to copy data: %v", err)
-		return
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tasks: %w", err)
 	}
+	return tasks, nil
+}
 
-	// Drop old table
-	_, err = tx.Exec(`DROP TABLE characters`)
+// insertTask inserts a new pending task through db or a transaction
+func insertTask(db execer, req *CreateTaskRequest) (*Task, error) {
+	now := time.Now()
+	model := req.Model
+	if model == "" {
+		model = ModelSora2
+	}
+	// Without conversion the prompt is the original one
+	originalPrompt := req.OriginalPrompt
+	if originalPrompt == "" {
+		originalPrompt = req.Prompt
+	}
+	result, err := db.Exec(`
+		INSERT INTO tasks (prompt, original_prompt, image_url, image_url2, duration, orientation, model, status, progress,
+			no_decorate, prompt_prefix, prompt_suffix, priority, scheduled_at, parent_task_id, batch_id, watermark, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Prompt, originalPrompt, req.ImageURL, req.ImageURL2, req.Duration, req.Orientation, model, StatusPending, 0,
+		req.NoDecorate, req.PromptPrefix, req.PromptSuffix, req.Priority, req.ScheduledAt, nullableID(req.ParentTaskID),
+		req.BatchID, req.Watermark, now, now)
 	if err != nil {
-		log.Printf("Characters migration failed to drop old table: %v", err)
-		return
+		return nil, fmt.Errorf("failed to insert task: %w", err)
 	}
 
-	// Rename new table
-	_, err = tx.Exec(`ALTER TABLE characters_new RENAME TO characters`)
+	id, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("Characters migration failed to rename table: %v", err)
-		return
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Characters migration failed to commit: %v", err)
-		return
+	for _, tag := range req.Tags {
+		if _, err := db.Exec("INSERT OR IGNORE INTO task_tags (task_id, tag) VALUES (?, ?)", id, tag); err != nil {
+			return nil, fmt.Errorf("failed to tag task %d: %w", id, err)
+		}
 	}
-	log.Println("Characters migration completed successfully")
+
+	return &Task{
+		ID:             id,
+		Prompt:         req.Prompt,
+		OriginalPrompt: originalPrompt,
+		ImageURL:       req.ImageURL,
+		ImageURL2:      req.ImageURL2,
+		Duration:       req.Duration,
+		Orientation:    req.Orientation,
+		Model:          model,
+		Status:         StatusPending,
+		Progress:       0,
+		NoDecorate:     req.NoDecorate,
+		PromptPrefix:   req.PromptPrefix,
+		PromptSuffix:   req.PromptSuffix,
+		Priority:       req.Priority,
+		ScheduledAt:    req.ScheduledAt,
+		ParentTaskID:   req.ParentTaskID,
+		BatchID:        req.BatchID,
+		Watermark:      req.Watermark,
+		Tags:           req.Tags,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
 }
 
-// CloseDB closes the database connection
-func CloseDB() error {
-	if DB != nil {
-		return DB.Close()
+// nullableID stores a zero ID as NULL
+func nullableID(id int64) interface{} {
+	if id == 0 {
+		return nil
 	}
-	return nil
+	return id
 }
 
-// CreateTask inserts a new task into the database
-func CreateTask(req *CreateTaskRequest) (*Task, error) {
+// CreateDerivedTask inserts a completed task whose video was produced locally from another task
+// (e.g. a trimmed clip); prompt and generation options are copied from the parent, parent_task_id
+// points at it
+func CreateDerivedTask(parent *Task, localPath string) (*Task, error) {
 	now := time.Now()
-	model := req.Model
-	if model == "" {
-		model = ModelSora2
-	}
 	result, err := DB.Exec(`
-		INSERT INTO tasks (prompt, image_url, image_url2, duration, orientation, model, status, progress, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		req.Prompt, req.ImageURL, req.ImageURL2, req.Duration, req.Orientation, model, StatusPending, 0, now, now)
+		INSERT INTO tasks (prompt, original_prompt, duration, orientation, model, status, progress, local_path, parent_task_id,
+			created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		parent.Prompt, parent.OriginalPrompt, parent.Duration, parent.Orientation, parent.Model, StatusCompleted, 100, localPath,
+		parent.ID, now, now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert task: %w", err)
+		return nil, fmt.Errorf("failed to insert derived task: %w", err)
 	}
 
 	id, err := result.LastInsertId()
@@ -336,53 +283,101 @@ func CreateTask(req *CreateTaskRequest) (*Task, error) {
 	}
 
 	return &Task{
-		ID:          id,
-		Prompt:      req.Prompt,
-		ImageURL:    req.ImageURL,
-		ImageURL2:   req.ImageURL2,
-		Duration:    req.Duration,
-		Orientation: req.Orientation,
-		Model:       model,
-		Status:      StatusPending,
-		Progress:    0,
-		CreatedAt:   now,
-		UpdatedAt:   now,
+		ID:             id,
+		Prompt:         parent.Prompt,
+		OriginalPrompt: parent.OriginalPrompt,
+		Duration:       parent.Duration,
+		Orientation:    parent.Orientation,
+		Model:          parent.Model,
+		Status:         StatusCompleted,
+		Progress:       100,
+		LocalPath:      localPath,
+		ParentTaskID:   parent.ID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
 	}, nil
 }
 
-// GetTask retrieves a single task by ID
-func GetTask(id int64) (*Task, error) {
-	task := &Task{}
-	var imageURL, imageURL2, videoURL, localPath, taskID, model, failReason sql.NullString
+// CreateComposeTask inserts the processing task of a compose job concatenating the videos of inputs
+func CreateComposeTask(inputs []Task, prompt string) (*Task, error) {
+	now := time.Now()
+	first := inputs[0]
+	result, err := DB.Exec(`
+		INSERT INTO tasks (prompt, original_prompt, duration, orientation, model, status, progress, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		prompt, prompt, first.Duration, first.Orientation, ModelCompose, StatusProcessing, 0, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert compose task: %w", err)
+	}
 
-	err := DB.QueryRow(`
-		SELECT id, task_id, prompt, image_url, COALESCE(image_url2, '') as image_url2, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks WHERE id = ?`, id).Scan(
-		&task.ID, &taskID, &task.Prompt, &imageURL, &imageURL2, &task.Duration, &task.Orientation, &model,
-		&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
+	id, err := result.LastInsertId()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to get task: %w", err)
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
 
-	task.TaskID = taskID.String
-	task.ImageURL = imageURL.String
-	task.ImageURL2 = imageURL2.String
-	task.VideoURL = videoURL.String
-	task.LocalPath = localPath.String
-	task.Model = model.String
-	task.FailReason = failReason.String
+	return &Task{
+		ID:             id,
+		Prompt:         prompt,
+		OriginalPrompt: prompt,
+		Duration:       first.Duration,
+		Orientation:    first.Orientation,
+		Model:          ModelCompose,
+		Status:         StatusProcessing,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
+}
 
-	return task, nil
+// taskColumns is the column list shared by task queries, scanned by scanTask
+// The image columns are excluded for performance (base64 images are large)
+const taskColumns = `id, COALESCE(task_id, '') as task_id, prompt, duration, orientation, COALESCE(model, 'sora-2') as model,
+		status, progress, COALESCE(video_url, '') as video_url, COALESCE(local_path, '') as local_path,
+		COALESCE(fail_reason, '') as fail_reason, created_at, updated_at,
+		COALESCE(no_decorate, 0) as no_decorate, COALESCE(submitted_prompt, '') as submitted_prompt,
+		COALESCE(warning, '') as warning, COALESCE(warning_message, '') as warning_message,
+		COALESCE(retries, 0) as retries, COALESCE(starred, 0) as starred, COALESCE(priority, 0) as priority,
+		COALESCE(milestones_fired, 0) as milestones_fired, COALESCE(milestones_fired_high, 0) as milestones_fired_high,
+		COALESCE(api_key_fingerprint, '') as api_key_fingerprint,
+		COALESCE(parent_task_id, 0) as parent_task_id, scheduled_at, COALESCE(batch_id, '') as batch_id,
+		COALESCE(watermark, 0) as watermark, COALESCE(model_used, '') as model_used,
+		COALESCE(thumbnail, '') as thumbnail, COALESCE(duration_seconds, 0) as duration_seconds,
+		COALESCE(width, 0) as width, COALESCE(height, 0) as height, COALESCE(file_size_bytes, 0) as file_size_bytes,
+		COALESCE(download_progress, 0) as download_progress, COALESCE(original_prompt, '') as original_prompt,
+		COALESCE(failure_code, '') as failure_code, COALESCE(prompt_prefix, '') as prompt_prefix,
+		COALESCE(prompt_suffix, '') as prompt_suffix`
+
+// taskImageColumns is appended to taskColumns by queries that need the images
+const taskImageColumns = `, COALESCE(image_url, '') as image_url, COALESCE(image_url2, '') as image_url2`
+
+// scanTask scans a row selected with taskColumns (plus taskImageColumns when withImages is set)
+func scanTask(row rowScanner, withImages bool) (*Task, error) {
+	var task Task
+	dest := []interface{}{
+		&task.ID, &task.TaskID, &task.Prompt, &task.Duration, &task.Orientation, &task.Model,
+		&task.Status, &task.Progress, &task.VideoURL, &task.LocalPath,
+		&task.FailReason, &task.CreatedAt, &task.UpdatedAt,
+		&task.NoDecorate, &task.SubmittedPrompt,
+		&task.Warning, &task.WarningMessage,
+		&task.Retries, &task.Starred, &task.Priority,
+		&task.MilestonesFired[0], &task.MilestonesFired[1], &task.APIKeyFingerprint,
+		&task.ParentTaskID, &task.ScheduledAt, &task.BatchID,
+		&task.Watermark, &task.ModelUsed, &task.Thumbnail,
+		&task.DurationSeconds, &task.Width, &task.Height, &task.FileSizeBytes,
+		&task.DownloadProgress, &task.OriginalPrompt,
+		&task.FailureCode, &task.PromptPrefix, &task.PromptSuffix,
+	}
+	if withImages {
+		dest = append(dest, &task.ImageURL, &task.ImageURL2)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &task, nil
 }
 
-// GetAllTasks retrieves all tasks from the database (without image_url for performance)
-func GetAllTasks() ([]Task, error) {
-	rows, err := DB.Query(`
-		SELECT id, task_id, prompt, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks ORDER BY created_at DESC`)
+// queryTasks runs a query selecting taskColumns and scans all resulting rows
+func queryTasks(withImages bool, query string, args ...interface{}) ([]Task, error) {
+	rows, err := ReadDB.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query tasks: %w", err)
 	}
@@ -390,129 +385,344 @@ func GetAllTasks() ([]Task, error) {
 
 	var tasks []Task
 	for rows.Next() {
-		var task Task
-		var videoURL, localPath, taskID, model, failReason sql.NullString
-
-		err := rows.Scan(
-			&task.ID, &taskID, &task.Prompt, &task.Duration, &task.Orientation, &model,
-			&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
+		task, err := scanTask(rows, withImages)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan task: %w", err)
 		}
-
-		task.TaskID = taskID.String
-		task.VideoURL = videoURL.String
-		task.LocalPath = localPath.String
-		task.Model = model.String
-		task.FailReason = failReason.String
-		// image_url is intentionally not loaded for performance (base64 images are large)
-
-		tasks = append(tasks, task)
+		tasks = append(tasks, *task)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating tasks: %w", err)
 	}
+	rows.Close()
 
+	if err := attachTaskTags(tasks); err != nil {
+		return nil, err
+	}
 	return tasks, nil
 }
 
-// GetTasksPaginated retrieves tasks with pagination (without image_url for performance)
-func GetTasksPaginated(limit, offset int) ([]Task, int, error) {
-	// Get total count
-	var total int
-	err := DB.QueryRow("SELECT COUNT(*) FROM tasks").Scan(&total)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
+// attachTaskTags loads the tags of the given tasks in a single query
+func attachTaskTags(tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	index := make(map[int64]int, len(tasks))
+	placeholders := make([]string, len(tasks))
+	args := make([]interface{}, len(tasks))
+	for i, task := range tasks {
+		index[task.ID] = i
+		placeholders[i] = "?"
+		args[i] = task.ID
 	}
 
-	rows, err := DB.Query(`
-		SELECT id, task_id, prompt, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks ORDER BY created_at DESC LIMIT ? OFFSET ?`, limit, offset)
+	rows, err := ReadDB.Query(fmt.Sprintf("SELECT task_id, tag FROM task_tags WHERE task_id IN (%s) ORDER BY tag",
+		strings.Join(placeholders, ",")), args...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to query tasks: %w", err)
+		return fmt.Errorf("failed to query task tags: %w", err)
 	}
 	defer rows.Close()
 
-	var tasks []Task
 	for rows.Next() {
-		var task Task
-		var videoURL, localPath, taskID, model, failReason sql.NullString
+		var taskID int64
+		var tag string
+		if err := rows.Scan(&taskID, &tag); err != nil {
+			return fmt.Errorf("failed to scan task tag: %w", err)
+		}
+		if i, ok := index[taskID]; ok {
+			tasks[i].Tags = append(tasks[i].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
 
-		err := rows.Scan(
-			&task.ID, &taskID, &task.Prompt, &task.Duration, &task.Orientation, &model,
-			&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to scan task: %w", err)
+// GetTask retrieves a single task by ID
+func GetTask(id int64) (*Task, error) {
+	task, err := scanTask(ReadDB.QueryRow(`SELECT `+taskColumns+taskImageColumns+` FROM tasks WHERE id = ?`, id), true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	tasks := []Task{*task}
+	if err := attachTaskTags(tasks); err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+// GetTaskByLocalPath retrieves the task whose video is stored under the given file name
+// Returns nil when no task references the file
+func GetTaskByLocalPath(localPath string) (*Task, error) {
+	task, err := scanTask(ReadDB.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE local_path = ? ORDER BY id DESC LIMIT 1`, localPath), false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	return task, nil
+}
 
-		task.TaskID = taskID.String
-		task.VideoURL = videoURL.String
-		task.LocalPath = localPath.String
-		task.Model = model.String
-		task.FailReason = failReason.String
+// TaskFilter selects and orders the tasks listed by GetTasks and GetTasksPaginated; zero fields
+// don't filter, and tasks are listed newest first by default
+type TaskFilter struct {
+	Search    string   // Every whitespace separated term must occur in the prompt
+	Statuses  []string // Any of these statuses
+	Models    []string // Any of these models, tasks without a model count as sora-2
+	Warning   string   // Warning code, e.g. orientation_mismatch
+	Starred   *bool    // Only starred or only unstarred tasks
+	BatchID   string   // Tasks created together by one request
+	StartDate string   // Created on or after this day, YYYY-MM-DD in local time
+	EndDate   string   // Created on or before this day, YYYY-MM-DD in local time
+	SortBy    string   // One of TaskSortFields, created_at when empty
+	Ascending bool
+
+	// Bounds on the probed video, tasks without metadata never match a set bound
+	MinDurationSeconds float64
+	MaxDurationSeconds float64
+	MinWidth           int
+	MinHeight          int
+}
 
-		tasks = append(tasks, task)
+// TaskSortFields are the columns tasks can be sorted by
+// Only these names are ever interpolated into ORDER BY
+var TaskSortFields = []string{"created_at", "updated_at", "status", "progress"}
+
+// IsTaskSortField reports whether tasks can be sorted by field
+func IsTaskSortField(field string) bool {
+	for _, allowed := range TaskSortFields {
+		if field == allowed {
+			return true
+		}
 	}
+	return false
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating tasks: %w", err)
+// orderBy returns the ORDER BY clause of the filter
+// Fields with many equal values get the ID as tie-breaker so pages are stable
+func (f TaskFilter) orderBy() string {
+	field := f.SortBy
+	if !IsTaskSortField(field) {
+		field = "created_at"
+	}
+	direction := "DESC"
+	if f.Ascending {
+		direction = "ASC"
+	}
+	if field == "status" || field == "progress" {
+		return " ORDER BY " + field + " " + direction + ", id " + direction
 	}
+	return " ORDER BY " + field + " " + direction
+}
 
-	return tasks, total, nil
+// placeholders returns n comma separated "?" for an IN clause
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
 }
 
-// GetTasksByStatus retrieves tasks with specific statuses (for polling pending tasks)
-func GetTasksByStatus(statuses []string) ([]Task, error) {
-	if len(statuses) == 0 {
-		return []Task{}, nil
+// taskDateLayout is the format of the day bounds of TaskFilter
+const taskDateLayout = "2006-01-02"
+
+// nextDay returns the day after a YYYY-MM-DD date
+func nextDay(date string) string {
+	day, err := time.Parse(taskDateLayout, date)
+	if err != nil {
+		return date
 	}
+	return day.AddDate(0, 0, 1).Format(taskDateLayout)
+}
 
-	// Build query with placeholders
-	placeholders := make([]string, len(statuses))
-	args := make([]interface{}, len(statuses))
-	for i, s := range statuses {
-		placeholders[i] = "?"
-		args[i] = s
+// where returns the WHERE clause, including the keyword, and its arguments
+func (f TaskFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if strings.TrimSpace(f.Search) != "" {
+		condition, searchArgs := taskSearchCondition(f.Search)
+		conditions = append(conditions, condition)
+		args = append(args, searchArgs...)
+	}
+	if len(f.Statuses) > 0 {
+		conditions = append(conditions, "status IN ("+placeholders(len(f.Statuses))+")")
+		for _, status := range f.Statuses {
+			args = append(args, status)
+		}
 	}
+	if len(f.Models) > 0 {
+		conditions = append(conditions, "COALESCE(NULLIF(model, ''), ?) IN ("+placeholders(len(f.Models))+")")
+		args = append(args, ModelSora2)
+		for _, model := range f.Models {
+			args = append(args, model)
+		}
+	}
+	if f.Warning != "" {
+		conditions = append(conditions, "warning = ?")
+		args = append(args, f.Warning)
+	}
+	if f.Starred != nil {
+		conditions = append(conditions, "COALESCE(starred, 0) = ?")
+		args = append(args, *f.Starred)
+	}
+	if f.BatchID != "" {
+		conditions = append(conditions, "batch_id = ?")
+		args = append(args, f.BatchID)
+	}
+	// created_at is stored as RFC 3339 text in the server's local time, which SQLite's date()
+	// can't parse; its leading YYYY-MM-DD is the local day, so days compare as string prefixes
+	// and the created_at index is used
+	if f.StartDate != "" {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.StartDate)
+	}
+	if f.EndDate != "" {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, nextDay(f.EndDate))
+	}
+	if f.MinDurationSeconds > 0 {
+		conditions = append(conditions, "duration_seconds >= ?")
+		args = append(args, f.MinDurationSeconds)
+	}
+	if f.MaxDurationSeconds > 0 {
+		conditions = append(conditions, "duration_seconds > 0 AND duration_seconds <= ?")
+		args = append(args, f.MaxDurationSeconds)
+	}
+	if f.MinWidth > 0 {
+		conditions = append(conditions, "width >= ?")
+		args = append(args, f.MinWidth)
+	}
+	if f.MinHeight > 0 {
+		conditions = append(conditions, "height >= ?")
+		args = append(args, f.MinHeight)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
 
-	query := fmt.Sprintf(`
-		SELECT id, task_id, prompt, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks WHERE status IN (%s) ORDER BY created_at DESC`,
-		strings.Join(placeholders, ","))
+// GetTasks retrieves all tasks matching the filter, newest first
+func GetTasks(filter TaskFilter) ([]Task, error) {
+	where, args := filter.where()
+	return queryTasks(false, `SELECT `+taskColumns+` FROM tasks`+where+filter.orderBy(), args...)
+}
+
+const (
+	// TombstoneRetention is how long the IDs of deleted tasks are kept for updated_since polls,
+	// a client whose last poll is older must reload the whole list
+	TombstoneRetention = 7 * 24 * time.Hour
+	// sinceMargin widens the SQL bound of updated_since queries, the stored time strings are in the
+	// local time of their write and only compare reliably within a DST shift; matches are then
+	// checked in Go
+	sinceMargin = time.Hour
+)
+
+// sinceBound returns the string compared with the stored times to preselect rows after t
+func sinceBound(t time.Time) string {
+	return t.Add(-sinceMargin).Local().Format("2006-01-02 15:04:05")
+}
+
+// GetTasksUpdatedSince retrieves the tasks matching the filter whose updated_at is strictly after since
+func GetTasksUpdatedSince(filter TaskFilter, since time.Time) ([]Task, error) {
+	where, args := filter.where()
+	if where == "" {
+		where = " WHERE updated_at >= ?"
+	} else {
+		where += " AND updated_at >= ?"
+	}
+	tasks, err := queryTasks(false, `SELECT `+taskColumns+` FROM tasks`+where+filter.orderBy(), append(args, sinceBound(since))...)
+	if err != nil {
+		return nil, err
+	}
+	var updated []Task
+	for _, task := range tasks {
+		if task.UpdatedAt.After(since) {
+			updated = append(updated, task)
+		}
+	}
+	return updated, nil
+}
+
+// recordTombstone remembers the ID of a deleted task and drops the tombstones past TombstoneRetention
+func recordTombstone(db execer, id int64) error {
+	now := time.Now()
+	if _, err := db.Exec("INSERT OR REPLACE INTO task_tombstones (task_id, deleted_at) VALUES (?, ?)", id, now); err != nil {
+		return fmt.Errorf("failed to record deleted task: %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM task_tombstones WHERE deleted_at < ?", sinceBound(now.Add(-TombstoneRetention))); err != nil {
+		return fmt.Errorf("failed to prune deleted tasks: %w", err)
+	}
+	return nil
+}
 
-	rows, err := DB.Query(query, args...)
+// GetDeletedTaskIDsSince returns the IDs of the tasks deleted strictly after since
+func GetDeletedTaskIDsSince(since time.Time) ([]int64, error) {
+	rows, err := ReadDB.Query("SELECT task_id, deleted_at FROM task_tombstones WHERE deleted_at >= ? ORDER BY task_id", sinceBound(since))
 	if err != nil {
-		return nil, fmt.Errorf("failed to query tasks by status: %w", err)
+		return nil, fmt.Errorf("failed to get deleted tasks: %w", err)
 	}
 	defer rows.Close()
 
-	var tasks []Task
+	ids := []int64{}
 	for rows.Next() {
-		var task Task
-		var videoURL, localPath, taskID, model, failReason sql.NullString
-
-		err := rows.Scan(
-			&task.ID, &taskID, &task.Prompt, &task.Duration, &task.Orientation, &model,
-			&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan task: %w", err)
+		var id int64
+		var deletedAt time.Time
+		if err := rows.Scan(&id, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan deleted task: %w", err)
+		}
+		if deletedAt.After(since) {
+			ids = append(ids, id)
 		}
+	}
+	return ids, rows.Err()
+}
 
-		task.TaskID = taskID.String
-		task.VideoURL = videoURL.String
-		task.LocalPath = localPath.String
-		task.Model = model.String
-		task.FailReason = failReason.String
+// GetAllTasks retrieves all tasks from the database (without image_url for performance)
+func GetAllTasks() ([]Task, error) {
+	return GetTasks(TaskFilter{})
+}
 
-		tasks = append(tasks, task)
+// GetTasksPaginated retrieves a page of the tasks matching the filter (without image_url for performance)
+// The total is the number of matching tasks
+func GetTasksPaginated(filter TaskFilter, limit, offset int) ([]Task, int, error) {
+	where, args := filter.where()
+
+	// Get total count
+	var total int
+	err := ReadDB.QueryRow("SELECT COUNT(*) FROM tasks"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating tasks: %w", err)
+	tasks, err := queryTasks(false, `SELECT `+taskColumns+` FROM tasks`+where+filter.orderBy()+` LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return tasks, nil
+	return tasks, total, nil
+}
+
+// GetTasksByStatus retrieves tasks with specific statuses (for polling pending tasks)
+func GetTasksByStatus(statuses []string) ([]Task, error) {
+	if len(statuses) == 0 {
+		return []Task{}, nil
+	}
+
+	// Build query with placeholders
+	placeholders := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
+	for i, s := range statuses {
+		placeholders[i] = "?"
+		args[i] = s
+	}
+
+	query := fmt.Sprintf(`SELECT `+taskColumns+` FROM tasks WHERE status IN (%s) ORDER BY created_at DESC`,
+		strings.Join(placeholders, ","))
+
+	return queryTasks(false, query, args...)
 }
 
 // GetTasksByIds retrieves tasks by their IDs (for polling specific tasks)
@@ -529,53 +739,223 @@ func GetTasksByIds(ids []int64) ([]Task, error) {
 		args[i] = id
 	}
 
-	query := fmt.Sprintf(`
-		SELECT id, task_id, prompt, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks WHERE id IN (%s) ORDER BY created_at DESC`,
+	query := fmt.Sprintf(`SELECT `+taskColumns+` FROM tasks WHERE id IN (%s) ORDER BY created_at DESC`,
 		strings.Join(placeholders, ","))
 
-	rows, err := DB.Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query tasks by IDs: %w", err)
-	}
-	defer rows.Close()
-
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		var videoURL, localPath, taskID, model, failReason sql.NullString
-
-		err := rows.Scan(
-			&task.ID, &taskID, &task.Prompt, &task.Duration, &task.Orientation, &model,
-			&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan task: %w", err)
-		}
+	return queryTasks(false, query, args...)
+}
 
-		task.TaskID = taskID.String
-		task.VideoURL = videoURL.String
-		task.LocalPath = localPath.String
-		task.Model = model.String
-		task.FailReason = failReason.String
+// GetTasksAfter retrieves up to limit tasks with an ID greater than afterID in ID order
+// Used to page through the whole table, e.g. for exports
+func GetTasksAfter(afterID int64, limit int, withImages bool) ([]Task, error) {
+	columns := taskColumns
+	if withImages {
+		columns += taskImageColumns
+	}
+	return queryTasks(withImages, `SELECT `+columns+` FROM tasks WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+}
 
-		tasks = append(tasks, task)
+// GetFilteredTasksAfter retrieves up to limit tasks matching the filter with an ID greater than
+// afterID, in ID order and without images; the filter's sort order is ignored
+func GetFilteredTasksAfter(filter TaskFilter, afterID int64, limit int) ([]Task, error) {
+	where, args := filter.where()
+	if where == "" {
+		where = " WHERE id > ?"
+	} else {
+		where += " AND id > ?"
 	}
+	return queryTasks(false, `SELECT `+taskColumns+` FROM tasks`+where+` ORDER BY id LIMIT ?`, append(args, afterID, limit)...)
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating tasks: %w", err)
+// ImportTasks inserts exported tasks as new rows in a single transaction
+// Tasks whose API task_id already exists are skipped, interrupted submissions are imported as pending
+// Returns the number of imported and skipped tasks
+func ImportTasks(tasks []Task) (int, int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to start transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return tasks, nil
-}
+	imported, skipped := 0, 0
+	for _, task := range tasks {
+		if task.TaskID != "" {
+			var exists int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM tasks WHERE task_id = ?", task.TaskID).Scan(&exists); err != nil {
+				return 0, 0, fmt.Errorf("failed to check task %s: %w", task.TaskID, err)
+			}
+			if exists > 0 {
+				skipped++
+				continue
+			}
+		}
+
+		if task.Status == "" || task.Status == StatusSubmitting {
+			task.Status = StatusPending
+		}
+		if task.Status == StatusDownloading {
+			task.Status = StatusProcessing
+		}
+		if task.Model == "" {
+			task.Model = ModelSora2
+		}
+		now := time.Now()
+		if task.CreatedAt.IsZero() {
+			task.CreatedAt = now
+		}
+		if task.UpdatedAt.IsZero() {
+			task.UpdatedAt = now
+		}
+		var taskID interface{}
+		if task.TaskID != "" {
+			taskID = task.TaskID
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO tasks (task_id, prompt, original_prompt, image_url, image_url2, duration, orientation, model, status, progress,
+				video_url, local_path, fail_reason, no_decorate, prompt_prefix, prompt_suffix, submitted_prompt, warning, warning_message,
+				retries, starred, priority, scheduled_at, batch_id, watermark, model_used, thumbnail,
+				duration_seconds, width, height, file_size_bytes, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			taskID, task.Prompt, task.OriginalPrompt, task.ImageURL, task.ImageURL2, task.Duration, task.Orientation, task.Model,
+			task.Status, task.Progress, task.VideoURL, task.LocalPath, task.FailReason, task.NoDecorate,
+			task.PromptPrefix, task.PromptSuffix, task.SubmittedPrompt, task.Warning, task.WarningMessage, task.Retries, task.Starred, task.Priority,
+			task.ScheduledAt, task.BatchID, task.Watermark, task.ModelUsed, task.Thumbnail,
+			task.DurationSeconds, task.Width, task.Height, task.FileSizeBytes, task.CreatedAt, task.UpdatedAt)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to insert task: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get last insert id: %w", err)
+		}
+		for _, tag := range task.Tags {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO task_tags (task_id, tag) VALUES (?, ?)", id, tag); err != nil {
+				return 0, 0, fmt.Errorf("failed to insert task tag: %w", err)
+			}
+		}
+		imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return imported, skipped, nil
+}
+
+// countGrouped runs a "SELECT key, COUNT(*) ... GROUP BY key" query and returns the counts by key
+func countGrouped(query string, args ...interface{}) (map[string]int64, error) {
+	rows, err := ReadDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var key string
+		var count int64
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan count: %w", err)
+		}
+		counts[key] = count
+	}
+	return counts, rows.Err()
+}
+
+// GetTaskStats counts tasks by status and by model, and those created on or after today and
+// weekStart (YYYY-MM-DD, local time)
+// Grouped queries over the indexed status, model and created_at columns, like GetTaskCounts
+func GetTaskStats(today, weekStart string) (*TaskStats, error) {
+	rows, err := ReadDB.Query("SELECT status, COUNT(*), SUM(created_at >= ?), SUM(created_at >= ?) FROM tasks GROUP BY status", today, weekStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &TaskStats{ByStatus: make(map[string]int64)}
+	for rows.Next() {
+		var status string
+		var count, createdToday, createdThisWeek int64
+		if err := rows.Scan(&status, &count, &createdToday, &createdThisWeek); err != nil {
+			return nil, fmt.Errorf("failed to scan count: %w", err)
+		}
+		stats.ByStatus[status] = count
+		stats.Total += count
+		stats.CreatedToday += createdToday
+		stats.CreatedThisWeek += createdThisWeek
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tasks without a model are submitted as sora-2
+	if stats.ByModel, err = countGrouped("SELECT COALESCE(NULLIF(model, ''), ?), COUNT(*) FROM tasks GROUP BY 1", ModelSora2); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetTaskCounts counts tasks by status and those created on or after today (YYYY-MM-DD, local time)
+// A single grouped query over the status and created_at index, cheap enough to poll
+func GetTaskCounts(today string) (*TaskCounts, error) {
+	rows, err := ReadDB.Query("SELECT status, COUNT(*), SUM(created_at >= ?) FROM tasks GROUP BY status", today)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	defer rows.Close()
+
+	counts := &TaskCounts{ByStatus: make(map[string]int64)}
+	for rows.Next() {
+		var status string
+		var count, createdToday int64
+		if err := rows.Scan(&status, &count, &createdToday); err != nil {
+			return nil, fmt.Errorf("failed to scan count: %w", err)
+		}
+		counts.ByStatus[status] = count
+		counts.Total += count
+		counts.CreatedToday += createdToday
+	}
+	return counts, rows.Err()
+}
+
+// GetCharacterStatusCounts counts characters by status
+func GetCharacterStatusCounts() (map[string]int64, error) {
+	return countGrouped("SELECT status, COUNT(*) FROM characters GROUP BY status")
+}
+
+// GetFailedTasksSince retrieves failed tasks that reached the provider (have a task_id) and were
+// updated after since, newest first
+func GetFailedTasksSince(since time.Time) ([]Task, error) {
+	tasks, err := queryTasks(false, `SELECT `+taskColumns+` FROM tasks
+		WHERE status = ? AND COALESCE(task_id, '') != '' ORDER BY updated_at DESC`, StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	// updated_at is compared in Go, the stored time strings don't compare reliably in SQL
+	var recent []Task
+	for _, task := range tasks {
+		if task.UpdatedAt.After(since) {
+			recent = append(recent, task)
+		}
+	}
+	return recent, nil
+}
+
+// GetTasksByWarning retrieves tasks flagged with the given warning code
+func GetTasksByWarning(warning string) ([]Task, error) {
+	return GetTasks(TaskFilter{Warning: warning})
+}
 
 // UpdateTask updates an existing task in the database
+// The images are left alone, they're set at creation or by UpdateTaskFields and tasks loaded
+// without them would clear them
 func UpdateTask(task *Task) error {
 	task.UpdatedAt = time.Now()
 	_, err := DB.Exec(`
 		UPDATE tasks SET
 			task_id = ?,
 			prompt = ?,
-			image_url = ?,
 			duration = ?,
 			orientation = ?,
 			model = ?,
@@ -584,22 +964,111 @@ func UpdateTask(task *Task) error {
 			video_url = ?,
 			local_path = ?,
 			fail_reason = ?,
+			failure_code = ?,
+			submitted_prompt = ?,
+			warning = ?,
+			warning_message = ?,
+			retries = ?,
+			milestones_fired = ?,
+			milestones_fired_high = ?,
+			api_key_fingerprint = ?,
+			model_used = ?,
+			thumbnail = ?,
+			duration_seconds = ?,
+			width = ?,
+			height = ?,
+			file_size_bytes = ?,
+			download_progress = ?,
 			updated_at = ?
 		WHERE id = ?`,
-		task.TaskID, task.Prompt, task.ImageURL, task.Duration, task.Orientation, task.Model,
-		task.Status, task.Progress, task.VideoURL, task.LocalPath, task.FailReason, task.UpdatedAt, task.ID)
+		task.TaskID, task.Prompt, task.Duration, task.Orientation, task.Model,
+		task.Status, task.Progress, task.VideoURL, task.LocalPath, task.FailReason, task.FailureCode, task.SubmittedPrompt,
+		task.Warning, task.WarningMessage, task.Retries, task.MilestonesFired[0], task.MilestonesFired[1], task.APIKeyFingerprint, task.ModelUsed, task.Thumbnail,
+		task.DurationSeconds, task.Width, task.Height, task.FileSizeBytes, task.DownloadProgress, task.UpdatedAt, task.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update task: %w", err)
 	}
 	return nil
 }
 
+// editableTaskColumns lists the columns UpdateTaskFields may write
+var editableTaskColumns = map[string]bool{
+	"prompt":          true,
+	"original_prompt": true,
+	"image_url":       true,
+	"duration":        true,
+	"orientation":     true,
+	"model":           true,
+	"priority":        true,
+	"scheduled_at":    true,
+}
+
+// SetTaskPriority changes the priority of a pending task; returns false when the task isn't pending,
+// tasks already submitted keep their place
+func SetTaskPriority(id int64, priority int) (bool, error) {
+	result, err := DB.Exec("UPDATE tasks SET priority = ?, updated_at = ? WHERE id = ? AND status = ?",
+		priority, time.Now(), id, StatusPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to set task priority: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// GetMaxPendingPriority returns the highest priority among pending tasks, 0 when there are none
+func GetMaxPendingPriority() (int, error) {
+	var priority int
+	err := ReadDB.QueryRow("SELECT COALESCE(MAX(priority), 0) FROM tasks WHERE status = ?", StatusPending).Scan(&priority)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get max priority: %w", err)
+	}
+	return priority, nil
+}
+
+// UpdateTaskFields writes only the given columns of a task that hasn't been submitted yet
+// The update only applies while the task is pending with an empty task_id; returns whether it was applied
+func UpdateTaskFields(id int64, fields map[string]interface{}) (bool, error) {
+	if len(fields) == 0 {
+		return true, nil
+	}
+
+	var sets []string
+	var args []interface{}
+	for column, value := range fields {
+		if !editableTaskColumns[column] {
+			return false, fmt.Errorf("column %s cannot be updated", column)
+		}
+		sets = append(sets, column+" = ?")
+		args = append(args, value)
+	}
+	sets = append(sets, "updated_at = ?")
+	args = append(args, time.Now(), id, StatusPending)
+
+	result, err := DB.Exec(`UPDATE tasks SET `+strings.Join(sets, ", ")+`
+		WHERE id = ? AND status = ? AND COALESCE(task_id, '') = ''`, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update task fields: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
 // DeleteTask removes a task from the database by ID
 func DeleteTask(id int64) error {
 	result, err := DB.Exec("DELETE FROM tasks WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete task: %w", err)
 	}
+	_, _ = DB.Exec("DELETE FROM task_characters WHERE task_id = ?", id)
+	_, _ = DB.Exec("DELETE FROM task_tags WHERE task_id = ?", id)
+	_, _ = DB.Exec("DELETE FROM task_events WHERE task_id = ?", id)
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
@@ -609,91 +1078,265 @@ func DeleteTask(id int64) error {
 	if rowsAffected == 0 {
 		return nil // Task not found, but that's okay per requirement 5.4
 	}
+	if err := recordTombstone(DB, id); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-// GetPendingTasks retrieves all tasks that need processing (pending or processing status)
-func GetPendingTasks() ([]Task, error) {
-	rows, err := DB.Query(`
-		SELECT id, task_id, prompt, image_url, COALESCE(image_url2, '') as image_url2, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks 
-		WHERE status IN (?, ?)
-		ORDER BY created_at ASC`,
-		StatusPending, StatusProcessing)
+// ClaimTask atomically moves a task from fromStatus to toStatus
+// Returns false when the task is no longer in fromStatus, i.e. another code path claimed it first
+func ClaimTask(id int64, fromStatus, toStatus string) (bool, error) {
+	result, err := DB.Exec("UPDATE tasks SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		toStatus, time.Now(), id, fromStatus)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query pending tasks: %w", err)
+		return false, fmt.Errorf("failed to claim task: %w", err)
 	}
-	defer rows.Close()
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
 
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		var imageURL, imageURL2, videoURL, localPath, taskID, model, failReason sql.NullString
+// ResetInterruptedSubmissions returns tasks left in the submitting state by a previous run to pending
+func ResetInterruptedSubmissions() (int64, error) {
+	result, err := DB.Exec("UPDATE tasks SET status = ?, updated_at = ? WHERE status = ?",
+		StatusPending, time.Now(), StatusSubmitting)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset interrupted submissions: %w", err)
+	}
+	return result.RowsAffected()
+}
 
-		err := rows.Scan(
-			&task.ID, &taskID, &task.Prompt, &imageURL, &imageURL2, &task.Duration, &task.Orientation, &model,
-			&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan task: %w", err)
-		}
+// ResetInterruptedDownloads returns tasks left in the downloading state by a previous run to processing,
+// the next poll downloads them again, resuming from their .part file
+func ResetInterruptedDownloads() (int64, error) {
+	result, err := DB.Exec("UPDATE tasks SET status = ?, updated_at = ? WHERE status = ?",
+		StatusProcessing, time.Now(), StatusDownloading)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset interrupted downloads: %w", err)
+	}
+	return result.RowsAffected()
+}
 
-		task.TaskID = taskID.String
-		task.ImageURL = imageURL.String
-		task.ImageURL2 = imageURL2.String
-		task.VideoURL = videoURL.String
-		task.LocalPath = localPath.String
-		task.Model = model.String
-		task.FailReason = failReason.String
+// FailInterruptedComposes fails the compose tasks left processing by a previous run, their ffmpeg
+// process is gone
+func FailInterruptedComposes() (int64, error) {
+	result, err := DB.Exec("UPDATE tasks SET status = ?, fail_reason = ?, updated_at = ? WHERE status = ? AND model = ?",
+		StatusFailed, "interrupted by a restart", time.Now(), StatusProcessing, ModelCompose)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted compose tasks: %w", err)
+	}
+	return result.RowsAffected()
+}
 
-		tasks = append(tasks, task)
+// UpdateTaskStatus stores the status, progress, fail_reason and failure_code of a task
+// The processor saves its transitions with it and the other targeted updates, UpdateTask is kept
+// for edits of the whole row
+func UpdateTaskStatus(id int64, status string, progress int, failReason, failureCode string) error {
+	_, err := DB.Exec("UPDATE tasks SET status = ?, progress = ?, fail_reason = ?, failure_code = ?, updated_at = ? WHERE id = ?",
+		status, progress, failReason, failureCode, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update task status: %w", err)
 	}
+	return nil
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating pending tasks: %w", err)
+// UpdateTaskSubmission stores the outcome of a submission attempt: the remote task ID, the prompt
+// sent, the API key and model that took it, and the failed attempts so far
+func UpdateTaskSubmission(id int64, taskID, submittedPrompt, keyFingerprint, modelUsed string, retries int) error {
+	_, err := DB.Exec(`UPDATE tasks SET task_id = ?, submitted_prompt = ?, api_key_fingerprint = ?, model_used = ?,
+		retries = ?, updated_at = ? WHERE id = ?`,
+		taskID, submittedPrompt, keyFingerprint, modelUsed, retries, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update task submission: %w", err)
 	}
+	return nil
+}
 
-	return tasks, nil
+// UpdateTaskCompletion stores the remote video URL of a task, its local file once downloaded and
+// the download progress
+func UpdateTaskCompletion(id int64, videoURL, localPath string, downloadProgress int) error {
+	_, err := DB.Exec("UPDATE tasks SET video_url = ?, local_path = ?, download_progress = ?, updated_at = ? WHERE id = ?",
+		videoURL, localPath, downloadProgress, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update task completion: %w", err)
+	}
+	return nil
 }
 
-// GetTasksByDateRange retrieves tasks within a date range
-func GetTasksByDateRange(startDate, endDate string) ([]Task, error) {
-	rows, err := DB.Query(`
-		SELECT id, task_id, prompt, duration, orientation, COALESCE(model, 'sora-2') as model, status, progress, video_url, local_path, COALESCE(fail_reason, '') as fail_reason, created_at, updated_at
-		FROM tasks 
-		WHERE date(created_at) >= date(?) AND date(created_at) <= date(?)
-		ORDER BY created_at DESC`, startDate, endDate)
+// SetTaskWarning stores the warning code and message of a task, empty to clear them
+func SetTaskWarning(id int64, warning, message string) error {
+	if _, err := DB.Exec("UPDATE tasks SET warning = ?, warning_message = ?, updated_at = ? WHERE id = ?", warning, message, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to save warning: %w", err)
+	}
+	return nil
+}
+
+// SetTaskMilestones stores the webhook progress milestones fired for a task
+func SetTaskMilestones(id int64, fired milestoneSet) error {
+	if _, err := DB.Exec("UPDATE tasks SET milestones_fired = ?, milestones_fired_high = ? WHERE id = ?", fired[0], fired[1], id); err != nil {
+		return fmt.Errorf("failed to save milestones: %w", err)
+	}
+	return nil
+}
+
+// SetTaskProgress stores the progress of a task
+func SetTaskProgress(id int64, progress int) error {
+	if _, err := DB.Exec("UPDATE tasks SET progress = ?, updated_at = ? WHERE id = ?", progress, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to save progress: %w", err)
+	}
+	return nil
+}
+
+// UpdateProcessingProgress stores the progress and fired milestones of a task still processing
+// Returns false without writing when the task has left processing, a poll answered after the task
+// was cancelled or reset must not overwrite it
+func UpdateProcessingProgress(id int64, progress int, milestonesFired milestoneSet) (bool, error) {
+	result, err := DB.Exec(`UPDATE tasks SET progress = ?, milestones_fired = ?, milestones_fired_high = ?, updated_at = ?
+		WHERE id = ? AND status = ?`,
+		progress, milestonesFired[0], milestonesFired[1], time.Now(), id, StatusProcessing)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query tasks by date range: %w", err)
+		return false, fmt.Errorf("failed to save progress: %w", err)
 	}
-	defer rows.Close()
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
 
-	var tasks []Task
-	for rows.Next() {
-		var task Task
-		var videoURL, localPath, taskID, model, failReason sql.NullString
+// SetTaskDownloadProgress stores the percentage of the video of a task downloaded so far
+func SetTaskDownloadProgress(id int64, percent int) error {
+	if _, err := DB.Exec("UPDATE tasks SET download_progress = ?, updated_at = ? WHERE id = ?", percent, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to save download progress: %w", err)
+	}
+	return nil
+}
 
-		err := rows.Scan(
-			&task.ID, &taskID, &task.Prompt, &task.Duration, &task.Orientation, &model,
-			&task.Status, &task.Progress, &videoURL, &localPath, &failReason, &task.CreatedAt, &task.UpdatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan task: %w", err)
+// GetTaskStatus returns the current status of a task, or "" if it doesn't exist
+func GetTaskStatus(id int64) (string, error) {
+	var status string
+	err := ReadDB.QueryRow("SELECT status FROM tasks WHERE id = ?", id).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
 		}
+		return "", fmt.Errorf("failed to get task status: %w", err)
+	}
+	return status, nil
+}
 
-		task.TaskID = taskID.String
-		task.VideoURL = videoURL.String
-		task.LocalPath = localPath.String
-		task.Model = model.String
-		task.FailReason = failReason.String
+// MaxAPIResponseBytes caps the raw upstream response kept with a failed task
+const MaxAPIResponseBytes = 8 << 10
 
-		tasks = append(tasks, task)
+// SetTaskAPIResponse stores the raw upstream response that made a task fail, capped at
+// MaxAPIResponseBytes
+func SetTaskAPIResponse(id int64, response string) error {
+	if len(response) > MaxAPIResponseBytes {
+		response = strings.ToValidUTF8(response[:MaxAPIResponseBytes], "")
 	}
+	if _, err := DB.Exec("UPDATE tasks SET last_api_response = ? WHERE id = ?", response, id); err != nil {
+		return fmt.Errorf("failed to save API response: %w", err)
+	}
+	return nil
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating tasks: %w", err)
+// GetTaskAPIResponse returns the raw upstream response stored with a task
+func GetTaskAPIResponse(id int64) (string, error) {
+	var response string
+	err := ReadDB.QueryRow("SELECT COALESCE(last_api_response, '') FROM tasks WHERE id = ?", id).Scan(&response)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to get API response: %w", err)
 	}
+	return response, nil
+}
 
-	return tasks, nil
+// SetTaskLocalPath points a task at another local video file
+func SetTaskLocalPath(id int64, localPath string) error {
+	if _, err := DB.Exec("UPDATE tasks SET local_path = ?, updated_at = ? WHERE id = ?", localPath, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to save local path: %w", err)
+	}
+	return nil
+}
+
+// SetTaskThumbnail stores the thumbnail file name of a task
+func SetTaskThumbnail(id int64, thumbnail string) error {
+	if _, err := DB.Exec("UPDATE tasks SET thumbnail = ? WHERE id = ?", thumbnail, id); err != nil {
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	return nil
+}
+
+// GetTasksWithoutThumbnail returns the completed tasks with a downloaded video but no thumbnail
+func GetTasksWithoutThumbnail() ([]Task, error) {
+	return queryTasks(false, `SELECT `+taskColumns+` FROM tasks
+		WHERE status = ? AND COALESCE(local_path, '') != '' AND COALESCE(thumbnail, '') = ''
+		ORDER BY id`, StatusCompleted)
+}
+
+// SetTaskMetadata stores the probed metadata of the downloaded video of a task
+func SetTaskMetadata(id int64, task *Task) error {
+	_, err := DB.Exec("UPDATE tasks SET duration_seconds = ?, width = ?, height = ?, file_size_bytes = ? WHERE id = ?",
+		task.DurationSeconds, task.Width, task.Height, task.FileSizeBytes, id)
+	if err != nil {
+		return fmt.Errorf("failed to save video metadata: %w", err)
+	}
+	return nil
+}
+
+// GetTasksWithoutMetadata returns the completed tasks with a downloaded video that was never probed
+func GetTasksWithoutMetadata() ([]Task, error) {
+	return queryTasks(false, `SELECT `+taskColumns+` FROM tasks
+		WHERE status = ? AND COALESCE(local_path, '') != '' AND COALESCE(file_size_bytes, 0) = 0
+		ORDER BY id`, StatusCompleted)
+}
+
+// CancelTask moves a task from fromStatus to toStatus with the given fail_reason
+// The update only applies if the task is still in fromStatus; returns whether it was applied
+func CancelTask(id int64, fromStatus, toStatus, failReason string) (bool, error) {
+	result, err := DB.Exec(`
+		UPDATE tasks SET status = ?, fail_reason = ?, failure_code = '', updated_at = ?
+		WHERE id = ? AND status = ?`,
+		toStatus, failReason, time.Now(), id, fromStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel task: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// GetPendingTasks retrieves all tasks that need processing (pending or processing status)
+// Compose tasks are processed locally and left out; the images, only needed at submission and
+// possibly several MB of base64 each, are loaded by GetTaskImages
+func GetPendingTasks() ([]Task, error) {
+	return queryTasks(false, `SELECT `+taskColumns+`
+		FROM tasks
+		WHERE status IN (?, ?) AND COALESCE(model, '') != ?
+		ORDER BY COALESCE(priority, 0) DESC, created_at ASC`,
+		StatusPending, StatusProcessing, ModelCompose)
+}
+
+// GetTaskImages returns the first and last frame images of a task
+func GetTaskImages(id int64) (string, string, error) {
+	var imageURL, imageURL2 string
+	err := ReadDB.QueryRow(`SELECT COALESCE(image_url, ''), COALESCE(image_url2, '') FROM tasks WHERE id = ?`, id).
+		Scan(&imageURL, &imageURL2)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get task images: %w", err)
+	}
+	return imageURL, imageURL2, nil
+}
+
+// GetTasksByDateRange retrieves tasks created from startDate to endDate inclusive (YYYY-MM-DD, local time)
+func GetTasksByDateRange(startDate, endDate string) ([]Task, error) {
+	return GetTasks(TaskFilter{StartDate: startDate, EndDate: endDate})
 }
 
 // CreateCharacter inserts a new character into the database
@@ -730,79 +1373,281 @@ func CreateCharacter(char *Character) (*Character, error) {
 	return char, nil
 }
 
-// GetAllCharacters retrieves all characters from the database ordered by created_at DESC
+// characterColumns is the column list shared by all character queries, scanned by scanCharacter
+const characterColumns = `id, COALESCE(api_character_id, '') as api_character_id, COALESCE(username, '') as username,
+		       COALESCE(avatar_url, '') as avatar_url, custom_name, COALESCE(description, '') as description,
+		       source_type, source_value, timestamps, status, progress, COALESCE(fail_reason, '') as fail_reason, created_at,
+		       COALESCE(pinned, 0) as pinned, last_used_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCharacter scans a row selected with characterColumns
+func scanCharacter(row rowScanner) (*Character, error) {
+	var char Character
+	var apiCharacterID, username, avatarURL, description, failReason sql.NullString
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&char.ID, &apiCharacterID, &username, &avatarURL, &char.CustomName, &description,
+		&char.SourceType, &char.SourceValue, &char.Timestamps,
+		&char.Status, &char.Progress, &failReason, &char.CreatedAt,
+		&char.Pinned, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	char.ApiCharacterID = apiCharacterID.String
+	char.Username = username.String
+	char.AvatarURL = avatarURL.String
+	char.Description = description.String
+	char.FailReason = failReason.String
+	if lastUsedAt.Valid {
+		char.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &char, nil
+}
+
+// Character sort options for GetAllCharactersSorted
+const (
+	CharacterSortDefault  = ""
+	CharacterSortLastUsed = "last_used"
+	CharacterSortName     = "name"
+	CharacterSortCreated  = "created"
+)
+
+// characterOrderBy maps a sort option to its ORDER BY clause
+// The default puts pinned characters first, then the most recently used ones
+var characterOrderBy = map[string]string{
+	CharacterSortDefault:  "pinned DESC, last_used_at DESC, created_at DESC",
+	CharacterSortLastUsed: "last_used_at DESC, created_at DESC",
+	CharacterSortName:     "custom_name ASC, created_at DESC",
+	CharacterSortCreated:  "created_at DESC",
+}
+
+// GetAllCharacters retrieves all characters from the database in the default order
 func GetAllCharacters() ([]Character, error) {
-	rows, err := DB.Query(`
-		SELECT id, COALESCE(api_character_id, '') as api_character_id, COALESCE(username, '') as username, 
-		       COALESCE(avatar_url, '') as avatar_url, custom_name, COALESCE(description, '') as description, 
-		       source_type, source_value, timestamps, status, progress, COALESCE(fail_reason, '') as fail_reason, created_at
-		FROM characters ORDER BY created_at DESC`)
+	return GetAllCharactersSorted(CharacterSortDefault)
+}
+
+// GetAllCharactersSorted retrieves all characters using one of the character sort options
+func GetAllCharactersSorted(sort string) ([]Character, error) {
+	characters, _, err := GetCharactersFiltered(CharacterFilter{Sort: sort}, 0, 0)
+	return characters, err
+}
+
+// CharacterFilter selects and orders the characters listed by GetCharactersFiltered, zero fields
+// don't filter
+type CharacterFilter struct {
+	Query    string   // Substring of custom_name or username, case-insensitive for ASCII
+	Statuses []string // Any of these statuses
+	Sort     string   // One of the character sort options
+}
+
+// where returns the WHERE clause of the filter and its arguments
+func (f CharacterFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if query := strings.TrimSpace(f.Query); query != "" {
+		conditions = append(conditions, `(custom_name LIKE ? ESCAPE '\' OR COALESCE(username, '') LIKE ? ESCAPE '\')`)
+		pattern := "%" + escapeLike(query) + "%"
+		args = append(args, pattern, pattern)
+	}
+	if len(f.Statuses) > 0 {
+		conditions = append(conditions, "status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, status := range f.Statuses {
+			args = append(args, status)
+		}
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// GetCharactersFiltered retrieves a page of the characters matching filter and the total number
+// of matches; limit 0 returns all of them without counting, total is then the number returned
+func GetCharactersFiltered(filter CharacterFilter, limit, offset int) ([]Character, int, error) {
+	orderBy, ok := characterOrderBy[filter.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid character sort: %s", filter.Sort)
+	}
+	where, args := filter.where()
+
+	query := `SELECT ` + characterColumns + ` FROM characters` + where + ` ORDER BY ` + orderBy
+	total := -1
+	if limit > 0 {
+		if err := ReadDB.QueryRow("SELECT COUNT(*) FROM characters"+where, args...).Scan(&total); err != nil {
+			return nil, 0, fmt.Errorf("failed to count characters: %w", err)
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+
+	rows, err := ReadDB.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query characters: %w", err)
+		return nil, 0, fmt.Errorf("failed to query characters: %w", err)
 	}
 	defer rows.Close()
 
 	var characters []Character
 	for rows.Next() {
-		var char Character
-		var apiCharacterID, username, avatarURL, description, failReason sql.NullString
-
-		err := rows.Scan(
-			&char.ID, &apiCharacterID, &username, &avatarURL, &char.CustomName, &description,
-			&char.SourceType, &char.SourceValue, &char.Timestamps,
-			&char.Status, &char.Progress, &failReason, &char.CreatedAt)
+		char, err := scanCharacter(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan character: %w", err)
+			return nil, 0, fmt.Errorf("failed to scan character: %w", err)
 		}
-
-		char.ApiCharacterID = apiCharacterID.String
-		char.Username = username.String
-		char.AvatarURL = avatarURL.String
-		char.Description = description.String
-		char.FailReason = failReason.String
-
-		characters = append(characters, char)
+		characters = append(characters, *char)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating characters: %w", err)
+		return nil, 0, fmt.Errorf("error iterating characters: %w", err)
+	}
+	if total < 0 {
+		total = len(characters)
 	}
 
-	return characters, nil
+	return characters, total, nil
 }
 
 // GetCharacter retrieves a single character by ID
 func GetCharacter(id int64) (*Character, error) {
-	char := &Character{}
-	var apiCharacterID, username, avatarURL, description, failReason sql.NullString
-
-	err := DB.QueryRow(`
-		SELECT id, COALESCE(api_character_id, '') as api_character_id, COALESCE(username, '') as username,
-		       COALESCE(avatar_url, '') as avatar_url, custom_name, COALESCE(description, '') as description,
-		       source_type, source_value, timestamps, status, progress, COALESCE(fail_reason, '') as fail_reason, created_at
-		FROM characters WHERE id = ?`, id).Scan(
-		&char.ID, &apiCharacterID, &username, &avatarURL, &char.CustomName, &description,
-		&char.SourceType, &char.SourceValue, &char.Timestamps,
-		&char.Status, &char.Progress, &failReason, &char.CreatedAt)
+	char, err := scanCharacter(ReadDB.QueryRow(`SELECT `+characterColumns+` FROM characters WHERE id = ?`, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to get character: %w", err)
 	}
+	return char, nil
+}
 
-	char.ApiCharacterID = apiCharacterID.String
-	char.Username = username.String
-	char.AvatarURL = avatarURL.String
-	char.Description = description.String
-	char.FailReason = failReason.String
-
+// GetCharacterByAPIID retrieves a character by its provider character ID
+func GetCharacterByAPIID(apiCharacterID string) (*Character, error) {
+	char, err := scanCharacter(ReadDB.QueryRow(`SELECT `+characterColumns+` FROM characters WHERE api_character_id = ? LIMIT 1`, apiCharacterID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get character: %w", err)
+	}
 	return char, nil
 }
 
-// UpdateCharacterStatus updates the status, progress, api_character_id, username, avatar_url, and fail_reason of a character
-func UpdateCharacterStatus(id int64, status string, progress int, apiCharacterID string, username string, avatarURL string, failReason string) error {
-	result, err := DB.Exec(`
+// ToggleCharacterPinned flips the pinned flag of a character
+func ToggleCharacterPinned(id int64) error {
+	result, err := DB.Exec("UPDATE characters SET pinned = 1 - COALESCE(pinned, 0) WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to toggle character pin: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("character not found")
+	}
+
+	return nil
+}
+
+// errCharacterNameTaken is returned by UpdateCharacterDetails when another character has the name
+var errCharacterNameTaken = errors.New("character name already in use")
+
+// UpdateCharacterDetails sets the custom name and description of a character
+// Prompts reference characters by name, so a name used by another character is rejected with
+// errCharacterNameTaken; returns false when the character doesn't exist
+func UpdateCharacterDetails(id int64, customName, description string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var taken int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM characters WHERE custom_name = ? AND id != ?", customName, id).Scan(&taken); err != nil {
+		return false, fmt.Errorf("failed to check character name: %w", err)
+	}
+	if taken > 0 {
+		return false, errCharacterNameTaken
+	}
+
+	result, err := tx.Exec("UPDATE characters SET custom_name = ?, description = ? WHERE id = ?", customName, description, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to update character: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit character update: %w", err)
+	}
+	return true, nil
+}
+
+// ToggleTaskStarred flips the starred flag of a task
+func ToggleTaskStarred(id int64) error {
+	result, err := DB.Exec("UPDATE tasks SET starred = 1 - COALESCE(starred, 0), updated_at = ? WHERE id = ?", time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to toggle task star: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("task not found")
+	}
+
+	return nil
+}
+
+// LinkTaskCharacters records which characters a task's prompt referenced
+// and bumps their last_used_at timestamp
+func LinkTaskCharacters(taskID int64, characterIDs []int64) error {
+	if len(characterIDs) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := linkTaskCharacters(tx, taskID, characterIDs, time.Now()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit character links: %w", err)
+	}
+	return nil
+}
+
+// linkTaskCharacters records the characters used by a task and marks them used at now
+func linkTaskCharacters(db execer, taskID int64, characterIDs []int64, now time.Time) error {
+	for _, charID := range characterIDs {
+		if _, err := db.Exec("INSERT OR IGNORE INTO task_characters (task_id, character_id) VALUES (?, ?)", taskID, charID); err != nil {
+			return fmt.Errorf("failed to link character %d: %w", charID, err)
+		}
+		if _, err := db.Exec("UPDATE characters SET last_used_at = ? WHERE id = ?", now, charID); err != nil {
+			return fmt.Errorf("failed to update last_used_at of character %d: %w", charID, err)
+		}
+	}
+	return nil
+}
+
+// UpdateCharacterStatus updates the status, progress, api_character_id, username, avatar_url, and fail_reason of a character
+func UpdateCharacterStatus(id int64, status string, progress int, apiCharacterID string, username string, avatarURL string, failReason string) error {
+	result, err := DB.Exec(`
 		UPDATE characters SET
 			status = ?,
 			progress = ?,
@@ -828,12 +1673,59 @@ func UpdateCharacterStatus(id int64, status string, progress int, apiCharacterID
 	return nil
 }
 
+// GetActiveTaskPrompts returns the prompts of the pending and processing tasks
+func GetActiveTaskPrompts() ([]string, error) {
+	rows, err := ReadDB.Query("SELECT prompt FROM tasks WHERE status IN (?, ?)", StatusPending, StatusProcessing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task prompts: %w", err)
+	}
+	defer rows.Close()
+
+	var prompts []string
+	for rows.Next() {
+		var prompt string
+		if err := rows.Scan(&prompt); err != nil {
+			return nil, fmt.Errorf("failed to scan task prompt: %w", err)
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, rows.Err()
+}
+
+// GetTasksReferencingCharacter returns the IDs of the pending and processing tasks whose prompt
+// contains the custom name of the character or its @{api_character_id} form
+// instr matches case-sensitively, like the conversion of character references
+func GetTasksReferencingCharacter(char *Character) ([]int64, error) {
+	conditions := []string{"instr(prompt, ?) > 0"}
+	args := []interface{}{StatusPending, StatusProcessing, char.CustomName}
+	if char.ApiCharacterID != "" {
+		conditions = append(conditions, "instr(prompt, ?) > 0")
+		args = append(args, "@{"+char.ApiCharacterID+"}")
+	}
+	rows, err := ReadDB.Query(`SELECT id FROM tasks WHERE status IN (?, ?) AND (`+strings.Join(conditions, " OR ")+`) ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // DeleteCharacter removes a character from the database by ID
 func DeleteCharacter(id int64) error {
 	result, err := DB.Exec("DELETE FROM characters WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete character: %w", err)
 	}
+	_, _ = DB.Exec("DELETE FROM task_characters WHERE character_id = ?", id)
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
@@ -847,26 +1739,679 @@ func DeleteCharacter(id int64) error {
 	return nil
 }
 
-// ResetFailedTasks resets failed and processing tasks to pending for retry
-// Returns the number of tasks updated
-func ResetFailedTasks() (int64, error) {
-	result, err := DB.Exec(`
+// templateColumns is the column list read by scanTemplate
+const templateColumns = `id, name, body, COALESCE(duration, ''), COALESCE(orientation, ''), COALESCE(model, ''),
+	COALESCE(prompt_prefix, ''), COALESCE(prompt_suffix, ''), created_at, updated_at`
+
+// scanTemplate scans a row selected with templateColumns
+func scanTemplate(row interface{ Scan(...interface{}) error }) (*PromptTemplate, error) {
+	var tmpl PromptTemplate
+	err := row.Scan(&tmpl.ID, &tmpl.Name, &tmpl.Body, &tmpl.Duration, &tmpl.Orientation, &tmpl.Model,
+		&tmpl.PromptPrefix, &tmpl.PromptSuffix, &tmpl.CreatedAt, &tmpl.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Placeholders = TemplatePlaceholders(tmpl.Body)
+	return &tmpl, nil
+}
+
+// GetAllTemplates retrieves all prompt templates by name
+func GetAllTemplates() ([]PromptTemplate, error) {
+	rows, err := ReadDB.Query(`SELECT ` + templateColumns + ` FROM templates ORDER BY name COLLATE NOCASE, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []PromptTemplate{}
+	for rows.Next() {
+		tmpl, err := scanTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		templates = append(templates, *tmpl)
+	}
+	return templates, rows.Err()
+}
+
+// GetTemplate retrieves a prompt template by ID, nil when it doesn't exist
+func GetTemplate(id int64) (*PromptTemplate, error) {
+	tmpl, err := scanTemplate(ReadDB.QueryRow(`SELECT `+templateColumns+` FROM templates WHERE id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// CreateTemplate inserts a new prompt template
+func CreateTemplate(tmpl *PromptTemplate) (*PromptTemplate, error) {
+	now := time.Now()
+	result, err := DB.Exec(`INSERT INTO templates (name, body, duration, orientation, model, prompt_prefix, prompt_suffix,
+		created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		tmpl.Name, tmpl.Body, tmpl.Duration, tmpl.Orientation, tmpl.Model, tmpl.PromptPrefix, tmpl.PromptSuffix, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert template: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+	return GetTemplate(id)
+}
+
+// UpdateTemplate replaces the name, body, defaults and prompt decoration of a prompt template
+func UpdateTemplate(tmpl *PromptTemplate) error {
+	result, err := DB.Exec(`UPDATE templates SET name = ?, body = ?, duration = ?, orientation = ?, model = ?,
+		prompt_prefix = ?, prompt_suffix = ?, updated_at = ? WHERE id = ?`,
+		tmpl.Name, tmpl.Body, tmpl.Duration, tmpl.Orientation, tmpl.Model, tmpl.PromptPrefix, tmpl.PromptSuffix,
+		time.Now(), tmpl.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update template: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("template not found")
+	}
+	return nil
+}
+
+// DeleteTemplate deletes a prompt template
+func DeleteTemplate(id int64) error {
+	result, err := DB.Exec("DELETE FROM templates WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("template not found")
+	}
+	return nil
+}
+
+// resetTaskForRetrySQL moves a task back to pending, clearing its previous run
+const resetTaskForRetrySQL = `
 		UPDATE tasks SET
 			status = ?,
 			task_id = '',
+			model_used = '',
 			progress = 0,
+			download_progress = 0,
 			video_url = '',
-			updated_at = ?
-		WHERE status IN (?, ?)`,
-		StatusPending, time.Now(), StatusFailed, StatusProcessing)
+			local_path = '',
+			fail_reason = '',
+			failure_code = '',
+			warning = '',
+			warning_message = '',
+			thumbnail = '',
+			duration_seconds = 0,
+			width = 0,
+			height = 0,
+			file_size_bytes = 0,
+			last_api_response = '',
+			retries = 0,
+			milestones_fired = 0,
+			milestones_fired_high = 0,
+			updated_at = ?`
+
+// retryLeftovers returns the local files and thumbnails of the tasks matching where, which a reset
+// abandons; paths are relative to the output directory
+func retryLeftovers(tx *sql.Tx, where string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(`SELECT COALESCE(local_path, ''), COALESCE(thumbnail, '') FROM tasks
+		WHERE (COALESCE(local_path, '') != '' OR COALESCE(thumbnail, '') != '') AND `+where, args...)
 	if err != nil {
-		return 0, fmt.Errorf("failed to reset tasks: %w", err)
+		return nil, fmt.Errorf("failed to query local files: %w", err)
 	}
+	defer rows.Close()
 
-	rowsAffected, err := result.RowsAffected()
+	var paths []string
+	for rows.Next() {
+		var path, thumbnail string
+		if err := rows.Scan(&path, &thumbnail); err != nil {
+			return nil, fmt.Errorf("failed to scan local file: %w", err)
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+		if thumbnail != "" {
+			paths = append(paths, filepath.Join(ThumbnailSubdirectory, filepath.Base(thumbnail)))
+		}
+	}
+	return paths, rows.Err()
+}
+
+// resetTaskWithOverrides resets a single task in fromStatus to pending, applying the retry overrides
+// The options replaced are recorded in the audit log so the original values stay visible
+func resetTaskWithOverrides(tx *sql.Tx, id int64, fromStatus string, overrides *RetryOverrides, now time.Time) (bool, error) {
+	var duration, orientation, model string
+	var watermark bool
+	err := tx.QueryRow("SELECT duration, orientation, COALESCE(model, ''), COALESCE(watermark, 0) FROM tasks WHERE id = ? AND status = ?", id, fromStatus).
+		Scan(&duration, &orientation, &model, &watermark)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get task %d: %w", id, err)
+	}
+
+	query := resetTaskForRetrySQL
+	args := []interface{}{StatusPending, now}
+	var changes []string
+	for _, field := range []struct {
+		column   string
+		original string
+		value    *string
+	}{
+		{"duration", duration, overrides.Duration},
+		{"orientation", orientation, overrides.Orientation},
+		{"model", model, overrides.Model},
+	} {
+		if field.value == nil || *field.value == field.original {
+			continue
+		}
+		query += ", " + field.column + " = ?"
+		args = append(args, *field.value)
+		changes = append(changes, fmt.Sprintf("%s %s -> %s", field.column, field.original, *field.value))
+	}
+	if overrides.Watermark != nil && *overrides.Watermark != watermark {
+		query += ", watermark = ?"
+		args = append(args, *overrides.Watermark)
+		changes = append(changes, fmt.Sprintf("watermark %t -> %t", watermark, *overrides.Watermark))
+	}
+
+	result, err := tx.Exec(query+` WHERE id = ? AND status = ?`, append(args, id, fromStatus)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to reset task %d: %w", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if len(changes) == 0 {
+		recordTaskEvent(tx, id, HistoryRetried, "retried from "+fromStatus)
+		return true, nil
+	}
+	recordTaskEvent(tx, id, HistoryRetried, "retried with overrides: "+strings.Join(changes, ", "))
+	detail := fmt.Sprintf("task %d retried with overrides: %s", id, strings.Join(changes, ", "))
+	if err := recordAudit(tx, "tasks.retry", detail); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetFailedTaskPrompt replaces the converted prompt of a failed task and its linked characters
+// Returns false when the task is no longer failed
+func SetFailedTaskPrompt(id int64, prompt string, characterIDs []int64) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec("UPDATE tasks SET prompt = ?, updated_at = ? WHERE id = ? AND status = ?", prompt, now, id, StatusFailed)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		return false, fmt.Errorf("failed to update task prompt: %w", err)
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec("DELETE FROM task_characters WHERE task_id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to unlink characters: %w", err)
+	}
+	if err := linkTaskCharacters(tx, id, characterIDs, now); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit task prompt: %w", err)
+	}
+	return true, nil
+}
 
-	return rowsAffected, nil
+// RetryTask resets a single failed task to pending, applying the optional overrides
+// Returns false when the task is no longer failed
+func RetryTask(id int64, overrides *RetryOverrides) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if overrides == nil {
+		overrides = &RetryOverrides{}
+	}
+	reset, err := resetTaskWithOverrides(tx, id, StatusFailed, overrides, time.Now())
+	if err != nil || !reset {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit retry: %w", err)
+	}
+	return true, nil
+}
+
+// RetryFailedTasks resets every failed task to pending for retry, applying the optional overrides
+// Returns the IDs of the tasks reset and their local files for the caller to delete
+func RetryFailedTasks(overrides *RetryOverrides) ([]int64, []string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	ids, err := queryTaskIDs(tx, StatusFailed)
+	if err != nil {
+		return nil, nil, err
+	}
+	reset, localPaths, err := resetTasks(tx, ids, StatusFailed, overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit reset: %w", err)
+	}
+	return reset, localPaths, nil
+}
+
+// ResetStuckTasks resets processing tasks not updated since stuckBefore to pending, applying the
+// optional overrides; their remote generations are abandoned, so healthy in-flight tasks must not be included
+// Returns the IDs of the tasks reset and their local files for the caller to delete
+func ResetStuckTasks(stuckBefore time.Time, overrides *RetryOverrides) ([]int64, []string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// updated_at is compared in Go, the stored time strings don't compare reliably in SQL
+	rows, err := tx.Query(`SELECT id, updated_at FROM tasks WHERE status = ? AND COALESCE(model, '') != ? ORDER BY id`, StatusProcessing, ModelCompose)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query processing tasks: %w", err)
+	}
+	var stuck []int64
+	for rows.Next() {
+		var id int64
+		var updatedAt time.Time
+		if err := rows.Scan(&id, &updatedAt); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		if updatedAt.Before(stuckBefore) {
+			stuck = append(stuck, id)
+		}
+	}
+	rows.Close()
+
+	reset, localPaths, err := resetTasks(tx, stuck, StatusProcessing, overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit reset: %w", err)
+	}
+	return reset, localPaths, nil
+}
+
+// ResetProcessingTask resets a processing task to pending, abandoning its remote generation
+// Returns false when the task is no longer processing, and its local files for the caller to delete
+func ResetProcessingTask(id int64) (bool, []string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reset, localPaths, err := resetTasks(tx, []int64{id}, StatusProcessing, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, nil, fmt.Errorf("failed to commit reset: %w", err)
+	}
+	return len(reset) > 0, localPaths, nil
+}
+
+// resetTasks resets the given tasks still in fromStatus to pending, applying the optional overrides
+// Returns the IDs of the tasks reset and their local files
+func resetTasks(tx *sql.Tx, ids []int64, fromStatus string, overrides *RetryOverrides) ([]int64, []string, error) {
+	if overrides == nil {
+		overrides = &RetryOverrides{}
+	}
+	now := time.Now()
+	reset := []int64{}
+	var localPaths []string
+	for _, id := range ids {
+		paths, err := retryLeftovers(tx, "id = ? AND status = ?", id, fromStatus)
+		if err != nil {
+			return nil, nil, err
+		}
+		ok, err := resetTaskWithOverrides(tx, id, fromStatus, overrides, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			reset = append(reset, id)
+			localPaths = append(localPaths, paths...)
+		}
+	}
+	return reset, localPaths, nil
+}
+
+// bulkRetrySkipReasons are the statuses RetryTasks leaves alone, with the reason reported
+var bulkRetrySkipReasons = map[string]string{
+	StatusCompleted:   "task is completed",
+	StatusPending:     "task is already pending",
+	StatusSubmitting:  "task is being submitted",
+	StatusDownloading: "task is being downloaded",
+}
+
+// RetryTasks resets the given tasks to pending in a single transaction
+// Completed, pending, submitting and downloading tasks are skipped, as are compose tasks; processing ones abandon
+// their remote generation
+// Returns the number of tasks reset, the skipped ones with the reason and the local files of the reset tasks
+// for the caller to delete
+func RetryTasks(ids []int64) (int64, []BulkSkippedTask, []string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var reset int64
+	skipped := []BulkSkippedTask{}
+	var retried []int64
+	var localPaths []string
+	for _, id := range ids {
+		var status, model string
+		err := tx.QueryRow("SELECT status, COALESCE(model, '') FROM tasks WHERE id = ?", id).Scan(&status, &model)
+		if err == sql.ErrNoRows {
+			skipped = append(skipped, BulkSkippedTask{ID: id, Reason: "task not found"})
+			continue
+		}
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to get task %d: %w", id, err)
+		}
+		if reason, skip := bulkRetrySkipReasons[status]; skip {
+			skipped = append(skipped, BulkSkippedTask{ID: id, Reason: reason})
+			continue
+		}
+		if model == ModelCompose {
+			skipped = append(skipped, BulkSkippedTask{ID: id, Reason: "compose tasks can't be retried"})
+			continue
+		}
+
+		paths, err := retryLeftovers(tx, "id = ?", id)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		result, err := tx.Exec(resetTaskForRetrySQL+` WHERE id = ? AND status = ?`, StatusPending, now, id, status)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to reset task %d: %w", id, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			recordTaskEvent(tx, id, HistoryRetried, "retried from "+status)
+			reset += n
+			retried = append(retried, id)
+			localPaths = append(localPaths, paths...)
+		}
+	}
+
+	detail, _ := json.Marshal(map[string]interface{}{"ids": retried})
+	if err := recordAudit(tx, "tasks.bulk_retry", string(detail)); err != nil {
+		return 0, nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to commit bulk retry: %w", err)
+	}
+	return reset, skipped, localPaths, nil
+}
+
+// queryTaskIDs returns the IDs of the tasks in the given status, compose tasks can't be resubmitted
+// and are left out
+func queryTaskIDs(tx *sql.Tx, status string) ([]int64, error) {
+	rows, err := tx.Query("SELECT id FROM tasks WHERE status = ? AND COALESCE(model, '') != ? ORDER BY id", status, ModelCompose)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetAuthFailedTaskIDs returns the IDs of failed tasks whose failure_code is auth
+// Tasks failed for content or validation reasons have other codes, even when their fail_reason
+// quotes a 401 or 403
+func GetAuthFailedTaskIDs() ([]int64, error) {
+	rows, err := ReadDB.Query("SELECT id FROM tasks WHERE status = ? AND failure_code = ?", StatusFailed, FailureAuth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tasks: %w", err)
+	}
+
+	return ids, nil
+}
+
+// RequeueAuthFailedTasks resets tasks that failed with authentication errors to pending, clearing
+// their previous run like a retry
+// Returns the number of tasks re-queued and their local files for the caller to delete
+func RequeueAuthFailedTasks() (int64, []string, error) {
+	ids, err := GetAuthFailedTaskIDs()
+	if err != nil || len(ids) == 0 {
+		return 0, nil, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int64
+	var localPaths []string
+	now := time.Now()
+	for _, id := range ids {
+		paths, err := retryLeftovers(tx, "id = ? AND status = ?", id, StatusFailed)
+		if err != nil {
+			return 0, nil, err
+		}
+		result, err := tx.Exec(resetTaskForRetrySQL+` WHERE id = ? AND status = ?`, StatusPending, now, id, StatusFailed)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to requeue task %d: %w", id, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			recordTaskEvent(tx, id, HistoryRetried, "re-queued after authentication failure")
+			count += n
+			localPaths = append(localPaths, paths...)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit requeue: %w", err)
+	}
+	return count, localPaths, nil
+}
+
+// GetSetting reads a value from app_settings, returning "" if it isn't set
+func GetSetting(key string) (string, error) {
+	var value string
+	err := ReadDB.QueryRow("SELECT value FROM app_settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// SetSetting writes a value to app_settings
+func SetSetting(key, value string) error {
+	_, err := DB.Exec(`
+		INSERT INTO app_settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to set setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// BulkUpdateTasks applies the starred, tag and priority changes of req to every task in a single transaction
+// Tasks that don't exist or reject the change are reported in the results without aborting the others
+// The operation is recorded in the audit log
+func BulkUpdateTasks(req *BulkUpdateTasksRequest) ([]BulkUpdateResult, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]BulkUpdateResult, 0, len(req.IDs))
+	updated := 0
+	for _, id := range req.IDs {
+		var status string
+		err := tx.QueryRow("SELECT status FROM tasks WHERE id = ?", id).Scan(&status)
+		if err == sql.ErrNoRows {
+			results = append(results, BulkUpdateResult{ID: id, Error: "task not found"})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get task %d: %w", id, err)
+		}
+		// Priority only orders the queue, so it can't change once a task is submitted
+		if req.Priority != nil && status != StatusPending {
+			results = append(results, BulkUpdateResult{ID: id, Error: "priority can only be changed on pending tasks"})
+			continue
+		}
+
+		if req.SetStarred != nil {
+			if _, err := tx.Exec("UPDATE tasks SET starred = ? WHERE id = ?", *req.SetStarred, id); err != nil {
+				return nil, fmt.Errorf("failed to update task %d: %w", id, err)
+			}
+		}
+		if req.Priority != nil {
+			if _, err := tx.Exec("UPDATE tasks SET priority = ? WHERE id = ?", *req.Priority, id); err != nil {
+				return nil, fmt.Errorf("failed to update task %d: %w", id, err)
+			}
+		}
+		for _, tag := range req.AddTags {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO task_tags (task_id, tag) VALUES (?, ?)", id, tag); err != nil {
+				return nil, fmt.Errorf("failed to tag task %d: %w", id, err)
+			}
+		}
+		for _, tag := range req.RemoveTags {
+			if _, err := tx.Exec("DELETE FROM task_tags WHERE task_id = ? AND tag = ?", id, tag); err != nil {
+				return nil, fmt.Errorf("failed to untag task %d: %w", id, err)
+			}
+		}
+		if _, err := tx.Exec("UPDATE tasks SET updated_at = ? WHERE id = ?", time.Now(), id); err != nil {
+			return nil, fmt.Errorf("failed to update task %d: %w", id, err)
+		}
+		results = append(results, BulkUpdateResult{ID: id, Success: true})
+		updated++
+	}
+
+	detail, _ := json.Marshal(map[string]interface{}{"request": req, "updated": updated})
+	if err := recordAudit(tx, "tasks.bulk_update", string(detail)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit bulk update: %w", err)
+	}
+	return results, nil
+}
+
+// BulkDeleteTasks deletes the given tasks and their characters, tags and history in a single transaction
+// Returns a result per ID and the local video paths of the deleted tasks, for the caller to remove
+// once the rows are gone
+func BulkDeleteTasks(ids []int64) ([]BulkUpdateResult, []string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]BulkUpdateResult, 0, len(ids))
+	var localPaths []string
+	var deleted []int64
+	for _, id := range ids {
+		var localPath string
+		err := tx.QueryRow("SELECT COALESCE(local_path, '') FROM tasks WHERE id = ?", id).Scan(&localPath)
+		if err == sql.ErrNoRows {
+			results = append(results, BulkUpdateResult{ID: id, Error: "task not found"})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get task %d: %w", id, err)
+		}
+
+		for _, query := range []string{
+			"DELETE FROM tasks WHERE id = ?",
+			"DELETE FROM task_characters WHERE task_id = ?",
+			"DELETE FROM task_tags WHERE task_id = ?",
+			"DELETE FROM task_events WHERE task_id = ?",
+		} {
+			if _, err := tx.Exec(query, id); err != nil {
+				return nil, nil, fmt.Errorf("failed to delete task %d: %w", id, err)
+			}
+		}
+		if err := recordTombstone(tx, id); err != nil {
+			return nil, nil, err
+		}
+		if localPath != "" {
+			localPaths = append(localPaths, localPath)
+		}
+		results = append(results, BulkUpdateResult{ID: id, Success: true})
+		deleted = append(deleted, id)
+	}
+
+	detail, _ := json.Marshal(map[string]interface{}{"ids": deleted})
+	if err := recordAudit(tx, "tasks.bulk_delete", string(detail)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit bulk delete: %w", err)
+	}
+	return results, localPaths, nil
+}
+
+// RecordAudit appends an entry to the audit log outside of a transaction
+func RecordAudit(action, detail string) error {
+	_, err := DB.Exec("INSERT INTO audit_log (action, detail, created_at) VALUES (?, ?, ?)", action, detail, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// recordAudit appends an entry to the audit log as part of the given transaction
+func recordAudit(tx *sql.Tx, action, detail string) error {
+	_, err := tx.Exec("INSERT INTO audit_log (action, detail, created_at) VALUES (?, ?, ?)", action, detail, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
 }
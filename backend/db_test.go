@@ -0,0 +1,725 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// createFutureSchemaDB writes a database fixture as a newer build would leave it:
+// the current schema plus a table and a column this build doesn't know about, with a higher user_version
+func createFutureSchemaDB(t *testing.T) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "future.db")
+	if err := InitDB(dbPath); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	for _, stmt := range []string{
+		`ALTER TABLE tasks ADD COLUMN future_column TEXT NOT NULL DEFAULT 'x'`,
+		`CREATE TABLE future_table (id INTEGER PRIMARY KEY)`,
+		`INSERT INTO tasks (prompt, duration, orientation, status) VALUES ('from the future', '10s', 'landscape', 'completed')`,
+		`DROP TABLE characters`,
+		`PRAGMA user_version = 999`,
+	} {
+		if _, err := DB.Exec(stmt); err != nil {
+			t.Fatalf("failed to build fixture: %v", err)
+		}
+	}
+	return dbPath
+}
+
+// TestInitDBRefusesNewerSchema verifies that InitDB leaves a newer schema untouched
+func TestInitDBRefusesNewerSchema(t *testing.T) {
+	dbPath := createFutureSchemaDB(t)
+
+	err := InitDB(dbPath)
+	var tooNew *SchemaTooNewError
+	if !errors.As(err, &tooNew) {
+		t.Fatalf("expected SchemaTooNewError, got %v", err)
+	}
+	if tooNew.Version != 999 || tooNew.Supported != SchemaVersion {
+		t.Errorf("unexpected versions in error: %+v", tooNew)
+	}
+
+	// No migration may have run: the characters table dropped by the newer version must not come back
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen fixture: %v", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'characters'").Scan(&count); err != nil {
+		t.Fatalf("failed to inspect schema: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("InitDB migrated a newer schema")
+	}
+}
+
+// TestOpenDBReadOnlyNewerSchema verifies that a newer schema can be browsed but not modified
+func TestOpenDBReadOnlyNewerSchema(t *testing.T) {
+	dbPath := createFutureSchemaDB(t)
+
+	if err := OpenDBReadOnly(dbPath); err != nil {
+		t.Fatalf("OpenDBReadOnly failed: %v", err)
+	}
+	defer CloseDB()
+
+	tasks, err := GetTasksByStatus([]string{StatusCompleted})
+	if err != nil {
+		t.Fatalf("failed to read tasks: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Prompt != "from the future" {
+		t.Errorf("unexpected tasks: %+v", tasks)
+	}
+
+	if _, err := DB.Exec("UPDATE tasks SET prompt = 'changed'"); err == nil {
+		t.Errorf("expected write to fail in read-only mode")
+	}
+}
+
+// TestInitDBRecordsSchemaVersion verifies that a fresh database is stamped with SchemaVersion
+func TestInitDBRecordsSchemaVersion(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "fresh.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	version, err := GetSchemaVersion()
+	if err != nil {
+		t.Fatalf("GetSchemaVersion failed: %v", err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("schema version = %d, want %d", version, SchemaVersion)
+	}
+}
+
+// TestMigrateLegacyDatabase upgrades a database left by the probe-based migrations of old builds
+// and checks every migration is recorded once
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := slices.Concat(brokenDatabaseFixtures["old tasks table with junk rows"], brokenDatabaseFixtures["characters stuck between schemas"])
+	for _, stmt := range fixture {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to build fixture: %v", err)
+		}
+	}
+	db.Close()
+
+	if err := InitDB(dbPath); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	var createSQL string
+	DB.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'tasks'").Scan(&createSQL)
+	if strings.Contains(strings.ToUpper(createSQL), "UNIQUE") {
+		t.Errorf("task_id is still UNIQUE: %s", createSQL)
+	}
+	// The probe rows are removed, the task created with the same prompt is kept
+	tasks, _ := GetAllTasks()
+	byPrompt := make(map[string]Task)
+	for _, task := range tasks {
+		byPrompt[task.Prompt] = task
+	}
+	if _, kept := byPrompt["test"]; len(tasks) != 2 || byPrompt["keep me"].LocalPath != "a.mp4" || !kept {
+		t.Errorf("tasks after migration: %+v", tasks)
+	}
+	for range 2 {
+		if _, err := CreateTask(&CreateTaskRequest{Prompt: "pending", Duration: Duration10s, Orientation: OrientationLandscape}); err != nil {
+			t.Errorf("CreateTask with an empty task_id failed: %v", err)
+		}
+	}
+	characters, _ := GetAllCharactersSorted(CharacterSortName)
+	if len(characters) != 2 {
+		t.Errorf("got %d characters after migration, want 2", len(characters))
+	}
+	CloseDB()
+
+	// Applied migrations are skipped on the next start
+	if err := InitDB(dbPath); err != nil {
+		t.Fatalf("second InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	var applied int
+	DB.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+	}
+}
+
+// TestFailedMigrationStopsInitDB verifies a failing migration is rolled back and reported
+func TestFailedMigrationStopsInitDB(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "broken.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A legacy character without a name can't be copied to the training API schema
+	for _, stmt := range []string{
+		`CREATE TABLE characters (id INTEGER PRIMARY KEY AUTOINCREMENT, api_id TEXT, from_task_id TEXT,
+			custom_name TEXT, description TEXT, timestamps TEXT NOT NULL, created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO characters (api_id, from_task_id, timestamps) VALUES ('char_1', 'task_1', '1,3')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to build fixture: %v", err)
+		}
+	}
+	db.Close()
+
+	err = InitDB(dbPath)
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.Version != 24 {
+		t.Fatalf("expected migration 24 to fail, got %v", err)
+	}
+
+	db, err = sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var last, legacy int
+	db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&last)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('characters') WHERE name = 'api_id'").Scan(&legacy)
+	if last != 23 || legacy != 1 {
+		t.Errorf("last recorded migration %d, legacy characters table kept %v", last, legacy == 1)
+	}
+}
+
+// TestClaimTask verifies that only the first claim of a task succeeds
+func TestClaimTask(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "claim.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "claim me", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+
+	claimed, err := ClaimTask(task.ID, StatusPending, StatusSubmitting)
+	if err != nil || !claimed {
+		t.Fatalf("first claim: claimed=%v err=%v", claimed, err)
+	}
+	claimed, err = ClaimTask(task.ID, StatusPending, StatusSubmitting)
+	if err != nil || claimed {
+		t.Fatalf("second claim: claimed=%v err=%v", claimed, err)
+	}
+
+	status, _ := GetTaskStatus(task.ID)
+	if status != StatusSubmitting {
+		t.Errorf("status = %q, want %q", status, StatusSubmitting)
+	}
+}
+
+// TestUpdateProcessingProgress checks a progress update doesn't bring back a task that left processing
+func TestUpdateProcessingProgress(t *testing.T) {
+	if err := InitDB(":memory:"); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	UpdateTaskStatus(task.ID, StatusProcessing, 10, "", "")
+	if saved, err := UpdateProcessingProgress(task.ID, 40, milestonesUpTo(40)); err != nil || !saved {
+		t.Fatalf("processing task: saved=%v err=%v", saved, err)
+	}
+
+	if cancelled, err := CancelTask(task.ID, StatusProcessing, StatusCancelled, ""); err != nil || !cancelled {
+		t.Fatalf("CancelTask: cancelled=%v err=%v", cancelled, err)
+	}
+	if saved, err := UpdateProcessingProgress(task.ID, 60, milestonesUpTo(60)); err != nil || saved {
+		t.Fatalf("cancelled task: saved=%v err=%v", saved, err)
+	}
+	got, _ := GetTask(task.ID)
+	if got.Status != StatusCancelled || got.Progress == 60 || got.MilestonesFired.has(50) {
+		t.Errorf("cancelled task overwritten: status=%q progress=%d milestones=%v", got.Status, got.Progress, got.MilestonesFired)
+	}
+}
+
+// TestConcurrentReadsAndWrites lists tasks from several goroutines while progress updates are
+// written, none may fail with "database is locked", and reads don't wait for an open write
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "concurrent.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	var ids []int64
+	for i := 0; i < 20; i++ {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: fmt.Sprintf("task %d", i), Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, ReadPoolSize+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for progress := 1; progress <= 100; progress++ {
+			for _, id := range ids {
+				if err := SetTaskProgress(id, progress); err != nil {
+					errs <- fmt.Errorf("SetTaskProgress: %w", err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < ReadPoolSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := GetAllTasks(); err != nil {
+					errs <- fmt.Errorf("GetAllTasks: %w", err)
+					return
+				}
+				if _, _, err := GetTasksPaginated(TaskFilter{}, 10, 0); err != nil {
+					errs <- fmt.Errorf("GetTasksPaginated: %w", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// A write transaction holds the writer connection, reads still go through
+	tx, err := DB.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE tasks SET progress = 0"); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := GetAllTasks()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("GetAllTasks during a write: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetAllTasks waited for the write transaction")
+	}
+	if task, _ := GetTask(ids[0]); task.Progress != 100 {
+		t.Errorf("progress = %d while the update is uncommitted, want 100", task.Progress)
+	}
+}
+
+// TestVacuumDatabase reports the pages freed by deleted tasks, refuses to vacuum while a task is
+// processing and reclaims the free pages
+func TestVacuumDatabase(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "vacuum.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	var ids []int64
+	for i := 0; i < 50; i++ {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: strings.Repeat("long prompt ", 1000), Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+	DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusProcessing, ids[0])
+	DB.Exec("DELETE FROM tasks WHERE id > ?", ids[0])
+
+	stats, err := GetDatabaseStats()
+	if err != nil {
+		t.Fatalf("GetDatabaseStats failed: %v", err)
+	}
+	if stats.Tables["tasks"] != 1 || stats.FreelistPages == 0 || stats.SizeBytes != stats.PageCount*stats.PageSize {
+		t.Errorf("stats before vacuum: %+v", stats)
+	}
+	if _, ok := stats.Tables[taskSearchTable]; ok {
+		t.Errorf("search table counted: %v", stats.Tables)
+	}
+
+	rec := httptest.NewRecorder()
+	handleVacuum(rec, httptest.NewRequest(http.MethodPost, "/api/maintenance/vacuum", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("vacuum with a processing task: status %d, want 409", rec.Code)
+	}
+
+	result, err := VacuumDatabase()
+	if err != nil {
+		t.Fatalf("VacuumDatabase failed: %v", err)
+	}
+	if result.After.FreelistPages != 0 || result.After.WALBytes != 0 || result.ReclaimedBytes <= 0 {
+		t.Errorf("after vacuum: %+v, reclaimed %d", result.After, result.ReclaimedBytes)
+	}
+}
+
+// TestRetryFailedSkipsProcessing verifies that retrying failed tasks leaves processing ones alone
+// and that resetting stuck tasks only touches processing tasks older than the threshold
+func TestRetryFailedSkipsProcessing(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "reset.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	create := func(status string, updatedAt time.Time) int64 {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: status, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, task_id = 'video_x', updated_at = ? WHERE id = ?", status, updatedAt, task.ID)
+		return task.ID
+	}
+	failedID := create(StatusFailed, time.Now())
+	healthyID := create(StatusProcessing, time.Now())
+	stuckID := create(StatusProcessing, time.Now().Add(-2*time.Hour))
+
+	ids, _, err := RetryFailedTasks(nil)
+	if err != nil || !slices.Equal(ids, []int64{failedID}) {
+		t.Fatalf("retry failed: ids=%v err=%v", ids, err)
+	}
+	if status, _ := GetTaskStatus(stuckID); status != StatusProcessing {
+		t.Errorf("processing task reset by the failed retry")
+	}
+
+	ids, _, err = ResetStuckTasks(time.Now().Add(-30*time.Minute), nil)
+	if err != nil || !slices.Equal(ids, []int64{stuckID}) {
+		t.Fatalf("reset stuck: ids=%v err=%v", ids, err)
+	}
+	for id, want := range map[int64]string{failedID: StatusPending, healthyID: StatusProcessing, stuckID: StatusPending} {
+		if status, _ := GetTaskStatus(id); status != want {
+			t.Errorf("task %d status = %q, want %q", id, status, want)
+		}
+	}
+}
+
+// TestRequeueAuthFailedTasks checks only tasks stored with the auth failure code are re-queued,
+// whatever their fail_reason quotes
+func TestRequeueAuthFailedTasks(t *testing.T) {
+	if err := InitDB(":memory:"); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	create := func(failReason, failureCode string) int64 {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		if err := UpdateTaskStatus(task.ID, StatusFailed, 0, failReason, failureCode); err != nil {
+			t.Fatalf("UpdateTaskStatus failed: %v", err)
+		}
+		return task.ID
+	}
+	authID := create("无法提交: API error (status 401): invalid api key", FailureAuth)
+	contentID := create("内容未通过审核: API error (status 403): content violates policy", FailureContentPolicy)
+	uncodedID := create("API error (status 401): unauthorized", "")
+	// Left by an earlier run of the task
+	DB.Exec(`UPDATE tasks SET local_path = 'partial.mp4', thumbnail = 'thumbs/partial.jpg', warning = 'orientation',
+		warning_message = 'rotated', width = 720, last_api_response = '{}' WHERE id = ?`, authID)
+
+	count, localPaths, err := RequeueAuthFailedTasks()
+	if err != nil || count != 1 {
+		t.Fatalf("RequeueAuthFailedTasks = %d, %v, want 1", count, err)
+	}
+	if want := []string{"partial.mp4", filepath.Join(ThumbnailSubdirectory, "partial.jpg")}; !slices.Equal(localPaths, want) {
+		t.Errorf("leftover files = %v, want %v", localPaths, want)
+	}
+	for id, want := range map[int64]string{authID: StatusPending, contentID: StatusFailed, uncodedID: StatusFailed} {
+		if status, _ := GetTaskStatus(id); status != want {
+			t.Errorf("task %d status = %q, want %q", id, status, want)
+		}
+	}
+	if task, _ := GetTask(authID); task.FailReason != "" || task.FailureCode != "" || task.LocalPath != "" ||
+		task.Thumbnail != "" || task.Warning != "" || task.WarningMessage != "" || task.Width != 0 {
+		t.Errorf("re-queued task keeps its previous run: %+v", task)
+	}
+
+	// Tasks failed before the codes were stored are classified content first
+	if code := legacyFailureCode("内容未通过审核: API error (status 403): content violates policy"); code != FailureContentPolicy {
+		t.Errorf("legacy content policy 403 classified %q", code)
+	}
+	if code := legacyFailureCode("API error (status 401): invalid api key"); code != FailureAuth {
+		t.Errorf("legacy auth failure classified %q", code)
+	}
+}
+
+func TestGetFailedTasksSince(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "reconcile.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	create := func(status, taskID string, updatedAt time.Time) int64 {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: status, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, task_id = ?, updated_at = ? WHERE id = ?", status, taskID, updatedAt, task.ID)
+		return task.ID
+	}
+	recentID := create(StatusFailed, "video_recent", time.Now().Add(-time.Hour))
+	create(StatusFailed, "video_old", time.Now().Add(-72*time.Hour))
+	create(StatusFailed, "", time.Now())
+	create(StatusCompleted, "video_done", time.Now())
+
+	tasks, err := GetFailedTasksSince(time.Now().Add(-DefaultReconcileLookback))
+	if err != nil {
+		t.Fatalf("GetFailedTasksSince failed: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != recentID {
+		t.Fatalf("got %d tasks, want only task %d", len(tasks), recentID)
+	}
+}
+
+func TestRetryTaskOverrides(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "retry.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "too big", Duration: Duration10s, Orientation: OrientationPortrait})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	landscape := OrientationLandscape
+	overrides := &RetryOverrides{Orientation: &landscape}
+
+	if reset, err := RetryTask(task.ID, overrides); err != nil || reset {
+		t.Fatalf("pending task retried: reset=%v err=%v", reset, err)
+	}
+
+	DB.Exec("UPDATE tasks SET status = ?, task_id = 'video_x', fail_reason = 'content size' WHERE id = ?", StatusFailed, task.ID)
+	if reset, err := RetryTask(task.ID, overrides); err != nil || !reset {
+		t.Fatalf("RetryTask: reset=%v err=%v", reset, err)
+	}
+
+	got, _ := GetTask(task.ID)
+	if got.Status != StatusPending || got.TaskID != "" || got.Orientation != OrientationLandscape || got.Duration != Duration10s {
+		t.Errorf("retried task = %+v", got)
+	}
+	var detail string
+	if err := DB.QueryRow("SELECT detail FROM audit_log WHERE action = 'tasks.retry'").Scan(&detail); err != nil {
+		t.Fatalf("no audit entry: %v", err)
+	}
+	if !strings.Contains(detail, "orientation portrait -> landscape") {
+		t.Errorf("audit detail = %q", detail)
+	}
+
+	// The watermark is turned on with the retry, and recorded like the other options
+	watermark := true
+	DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusFailed, task.ID)
+	if reset, err := RetryTask(task.ID, &RetryOverrides{Watermark: &watermark}); err != nil || !reset {
+		t.Fatalf("RetryTask with watermark: reset=%v err=%v", reset, err)
+	}
+	if got, _ := GetTask(task.ID); !got.Watermark || got.Orientation != OrientationLandscape {
+		t.Errorf("task retried with watermark = %+v", got)
+	}
+	if err := DB.QueryRow("SELECT detail FROM audit_log WHERE action = 'tasks.retry' ORDER BY id DESC LIMIT 1").Scan(&detail); err != nil || !strings.Contains(detail, "watermark false -> true") {
+		t.Errorf("audit detail = %q, err %v", detail, err)
+	}
+	events, _ := GetTaskEvents(task.ID)
+	if !slices.ContainsFunc(events, func(e TaskHistoryEvent) bool { return strings.Contains(e.Detail, "watermark false -> true") }) {
+		t.Errorf("no watermark change in the history: %+v", events)
+	}
+}
+
+func TestGetTasksByDateRange(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "dates.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	create := func(createdAt time.Time) int64 {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "p", Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET created_at = ? WHERE id = ?", createdAt, task.ID)
+		return task.ID
+	}
+	friday := create(time.Date(2026, 10, 9, 23, 59, 0, 0, time.Local))
+	saturday := create(time.Date(2026, 10, 10, 0, 0, 0, 0, time.Local))
+	sunday := create(time.Date(2026, 10, 11, 23, 59, 59, 0, time.Local))
+	create(time.Date(2026, 10, 12, 0, 0, 1, 0, time.Local))
+
+	tasks, err := GetTasksByDateRange("2026-10-10", "2026-10-11")
+	if err != nil {
+		t.Fatalf("GetTasksByDateRange failed: %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != sunday || tasks[1].ID != saturday {
+		t.Errorf("weekend tasks = %+v, want %d and %d", tasks, sunday, saturday)
+	}
+
+	page, total, err := GetTasksPaginated(TaskFilter{EndDate: "2026-10-10"}, 1, 0)
+	if err != nil || total != 2 || len(page) != 1 || page[0].ID != saturday {
+		t.Errorf("open start range: total %d, page %+v, err %v (friday is %d)", total, page, err, friday)
+	}
+
+	if err := validateDateRange("2026-10-11", "2026-10-10"); err == nil {
+		t.Errorf("end before start accepted")
+	}
+	if err := validateDateRange("10/10/2026", ""); err == nil {
+		t.Errorf("invalid date accepted")
+	}
+}
+
+func TestGetTasksSorted(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "sort.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	base := time.Now().Add(-time.Hour)
+	var ids []int64
+	for i, progress := range []int{50, 0, 50} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "p", Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		// Created in order, but the first task finished last
+		DB.Exec("UPDATE tasks SET progress = ?, created_at = ?, updated_at = ? WHERE id = ?",
+			progress, base.Add(time.Duration(i)*time.Minute), base.Add(time.Duration(10-i)*time.Minute), task.ID)
+		ids = append(ids, task.ID)
+	}
+
+	order := func(filter TaskFilter) []int64 {
+		t.Helper()
+		tasks, _, err := GetTasksPaginated(filter, 10, 0)
+		if err != nil {
+			t.Fatalf("GetTasksPaginated failed: %v", err)
+		}
+		var got []int64
+		for _, task := range tasks {
+			got = append(got, task.ID)
+		}
+		return got
+	}
+	cases := []struct {
+		filter TaskFilter
+		want   []int64
+	}{
+		{TaskFilter{}, []int64{ids[2], ids[1], ids[0]}},
+		{TaskFilter{SortBy: "updated_at"}, []int64{ids[0], ids[1], ids[2]}},
+		{TaskFilter{SortBy: "created_at", Ascending: true}, []int64{ids[0], ids[1], ids[2]}},
+		{TaskFilter{SortBy: "progress", Ascending: true}, []int64{ids[1], ids[0], ids[2]}},
+		{TaskFilter{SortBy: "prompt; DROP TABLE tasks"}, []int64{ids[2], ids[1], ids[0]}},
+	}
+	for _, tc := range cases {
+		if got := order(tc.filter); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%+v: order %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+}
+
+// TestTargetedTaskUpdates checks each targeted update only writes its own columns, the image and
+// prompt of the task are never rewritten
+func TestTargetedTaskUpdates(t *testing.T) {
+	if err := InitDB(":memory:"); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	image := "data:image/png;base64," + strings.Repeat("A", 4096)
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", ImageURL: image, Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+
+	if err := UpdateTaskSubmission(task.ID, "video_1", "prefix a cat", "fp", "sora2-landscape", 1); err != nil {
+		t.Fatalf("UpdateTaskSubmission failed: %v", err)
+	}
+	if err := UpdateTaskStatus(task.ID, StatusProcessing, 40, "", ""); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+	if err := UpdateTaskCompletion(task.ID, "https://example.com/v.mp4", "video_1.mp4", 100); err != nil {
+		t.Fatalf("UpdateTaskCompletion failed: %v", err)
+	}
+
+	got, _ := GetTask(task.ID)
+	if got.TaskID != "video_1" || got.SubmittedPrompt != "prefix a cat" || got.APIKeyFingerprint != "fp" || got.ModelUsed != "sora2-landscape" || got.Retries != 1 {
+		t.Errorf("submission = %+v", got)
+	}
+	if got.Status != StatusProcessing || got.Progress != 40 || got.VideoURL != "https://example.com/v.mp4" || got.LocalPath != "video_1.mp4" || got.DownloadProgress != 100 {
+		t.Errorf("status %s progress %d video %q %q %d", got.Status, got.Progress, got.VideoURL, got.LocalPath, got.DownloadProgress)
+	}
+	if got.Prompt != "a cat" || got.ImageURL != image {
+		t.Errorf("prompt %q or image rewritten", got.Prompt)
+	}
+}
+
+// TestRetryTasks checks a bulk retry resets only the selected tasks and reports the skipped ones
+func TestRetryTasks(t *testing.T) {
+	if err := InitDB(":memory:"); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	ids := make(map[string]int64)
+	for _, status := range []string{StatusFailed, StatusProcessing, StatusCompleted, StatusPending, "unselected"} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: status, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		dbStatus := status
+		if status == "unselected" {
+			dbStatus = StatusFailed
+		}
+		DB.Exec(`UPDATE tasks SET status = ?, task_id = 'video_x', progress = 40, video_url = 'https://example.com/v.mp4',
+			fail_reason = 'boom' WHERE id = ?`, dbStatus, task.ID)
+		ids[status] = task.ID
+	}
+
+	reset, skipped, _, err := RetryTasks([]int64{ids[StatusFailed], ids[StatusProcessing], ids[StatusCompleted], ids[StatusPending], 9999})
+	if err != nil {
+		t.Fatalf("RetryTasks failed: %v", err)
+	}
+	if reset != 2 {
+		t.Errorf("reset = %d, want 2", reset)
+	}
+	wantSkipped := []BulkSkippedTask{
+		{ids[StatusCompleted], "task is completed"},
+		{ids[StatusPending], "task is already pending"},
+		{9999, "task not found"},
+	}
+	if fmt.Sprint(skipped) != fmt.Sprint(wantSkipped) {
+		t.Errorf("skipped = %v, want %v", skipped, wantSkipped)
+	}
+
+	for _, status := range []string{StatusFailed, StatusProcessing} {
+		task, _ := GetTask(ids[status])
+		if task.Status != StatusPending || task.TaskID != "" || task.Progress != 0 || task.VideoURL != "" || task.FailReason != "" {
+			t.Errorf("%s task not reset: %+v", status, task)
+		}
+	}
+	if task, _ := GetTask(ids[StatusCompleted]); task.Status != StatusCompleted || task.VideoURL == "" {
+		t.Errorf("completed task changed: %+v", task)
+	}
+	if task, _ := GetTask(ids["unselected"]); task.Status != StatusFailed || task.FailReason != "boom" {
+		t.Errorf("unselected task changed: %+v", task)
+	}
+}
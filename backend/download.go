@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// PartSuffix marks a download in progress, the file is renamed to its final .mp4 name once complete
+	PartSuffix = ".part"
+	// partStateSuffix is appended to a .part file for the sidecar listing its completed byte ranges
+	partStateSuffix = ".json"
+	// StalePartAge is the age after which an abandoned .part file is removed
+	StalePartAge = 24 * time.Hour
+)
+
+const (
+	// MaxDownloadThreads bounds download_threads
+	MaxDownloadThreads = 32
+	// DefaultDownloadChunkMinMB is the smallest chunk of a parallel download when download_chunk_min_mb is not set
+	DefaultDownloadChunkMinMB = 1
+)
+
+// DownloadOptions controls how videos are downloaded, see the download_* settings
+type DownloadOptions struct {
+	Threads        int   // Parallel range requests, 0 for automatic
+	ChunkMinBytes  int64 // Smallest chunk of a parallel download
+	BytesPerSecond int64 // Bandwidth cap of each download, 0 is unlimited
+}
+
+// downloadOptions returns the download settings of the configuration
+func downloadOptions(config *Config) DownloadOptions {
+	chunkMinMB := config.DownloadChunkMinMB
+	if chunkMinMB <= 0 {
+		chunkMinMB = DefaultDownloadChunkMinMB
+	}
+	return DownloadOptions{
+		Threads:        config.DownloadThreads,
+		ChunkMinBytes:  int64(chunkMinMB) << 20,
+		BytesPerSecond: int64(config.DownloadRateLimitKbps) * 1000 / 8,
+	}
+}
+
+// validateDownloadOptions checks the download_* settings
+func validateDownloadOptions(config *Config) error {
+	if config.DownloadThreads < 0 || config.DownloadThreads > MaxDownloadThreads {
+		return fmt.Errorf("download_threads must be between 0 and %d", MaxDownloadThreads)
+	}
+	if config.DownloadChunkMinMB < 0 {
+		return fmt.Errorf("download_chunk_min_mb must not be negative")
+	}
+	if config.DownloadWorkers < 0 || config.DownloadWorkers > MaxDownloadWorkers {
+		return fmt.Errorf("download_workers must be between 0 and %d", MaxDownloadWorkers)
+	}
+	if config.DownloadRateLimitKbps < 0 {
+		return fmt.Errorf("download_rate_limit_kbps must not be negative")
+	}
+	return nil
+}
+
+// threads returns the number of range requests for a video of the given size, 1 for a single stream
+// Chunks are never smaller than ChunkMinBytes
+func (o DownloadOptions) threads(size int64) int {
+	threads := o.Threads
+	if threads == 0 {
+		threads = 8
+		if size < 10*1024*1024 { // Less than 10MB
+			threads = 4
+		}
+	}
+	return int(max(1, min(int64(threads), size/o.ChunkMinBytes)))
+}
+
+// String describes the options for the download log
+func (o DownloadOptions) String() string {
+	threads := "auto"
+	if o.Threads > 0 {
+		threads = fmt.Sprint(o.Threads)
+	}
+	rate := "unlimited"
+	if o.BytesPerSecond > 0 {
+		rate = fmt.Sprintf("%d kbps", o.BytesPerSecond*8/1000)
+	}
+	return fmt.Sprintf("threads %s, min chunk %d MB, rate %s", threads, o.ChunkMinBytes>>20, rate)
+}
+
+// partFilePath returns the .part file of the video of an upstream task
+// The name is stable across attempts so a retry resumes where the previous one stopped
+func partFilePath(taskID string) string {
+	return filepath.Join(OutputDirectory(), strings.ReplaceAll(taskID, ":", "_")+".mp4"+PartSuffix)
+}
+
+// partState records which byte ranges of a .part file have been written
+type partState struct {
+	Size int64      `json:"size"` // Expected size of the video, 0 when unknown
+	Done [][2]int64 `json:"done"` // Completed inclusive byte ranges, sorted and merged
+	path string
+}
+
+// loadPartState reads the sidecar of a .part file
+// Without a sidecar, or when it was written for another size, the download starts over
+func loadPartState(partPath string, size int64) *partState {
+	state := &partState{Size: size, path: partPath + partStateSuffix}
+	data, err := os.ReadFile(state.path)
+	if err != nil {
+		return state
+	}
+	var saved partState
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Size != size {
+		return state
+	}
+	info, err := os.Stat(partPath)
+	if err != nil || (size > 0 && info.Size() != size) {
+		return state
+	}
+	state.Done = saved.Done
+	return state
+}
+
+// add marks [start, end] as written, merging it with the adjacent ranges
+func (s *partState) add(start, end int64) {
+	ranges := append(s.Done, [2]int64{start, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1]+1 {
+			last[1] = max(last[1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.Done = merged
+}
+
+// missing returns the ranges of [start, end] that have not been written yet
+func (s *partState) missing(start, end int64) [][2]int64 {
+	var gaps [][2]int64
+	for _, r := range s.Done {
+		if r[1] < start || r[0] > end {
+			continue
+		}
+		if r[0] > start {
+			gaps = append(gaps, [2]int64{start, r[0] - 1})
+		}
+		start = r[1] + 1
+	}
+	if start <= end {
+		gaps = append(gaps, [2]int64{start, end})
+	}
+	return gaps
+}
+
+// prefix returns the length of the contiguous written range at the start of the file
+func (s *partState) prefix() int64 {
+	if len(s.Done) == 0 || s.Done[0][0] != 0 {
+		return 0
+	}
+	return s.Done[0][1] + 1
+}
+
+// written returns the number of bytes already written
+func (s *partState) written() int64 {
+	var total int64
+	for _, r := range s.Done {
+		total += r[1] - r[0] + 1
+	}
+	return total
+}
+
+// save writes the sidecar, failures only cost the ability to resume
+func (s *partState) save() {
+	data, _ := json.Marshal(s)
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		log.Printf("[Download] 保存下载进度失败: %v", err)
+	}
+}
+
+// removePart deletes a .part file and its sidecar
+func removePart(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + partStateSuffix)
+}
+
+// finishPart moves a complete .part file to its final name and drops the sidecar
+func finishPart(partPath, localPath string) error {
+	if err := os.Rename(partPath, localPath); err != nil {
+		removePart(partPath)
+		return err
+	}
+	os.Remove(partPath + partStateSuffix)
+	return nil
+}
+
+// cleanStaleParts removes the .part files, and their sidecars, abandoned for more than StalePartAge
+func cleanStaleParts() {
+	entries, err := os.ReadDir(OutputDirectory())
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-StalePartAge)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), PartSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		log.Printf("[Download] 删除过期的未完成下载: %s", entry.Name())
+		removePart(filepath.Join(OutputDirectory(), entry.Name()))
+	}
+}
+
+// DownloadProgressFunc receives the bytes of a video written so far and its total size
+type DownloadProgressFunc func(written, total int64)
+
+// downloadProgressKey is the context key of the DownloadProgressFunc of a download
+type downloadProgressKey struct{}
+
+// WithDownloadProgress returns a context whose video downloads report their progress to fn
+// Chunks are written concurrently, fn must be safe for concurrent use
+func WithDownloadProgress(ctx context.Context, fn DownloadProgressFunc) context.Context {
+	return context.WithValue(ctx, downloadProgressKey{}, fn)
+}
+
+// downloadCounter counts the bytes written by all the chunks of a download
+type downloadCounter struct {
+	written atomic.Int64
+	total   int64
+	report  DownloadProgressFunc
+}
+
+// newDownloadCounter starts counting from the bytes already written by an earlier attempt
+// total is 0 when the size is unknown, progress is then not reported
+func newDownloadCounter(ctx context.Context, written, total int64) *downloadCounter {
+	counter := &downloadCounter{total: total}
+	counter.written.Store(written)
+	counter.report, _ = ctx.Value(downloadProgressKey{}).(DownloadProgressFunc)
+	return counter
+}
+
+func (c *downloadCounter) Write(p []byte) (int, error) {
+	written := c.written.Add(int64(len(p)))
+	if c.report != nil && c.total > 0 {
+		c.report(written, c.total)
+	}
+	return len(p), nil
+}
+
+// tokenBucket limits the bandwidth shared by the chunks of a download
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket letting bytesPerSecond through, nil when unlimited
+func newTokenBucket(bytesPerSecond int64) *tokenBucket {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &tokenBucket{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// take reserves n bytes and waits until they may pass
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitedReader reads through a tokenBucket
+type rateLimitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	bucket *tokenBucket
+}
+
+// limitReader returns r limited by bucket, r itself when bucket is nil
+func limitReader(ctx context.Context, r io.Reader, bucket *tokenBucket) io.Reader {
+	if bucket == nil {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, bucket: bucket}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// Small reads keep the waits short and the rate smooth
+	if limit := int(l.bucket.burst / 4); limit > 0 && len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.bucket.take(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Task event types sent over /api/events
+const (
+	EventTaskUpdated   = "task_updated"
+	EventTaskCompleted = "task_completed"
+	EventTaskFailed    = "task_failed"
+)
+
+const (
+	// eventBufferSize is the number of events buffered per subscriber before it is dropped
+	eventBufferSize = 64
+	// eventKeepAlive is the interval of SSE comments keeping idle connections open
+	eventKeepAlive = 30 * time.Second
+)
+
+// TaskEvent is a task change pushed to event subscribers
+type TaskEvent struct {
+	Type string
+	Task Task
+}
+
+// eventBroadcaster fans task events out to the connected SSE clients
+type eventBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan TaskEvent]struct{}
+}
+
+// events is the global task event broadcaster
+var events = &eventBroadcaster{subscribers: make(map[chan TaskEvent]struct{})}
+
+// Subscribe registers a new subscriber; the channel is closed when it unsubscribes or falls behind
+func (b *eventBroadcaster) Subscribe() chan TaskEvent {
+	ch := make(chan TaskEvent, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *eventBroadcaster) Unsubscribe(ch chan TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends the event to all subscribers without blocking
+// A subscriber whose buffer is full is dropped; the client reconnects and gets a fresh snapshot
+func (b *eventBroadcaster) Publish(event TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// PublishTaskUpdate publishes a task change with the event type derived from its status
+func PublishTaskUpdate(task *Task) {
+	eventType := EventTaskUpdated
+	switch task.Status {
+	case StatusCompleted:
+		eventType = EventTaskCompleted
+	case StatusFailed, StatusCancelled:
+		eventType = EventTaskFailed
+	}
+	events.Publish(TaskEvent{Type: eventType, Task: eventTask(task)})
+}
+
+// eventTask strips the images from a task like the task list responses do (base64 images are large)
+func eventTask(task *Task) Task {
+	t := *task
+	t.ImageURL = ""
+	t.ImageURL2 = ""
+	return t
+}
+
+// writeEvent writes a single SSE message
+func writeEvent(w http.ResponseWriter, event TaskEvent) error {
+	data, err := json.Marshal(event.Task)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// handleEvents handles GET /api/events
+// Streams task changes as Server-Sent Events, starting with a snapshot of the tasks still in progress
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// Subscribe before loading the snapshot so no change in between is lost
+	ch := events.Subscribe()
+	defer events.Unsubscribe(ch)
+
+	snapshot, err := GetTasksByStatus([]string{StatusPending, StatusSubmitting, StatusProcessing, StatusDownloading})
+	if err != nil {
+		log.Printf("Failed to get tasks for event snapshot: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for i := range snapshot {
+		if err := writeEvent(w, TaskEvent{Type: EventTaskUpdated, Task: snapshot[i]}); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
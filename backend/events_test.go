@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestEventsSnapshot checks the snapshot sent on connect holds every task still in progress
+func TestEventsSnapshot(t *testing.T) {
+	if err := InitDB(":memory:"); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	for _, status := range []string{StatusPending, StatusSubmitting, StatusProcessing, StatusDownloading, StatusCompleted} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: status, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		UpdateTaskStatus(task.ID, status, 0, "", "")
+	}
+
+	// A client that is already gone only gets the snapshot
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	handleEvents(rec, httptest.NewRequest(http.MethodGet, "/api/events", nil).WithContext(ctx))
+
+	body := rec.Body.String()
+	for _, status := range []string{StatusPending, StatusSubmitting, StatusProcessing, StatusDownloading} {
+		if !strings.Contains(body, `"status":"`+status+`"`) {
+			t.Errorf("%s task missing from the snapshot", status)
+		}
+	}
+	if strings.Contains(body, `"status":"`+StatusCompleted+`"`) {
+		t.Errorf("completed task in the snapshot")
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ExportPageSize is the number of tasks read from the database per page of an export
+	ExportPageSize = 500
+	// ImportBatchSize is the number of tasks inserted per transaction during an import
+	ImportBatchSize = 200
+
+	exportFormatJSON   = "json"
+	exportFormatNDJSON = "ndjson"
+)
+
+// ImportResult is the result of a task import job
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // Tasks whose API task_id already exists
+}
+
+// handleExportTasks handles GET /api/tasks/export
+// Streams all tasks in ID order as a JSON array or, with format=ndjson, one task per line
+// Images are only included with include_images=true; after_id=N resumes an interrupted export
+// after the last task received
+func handleExportTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = exportFormatJSON
+	}
+	if format != exportFormatJSON && format != exportFormatNDJSON {
+		writeError(w, http.StatusBadRequest, "format must be json or ndjson")
+		return
+	}
+	includeImages := query.Get("include_images") == "true"
+	var afterID int64
+	if afterStr := query.Get("after_id"); afterStr != "" {
+		id, err := strconv.ParseInt(afterStr, 10, 64)
+		if err != nil || id < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid after_id")
+			return
+		}
+		afterID = id
+	}
+
+	// Read the first page before committing to a 200 response
+	tasks, err := GetTasksAfter(afterID, ExportPageSize, includeImages)
+	if err != nil {
+		log.Printf("Failed to export tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to export tasks")
+		return
+	}
+
+	if format == exportFormatNDJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasks.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	first := true
+	if format == exportFormatJSON {
+		io.WriteString(w, "[\n")
+	}
+
+	for len(tasks) > 0 {
+		for i := range tasks {
+			if format == exportFormatJSON && !first {
+				io.WriteString(w, ",")
+			}
+			first = false
+			// Encode appends the newline that terminates NDJSON lines
+			if err := encoder.Encode(&tasks[i]); err != nil {
+				// Client went away, it can resume with after_id
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		afterID = tasks[len(tasks)-1].ID
+		if len(tasks) < ExportPageSize {
+			break
+		}
+		if tasks, err = GetTasksAfter(afterID, ExportPageSize, includeImages); err != nil {
+			// Headers are sent already, the truncated output is resumable from the last ID written
+			log.Printf("Failed to export tasks after %d: %v", afterID, err)
+			return
+		}
+	}
+
+	if format == exportFormatJSON {
+		io.WriteString(w, "]\n")
+	}
+}
+
+// taskCSVHeader is the header row of the CSV export
+var taskCSVHeader = []string{"id", "task_id", "prompt", "model", "duration", "orientation", "status",
+	"fail_reason", "created_at", "updated_at", "local_file", "model_used"}
+
+// taskCSVRecord returns the CSV export row of a task
+func taskCSVRecord(task *Task) []string {
+	return []string{
+		strconv.FormatInt(task.ID, 10), task.TaskID, task.Prompt, task.Model, task.Duration, task.Orientation,
+		task.Status, task.FailReason, task.CreatedAt.Format(time.RFC3339), task.UpdatedAt.Format(time.RFC3339),
+		task.LocalPath, task.ModelUsed,
+	}
+}
+
+// handleExportTasksCSV handles GET /api/tasks/export.csv
+// Streams the tasks matching the list filters (see parseTaskFilter) in ID order as a spreadsheet
+// friendly CSV, prefixed with a UTF-8 BOM so Excel reads Chinese prompts correctly
+func handleExportTasksCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Read the first page before committing to a 200 response
+	tasks, err := GetFilteredTasksAfter(filter, 0, ExportPageSize)
+	if err != nil {
+		log.Printf("Failed to export tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to export tasks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasks-%s.csv"`, time.Now().Format(taskDateLayout)))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	io.WriteString(w, "\uFEFF")
+	writer := csv.NewWriter(w)
+	writer.Write(taskCSVHeader)
+	for len(tasks) > 0 {
+		for i := range tasks {
+			writer.Write(taskCSVRecord(&tasks[i]))
+		}
+		writer.Flush()
+		if writer.Error() != nil {
+			// Client went away
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(tasks) < ExportPageSize {
+			break
+		}
+		afterID := tasks[len(tasks)-1].ID
+		if tasks, err = GetFilteredTasksAfter(filter, afterID, ExportPageSize); err != nil {
+			// Headers are sent already, the output is truncated
+			log.Printf("Failed to export tasks after %d: %v", afterID, err)
+			return
+		}
+	}
+}
+
+// countingReader counts the bytes read through it for progress reporting
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// handleImportTasks handles POST /api/tasks/import
+// Accepts a JSON array of exported tasks or, with format=ndjson (or an NDJSON content type), one task per line
+// The body is spooled to a temporary file and imported by a background job in batches of ImportBatchSize
+func handleImportTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatJSON
+		if strings.Contains(r.Header.Get("Content-Type"), "ndjson") {
+			format = exportFormatNDJSON
+		}
+	}
+	if format != exportFormatJSON && format != exportFormatNDJSON {
+		writeError(w, http.StatusBadRequest, "format must be json or ndjson")
+		return
+	}
+
+	spool, err := os.CreateTemp("", "videogen-import-*")
+	if err != nil {
+		log.Printf("Failed to create import file: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to store import")
+		return
+	}
+	size, err := io.Copy(spool, r.Body)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	job := StartJob("import_tasks", func(job *JobHandle) (interface{}, error) {
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		return importTasks(job, spool, size, format)
+	})
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// importTasks reads tasks from r and inserts them in batches, reporting progress by bytes read
+func importTasks(job *JobHandle, r io.Reader, size int64, format string) (*ImportResult, error) {
+	result := &ImportResult{}
+	counter := &countingReader{r: r}
+	var batch []Task
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		imported, skipped, err := ImportTasks(batch)
+		if err != nil {
+			return err
+		}
+		result.Imported += imported
+		result.Skipped += skipped
+		batch = batch[:0]
+
+		progress := 100
+		if size > 0 {
+			progress = int(counter.n.Load() * 100 / size)
+		}
+		job.SetProgress(progress, fmt.Sprintf("imported %d tasks", result.Imported))
+		return nil
+	}
+
+	add := func(task Task, position string) error {
+		if strings.TrimSpace(task.Prompt) == "" && task.ImageURL == "" {
+			return fmt.Errorf("%s: task has no prompt or image (imported %d tasks before)", position, result.Imported)
+		}
+		tags, err := normalizeTaskTags(task.Tags)
+		if err != nil {
+			return fmt.Errorf("%s: %v (imported %d tasks before)", position, err, result.Imported)
+		}
+		task.Tags = tags
+		batch = append(batch, task)
+		if len(batch) >= ImportBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	if format == exportFormatNDJSON {
+		reader := bufio.NewReader(counter)
+		for line := 1; ; line++ {
+			data, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to read line %d: %w", line, err)
+			}
+			if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+				var task Task
+				if jsonErr := json.Unmarshal(trimmed, &task); jsonErr != nil {
+					return nil, fmt.Errorf("line %d: invalid task: %v (imported %d tasks before)", line, jsonErr, result.Imported)
+				}
+				if addErr := add(task, fmt.Sprintf("line %d", line)); addErr != nil {
+					return nil, addErr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	} else {
+		decoder := json.NewDecoder(counter)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, fmt.Errorf("expected a JSON array of tasks")
+		}
+		for i := 1; decoder.More(); i++ {
+			var task Task
+			if err := decoder.Decode(&task); err != nil {
+				return nil, fmt.Errorf("task %d: invalid task: %v (imported %d tasks before)", i, err, result.Imported)
+			}
+			if err := add(task, fmt.Sprintf("task %d", i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+const (
+	// MaxCSVImportBytes is the maximum size of an uploaded CSV shot list
+	MaxCSVImportBytes = 10 << 20
+	// MaxCSVImportRows is the maximum number of tasks a CSV upload may create
+	MaxCSVImportRows = 1000
+)
+
+// CSVImportRow is the outcome of a row of a CSV upload: the created task or why the row was rejected
+// Row is the line the row starts on, counting the header as line 1
+type CSVImportRow struct {
+	Row      int      `json:"row"`
+	ID       int64    `json:"id,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// csvImportColumns are the columns read from a CSV upload, others (e.g. those of the CSV export) are ignored
+var csvImportColumns = []string{"prompt", "duration", "orientation", "model", "image_url", "tags"}
+
+// csvImportTask turns a CSV row into a create request; fields maps column names to row positions
+func csvImportTask(record []string, fields map[string]int) (*CreateTaskRequest, error) {
+	value := func(column string) string {
+		if i, ok := fields[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	req := &CreateTaskRequest{
+		Prompt:      value("prompt"),
+		ImageURL:    value("image_url"),
+		Duration:    strings.ToLower(value("duration")),
+		Orientation: strings.ToLower(value("orientation")),
+		Model:       value("model"),
+	}
+	if req.Prompt == "" && req.ImageURL == "" {
+		return nil, fmt.Errorf("prompt or image_url is required")
+	}
+	// Spreadsheets tend to hold a bare number of seconds
+	if _, err := strconv.Atoi(req.Duration); err == nil {
+		req.Duration += "s"
+	}
+	if err := validateTaskOptions(req.Duration, req.Orientation); err != nil {
+		return nil, err
+	}
+	if req.Duration == "" {
+		req.Duration = Duration10s
+	}
+	if req.Orientation == "" {
+		req.Orientation = OrientationLandscape
+	}
+	if req.Model == "" {
+		req.Model = ModelSora2
+	}
+	if err := validateTaskModel(CurrentConfig(), req.Model); err != nil {
+		return nil, err
+	}
+	if err := resolveImageRef(req); err != nil {
+		return nil, err
+	}
+	if err := validateTaskImage(req.ImageURL, maxImageDimension(CurrentConfig())); err != nil {
+		return nil, err
+	}
+
+	// Tags are separated by commas or semicolons within the cell
+	tags := strings.FieldsFunc(value("tags"), func(r rune) bool { return r == ',' || r == ';' })
+	for i := range tags {
+		tags[i] = strings.TrimSpace(tags[i])
+	}
+	var err error
+	if req.Tags, err = normalizeTaskTags(tags); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// handleImportTasksCSV handles POST /api/tasks/import-csv
+// Reads the CSV shot list uploaded as the multipart field "file" and creates a pending task per valid
+// row, all in one transaction and tagged with a shared batch; rows are reported with their task ID or
+// the reason they were rejected. The header names the columns, only prompt is required
+func handleImportTasksCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxCSVImportBytes)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("A CSV file of at most %d MB is required in the file field", MaxCSVImportBytes>>20))
+		return
+	}
+	defer file.Close()
+
+	// Excel prefixes UTF-8 CSVs with a BOM
+	input := bufio.NewReader(file)
+	if bom, err := input.Peek(3); err == nil && string(bom) == "\uFEFF" {
+		input.Discard(3)
+	}
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read the CSV header")
+		return
+	}
+	fields := make(map[string]int)
+	for i, name := range header {
+		fields[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := fields["prompt"]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The CSV header must name the columns: %s", strings.Join(csvImportColumns, ", ")))
+		return
+	}
+
+	characters, err := GetAllCharacters()
+	if err != nil {
+		log.Printf("Warning: Failed to get characters for reference conversion: %v", err)
+		// Continue without conversion if we can't get characters
+	}
+	implicit := CurrentConfig().ImplicitCharacterReferences
+
+	batchID := newBatchID()
+	results := []CSVImportRow{}
+	var valid []int
+	var taskReqs []*CreateTaskRequest
+	var characterIDs [][]int64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			results = append(results, CSVImportRow{Row: parseErr.StartLine, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Failed to read the CSV file")
+			return
+		}
+
+		// Rows left empty at the end of a sheet aren't errors
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if len(taskReqs) >= MaxCSVImportRows {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("At most %d rows can be imported at once", MaxCSVImportRows))
+			return
+		}
+
+		row := CSVImportRow{Row: line}
+		req, err := csvImportTask(record, fields)
+		if err != nil {
+			row.Error = err.Error()
+			results = append(results, row)
+			continue
+		}
+		req.BatchID = batchID
+		var used []int64
+		if characters != nil && req.Prompt != "" {
+			req.OriginalPrompt = req.Prompt
+			req.Prompt, used, row.Warnings, err = ResolveCharacterReferences(req.Prompt, characters, implicit)
+			if err != nil {
+				row.Error = err.Error()
+				results = append(results, row)
+				continue
+			}
+		}
+		valid = append(valid, len(results))
+		results = append(results, row)
+		taskReqs = append(taskReqs, req)
+		characterIDs = append(characterIDs, used)
+	}
+	if len(taskReqs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "No valid row to import", "results": results})
+		return
+	}
+
+	tasks, err := CreateTasks(taskReqs, characterIDs)
+	if err != nil {
+		log.Printf("Failed to import CSV tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create tasks")
+		return
+	}
+	for j, task := range tasks {
+		results[valid[j]].ID = task.ID
+	}
+	log.Printf("Imported %d tasks from CSV (%d rows rejected)", len(tasks), len(results)-len(tasks))
+
+	var warnings []string
+	if warning := BatchSpaceWarning(len(tasks)); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"batch_id": batchID,
+		"created":  len(tasks),
+		"results":  results,
+		"warnings": warnings,
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportImportNDJSON exports tasks as NDJSON across several pages, resumes with after_id
+// and imports the stream into a fresh database
+func TestExportImportNDJSON(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "export.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	total := ExportPageSize + 3
+	for i := 0; i < total; i++ {
+		req := &CreateTaskRequest{Prompt: fmt.Sprintf("prompt %d", i), ImageURL: "data:image/png;base64,AAAA", Duration: Duration10s, Orientation: OrientationLandscape}
+		if _, err := CreateTask(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var firstID int64
+	DB.QueryRow("SELECT MIN(id) FROM tasks").Scan(&firstID)
+	DB.Exec("UPDATE tasks SET task_id = 'video_' || id, status = ? WHERE id < ?", StatusCompleted, firstID+2)
+
+	export := func(query string) []string {
+		rec := httptest.NewRecorder()
+		handleExportTasks(rec, httptest.NewRequest(http.MethodGet, "/api/tasks/export?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("export failed: %d %s", rec.Code, rec.Body.String())
+		}
+		var lines []string
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return lines
+	}
+
+	lines := export("format=ndjson")
+	if len(lines) != total {
+		t.Fatalf("exported %d lines, want %d", len(lines), total)
+	}
+	if strings.Contains(lines[0], "image_url") {
+		t.Errorf("images must only be exported on request: %s", lines[0])
+	}
+	var last Task
+	json.Unmarshal([]byte(lines[ExportPageSize-1]), &last)
+	if resumed := export(fmt.Sprintf("format=ndjson&after_id=%d", last.ID)); len(resumed) != 3 {
+		t.Errorf("resumed export returned %d lines, want 3", len(resumed))
+	}
+	withImages := export("format=ndjson&include_images=true")
+	if !strings.Contains(withImages[0], "data:image/png") {
+		t.Errorf("expected image in export: %s", withImages[0])
+	}
+
+	var array []Task
+	rec := httptest.NewRecorder()
+	handleExportTasks(rec, httptest.NewRequest(http.MethodGet, "/api/tasks/export", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &array); err != nil || len(array) != total {
+		t.Fatalf("JSON export: %d tasks, err %v", len(array), err)
+	}
+	CloseDB()
+
+	// Import into a fresh database, then again to check duplicates are skipped
+	if err := InitDB(filepath.Join(t.TempDir(), "import.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	stream := strings.Join(withImages, "\n") + "\n"
+	result, err := importTasks(&JobHandle{}, strings.NewReader(stream), int64(len(stream)), exportFormatNDJSON)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if result.Imported != total || result.Skipped != 0 {
+		t.Errorf("unexpected import result: %+v", result)
+	}
+	var importedID int64
+	DB.QueryRow("SELECT id FROM tasks WHERE task_id = ?", fmt.Sprintf("video_%d", firstID)).Scan(&importedID)
+	imported, err := GetTask(importedID)
+	if err != nil || imported == nil || imported.Status != StatusCompleted || imported.ImageURL == "" {
+		t.Errorf("task not imported as exported: %+v %v", imported, err)
+	}
+
+	result, err = importTasks(&JobHandle{}, strings.NewReader(stream), int64(len(stream)), exportFormatNDJSON)
+	if err != nil || result.Skipped != 2 {
+		t.Errorf("re-import should skip the 2 tasks with an API task ID: %+v %v", result, err)
+	}
+
+	if _, err := importTasks(&JobHandle{}, strings.NewReader("{\"prompt\":\"ok\"}\nnot json\n"), 20, exportFormatNDJSON); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error pointing at line 2, got %v", err)
+	}
+}
+
+// TestExportTasksCSV checks the filters, the escaping of prompts and the dated attachment name
+func TestExportTasksCSV(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "csv.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	tricky := "a \"quoted\" cat, on a\nnew line"
+	for _, task := range []struct{ prompt, status string }{
+		{tricky, StatusCompleted},
+		{"plain dog", StatusFailed},
+	} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: task.prompt, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, local_path = 'cat.mp4' WHERE id = ?", task.status, created.ID)
+	}
+
+	rec := httptest.NewRecorder()
+	handleExportTasksCSV(rec, httptest.NewRequest(http.MethodGet, "/api/tasks/export.csv?status=completed", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); !strings.Contains(disposition, "tasks-"+time.Now().Format("2006-01-02")+".csv") {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(rec.Body.String(), "\uFEFF"))).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d rows, want header and 1 task", len(records))
+	}
+	if strings.Join(records[0], ",") != strings.Join(taskCSVHeader, ",") {
+		t.Errorf("header = %v", records[0])
+	}
+	if row := records[1]; row[2] != tricky || row[6] != StatusCompleted || row[10] != "cat.mp4" {
+		t.Errorf("row = %q", row)
+	}
+
+	rec = httptest.NewRecorder()
+	handleExportTasksCSV(rec, httptest.NewRequest(http.MethodGet, "/api/tasks/export.csv?start=2026-13-01", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid date: status %d, want 400", rec.Code)
+	}
+}
+
+// TestImportTasksCSV uploads a Windows spreadsheet export with a BOM and a multi-line prompt and
+// checks the per-row report
+func TestImportTasksCSV(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "csv.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{})
+
+	upload := func(content string) (int, map[string]json.RawMessage) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "shots.csv")
+		part.Write([]byte(content))
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/import-csv", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		handleImportTasksCSV(rec, req)
+		var resp map[string]json.RawMessage
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	content := "\uFEFFPrompt,Duration,Orientation,Model,Image_URL,Tags\r\n" +
+		"\"a cat\r\nsleeping\",15,Portrait,,,\"pets; calm\"\r\n" +
+		",10s,,,,\r\n" +
+		"a dog,20s,,,,\r\n" +
+		",,,,,\r\n" +
+		"a bird,,,veo3,,\r\n"
+	code, resp := upload(content)
+	if code != http.StatusCreated {
+		t.Fatalf("status %d, want 201", code)
+	}
+	var rows []CSVImportRow
+	json.Unmarshal(resp["results"], &rows)
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4: %+v", len(rows), rows)
+	}
+	want := []struct {
+		row     int
+		created bool
+	}{{2, true}, {4, false}, {5, false}, {7, true}}
+	for i, w := range want {
+		if rows[i].Row != w.row || (rows[i].ID != 0) != w.created || (rows[i].Error == "") != w.created {
+			t.Errorf("row %d: %+v, want line %d created=%v", i, rows[i], w.row, w.created)
+		}
+	}
+
+	task, err := GetTask(rows[0].ID)
+	if err != nil || task == nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	if task.Prompt != "a cat\nsleeping" || task.Duration != Duration15s || task.Orientation != OrientationPortrait ||
+		task.Model != ModelSora2 || strings.Join(task.Tags, ",") != "calm,pets" || task.BatchID == "" {
+		t.Errorf("unexpected task: %+v", task)
+	}
+
+	if code, _ := upload("title,duration\r\ncat,10s\r\n"); code != http.StatusBadRequest {
+		t.Errorf("missing prompt column: status %d, want 400", code)
+	}
+	if code, _ := upload("prompt\r\n \r\n"); code != http.StatusBadRequest {
+		t.Errorf("no valid row: status %d, want 400", code)
+	}
+}
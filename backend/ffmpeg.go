@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// keyframeTolerance is how close (in seconds) a cut point must be to a keyframe for stream copy
+const keyframeTolerance = 0.05
+
+// VideoInfo holds the properties of a video file as reported by ffprobe
+type VideoInfo struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+}
+
+// FFmpegAvailable reports whether both ffmpeg and ffprobe can be found
+func FFmpegAvailable() bool {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return false
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return false
+	}
+	return true
+}
+
+// runCommand runs an external command and returns its stdout
+// stderr is included in the error to make ffmpeg failures readable
+func runCommand(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return nil, fmt.Errorf("%s failed: %w: %s", name, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// ProbeVideo reads the duration and dimensions of the first video stream
+func ProbeVideo(path string) (*VideoInfo, error) {
+	out, err := runCommand("ffprobe", "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	info := &VideoInfo{}
+	if probe.Format.Duration != "" {
+		info.DurationSeconds, err = strconv.ParseFloat(probe.Format.Duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %w", probe.Format.Duration, err)
+		}
+	}
+	if len(probe.Streams) > 0 {
+		info.Width = probe.Streams[0].Width
+		info.Height = probe.Streams[0].Height
+	}
+	return info, nil
+}
+
+// Orientation returns portrait or landscape from the video dimensions, square for equal sides
+func (info *VideoInfo) Orientation() string {
+	switch {
+	case info.Width > info.Height:
+		return OrientationLandscape
+	case info.Height > info.Width:
+		return OrientationPortrait
+	default:
+		return "square"
+	}
+}
+
+// keyframeTimes lists the presentation timestamps of the keyframes in the first video stream
+func keyframeTimes(path string) ([]float64, error) {
+	out, err := runCommand("ffprobe", "-v", "error",
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var times []float64
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.Trim(strings.TrimSpace(line), ",")
+		if line == "" {
+			continue
+		}
+		t, err := strconv.ParseFloat(line, 64)
+		if err == nil {
+			times = append(times, t)
+		}
+	}
+	return times, nil
+}
+
+// isKeyframeAligned reports whether t lies on (or very close to) one of the keyframes
+func isKeyframeAligned(t float64, keyframes []float64) bool {
+	for _, k := range keyframes {
+		if math.Abs(k-t) <= keyframeTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractFrame saves the frame at the given time of src as a small JPEG
+func ExtractFrame(src, dst string, at float64) error {
+	_, err := runCommand("ffmpeg", "-y", "-v", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", src,
+		"-frames:v", "1",
+		"-vf", "scale=320:-2",
+		dst)
+	return err
+}
+
+// TrimVideo cuts [start, end) seconds from src into dst
+// Uses stream copy when start falls on a keyframe, otherwise re-encodes for a frame-accurate cut
+// Returns whether the clip was re-encoded
+func TrimVideo(src, dst string, start, end float64) (bool, error) {
+	keyframes, err := keyframeTimes(src)
+	if err != nil {
+		return false, err
+	}
+
+	reencode := !isKeyframeAligned(start, keyframes)
+	args := []string{"-y", "-v", "error",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", src,
+		"-t", strconv.FormatFloat(end-start, 'f', 3, 64),
+	}
+	if reencode {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-c:a", "aac")
+	} else {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	}
+	args = append(args, "-movflags", "+faststart", dst)
+
+	if _, err := runCommand("ffmpeg", args...); err != nil {
+		return reencode, err
+	}
+	return reencode, nil
+}
+
+// ConcatVideos joins srcs, in order, into dst with ffmpeg's concat demuxer
+// Streams are copied when all inputs share their dimensions, otherwise every clip is scaled and
+// padded to width x height and re-encoded. progress receives the percentage of totalSeconds written
+func ConcatVideos(srcs []string, dst string, width, height int, reencode bool, totalSeconds float64, progress func(int)) error {
+	listPath := dst + ".txt"
+	var list strings.Builder
+	for _, src := range srcs {
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+	defer os.Remove(listPath)
+
+	args := []string{"-y", "-v", "error", "-nostats", "-progress", "pipe:1",
+		"-f", "concat", "-safe", "0", "-i", listPath}
+	if reencode {
+		args = append(args,
+			"-vf", fmt.Sprintf("scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1", width, height),
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-c:a", "aac")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-movflags", "+faststart", dst)
+
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	// -progress writes key=value lines, out_time_us is the position reached in the output
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "out_time_us=")
+		if !ok || totalSeconds <= 0 {
+			continue
+		}
+		if us, err := strconv.ParseFloat(value, 64); err == nil {
+			progress(max(0, min(99, int(us/1e6*100/totalSeconds))))
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// frontendHandler serves the frontend files, and index.html for the SPA routes
+func frontendHandler(frontendContent fs.FS) http.HandlerFunc {
+	fileServer := http.FileServer(http.FS(frontendContent))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handle CORS preflight
+		if !handleCORS(w, r) {
+			return
+		}
+
+		// Try to serve static file
+		path := r.URL.Path
+		if path == "/" {
+			path = "/index.html"
+		}
+
+		// Check if file exists in embedded FS
+		if _, err := fs.Stat(frontendContent, strings.TrimPrefix(path, "/")); err == nil {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		// For SPA routing, serve index.html for non-API routes
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			r.URL.Path = "/"
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		if handleAuth(w, r) {
+			http.NotFound(w, r)
+		}
+	}
+}
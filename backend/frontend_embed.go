@@ -0,0 +1,16 @@
+//go:build !headless
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed dist/*
+var frontendFS embed.FS
+
+// frontendFiles returns the embedded frontend build
+func frontendFiles() (fs.FS, error) {
+	return fs.Sub(frontendFS, "dist")
+}
@@ -0,0 +1,10 @@
+//go:build headless
+
+package main
+
+import "io/fs"
+
+// frontendFiles returns nil, builds with the headless tag don't embed the frontend and serve the API only
+func frontendFiles() (fs.FS, error) {
+	return nil, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultHookTimeout is used when post_download_timeout is not configured
+	DefaultHookTimeout = 5 * time.Minute
+	// hookOutputLimit is the maximum number of bytes of hook output kept
+	hookOutputLimit = 4096
+)
+
+// WarningHookFailed is set on tasks whose post-download command exited with an error
+const WarningHookFailed = "hook_failed"
+
+// hookTimeout returns the configured post-download command timeout
+func hookTimeout(config *Config) time.Duration {
+	if config.PostDownloadTimeout > 0 {
+		return time.Duration(config.PostDownloadTimeout) * time.Second
+	}
+	return DefaultHookTimeout
+}
+
+// LogHookConfig reports the configured post-download command at startup
+func LogHookConfig(config *Config) {
+	if len(config.PostDownloadCommand) == 0 {
+		return
+	}
+	mode := "warning"
+	if config.PostDownloadStrict {
+		mode = "strict"
+	}
+	log.Printf("Post-download command enabled: %q (timeout %v, %s mode)", config.PostDownloadCommand, hookTimeout(config), mode)
+}
+
+// RunPostDownloadCommand runs the configured command for a downloaded video, killed when ctx is done
+// Task details are passed as VIDEOGEN_* environment variables
+// Returns the combined (truncated) output of the command
+func RunPostDownloadCommand(ctx context.Context, config *Config, task *Task) (string, error) {
+	if len(config.PostDownloadCommand) == 0 {
+		return "", nil
+	}
+
+	localPath, err := filepath.Abs(ResolveVideoPath(task.LocalPath))
+	if err != nil {
+		localPath = ResolveVideoPath(task.LocalPath)
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, hookTimeout(config))
+	defer cancel()
+
+	cmd := exec.CommandContext(hookCtx, config.PostDownloadCommand[0], config.PostDownloadCommand[1:]...)
+	cmd.Env = append(os.Environ(),
+		"VIDEOGEN_TASK_ID="+strconv.FormatInt(task.ID, 10),
+		"VIDEOGEN_API_TASK_ID="+task.TaskID,
+		"VIDEOGEN_LOCAL_PATH="+localPath,
+		"VIDEOGEN_PROMPT="+task.Prompt,
+		"VIDEOGEN_MODEL="+task.Model,
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	out := output.String()
+	if len(out) > hookOutputLimit {
+		out = out[len(out)-hookOutputLimit:]
+	}
+	if ctx.Err() != nil {
+		return out, fmt.Errorf("post-download command interrupted: %w", ctx.Err())
+	}
+	if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("post-download command timed out after %v", hookTimeout(config))
+	}
+	if err != nil {
+		return out, fmt.Errorf("post-download command failed: %w", err)
+	}
+	return out, nil
+}
+
+// runPostDownloadHook runs the post-download command for a completed task
+// It runs in the download worker that fetched the video, so at most download_workers commands run at
+// once, and Stop kills it; a failing command flags the task with a warning, or fails it when
+// post_download_strict is set
+func (p *TaskProcessor) runPostDownloadHook(task Task) {
+	config := p.currentConfig()
+	if len(config.PostDownloadCommand) == 0 || task.LocalPath == "" {
+		return
+	}
+
+	output, err := RunPostDownloadCommand(p.ctx, config, &task)
+	if err == nil {
+		log.Printf("[Hook] Task %d post-download command finished: %s", task.ID, output)
+		RecordTaskEvent(task.ID, HistoryHookFinished, output)
+		return
+	}
+	if p.ctx.Err() != nil {
+		// Shutting down, the task stays completed without a verdict from the command
+		log.Printf("[Hook] Task %d %v", task.ID, err)
+		return
+	}
+
+	log.Printf("[Hook] Task %d %v: %s", task.ID, err, output)
+	message := err.Error()
+	if output != "" {
+		message += ": " + output
+	}
+	RecordTaskEvent(task.ID, HistoryHookFailed, message)
+
+	current, getErr := GetTask(task.ID)
+	if getErr != nil || current == nil {
+		log.Printf("[Hook] Failed to load task %d: %v", task.ID, getErr)
+		return
+	}
+	current.Warning = WarningHookFailed
+	current.WarningMessage = message
+	if config.PostDownloadStrict && current.Status == StatusCompleted {
+		current.Status = StatusFailed
+		current.FailReason = message
+		if p.saveTransition(current, StatusCompleted, fieldsWarning) {
+			RecordTaskEvent(task.ID, HistoryFailed, message)
+		}
+		return
+	}
+	if err := p.updateTask(current, fieldsWarning); err != nil {
+		log.Printf("[Hook] Failed to update task %d: %v", task.ID, err)
+	}
+}
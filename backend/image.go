@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	// DefaultMaxImageDimension is the longest edge of submitted images when max_image_dimension is not set
+	DefaultMaxImageDimension = 2048
+	// resizedJPEGQuality is the quality of downscaled images, re-encoded as JPEG
+	resizedJPEGQuality = 90
+	// MaxImagePixels is the largest width x height accepted, decoding a bigger image to downscale it
+	// would allocate hundreds of megabytes; the header of a small file can claim any size
+	MaxImagePixels = 50_000_000
+)
+
+// maxImageDimension returns the configured longest edge of submitted images
+func maxImageDimension(config *Config) int {
+	if config.MaxImageDimension > 0 {
+		return config.MaxImageDimension
+	}
+	return DefaultMaxImageDimension
+}
+
+// errWebPDecode is returned when a WebP image would have to be decoded, only its header is supported
+var errWebPDecode = errors.New("decoding WebP images is not supported")
+
+func init() {
+	// Only the header of WebP images is read, enough to validate them and check their size
+	image.RegisterFormat("webp", "RIFF????WEBP", func(io.Reader) (image.Image, error) {
+		return nil, errWebPDecode
+	}, decodeWebPConfig)
+}
+
+// decodeWebPConfig reads the dimensions of a WebP image from its first chunk (VP8, VP8L or VP8X)
+func decodeWebPConfig(r io.Reader) (image.Config, error) {
+	header := make([]byte, 30)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return image.Config{}, fmt.Errorf("truncated WebP header")
+	}
+	var width, height int
+	switch string(header[12:16]) {
+	case "VP8X":
+		width = int(header[24]) | int(header[25])<<8 | int(header[26])<<16 + 1
+		height = int(header[27]) | int(header[28])<<8 | int(header[29])<<16 + 1
+	case "VP8L":
+		if header[20] != 0x2f {
+			return image.Config{}, fmt.Errorf("invalid WebP lossless signature")
+		}
+		bits := binary.LittleEndian.Uint32(header[21:25])
+		width = int(bits&0x3fff) + 1
+		height = int(bits>>14&0x3fff) + 1
+	case "VP8 ":
+		if !bytes.Equal(header[23:26], []byte{0x9d, 0x01, 0x2a}) {
+			return image.Config{}, fmt.Errorf("invalid WebP start code")
+		}
+		width = int(binary.LittleEndian.Uint16(header[26:28]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(header[28:30]) & 0x3fff)
+	default:
+		return image.Config{}, fmt.Errorf("unknown WebP chunk %q", header[12:16])
+	}
+	return image.Config{ColorModel: color.RGBAModel, Width: width, Height: height}, nil
+}
+
+// validateTaskImage checks the image of a task at creation: data URLs must hold a PNG, JPEG, GIF or
+// WebP image, and WebP images must not need downscaling. Remote URLs are left to the provider
+func validateTaskImage(imageURL string, maxDimension int) error {
+	data, _, err := loadImage(imageURL)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+	format, width, height, err := imageSize(data)
+	if err != nil {
+		return err
+	}
+	if format == "webp" && max(width, height) > maxDimension {
+		return fmt.Errorf("WebP images larger than %dpx can't be resized, convert the image to JPEG or PNG", maxDimension)
+	}
+	return nil
+}
+
+// imageSize returns the format and dimensions of an image, read from its header
+// Images of more than MaxImagePixels are rejected before anything decodes them
+func imageSize(data []byte) (string, int, int, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("unsupported or corrupt image, use PNG, JPEG, GIF or WebP: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return "", 0, 0, fmt.Errorf("image has no pixels")
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return "", 0, 0, fmt.Errorf("image is %dx%d, larger than %d megapixels", config.Width, config.Height, MaxImagePixels/1_000_000)
+	}
+	return format, config.Width, config.Height, nil
+}
+
+// downscaleImage shrinks an image whose longest edge exceeds maxDimension and re-encodes it as JPEG
+// Returns the image unchanged, with resized false, when it is small enough
+func downscaleImage(data []byte, maxDimension int) ([]byte, bool, error) {
+	_, width, height, err := imageSize(data)
+	if err != nil {
+		return nil, false, err
+	}
+	longest := max(width, height)
+	if longest <= maxDimension {
+		return data, false, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode image: %w", err)
+	}
+	newWidth := max(1, width*maxDimension/longest)
+	newHeight := max(1, height*maxDimension/longest)
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, resizeImage(src, newWidth, newHeight), &jpeg.Options{Quality: resizedJPEGQuality}); err != nil {
+		return nil, false, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return out.Bytes(), true, nil
+}
+
+// resizeImage scales src down to width x height, averaging a 2x2 grid of samples per pixel
+// Transparent areas are flattened onto white since the result is encoded as JPEG
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b uint32
+			for _, offset := range [][2]float64{{0.25, 0.25}, {0.75, 0.25}, {0.25, 0.75}, {0.75, 0.75}} {
+				sx := bounds.Min.X + int((float64(x)+offset[0])*scaleX)
+				sy := bounds.Min.Y + int((float64(y)+offset[1])*scaleY)
+				cr, cg, cb, ca := src.At(sx, sy).RGBA()
+				// Composite the premultiplied color over white
+				r += cr + 0xffff - ca
+				g += cg + 0xffff - ca
+				b += cb + 0xffff - ca
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / 4 >> 8), uint8(g / 4 >> 8), uint8(b / 4 >> 8), 0xff})
+		}
+	}
+	return dst
+}
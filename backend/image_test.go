@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func TestDownscaleImage(t *testing.T) {
+	large := testPNG(t, 300, 100)
+	resized, ok, err := downscaleImage(large, 120)
+	if err != nil || !ok {
+		t.Fatalf("downscaleImage = %v, %v", ok, err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(resized))
+	if err != nil {
+		t.Fatalf("resized image is not a JPEG: %v", err)
+	}
+	if size := img.Bounds().Size(); size != (image.Point{120, 40}) {
+		t.Errorf("resized to %v, want 120x40", size)
+	}
+
+	small := testPNG(t, 100, 50)
+	if data, ok, err := downscaleImage(small, 120); err != nil || ok || !bytes.Equal(data, small) {
+		t.Errorf("small image changed: ok=%v err=%v", ok, err)
+	}
+
+	// A few bytes of GIF whose header claims 65535x65535 are rejected without decoding
+	var bomb bytes.Buffer
+	gif.Encode(&bomb, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.White}), nil)
+	header := bomb.Bytes()
+	header[6], header[7], header[8], header[9] = 0xff, 0xff, 0xff, 0xff
+	if _, _, err := downscaleImage(header, 120); err == nil || !strings.Contains(err.Error(), "megapixels") {
+		t.Errorf("oversized image: %v", err)
+	}
+
+	// The client sends the resized bytes, the data URL stored with the task is untouched
+	client := NewVectorEngineClient("key")
+	client.SetMaxImageDimension(120)
+	data, mimeType, err := client.submissionImage("data:image/png;base64," + base64.StdEncoding.EncodeToString(large))
+	if err != nil || mimeType != "image/jpeg" || bytes.Equal(data, large) {
+		t.Errorf("submissionImage: type %q, err %v", mimeType, err)
+	}
+}
+
+func TestValidateTaskImage(t *testing.T) {
+	valid := "data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG(t, 8, 8))
+	if err := validateTaskImage(valid, 2048); err != nil {
+		t.Errorf("valid PNG rejected: %v", err)
+	}
+	if err := validateTaskImage("https://example.com/photo.jpg", 2048); err != nil {
+		t.Errorf("remote URL rejected: %v", err)
+	}
+	if err := validateTaskImage("data:image/png;base64,%%%not-base64", 2048); err == nil {
+		t.Errorf("corrupt base64 accepted")
+	}
+	if err := validateTaskImage("data:image/bmp;base64,"+base64.StdEncoding.EncodeToString([]byte("BM not supported")), 2048); err == nil {
+		t.Errorf("unsupported format accepted")
+	}
+
+	// A VP8X header of a 3000x2000 WebP image
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00")
+	webp = append(webp, 0xb7, 0x0b, 0x00, 0xcf, 0x07, 0x00)
+	format, width, height, err := imageSize(webp)
+	if err != nil || format != "webp" || width != 3000 || height != 2000 {
+		t.Fatalf("imageSize(webp) = %s %dx%d, %v", format, width, height, err)
+	}
+	webpURL := "data:image/webp;base64," + base64.StdEncoding.EncodeToString(webp)
+	if err := validateTaskImage(webpURL, 4096); err != nil {
+		t.Errorf("small enough WebP rejected: %v", err)
+	}
+	if err := validateTaskImage(webpURL, 2048); err == nil || !strings.Contains(err.Error(), "WebP") {
+		t.Errorf("oversized WebP: %v", err)
+	}
+}
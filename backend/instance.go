@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// instanceLockPath returns the file locked by the instance running on a database, so a second launch
+// doesn't start another processor on it; it holds the URL the instance serves at
+func instanceLockPath(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+// instanceURLWait is how long a second launch waits for a starting instance to write its URL
+const instanceURLWait = 5 * time.Second
+
+var (
+	// errLocked is returned by lockFile when another process holds the lock
+	errLocked = errors.New("file is locked")
+	// ErrInstanceRunning is returned by AcquireInstanceLock when another instance holds the lock
+	ErrInstanceRunning = errors.New("another instance is running")
+)
+
+// InstanceLock is the exclusive lock of the running instance
+// The operating system releases it when the process exits, a crash doesn't leave a stale lock
+type InstanceLock struct {
+	file *os.File
+}
+
+// AcquireInstanceLock takes the instance lock at path, ErrInstanceRunning when another instance holds it
+func AcquireInstanceLock(path string) (*InstanceLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errLocked) {
+			return nil, ErrInstanceRunning
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	// A URL left by an instance that crashed is cleared until this one serves
+	if err := file.Truncate(0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, fmt.Errorf("failed to clear lock file: %w", err)
+	}
+	return &InstanceLock{file: file}, nil
+}
+
+// SetURL records the URL the instance serves at, for a second launch to open
+func (l *InstanceLock) SetURL(url string) error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.WriteAt([]byte(url), 0)
+	return err
+}
+
+// Release releases the lock and clears the recorded URL
+// The file is kept, removing it could let a launch waiting on the old file and a new one both start
+func (l *InstanceLock) Release() error {
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	return l.file.Close()
+}
+
+// RunningInstanceURL returns the URL recorded by the instance holding the lock at path, waiting up
+// to wait for an instance that's still starting; empty when none was recorded in time
+func RunningInstanceURL(path string, wait time.Duration) string {
+	deadline := time.Now().Add(wait)
+	for {
+		if file, err := os.Open(path); err == nil {
+			data, _ := io.ReadAll(io.LimitReader(file, 1024))
+			file.Close()
+			if url := strings.TrimSpace(string(data)); url != "" {
+				return url
+			}
+		}
+		if time.Now().After(deadline) {
+			return ""
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestInstanceLock checks a second instance is refused while the lock is held and finds the URL of
+// the running one, and that the lock can be taken again once released
+func TestInstanceLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "videogen.db.lock")
+
+	lock, err := AcquireInstanceLock(path)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock: %v", err)
+	}
+	if url := RunningInstanceURL(path, 0); url != "" {
+		t.Errorf("URL %q before the server listens", url)
+	}
+	if err := lock.SetURL("https://localhost:8443"); err != nil {
+		t.Fatalf("SetURL: %v", err)
+	}
+
+	if _, err := AcquireInstanceLock(path); !errors.Is(err, ErrInstanceRunning) {
+		t.Fatalf("second instance: %v, want ErrInstanceRunning", err)
+	}
+	if url := RunningInstanceURL(path, 0); url != "https://localhost:8443" {
+		t.Errorf("running instance URL %q", url)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	relock, err := AcquireInstanceLock(path)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock after release: %v", err)
+	}
+	defer relock.Release()
+	if url := RunningInstanceURL(path, 0); url != "" {
+		t.Errorf("URL %q left after release", url)
+	}
+}
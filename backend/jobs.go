@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxFinishedJobs is the number of completed/failed jobs kept in memory for status queries
+const MaxFinishedJobs = 100
+
+// Job represents a long-running background operation (trimming, backfills, imports...)
+// Jobs live in memory only; their status uses the same values as tasks
+type Job struct {
+	ID        int64       `json:"id"`
+	Type      string      `json:"type"`
+	Status    string      `json:"status"` // pending, processing, completed, failed
+	Progress  int         `json:"progress"`
+	Message   string      `json:"message,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// JobFunc is the work performed by a job; the returned value is stored as the job result
+type JobFunc func(job *JobHandle) (interface{}, error)
+
+// JobHandle lets a running job report its progress
+type JobHandle struct {
+	id int64
+}
+
+// SetProgress updates the progress percentage and message of the running job
+func (h *JobHandle) SetProgress(progress int, message string) {
+	jobs.update(h.id, func(job *Job) {
+		job.Progress = progress
+		job.Message = message
+	})
+}
+
+// jobRegistry keeps track of all jobs started since the server came up
+type jobRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]*Job
+}
+
+// jobs is the global job registry
+var jobs = &jobRegistry{jobs: make(map[int64]*Job)}
+
+// StartJob registers a new job and runs fn in the background
+// Returns a snapshot of the newly created job
+func StartJob(jobType string, fn JobFunc) Job {
+	jobs.mu.Lock()
+	jobs.nextID++
+	now := time.Now()
+	job := &Job{
+		ID:        jobs.nextID,
+		Type:      jobType,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	jobs.jobs[job.ID] = job
+	snapshot := *job
+	jobs.mu.Unlock()
+
+	go func() {
+		jobs.update(job.ID, func(j *Job) { j.Status = StatusProcessing })
+
+		result, err := fn(&JobHandle{id: job.ID})
+		jobs.update(job.ID, func(j *Job) {
+			if err != nil {
+				j.Status = StatusFailed
+				j.Error = err.Error()
+				log.Printf("[Job] %s #%d 失败: %v", j.Type, j.ID, err)
+				return
+			}
+			j.Status = StatusCompleted
+			j.Progress = 100
+			j.Result = result
+		})
+		jobs.prune()
+	}()
+
+	return snapshot
+}
+
+// GetJob returns a snapshot of the job with the given ID
+func GetJob(id int64) (Job, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job, ok := jobs.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// ListJobs returns snapshots of all known jobs, newest first
+func ListJobs() []Job {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	list := make([]Job, 0, len(jobs.jobs))
+	for _, job := range jobs.jobs {
+		list = append(list, *job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list
+}
+
+// update applies fn to the job under the registry lock
+func (r *jobRegistry) update(id int64, fn func(job *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now()
+	}
+}
+
+// prune drops the oldest finished jobs beyond MaxFinishedJobs
+func (r *jobRegistry) prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var finished []int64
+	for id, job := range r.jobs {
+		if job.Status == StatusCompleted || job.Status == StatusFailed {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= MaxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i] < finished[j] })
+	for _, id := range finished[:len(finished)-MaxFinishedJobs] {
+		delete(r.jobs, id)
+	}
+}
+
+// handleJobs handles GET /api/jobs and GET /api/jobs/:id
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/")
+	if path == "" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": ListJobs()})
+		return
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	job, ok := GetJob(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,53 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+	procUnlockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+	// lockOffsetHigh places the locked byte far past the content, Windows locks are mandatory and
+	// would keep other processes from reading the URL written at the start of the file
+	lockOffsetHigh = 0x7fffffff
+)
+
+// lockFile takes an exclusive lock on file without waiting, errLocked when another process holds it
+func lockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	ret, _, err := procLockFileEx.Call(
+		file.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if ret == 0 {
+		if err == errorLockViolation {
+			return errLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile
+func unlockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	ret, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
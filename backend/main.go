@@ -1,130 +1,282 @@
 package main
 
 import (
-	"embed"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
-//go:embed dist/*
-var frontendFS embed.FS
-
 const (
 	// DatabasePath is the path to the SQLite database file
 	DatabasePath = "videogen.db"
+	// ShutdownTimeout bounds how long open requests, such as event streams, delay a shutdown
+	ShutdownTimeout = 10 * time.Second
 )
 
 // Global task processor instance
 var taskProcessor *TaskProcessor
 
-// Global config
+// Global config, read through CurrentConfig once the server is running
 var appConfig *Config
 
 func main() {
+	readOnly := flag.Bool("read-only", false, "open a database created by a newer version read-only instead of refusing to start")
+	repair := flag.Bool("repair", false, "check the database schema against the expected one, back it up and fix problems, then exit")
+	dryRun := flag.Bool("dry-run", false, "with --repair, only report the problems without changing anything")
+	noBrowser := flag.Bool("no-browser", false, "don't open the browser at startup")
+	headless := flag.Bool("headless", false, "serve the API only, without the frontend (implies --no-browser)")
+	flag.StringVar(&ConfigPath, "config", ConfigPath, "path to the configuration file")
+	dbPath := flag.String("db", DatabasePath, "path to the SQLite database file")
+	flag.IntVar(&portOverride, "port", 0, "port to listen on, overriding port in the configuration file")
+	flag.Parse()
+
+	if portOverride < 0 || portOverride > 65535 {
+		log.Fatalf("--port must be between 1 and 65535")
+	}
+	if *headless {
+		*noBrowser = true
+	}
+
+	// Only one instance may use the database, a second launch opens the running one instead
+	lock, err := AcquireInstanceLock(instanceLockPath(*dbPath))
+	if errors.Is(err, ErrInstanceRunning) {
+		if *repair {
+			log.Fatalf("Another instance is using %s, stop it before repairing", *dbPath)
+		}
+		url := RunningInstanceURL(instanceLockPath(*dbPath), instanceURLWait)
+		if url == "" {
+			log.Println("Another instance is already running")
+			return
+		}
+		log.Printf("Another instance is already running at %s", url)
+		if !*noBrowser {
+			openBrowser(url)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to check for a running instance: %v", err)
+	}
+	defer lock.Release()
+
+	if *repair {
+		if err := RunRepair(*dbPath, *dryRun, os.Stdout); err != nil {
+			log.Fatalf("Repair failed: %v", err)
+		}
+		return
+	}
+
 	// Load configuration
 	config, err := LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 	appConfig = config
+	listenPort = serverPort(config)
+	listenTLS = tlsSettings(config)
+
+	if err := validateBaseURL(dyuBaseURL(config)); err != nil {
+		log.Fatalf("Invalid dyu_base_url %q: %v", config.DyuBaseURL, err)
+	}
+	if err := validateDownloadOptions(config); err != nil {
+		log.Fatalf("Invalid download settings: %v", err)
+	}
+
+	// The proxy must be set before any API client is created
+	if err := SetupProxy(config.ProxyURL); err != nil {
+		log.Fatalf("Failed to configure proxy: %v", err)
+	}
 
 	// Check if API key is configured
 	if config.DyuAPIKey == "" {
-		log.Println("WARNING: 未配置API密钥。请编辑config.json添加dyu_api_key。")
+		log.Printf("WARNING: 未配置API密钥。请编辑%s添加dyu_api_key。", ConfigPath)
 		log.Println("应用将启动，但视频生成功能需要有效的API密钥。")
 	}
 
 	// Initialize database
-	if err := InitDB(DatabasePath); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	dbReadOnly := false
+	if err := InitDB(*dbPath); err != nil {
+		var tooNew *SchemaTooNewError
+		if !errors.As(err, &tooNew) {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		// A newer build has migrated this database, running our migrations could corrupt it
+		if !*readOnly {
+			log.Fatalf("%v\n请使用新版本程序，或使用 --read-only 参数以只读方式打开数据库", err)
+		}
+		log.Printf("WARNING: %v, opening database read-only", err)
+		if err := OpenDBReadOnly(*dbPath); err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		dbReadOnly = true
 	}
 	defer CloseDB()
 
+	// Re-queue (or report) tasks that failed because of the previous API key
+	if !dbReadOnly {
+		if _, _, err := HandleAPIKeyChange(config); err != nil {
+			log.Printf("Warning: failed to check API key change: %v", err)
+		}
+	}
+
 	// Ensure output directory exists
-	if err := EnsureOutputDirectory(); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	if err := SetupOutputDirectory(config.OutputDir); err != nil {
+		log.Fatalf("Output directory unusable: %v", err)
+	}
+	log.Printf("Videos are saved to %s", OutputDirectory())
+	recordDirs := RecordOutputDirectory
+	if dbReadOnly {
+		recordDirs = LoadPreviousOutputDirectories
+	}
+	if err := recordDirs(); err != nil {
+		log.Printf("Warning: failed to record the output directory: %v", err)
+	}
+	if config.AuthToken != "" {
+		log.Println("API token authentication enabled")
 	}
 
 	// Start background task processor
-	taskProcessor = NewTaskProcessor(config.DyuAPIKey)
-	taskProcessor.Start()
-	defer taskProcessor.Stop()
+	LogHookConfig(config)
+	LogWebhookConfig(config)
+	LogThumbnailConfig(config)
+	taskProcessor = NewTaskProcessor(config)
+	if dbReadOnly {
+		log.Println("Read-only mode: task processor not started")
+	} else {
+		taskProcessor.Start()
+		defer taskProcessor.Stop()
+	}
 
 	// Set up HTTP routes
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.HandleFunc("/api/tasks", corsMiddleware(handleTasks))
-	mux.HandleFunc("/api/tasks/", corsMiddleware(handleTaskByID))
-	mux.HandleFunc("/api/tasks-failed", corsMiddleware(handleDeleteFailedTasks))
-	mux.HandleFunc("/api/tasks-by-date", corsMiddleware(handleDeleteTasksByDateRange))
-	mux.HandleFunc("/api/tasks-retry-alt", corsMiddleware(handleRetryWithAlt))
-	mux.HandleFunc("/api/videos/", corsMiddleware(handleVideos))
-	mux.HandleFunc("/api/character-pictures/", corsMiddleware(handleCharacterPictures))
+	mux.HandleFunc("/api/tasks", apiMiddleware(handleTasks))
+	mux.HandleFunc("/api/tasks/", apiMiddleware(handleTaskByID))
+	mux.HandleFunc("/api/tasks-failed", apiMiddleware(handleDeleteFailedTasks))
+	mux.HandleFunc("/api/tasks-by-date", apiMiddleware(handleDeleteTasksByDateRange))
+	mux.HandleFunc("/api/tasks-bulk-delete", apiMiddleware(handleBulkDeleteTasks))
+	mux.HandleFunc("/api/tasks-bulk-retry", apiMiddleware(handleBulkRetryTasks))
+	mux.HandleFunc("/api/tasks-retry-failed", apiMiddleware(handleRetryFailedTasks))
+	mux.HandleFunc("/api/tasks-retry-alt", apiMiddleware(handleRetryFailedTasks))
+	mux.HandleFunc("/api/tasks-reset-stuck", apiMiddleware(handleResetStuckTasks))
+	mux.HandleFunc("/api/tasks-requeue-auth", apiMiddleware(handleRequeueAuthFailed))
+	mux.HandleFunc("/api/videos/", apiMiddleware(handleVideos))
+	mux.HandleFunc("/api/videos/archive", apiMiddleware(handleVideoArchive))
+	mux.HandleFunc("/api/compose", apiMiddleware(handleCompose))
+	mux.HandleFunc("/api/character-pictures/", apiMiddleware(handleCharacterPictures))
+	mux.HandleFunc("/api/character-sources/", apiMiddleware(handleCharacterSources))
+	mux.HandleFunc("/api/uploads", apiMiddleware(handleUploads))
+	mux.HandleFunc("/api/uploads/", apiMiddleware(handleUploadByID))
+	mux.HandleFunc("/api/thumbnails/backfill", apiMiddleware(handleThumbnailBackfill))
+	mux.HandleFunc("/api/thumbnails/", apiMiddleware(handleThumbnails))
+	mux.HandleFunc("/api/events", apiMiddleware(handleEvents))
+	mux.HandleFunc("/api/processor/status", apiMiddleware(handleProcessorStatus))
+	mux.HandleFunc("/api/processor/pause", apiMiddleware(handleProcessorPause))
+	mux.HandleFunc("/api/processor/resume", apiMiddleware(handleProcessorResume))
+	mux.HandleFunc("/api/config", apiMiddleware(handleConfig))
+	mux.HandleFunc("/api/config/validate-key", apiMiddleware(handleValidateKey))
+	mux.HandleFunc("/api/storage", apiMiddleware(handleStorage))
+	mux.HandleFunc("/api/stats", apiMiddleware(handleStats))
+	mux.HandleFunc("/api/health", apiMiddleware(handleHealth))
+	mux.HandleFunc("/api/maintenance/reconcile", apiMiddleware(handleReconcile))
+	mux.HandleFunc("/api/maintenance/metadata", apiMiddleware(handleMetadataBackfill))
+	mux.HandleFunc("/api/maintenance/db", apiMiddleware(handleDatabaseStats))
+	mux.HandleFunc("/api/maintenance/vacuum", apiMiddleware(handleVacuum))
+	mux.HandleFunc("/api/cleanup", apiMiddleware(handleCleanup))
+	mux.HandleFunc("/api/jobs", apiMiddleware(handleJobs))
+	mux.HandleFunc("/api/jobs/", apiMiddleware(handleJobs))
 
 	// Character API routes (Requirements 5.1)
-	mux.HandleFunc("/api/characters", corsMiddleware(handleCharacters))
-	mux.HandleFunc("/api/characters/", corsMiddleware(handleCharacterByID))
-
-	// Serve embedded frontend files
-	frontendContent, err := fs.Sub(frontendFS, "dist")
+	mux.HandleFunc("/api/characters", apiMiddleware(handleCharacters))
+	mux.HandleFunc("/api/templates", apiMiddleware(handleTemplates))
+	mux.HandleFunc("/api/templates/", apiMiddleware(handleTemplateByID))
+	mux.HandleFunc("/api/tasks/bulk-update", apiMiddleware(handleBulkUpdateTasks))
+	mux.HandleFunc("/api/tasks/batch", apiMiddleware(handleBatchCreateTasks))
+	mux.HandleFunc("/api/tasks/counts", apiMiddleware(handleTaskCounts))
+	mux.HandleFunc("/api/tasks/export", apiMiddleware(handleExportTasks))
+	mux.HandleFunc("/api/tasks/export.csv", apiMiddleware(handleExportTasksCSV))
+	mux.HandleFunc("/api/tasks/import", apiMiddleware(handleImportTasks))
+	mux.HandleFunc("/api/tasks/import-csv", apiMiddleware(handleImportTasksCSV))
+	mux.HandleFunc("/api/characters/import-id", apiMiddleware(handleImportCharacter))
+	mux.HandleFunc("/api/characters/", apiMiddleware(handleCharacterByID))
+
+	// Serve the embedded frontend, headless mode serves the API only
+	frontendContent, err := frontendFiles()
 	if err != nil {
 		log.Fatalf("Failed to get frontend files: %v", err)
 	}
-	fileServer := http.FileServer(http.FS(frontendContent))
-
-	// Handle all other routes with frontend
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Handle CORS preflight
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		// Try to serve static file
-		path := r.URL.Path
-		if path == "/" {
-			path = "/index.html"
-		}
-
-		// Check if file exists in embedded FS
-		if _, err := fs.Stat(frontendContent, strings.TrimPrefix(path, "/")); err == nil {
-			fileServer.ServeHTTP(w, r)
-			return
-		}
+	if *headless || frontendContent == nil {
+		log.Println("Headless mode: frontend not served")
+		mux.HandleFunc("/", apiMiddleware(http.NotFound))
+	} else {
+		mux.HandleFunc("/", frontendHandler(frontendContent))
+	}
 
-		// For SPA routing, serve index.html for non-API routes
-		if !strings.HasPrefix(r.URL.Path, "/api/") {
-			r.URL.Path = "/"
-			fileServer.ServeHTTP(w, r)
-			return
-		}
+	certFile, keyFile, err := serverTLSFiles(config)
+	if err != nil {
+		log.Fatalf("Failed to set up HTTPS: %v", err)
+	}
+	scheme := "http"
+	if certFile != "" {
+		scheme = "https"
+	}
 
-		http.NotFound(w, r)
-	})
+	serverAddr := fmt.Sprintf(":%d", listenPort)
+	url := fmt.Sprintf("%s://localhost:%d", scheme, listenPort)
 
-	serverAddr := fmt.Sprintf(":%d", config.Port)
-	url := fmt.Sprintf("http://localhost:%d", config.Port)
+	listener, err := net.Listen("tcp", serverAddr)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	if err := lock.SetURL(url); err != nil {
+		log.Printf("Warning: failed to record the server URL: %v", err)
+	}
 
-	log.Printf("Starting server on %s", serverAddr)
+	log.Printf("Starting server on %s (%s)", serverAddr, scheme)
 	log.Printf("Open your browser at: %s", url)
 
 	// Open browser automatically
-	go openBrowser(url)
+	if !*noBrowser {
+		go openBrowser(url)
+	}
 
-	if err := http.ListenAndServe(serverAddr, mux); err != nil {
+	// Shut down gracefully on Ctrl+C or SIGTERM so the deferred cleanup runs and releases the lock
+	server := &http.Server{Handler: mux}
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Println("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if certFile != "" {
+		err = server.ServeTLS(listener, certFile, keyFile)
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -143,21 +295,13 @@ func openBrowser(url string) {
 	cmd.Run()
 }
 
-// corsMiddleware adds CORS headers to responses
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// apiMiddleware adds CORS headers to responses, rejects origins outside allowed_origins and
+// requests without the auth_token when one is set
+func apiMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		// Handle preflight requests
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+		if handleCORS(w, r) && handleAuth(w, r) {
+			next(w, r)
 		}
-
-		next(w, r)
 	}
 }
 
@@ -185,7 +329,7 @@ func handleTasks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleTaskByID handles GET and DELETE requests to /api/tasks/:id
+// handleTaskByID handles GET, PATCH and DELETE requests to /api/tasks/:id and task sub-resources
 func handleTaskByID(w http.ResponseWriter, r *http.Request) {
 	// Extract task ID from URL path
 	path := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
@@ -194,15 +338,49 @@ func handleTaskByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := strconv.ParseInt(path, 10, 64)
+	// Sub-resources are addressed as /api/tasks/:id/<action>
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "Invalid task ID")
 		return
 	}
 
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "trim":
+			handleTrimTask(w, r, id)
+		case "cancel":
+			handleCancelTask(w, r, id)
+		case "retry":
+			handleRetryTask(w, r, id)
+		case "star":
+			handleStarTask(w, r, id)
+		case "duplicate":
+			handleDuplicateTask(w, r, id)
+		case "priority":
+			handleTaskPriority(w, r, id)
+		case "events":
+			handleTaskEvents(w, r, id)
+		case "debug":
+			handleTaskDebug(w, r, id)
+		case "probe":
+			handleProbeTask(w, r, id, parts[2:])
+		case "redownload":
+			handleRedownloadTask(w, r, id)
+		case "video":
+			handleTaskVideo(w, r, id)
+		default:
+			writeError(w, http.StatusNotFound, "Not found")
+		}
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		handleGetTask(w, r, id)
+	case http.MethodPatch:
+		handleUpdateTask(w, r, id)
 	case http.MethodDelete:
 		handleDeleteTask(w, r, id)
 	default:
@@ -211,6 +389,8 @@ func handleTaskByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleVideos serves video files from the output directory
+// The path is a .mp4 file name or a task ID; with download=1 the video is sent as an attachment named
+// after the task's date and prompt. Kept for compatibility, clients use /api/tasks/:id/video
 func handleVideos(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -224,9 +404,61 @@ func handleVideos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Prevent directory traversal
-	filename = filepath.Base(filename)
-	filePath := filepath.Join(OutputDirectory, filename)
+	// Prevent directory traversal: only plain file names are accepted
+	if strings.ContainsAny(filename, `/\`) || strings.Contains(filename, "..") {
+		writeError(w, http.StatusBadRequest, "Invalid filename")
+		return
+	}
+	if id, err := strconv.ParseInt(filename, 10, 64); err == nil {
+		handleTaskVideo(w, r, id)
+		return
+	}
+	if !strings.EqualFold(filepath.Ext(filename), ".mp4") {
+		writeError(w, http.StatusBadRequest, "Only .mp4 videos are served")
+		return
+	}
+
+	var task *Task
+	if r.URL.Query().Get("download") == "1" {
+		var err error
+		if task, err = GetTaskByLocalPath(filename); err != nil {
+			log.Printf("Failed to get task of video %s: %v", filename, err)
+			writeError(w, http.StatusInternalServerError, "Failed to get task")
+			return
+		}
+	}
+	serveVideo(w, r, filename, task)
+}
+
+// handleTaskVideo handles GET /api/tasks/:id/video
+// Serves the video of a completed task, download=1 works as for /api/videos
+func handleTaskVideo(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for video: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Status != StatusCompleted || task.LocalPath == "" {
+		writeError(w, http.StatusNotFound, "Task has no video")
+		return
+	}
+	serveVideo(w, r, filepath.Base(task.LocalPath), task)
+}
+
+// serveVideo serves a video of the output directory, range requests included
+// With download=1 it is sent as an attachment, named after the task when known
+func serveVideo(w http.ResponseWriter, r *http.Request, filename string, task *Task) {
+	filePath := ResolveVideoPath(filename)
 
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -234,6 +466,14 @@ func handleVideos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Query().Get("download") == "1" {
+		name, fallback := filename, filename
+		if task != nil {
+			name, fallback = videoDownloadName(task)
+		}
+		w.Header().Set("Content-Disposition", attachmentDisposition(name, fallback))
+	}
+
 	// Serve the file
 	http.ServeFile(w, r, filePath)
 }
@@ -273,6 +513,84 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	createTasks(w, r, req)
+}
+
+// maxTaskCount returns the largest count a create request may ask for
+func maxTaskCount(config *Config) int {
+	if config.MaxTaskCount > 0 {
+		return config.MaxTaskCount
+	}
+	return DefaultMaxTaskCount
+}
+
+// newBatchID returns a random ID shared by the tasks created by one request
+func newBatchID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleDuplicateTask handles POST /api/tasks/:id/duplicate
+// Creates pending copies of a task; the optional body overrides any field of the copy and sets count
+func handleDuplicateTask(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	source, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task to duplicate: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if source == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if source.Model == ModelCompose {
+		writeError(w, http.StatusConflict, "Compose tasks can't be duplicated")
+		return
+	}
+
+	// The copy converts the original prompt again, picking up renamed characters
+	prompt := source.OriginalPrompt
+	if prompt == "" {
+		prompt = source.Prompt
+	}
+	req := CreateTaskRequest{
+		Prompt:       prompt,
+		ImageURL:     source.ImageURL,
+		ImageURL2:    source.ImageURL2,
+		Duration:     source.Duration,
+		Orientation:  source.Orientation,
+		Model:        source.Model,
+		NoDecorate:   source.NoDecorate,
+		PromptPrefix: source.PromptPrefix,
+		PromptSuffix: source.PromptSuffix,
+		Watermark:    source.Watermark,
+		ParentTaskID: source.ID,
+	}
+	// Fields present in the body replace the copied ones
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	createTasks(w, r, req)
+}
+
+// createTasks validates req and creates its count of tasks, responding with the created tasks
+func createTasks(w http.ResponseWriter, r *http.Request, req CreateTaskRequest) {
+	// A template renders the prompt before character references are converted
+	if req.TemplateID != 0 && !applyTemplate(w, &req) {
+		return
+	}
+
+	if err := resolveImageRef(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// Validate: prompt or image is required
 	promptEmpty := strings.TrimSpace(req.Prompt) == ""
@@ -281,16 +599,57 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "Prompt or image is required")
 		return
 	}
+	if err := validateTaskModel(CurrentConfig(), req.Model); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateLastFrame(req.Model, req.ImageURL, req.ImageURL2); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, imageURL := range []string{req.ImageURL, req.ImageURL2} {
+		if err := validateTaskImage(imageURL, maxImageDimension(CurrentConfig())); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := validateTaskPriority(req.Priority); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.ScheduledAt != nil {
+		scheduledAt := req.ScheduledAt.Local()
+		req.ScheduledAt = &scheduledAt
+	}
+
+	// Validate count (default 1)
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+	if maxCount := maxTaskCount(CurrentConfig()); count < 1 || count > maxCount {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxCount))
+		return
+	}
 
 	// Convert character references in prompt (Requirements 4.3)
 	// Only completed characters are used for conversion
+	// Raw @{id} references are linked to local characters, unknown IDs produce warnings and unknown
+	// @name references fail the request
+	var usedCharacterIDs []int64
+	var warnings []string
 	if req.Prompt != "" {
 		characters, err := GetAllCharacters()
 		if err != nil {
 			log.Printf("Warning: Failed to get characters for reference conversion: %v", err)
 			// Continue without conversion if we can't get characters
 		} else {
-			req.Prompt = ConvertCharacterReferences(req.Prompt, characters)
+			req.OriginalPrompt = req.Prompt
+			req.Prompt, usedCharacterIDs, warnings, err = ResolveCharacterReferences(req.Prompt, characters, CurrentConfig().ImplicitCharacterReferences)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 		}
 	}
 
@@ -305,15 +664,32 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 		req.Model = ModelSora2
 	}
 
-	// Validate and set count (default to 1, allowed values: 1, 2, 4)
-	count := req.Count
-	if count <= 0 {
-		count = 1
-	} else if count != 1 && count != 2 && count != 4 {
-		count = 1 // Default to 1 if invalid value
+	// Dry run: preview the final prompt without creating anything
+	if r.URL.Query().Get("dry_run") == "true" {
+		submittedPrompt := req.Prompt
+		if !req.NoDecorate {
+			prefix, suffix := promptDecoration(CurrentConfig(), req.PromptPrefix, req.PromptSuffix)
+			submittedPrompt = DecoratePrompt(req.Prompt, prefix, suffix)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"prompt":           req.Prompt,
+			"submitted_prompt": submittedPrompt,
+			"duration":         req.Duration,
+			"orientation":      req.Orientation,
+			"model":            req.Model,
+			"warnings":         warnings,
+		})
+		return
 	}
 
-	// Create multiple tasks based on count
+	if warning := BatchSpaceWarning(count); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	// Create multiple tasks based on count, tagged with a shared batch
+	if count > 1 {
+		req.BatchID = newBatchID()
+	}
 	var createdTasks []CreateTaskResponse
 	for i := 0; i < count; i++ {
 		task, err := CreateTask(&req)
@@ -323,6 +699,11 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Track character usage for picker ordering, failures only affect ordering
+		if err := LinkTaskCharacters(task.ID, usedCharacterIDs); err != nil {
+			log.Printf("Warning: failed to link characters to task %d: %v", task.ID, err)
+		}
+
 		createdTasks = append(createdTasks, CreateTaskResponse{
 			ID:          task.ID,
 			Prompt:      task.Prompt,
@@ -333,6 +714,10 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 			Status:      task.Status,
 			Progress:    task.Progress,
 			CreatedAt:   task.CreatedAt,
+			Warnings:    warnings,
+
+			ParentTaskID: task.ParentTaskID,
+			BatchID:      task.BatchID,
 		})
 	}
 
@@ -340,7 +725,9 @@ func handleCreateTask(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, createdTasks)
 }
 
-// handleGetAllTasks handles GET /api/tasks with optional pagination, status filter, or ID filter
+// handleGetAllTasks handles GET /api/tasks
+// ids returns the given tasks; otherwise the filters of parseTaskFilter and limit/offset pagination combine,
+// updated_since returns only the matching tasks updated since and the deleted IDs
 func handleGetAllTasks(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
@@ -364,45 +751,43 @@ func handleGetAllTasks(w http.ResponseWriter, r *http.Request) {
 		if tasks == nil {
 			tasks = []Task{}
 		}
+		attachQueueEstimates(tasks)
+		attachFileExists(tasks)
 		writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": tasks})
 		return
 	}
 
-	// Check for status filter (for polling pending tasks only)
-	statusFilter := query.Get("status")
-	if statusFilter != "" {
-		statuses := strings.Split(statusFilter, ",")
-		tasks, err := GetTasksByStatus(statuses)
+	filter, err := parseTaskFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if sinceStr := query.Get("updated_since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339Nano, sinceStr)
 		if err != nil {
-			log.Printf("Failed to get tasks by status: %v", err)
-			writeError(w, http.StatusInternalServerError, "Failed to get tasks")
+			writeError(w, http.StatusBadRequest, "updated_since must be an RFC3339 time")
 			return
 		}
-		if tasks == nil {
-			tasks = []Task{}
-		}
-		writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": tasks})
+		handleGetTasksUpdatedSince(w, filter, since)
 		return
 	}
 
-	// Check for pagination
-	limitStr := query.Get("limit")
-	offsetStr := query.Get("offset")
-
-	if limitStr != "" {
+	// Pagination applies to the filtered tasks, total is the number of matches
+	if limitStr := query.Get("limit"); limitStr != "" {
 		limit, err := strconv.Atoi(limitStr)
 		if err != nil || limit <= 0 {
 			limit = 20
 		}
 		offset := 0
-		if offsetStr != "" {
+		if offsetStr := query.Get("offset"); offsetStr != "" {
 			offset, _ = strconv.Atoi(offsetStr)
 			if offset < 0 {
 				offset = 0
 			}
 		}
 
-		tasks, total, err := GetTasksPaginated(limit, offset)
+		tasks, total, err := GetTasksPaginated(filter, limit, offset)
 		if err != nil {
 			log.Printf("Failed to get paginated tasks: %v", err)
 			writeError(w, http.StatusInternalServerError, "Failed to get tasks")
@@ -411,6 +796,8 @@ func handleGetAllTasks(w http.ResponseWriter, r *http.Request) {
 		if tasks == nil {
 			tasks = []Task{}
 		}
+		attachQueueEstimates(tasks)
+		attachFileExists(tasks)
 		writeJSON(w, http.StatusOK, map[string]interface{}{
 			"tasks":  tasks,
 			"total":  total,
@@ -420,8 +807,8 @@ func handleGetAllTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Default: return all tasks
-	tasks, err := GetAllTasks()
+	// Default: return all matching tasks
+	tasks, err := GetTasks(filter)
 	if err != nil {
 		log.Printf("Failed to get tasks: %v", err)
 		writeError(w, http.StatusInternalServerError, "Failed to get tasks")
@@ -431,10 +818,115 @@ func handleGetAllTasks(w http.ResponseWriter, r *http.Request) {
 	if tasks == nil {
 		tasks = []Task{}
 	}
+	attachQueueEstimates(tasks)
+	attachFileExists(tasks)
 
 	writeJSON(w, http.StatusOK, TaskListResponse{Tasks: tasks})
 }
 
+// UpdatedSinceOverlap is subtracted from the server_time of updated_since responses, a change
+// written while the response was built is then sent again by the next poll instead of being missed
+const UpdatedSinceOverlap = 5 * time.Second
+
+// TasksUpdatedSinceResponse represents the response of GET /api/tasks?updated_since=
+type TasksUpdatedSinceResponse struct {
+	Tasks      []Task    `json:"tasks"`       // Matching tasks updated after updated_since
+	DeletedIDs []int64   `json:"deleted_ids"` // Tasks deleted after updated_since, whatever the filter
+	ServerTime time.Time `json:"server_time"` // updated_since of the next poll
+}
+
+// handleGetTasksUpdatedSince answers GET /api/tasks?updated_since=RFC3339 for incremental polling
+// Deletions older than TombstoneRetention are forgotten, clients polling less often reload the list
+func handleGetTasksUpdatedSince(w http.ResponseWriter, filter TaskFilter, since time.Time) {
+	serverTime := time.Now().Add(-UpdatedSinceOverlap)
+	tasks, err := GetTasksUpdatedSince(filter, since)
+	if err != nil {
+		log.Printf("Failed to get updated tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+	deletedIDs, err := GetDeletedTaskIDsSince(since)
+	if err != nil {
+		log.Printf("Failed to get deleted tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+
+	if tasks == nil {
+		tasks = []Task{}
+	}
+	attachQueueEstimates(tasks)
+	attachFileExists(tasks)
+	writeJSON(w, http.StatusOK, TasksUpdatedSinceResponse{Tasks: tasks, DeletedIDs: deletedIDs, ServerTime: serverTime})
+}
+
+// splitList splits a comma separated query parameter, dropping blank entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTaskFilter reads the filter and sort parameters of GET /api/tasks, which can all be combined:
+// q (prompt search), status and model (comma separated), warning, starred, batch_id, start and end
+// (YYYY-MM-DD, inclusive), min_duration and max_duration (seconds of the downloaded video),
+// min_width and min_height (pixels), sort and order (asc/desc)
+func parseTaskFilter(query url.Values) (TaskFilter, error) {
+	filter := TaskFilter{
+		Search:    strings.TrimSpace(query.Get("q")),
+		Statuses:  splitList(query.Get("status")),
+		Models:    splitList(query.Get("model")),
+		Warning:   query.Get("warning"),
+		BatchID:   query.Get("batch_id"),
+		StartDate: query.Get("start"),
+		EndDate:   query.Get("end"),
+		SortBy:    query.Get("sort"),
+	}
+	if err := validateDateRange(filter.StartDate, filter.EndDate); err != nil {
+		return filter, err
+	}
+	if starredStr := query.Get("starred"); starredStr != "" {
+		starred, err := strconv.ParseBool(starredStr)
+		if err != nil {
+			return filter, fmt.Errorf("starred must be true or false")
+		}
+		filter.Starred = &starred
+	}
+	for param, dest := range map[string]*float64{"min_duration": &filter.MinDurationSeconds, "max_duration": &filter.MaxDurationSeconds} {
+		if value := query.Get(param); value != "" {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				return filter, fmt.Errorf("%s must be a non-negative number of seconds", param)
+			}
+			*dest = seconds
+		}
+	}
+	for param, dest := range map[string]*int{"min_width": &filter.MinWidth, "min_height": &filter.MinHeight} {
+		if value := query.Get(param); value != "" {
+			pixels, err := strconv.Atoi(value)
+			if err != nil || pixels < 0 {
+				return filter, fmt.Errorf("%s must be a non-negative number of pixels", param)
+			}
+			*dest = pixels
+		}
+	}
+	if filter.SortBy != "" && !IsTaskSortField(filter.SortBy) {
+		return filter, fmt.Errorf("sort must be one of %s", strings.Join(TaskSortFields, ", "))
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("order must be asc or desc")
+	}
+	return filter, nil
+}
+
 // handleGetTask handles GET /api/tasks/:id
 func handleGetTask(w http.ResponseWriter, r *http.Request, id int64) {
 	task, err := GetTask(id)
@@ -449,9 +941,338 @@ func handleGetTask(w http.ResponseWriter, r *http.Request, id int64) {
 		return
 	}
 
+	tasks := []Task{*task}
+	attachQueueEstimates(tasks)
+	attachFileExists(tasks)
+	writeJSON(w, http.StatusOK, tasks[0])
+}
+
+// validateTaskOptions checks duration and orientation values when they are set
+func validateTaskOptions(duration, orientation string) error {
+	if duration != "" && duration != Duration10s && duration != Duration15s {
+		return fmt.Errorf("duration must be %s or %s", Duration10s, Duration15s)
+	}
+	if orientation != "" && orientation != OrientationPortrait && orientation != OrientationLandscape {
+		return fmt.Errorf("orientation must be %s or %s", OrientationPortrait, OrientationLandscape)
+	}
+	return nil
+}
+
+// validateLastFrame checks that a last frame image is only set for models that use it, along with
+// a first frame
+func validateLastFrame(model, imageURL, imageURL2 string) error {
+	if strings.TrimSpace(imageURL2) == "" {
+		return nil
+	}
+	if !SupportsLastFrame(model) {
+		return fmt.Errorf("image_url2 (last frame) is only supported by %s models", ModelVeo3)
+	}
+	if strings.TrimSpace(imageURL) == "" {
+		return fmt.Errorf("image_url2 (last frame) requires image_url (first frame)")
+	}
+	return nil
+}
+
+// decodeRetryOverrides reads the optional overrides body of a retry request
+// Returns nil when the body is empty
+func decodeRetryOverrides(r *http.Request) (*RetryOverrides, error) {
+	var overrides RetryOverrides
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Invalid request body")
+	}
+	if (overrides.Duration != nil && *overrides.Duration == "") || (overrides.Orientation != nil && *overrides.Orientation == "") {
+		return nil, fmt.Errorf("Duration and orientation cannot be empty")
+	}
+	if overrides.Model != nil && strings.TrimSpace(*overrides.Model) == "" {
+		return nil, fmt.Errorf("Model cannot be empty")
+	}
+	if overrides.Model != nil {
+		if err := validateTaskModel(CurrentConfig(), *overrides.Model); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTaskOptions(stringValue(overrides.Duration), stringValue(overrides.Orientation)); err != nil {
+		return nil, err
+	}
+	if overrides.Duration == nil && overrides.Orientation == nil && overrides.Model == nil && overrides.Watermark == nil {
+		return nil, nil
+	}
+	return &overrides, nil
+}
+
+// validateDateRange checks optional YYYY-MM-DD start and end dates
+func validateDateRange(startDate, endDate string) error {
+	var start, end time.Time
+	var err error
+	if startDate != "" {
+		if start, err = time.ParseInLocation(taskDateLayout, startDate, time.Local); err != nil {
+			return fmt.Errorf("invalid start date %q (format: YYYY-MM-DD)", startDate)
+		}
+	}
+	if endDate != "" {
+		if end, err = time.ParseInLocation(taskDateLayout, endDate, time.Local); err != nil {
+			return fmt.Errorf("invalid end date %q (format: YYYY-MM-DD)", endDate)
+		}
+	}
+	if startDate != "" && endDate != "" && end.Before(start) {
+		return fmt.Errorf("end date must not be before start date")
+	}
+	return nil
+}
+
+// Task curation limits
+const (
+	MinTaskPriority = -100
+	MaxTaskPriority = 100
+	MaxTagLength    = 32
+	MaxTagsPerTask  = 20
+)
+
+// validateTaskPriority checks that a priority is within the allowed range
+func validateTaskPriority(priority int) error {
+	if priority < MinTaskPriority || priority > MaxTaskPriority {
+		return fmt.Errorf("priority must be between %d and %d", MinTaskPriority, MaxTaskPriority)
+	}
+	return nil
+}
+
+// normalizeTaskTags trims and de-duplicates tags, rejecting empty or overlong ones
+func normalizeTaskTags(tags []string) ([]string, error) {
+	if len(tags) > MaxTagsPerTask {
+		return nil, fmt.Errorf("at most %d tags can be set at once", MaxTagsPerTask)
+	}
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("tags cannot be empty")
+		}
+		if len([]rune(tag)) > MaxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", MaxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// handleUpdateTask handles PATCH /api/tasks/:id
+// Edits the prompt and generation options of a task that hasn't been submitted yet
+func handleUpdateTask(w http.ResponseWriter, r *http.Request, id int64) {
+	var req UpdateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for update: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to update task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Status != StatusPending || task.TaskID != "" {
+		writeError(w, http.StatusConflict, "Only tasks that haven't been submitted can be edited")
+		return
+	}
+
+	fields := map[string]interface{}{}
+	if req.Duration != nil {
+		fields["duration"] = *req.Duration
+	}
+	if req.Orientation != nil {
+		fields["orientation"] = *req.Orientation
+	}
+	if req.Model != nil {
+		if strings.TrimSpace(*req.Model) == "" {
+			writeError(w, http.StatusBadRequest, "Model cannot be empty")
+			return
+		}
+		if err := validateTaskModel(CurrentConfig(), *req.Model); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fields["model"] = *req.Model
+		task.Model = *req.Model
+	}
+	if req.ImageURL != nil {
+		if err := resolveImageRef(&CreateTaskRequest{ImageURL: *req.ImageURL}); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateTaskImage(*req.ImageURL, maxImageDimension(CurrentConfig())); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fields["image_url"] = *req.ImageURL
+		task.ImageURL = *req.ImageURL
+	}
+	if err := validateLastFrame(task.Model, task.ImageURL, task.ImageURL2); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Priority != nil {
+		if err := validateTaskPriority(*req.Priority); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fields["priority"] = *req.Priority
+	}
+	if req.ScheduledAt != nil {
+		if *req.ScheduledAt == "" {
+			fields["scheduled_at"] = nil
+		} else {
+			scheduledAt, err := time.Parse(time.RFC3339, *req.ScheduledAt)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "scheduled_at must be an RFC 3339 time")
+				return
+			}
+			fields["scheduled_at"] = scheduledAt.Local()
+		}
+	}
+	if (req.Duration != nil && *req.Duration == "") || (req.Orientation != nil && *req.Orientation == "") {
+		writeError(w, http.StatusBadRequest, "Duration and orientation cannot be empty")
+		return
+	}
+	if err := validateTaskOptions(stringValue(req.Duration), stringValue(req.Orientation)); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var usedCharacterIDs []int64
+	if req.Prompt != nil {
+		prompt := *req.Prompt
+		// The edited prompt goes through character reference conversion like a new task
+		if prompt != "" {
+			characters, err := GetAllCharacters()
+			if err != nil {
+				log.Printf("Warning: Failed to get characters for reference conversion: %v", err)
+			} else {
+				var warnings []string
+				prompt, usedCharacterIDs, warnings, err = ResolveCharacterReferences(prompt, characters, CurrentConfig().ImplicitCharacterReferences)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				for _, warning := range warnings {
+					log.Printf("Warning: task %d: %s", id, warning)
+				}
+			}
+		}
+		fields["prompt"] = prompt
+		fields["original_prompt"] = *req.Prompt
+		task.Prompt = prompt
+	}
+
+	if strings.TrimSpace(task.Prompt) == "" && strings.TrimSpace(task.ImageURL) == "" {
+		writeError(w, http.StatusBadRequest, "Prompt or image is required")
+		return
+	}
+
+	updated, err := UpdateTaskFields(id, fields)
+	if err != nil {
+		log.Printf("Failed to update task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to update task")
+		return
+	}
+	if !updated {
+		writeError(w, http.StatusConflict, "Task has already been submitted")
+		return
+	}
+
+	if err := LinkTaskCharacters(id, usedCharacterIDs); err != nil {
+		log.Printf("Warning: failed to link characters to task %d: %v", id, err)
+	}
+
+	handleGetTask(w, r, id)
+}
+
+// handleTaskPriority handles POST /api/tasks/:id/priority
+// Sets the priority of a pending task from the body {"priority": N}; without a body the task is bumped
+// above every other pending task
+func handleTaskPriority(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Priority *int `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for priority change: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to set priority")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Status != StatusPending {
+		writeError(w, http.StatusConflict, "Priority can only be changed on pending tasks")
+		return
+	}
+
+	var priority int
+	if req.Priority != nil {
+		priority = *req.Priority
+		if err := validateTaskPriority(priority); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		highest, err := GetMaxPendingPriority()
+		if err != nil {
+			log.Printf("Failed to get pending priorities: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to set priority")
+			return
+		}
+		priority = min(max(highest+1, task.Priority), MaxTaskPriority)
+	}
+
+	updated, err := SetTaskPriority(id, priority)
+	if err != nil {
+		log.Printf("Failed to set priority of task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to set priority")
+		return
+	}
+	if !updated {
+		writeError(w, http.StatusConflict, "Task has already been submitted")
+		return
+	}
+
+	if task, err = GetTask(id); err != nil || task == nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	PublishTaskUpdate(task)
 	writeJSON(w, http.StatusOK, task)
 }
 
+// stringValue dereferences an optional string, returning "" for nil
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 // handleDeleteTask handles DELETE /api/tasks/:id
 func handleDeleteTask(w http.ResponseWriter, r *http.Request, id int64) {
 	// Get task to find local file path
@@ -483,6 +1304,99 @@ func handleDeleteTask(w http.ResponseWriter, r *http.Request, id int64) {
 	})
 }
 
+// handleCancelTask handles POST /api/tasks/:id/cancel
+// Pending tasks are failed before submission, processing and downloading tasks stop being polled
+func handleCancelTask(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for cancel: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to cancel task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	var toStatus, failReason string
+	switch task.Status {
+	case StatusPending:
+		toStatus, failReason = StatusFailed, CancelledFailReason
+	case StatusProcessing, StatusDownloading:
+		toStatus, failReason = StatusCancelled, CancelledFailReason
+	default:
+		writeError(w, http.StatusConflict, fmt.Sprintf("Task is already %s", task.Status))
+		return
+	}
+
+	// The processor may have moved the task on in the meantime
+	cancelled, err := CancelTask(id, task.Status, toStatus, failReason)
+	if err != nil {
+		log.Printf("Failed to cancel task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to cancel task")
+		return
+	}
+	if !cancelled {
+		writeError(w, http.StatusConflict, "Task status changed, please retry")
+		return
+	}
+
+	log.Printf("任务 %d 已取消 (%s -> %s)", id, task.Status, toStatus)
+	RecordTaskEvent(id, HistoryCancelled, fmt.Sprintf("%s -> %s", task.Status, toStatus))
+	task.Status = toStatus
+	task.FailReason = failReason
+	task.ImageURL = ""
+	task.ImageURL2 = ""
+	writeJSON(w, http.StatusOK, task)
+}
+
+// excludeStarred drops starred tasks from a cleanup unless force is set
+// Returns the tasks to delete and the number of starred tasks kept
+// Every bulk or automatic cleanup goes through it so keepers are never removed by accident
+func excludeStarred(tasks []Task, force bool) ([]Task, int) {
+	if force {
+		return tasks, 0
+	}
+	var deletable []Task
+	for _, task := range tasks {
+		if !task.Starred {
+			deletable = append(deletable, task)
+		}
+	}
+	return deletable, len(tasks) - len(deletable)
+}
+
+// handleStarTask handles POST /api/tasks/:id/star - toggle the starred flag
+func handleStarTask(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := ToggleTaskStarred(id); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		log.Printf("Failed to toggle task star: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to star task")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil || task == nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	PublishTaskUpdate(task)
+	writeJSON(w, http.StatusOK, task)
+}
+
 // handleDeleteFailedTasks handles DELETE /api/tasks-failed - delete all failed tasks
 func handleDeleteFailedTasks(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -497,6 +1411,7 @@ func handleDeleteFailedTasks(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "Failed to get failed tasks")
 		return
 	}
+	failedTasks, skipped := excludeStarred(failedTasks, r.URL.Query().Get("force") == "true")
 
 	deletedCount := 0
 	for _, task := range failedTasks {
@@ -513,30 +1428,199 @@ func handleDeleteFailedTasks(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"deleted": deletedCount,
-		"message": fmt.Sprintf("Deleted %d failed tasks", deletedCount),
+		"success":         true,
+		"deleted":         deletedCount,
+		"skipped_starred": skipped,
+		"message":         fmt.Sprintf("Deleted %d failed tasks", deletedCount),
 	})
 }
 
-// handleRetryWithAlt handles POST /api/tasks-retry-alt - retry failed sora-2 tasks with sora-2-alt
-func handleRetryWithAlt(w http.ResponseWriter, r *http.Request) {
+// DefaultStuckMinutes is how long a processing task must go without updates before
+// POST /api/tasks-reset-stuck considers it stuck
+const DefaultStuckMinutes = 30
+
+// deleteRetryLeftovers deletes the files of a previous run of retried tasks, e.g. a partial download
+// or a thumbnail, given relative to the output directory
+// A file that can't be removed is logged, the retry has already been committed
+func deleteRetryLeftovers(localPaths []string) {
+	for _, localPath := range localPaths {
+		if err := DeleteVideoFile(localPath); err != nil {
+			log.Printf("Warning: failed to delete file of retried task: %v", err)
+		}
+	}
+}
+
+// handleRetryTask handles POST /api/tasks/:id/retry
+// Resets a failed task to pending; the optional body {"duration", "orientation", "model"} changes
+// those options for the retry, the original values are kept in the audit log
+func handleRetryTask(w http.ResponseWriter, r *http.Request, id int64) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	count, err := ResetFailedTasks()
+	overrides, err := decodeRetryOverrides(r)
 	if err != nil {
-		log.Printf("Failed to retry tasks with alt: %v", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for retry: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to retry task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Status != StatusFailed {
+		writeError(w, http.StatusConflict, "Only failed tasks can be retried")
+		return
+	}
+	if task.Model == ModelCompose {
+		writeError(w, http.StatusConflict, "Compose tasks can't be retried, compose the clips again")
+		return
+	}
+
+	// The original prompt is converted again so renamed characters are picked up
+	if task.OriginalPrompt != "" {
+		characters, err := GetAllCharacters()
+		if err != nil {
+			log.Printf("Warning: Failed to get characters for reference conversion: %v", err)
+		} else {
+			prompt, usedCharacterIDs, _, err := ResolveCharacterReferences(task.OriginalPrompt, characters, CurrentConfig().ImplicitCharacterReferences)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if prompt != task.Prompt {
+				if _, err := SetFailedTaskPrompt(id, prompt, usedCharacterIDs); err != nil {
+					log.Printf("Failed to update prompt of task %d: %v", id, err)
+					writeError(w, http.StatusInternalServerError, "Failed to retry task")
+					return
+				}
+			}
+		}
+	}
+
+	reset, err := RetryTask(id, overrides)
+	if err != nil {
+		log.Printf("Failed to retry task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to retry task")
+		return
+	}
+	if !reset {
+		writeError(w, http.StatusConflict, "Task status changed, please retry")
+		return
+	}
+	if task.LocalPath != "" {
+		deleteRetryLeftovers([]string{task.LocalPath})
+	}
+
+	if task, err = GetTask(id); err != nil || task == nil {
+		log.Printf("Failed to get retried task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to retry task")
+		return
+	}
+	PublishTaskUpdate(task)
+	writeJSON(w, http.StatusOK, task)
+}
+
+// handleRetryFailedTasks handles POST /api/tasks-retry-failed, and POST /api/tasks-retry-alt for
+// older clients - reset every failed task to pending
+// The optional body {"duration", "orientation", "model"} is applied to every task reset
+func handleRetryFailedTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	overrides, err := decodeRetryOverrides(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ids, localPaths, err := RetryFailedTasks(overrides)
+	if err != nil {
+		log.Printf("Failed to retry failed tasks: %v", err)
 		writeError(w, http.StatusInternalServerError, "Failed to retry tasks")
 		return
 	}
+	deleteRetryLeftovers(localPaths)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"updated": len(ids),
+		"ids":     ids,
+		"message": fmt.Sprintf("已将 %d 个失败的任务重置为待处理", len(ids)),
+	})
+}
+
+// handleResetStuckTasks handles POST /api/tasks-reset-stuck?stuck_minutes=30
+// Resets processing tasks not updated for stuck_minutes (default 30) to pending; their remote
+// generations are abandoned. The optional body {"duration", "orientation", "model"} is applied to
+// every task reset
+func handleResetStuckTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stuckMinutes := DefaultStuckMinutes
+	if minutesStr := r.URL.Query().Get("stuck_minutes"); minutesStr != "" {
+		minutes, err := strconv.Atoi(minutesStr)
+		if err != nil || minutes < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid stuck_minutes")
+			return
+		}
+		stuckMinutes = minutes
+	}
+	overrides, err := decodeRetryOverrides(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stuckBefore := time.Now().Add(-time.Duration(stuckMinutes) * time.Minute)
+	ids, localPaths, err := ResetStuckTasks(stuckBefore, overrides)
+	if err != nil {
+		log.Printf("Failed to reset stuck tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to reset tasks")
+		return
+	}
+	deleteRetryLeftovers(localPaths)
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"updated": count,
-		"message": fmt.Sprintf("已将 %d 个失败/进行中的任务重置为待处理", count),
+		"updated": len(ids),
+		"ids":     ids,
+		"message": fmt.Sprintf("已将 %d 个超过 %d 分钟未更新的进行中任务重置为待处理", len(ids), stuckMinutes),
+	})
+}
+
+// handleRequeueAuthFailed handles POST /api/tasks-requeue-auth - re-queue tasks that failed with authentication errors
+// Tasks that failed for content or validation reasons are never touched
+func handleRequeueAuthFailed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	count, localPaths, err := RequeueAuthFailedTasks()
+	if err != nil {
+		log.Printf("Failed to requeue auth failed tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to requeue tasks")
+		return
+	}
+	deleteRetryLeftovers(localPaths)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"requeued": count,
+		"message":  fmt.Sprintf("已将 %d 个认证失败的任务重新排队", count),
 	})
 }
 
@@ -556,6 +1640,10 @@ func handleDeleteTasksByDateRange(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "start and end date are required (format: YYYY-MM-DD)")
 		return
 	}
+	if err := validateDateRange(startDate, endDate); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// Get tasks in date range
 	tasks, err := GetTasksByDateRange(startDate, endDate)
@@ -564,6 +1652,7 @@ func handleDeleteTasksByDateRange(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "Failed to get tasks")
 		return
 	}
+	tasks, skipped := excludeStarred(tasks, query.Get("force") == "true")
 
 	deletedCount := 0
 	for _, task := range tasks {
@@ -580,8 +1669,9 @@ func handleDeleteTasksByDateRange(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"deleted": deletedCount,
-		"message": fmt.Sprintf("Deleted %d tasks from %s to %s", deletedCount, startDate, endDate),
+		"success":         true,
+		"deleted":         deletedCount,
+		"skipped_starred": skipped,
+		"message":         fmt.Sprintf("Deleted %d tasks from %s to %s", deletedCount, startDate, endDate),
 	})
 }
@@ -0,0 +1,734 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listTasksResponse is the body of GET /api/tasks, total/limit/offset are only set when paginated
+type listTasksResponse struct {
+	Tasks  []Task `json:"tasks"`
+	Total  *int   `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// useTestConfig makes config the configuration in effect for the rest of the test
+func useTestConfig(t *testing.T, config *Config) {
+	t.Helper()
+	previous := CurrentConfig()
+	setCurrentConfig(config)
+	t.Cleanup(func() { setCurrentConfig(previous) })
+}
+
+// getTasks sends GET /api/tasks?<rawQuery> and decodes the response
+func getTasks(t *testing.T, rawQuery string) (int, listTasksResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleGetAllTasks(rec, httptest.NewRequest(http.MethodGet, "/api/tasks?"+rawQuery, nil))
+	var resp listTasksResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+// TestHandleGetAllTasksCombinedFilters checks that status, model, search and date filters combine
+// with each other and with pagination, and that the single-parameter forms keep their shape
+func TestHandleGetAllTasksCombinedFilters(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "list.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	for _, task := range []struct {
+		prompt, model, status string
+	}{
+		{"red dragon", "", StatusFailed},
+		{"blue dragon", "veo3", StatusFailed},
+		{"green dragon", "", StatusFailed},
+		{"red fox", "", StatusCompleted},
+		{"red owl", "veo3", StatusCompleted},
+	} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: task.prompt, Model: task.model, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", task.status, created.ID)
+	}
+
+	cases := []struct {
+		query string
+		count int
+		total int // -1 when the response must not be paginated
+	}{
+		{"status=failed&limit=2&offset=0", 2, 3},
+		{"status=failed&limit=2&offset=2", 1, 3},
+		{"status=failed&model=veo3&limit=10", 1, 1},
+		{"model=sora-2&limit=10", 3, 3},
+		{"q=red&status=completed,failed&limit=1", 1, 3},
+		{"q=dragon&model=sora-2", 2, -1},
+		{"status=failed", 3, -1},
+		{"limit=10", 5, 5},
+		{"", 5, -1},
+		{"start=2000-01-01&status=completed&limit=5", 2, 2},
+	}
+	for _, tc := range cases {
+		code, resp := getTasks(t, tc.query)
+		if code != http.StatusOK {
+			t.Errorf("%q: status %d", tc.query, code)
+			continue
+		}
+		if len(resp.Tasks) != tc.count {
+			t.Errorf("%q: %d tasks, want %d", tc.query, len(resp.Tasks), tc.count)
+		}
+		switch {
+		case tc.total < 0 && resp.Total != nil:
+			t.Errorf("%q: unexpected total %d", tc.query, *resp.Total)
+		case tc.total >= 0 && (resp.Total == nil || *resp.Total != tc.total):
+			t.Errorf("%q: total %v, want %d", tc.query, resp.Total, tc.total)
+		}
+	}
+
+	for _, query := range []string{"start=2026-10-11&end=2026-10-10", "start=yesterday", "sort=prompt", "order=up"} {
+		if code, _ := getTasks(t, query); code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, code)
+		}
+	}
+}
+
+// TestGetTasksUpdatedSince polls with updated_since and gets only the tasks changed since, with the
+// IDs of the deleted ones and the time of the next poll
+func TestGetTasksUpdatedSince(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "since.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	var ids []int64
+	for _, prompt := range []string{"unchanged", "progressed", "deleted", "other status"} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: prompt, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+	DB.Exec("UPDATE tasks SET status = ?", StatusProcessing)
+
+	since := time.Now()
+	SetTaskProgress(ids[1], 50)
+	DeleteTask(ids[2])
+	UpdateTaskStatus(ids[3], StatusFailed, 0, "boom", "")
+
+	poll := func(query string) (int, TasksUpdatedSinceResponse) {
+		rec := httptest.NewRecorder()
+		handleGetAllTasks(rec, httptest.NewRequest(http.MethodGet, "/api/tasks?"+query, nil))
+		var resp TasksUpdatedSinceResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	code, resp := poll("status=pending,processing&updated_since=" + url.QueryEscape(since.Format(time.RFC3339Nano)))
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(resp.Tasks) != 1 || resp.Tasks[0].ID != ids[1] || resp.Tasks[0].Progress != 50 {
+		t.Errorf("tasks = %+v, want only the progressed task", resp.Tasks)
+	}
+	if !slices.Equal(resp.DeletedIDs, []int64{ids[2]}) {
+		t.Errorf("deleted_ids = %v, want [%d]", resp.DeletedIDs, ids[2])
+	}
+	if resp.ServerTime.IsZero() || resp.ServerTime.After(time.Now()) {
+		t.Errorf("server_time = %v", resp.ServerTime)
+	}
+
+	if _, resp := poll("updated_since=" + url.QueryEscape(time.Now().Format(time.RFC3339Nano))); len(resp.Tasks) != 0 || len(resp.DeletedIDs) != 0 {
+		t.Errorf("nothing changed: %d tasks, deleted %v", len(resp.Tasks), resp.DeletedIDs)
+	}
+	if code, _ := poll("updated_since=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("invalid updated_since: status %d, want 400", code)
+	}
+}
+
+// TestCORSAllowedOrigins checks the wildcard without allowed_origins, and that with them only the
+// listed origins and same-origin requests get through, preflights included
+func TestCORSAllowedOrigins(t *testing.T) {
+	config := DefaultConfig()
+	useTestConfig(t, config)
+	handler := apiMiddleware(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	send := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8080/api/tasks/1", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodDelete, "https://evil.example.com"); rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("without allowed_origins: status %d, allow origin %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	config.AllowedOrigins = []string{"https://Videogen.example.com/"}
+	cases := []struct {
+		method, origin string
+		status         int
+		allowOrigin    string
+	}{
+		{http.MethodDelete, "https://videogen.example.com", http.StatusNoContent, "https://videogen.example.com"},
+		{http.MethodOptions, "https://videogen.example.com", http.StatusOK, "https://videogen.example.com"},
+		{http.MethodDelete, "https://evil.example.com", http.StatusForbidden, ""},
+		{http.MethodOptions, "https://evil.example.com", http.StatusForbidden, ""},
+		{http.MethodPost, "http://localhost:8080", http.StatusNoContent, "http://localhost:8080"},
+		{http.MethodGet, "", http.StatusNoContent, ""},
+	}
+	for _, tc := range cases {
+		rec := send(tc.method, tc.origin)
+		if rec.Code != tc.status || rec.Header().Get("Access-Control-Allow-Origin") != tc.allowOrigin {
+			t.Errorf("%s from %q: status %d, allow origin %q, want %d and %q", tc.method, tc.origin,
+				rec.Code, rec.Header().Get("Access-Control-Allow-Origin"), tc.status, tc.allowOrigin)
+		}
+	}
+
+	for _, origins := range [][]string{{"videogen.example.com"}, {"https://videogen.example.com/app"}, {"ftp://example.com"}} {
+		if err := validateAllowedOrigins(origins); err == nil {
+			t.Errorf("allowed_origins %v accepted", origins)
+		}
+	}
+}
+
+// TestAPITokenAuth checks that with auth_token set, API requests need the token in a header and
+// only file reads accept it as a query parameter
+func TestAPITokenAuth(t *testing.T) {
+	config := DefaultConfig()
+	useTestConfig(t, config)
+	handler := apiMiddleware(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	send := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		maps.Copy(req.Header, header)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodDelete, "/api/tasks/1", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("without auth_token: status %d", rec.Code)
+	}
+
+	config.AuthToken = "s3cret"
+	cases := []struct {
+		name, method, target string
+		header               http.Header
+		status               int
+	}{
+		{"no token", http.MethodDelete, "/api/tasks/1", nil, http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/tasks", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+		{"bearer", http.MethodDelete, "/api/tasks/1", http.Header{"Authorization": {"Bearer s3cret"}}, http.StatusNoContent},
+		{"header", http.MethodGet, "/api/tasks", http.Header{"X-Api-Token": {"s3cret"}}, http.StatusNoContent},
+		{"query on video", http.MethodGet, "/api/tasks/1/video?token=s3cret", nil, http.StatusNoContent},
+		{"query on picture", http.MethodGet, "/api/character-pictures/a.png?token=s3cret", nil, http.StatusNoContent},
+		{"query on events", http.MethodGet, "/api/events?token=s3cret", nil, http.StatusNoContent},
+		{"query on list", http.MethodGet, "/api/tasks?token=s3cret", nil, http.StatusUnauthorized},
+		{"query on delete", http.MethodDelete, "/api/videos/a.mp4?token=s3cret", nil, http.StatusUnauthorized},
+		{"preflight", http.MethodOptions, "/api/tasks/1", nil, http.StatusOK},
+		{"character source", http.MethodGet, "/api/character-sources/0123456789abcdef.mp4", nil, http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		rec := send(tc.method, tc.target, tc.header)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.status)
+		}
+		if rec.Code == http.StatusUnauthorized && !strings.Contains(rec.Body.String(), `"error"`) {
+			t.Errorf("%s: body %s, want a JSON error", tc.name, rec.Body.String())
+		}
+	}
+
+	if masked := configResponse(config).Config.AuthToken; masked != "****cret" {
+		t.Errorf("auth_token returned as %q", masked)
+	}
+}
+
+// TestStarredTasksSurviveCleanup checks the star toggle, the starred filter and that cleanups keep
+// starred tasks unless forced
+func TestStarredTasksSurviveCleanup(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "star.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	var ids []int64
+	for _, prompt := range []string{"keeper", "junk"} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: prompt, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusFailed, created.ID)
+		ids = append(ids, created.ID)
+	}
+
+	star := func(id int64) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleStarTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks/star", nil), id)
+		return rec
+	}
+	star(ids[0])
+	star(ids[1])
+	if rec := star(ids[1]); rec.Code != http.StatusOK {
+		t.Fatalf("star: status %d", rec.Code)
+	}
+	if rec := star(9999); rec.Code != http.StatusNotFound {
+		t.Errorf("star missing task: status %d, want 404", rec.Code)
+	}
+
+	if _, resp := getTasks(t, "starred=true"); len(resp.Tasks) != 1 || resp.Tasks[0].ID != ids[0] {
+		t.Errorf("starred=true returned %v", resp.Tasks)
+	}
+	if _, resp := getTasks(t, "starred=false&status=failed"); len(resp.Tasks) != 1 || resp.Tasks[0].ID != ids[1] {
+		t.Errorf("starred=false returned %v", resp.Tasks)
+	}
+	if code, _ := getTasks(t, "starred=maybe"); code != http.StatusBadRequest {
+		t.Errorf("starred=maybe: status %d, want 400", code)
+	}
+
+	deleteFailed := func(query string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		handleDeleteFailedTasks(rec, httptest.NewRequest(http.MethodDelete, "/api/tasks-failed"+query, nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+	if resp := deleteFailed(""); resp["deleted"] != float64(1) || resp["skipped_starred"] != float64(1) {
+		t.Fatalf("cleanup without force: %v", resp)
+	}
+	if task, _ := GetTask(ids[0]); task == nil {
+		t.Fatal("starred task was deleted")
+	}
+	if resp := deleteFailed("?force=true"); resp["deleted"] != float64(1) {
+		t.Fatalf("forced cleanup: %v", resp)
+	}
+	if task, _ := GetTask(ids[0]); task != nil {
+		t.Error("starred task survived a forced cleanup")
+	}
+}
+
+// TestDuplicateTask checks the copied fields, body overrides, count and parent_task_id of duplicates
+func TestDuplicateTask(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "duplicate.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, DefaultConfig())
+
+	source, err := CreateTask(&CreateTaskRequest{Prompt: "a lighthouse", ImageURL: "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII=", Model: "veo3", Duration: Duration15s, Orientation: OrientationPortrait})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	DB.Exec("UPDATE tasks SET status = ?, task_id = 'video_1' WHERE id = ?", StatusCompleted, source.ID)
+
+	duplicate := func(id int64, body string) (int, []CreateTaskResponse) {
+		rec := httptest.NewRecorder()
+		handleDuplicateTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks/duplicate", strings.NewReader(body)), id)
+		var created []CreateTaskResponse
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		return rec.Code, created
+	}
+
+	code, created := duplicate(source.ID, "")
+	if code != http.StatusCreated || len(created) != 1 {
+		t.Fatalf("duplicate: status %d, %d tasks", code, len(created))
+	}
+	copied, _ := GetTask(created[0].ID)
+	if copied.Prompt != source.Prompt || copied.ImageURL != source.ImageURL || copied.Model != "veo3" ||
+		copied.Duration != Duration15s || copied.Orientation != OrientationPortrait {
+		t.Errorf("copy differs from source: %+v", copied)
+	}
+	if copied.Status != StatusPending || copied.TaskID != "" || copied.ParentTaskID != source.ID || created[0].ParentTaskID != source.ID {
+		t.Errorf("copy status=%q task_id=%q parent=%d", copied.Status, copied.TaskID, copied.ParentTaskID)
+	}
+
+	code, created = duplicate(source.ID, `{"orientation":"landscape","count":2}`)
+	if code != http.StatusCreated || len(created) != 2 {
+		t.Fatalf("duplicate with count: status %d, %d tasks", code, len(created))
+	}
+	if created[0].Orientation != OrientationLandscape || created[0].Prompt != source.Prompt {
+		t.Errorf("override not applied: %+v", created[0])
+	}
+
+	if code, _ := duplicate(9999, ""); code != http.StatusNotFound {
+		t.Errorf("missing source: status %d, want 404", code)
+	}
+	if code, _ := duplicate(source.ID, "{"); code != http.StatusBadRequest {
+		t.Errorf("invalid body: status %d, want 400", code)
+	}
+}
+
+// TestOriginalPrompt checks the prompt as written is kept next to the converted one and converted
+// again by retry and duplicate
+func TestOriginalPrompt(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "original.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, DefaultConfig())
+
+	char, _ := CreateCharacter(&Character{CustomName: "Alice", ApiCharacterID: "char_a", SourceType: "url", Status: StatusCompleted})
+
+	rec := httptest.NewRecorder()
+	handleCreateTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{"prompt": "@Alice waves"}`)))
+	var created []CreateTaskResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec.Code != http.StatusCreated || len(created) != 1 {
+		t.Fatalf("create: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	task, _ := GetTask(created[0].ID)
+	if task.Prompt != "@{char_a} waves" || task.OriginalPrompt != "@Alice waves" {
+		t.Fatalf("prompt %q, original_prompt %q", task.Prompt, task.OriginalPrompt)
+	}
+
+	// The character is trained again under a new provider ID
+	DB.Exec("UPDATE characters SET api_character_id = 'char_b' WHERE id = ?", char.ID)
+	DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusFailed, task.ID)
+
+	rec = httptest.NewRecorder()
+	handleRetryTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks/retry", nil), task.ID)
+	if task, _ = GetTask(task.ID); rec.Code != http.StatusOK || task.Prompt != "@{char_b} waves" || task.OriginalPrompt != "@Alice waves" {
+		t.Errorf("retry: status %d, prompt %q, original_prompt %q", rec.Code, task.Prompt, task.OriginalPrompt)
+	}
+
+	DB.Exec("UPDATE characters SET api_character_id = 'char_c' WHERE id = ?", char.ID)
+	rec = httptest.NewRecorder()
+	handleDuplicateTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks/duplicate", nil), task.ID)
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec.Code != http.StatusCreated || len(created) != 1 || created[0].Prompt != "@{char_c} waves" {
+		t.Errorf("duplicate: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestRetryAllClearsFailedRun checks tasks reset by the retry-all endpoint carry nothing from the
+// failed attempt, and that its partial download is deleted
+func TestRetryAllClearsFailedRun(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "retry.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "retry me", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	partial := filepath.Join(OutputDirectory(), "partial.mp4")
+	os.WriteFile(partial, fakeMP4(100), 0644)
+	thumbnail := filepath.Join(ThumbnailDirectory(), "1.jpg")
+	os.MkdirAll(ThumbnailDirectory(), 0755)
+	os.WriteFile(thumbnail, []byte("jpeg"), 0644)
+	DB.Exec(`UPDATE tasks SET status = ?, task_id = 'video_x', progress = 60, video_url = 'https://example.com/v.mp4',
+		local_path = 'partial.mp4', fail_reason = 'download interrupted', warning = ?, warning_message = 'x',
+		thumbnail = '1.jpg', duration_seconds = 10, width = 1280, height = 720, file_size_bytes = 100
+		WHERE id = ?`, StatusFailed, WarningOrientationMismatch, task.ID)
+	SetTaskAPIResponse(task.ID, `{"status":"failed"}`)
+
+	rec := httptest.NewRecorder()
+	handleRetryFailedTasks(rec, httptest.NewRequest(http.MethodPost, "/api/tasks-retry-failed", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("retry all: status %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	task, _ = GetTask(task.ID)
+	body, _ := json.Marshal(task)
+	var fields map[string]interface{}
+	json.Unmarshal(body, &fields)
+	for _, residue := range []string{"fail_reason", "local_path", "video_url", "warning", "warning_message",
+		"thumbnail", "duration_seconds", "width", "height", "file_size_bytes"} {
+		if value, ok := fields[residue]; ok {
+			t.Errorf("%s = %v after the reset", residue, value)
+		}
+	}
+	if task.Status != StatusPending || task.TaskID != "" || task.Progress != 0 {
+		t.Errorf("reset task: %s", body)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("partial download kept: %v", err)
+	}
+	if _, err := os.Stat(thumbnail); !os.IsNotExist(err) {
+		t.Errorf("thumbnail kept: %v", err)
+	}
+	if response, _ := GetTaskAPIResponse(task.ID); response != "" {
+		t.Errorf("last API response kept: %s", response)
+	}
+}
+
+// TestBulkDeleteTasks checks per-ID results, file removal and the request validation
+func TestBulkDeleteTasks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "bulk.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	var ids []int64
+	for i, localPath := range []string{"kept.mp4", "present.mp4", "missing.mp4"} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: fmt.Sprintf("task %d", i), Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, local_path = ? WHERE id = ?", StatusCompleted, localPath, created.ID)
+		ids = append(ids, created.ID)
+	}
+	os.WriteFile(filepath.Join(OutputDirectory(), "kept.mp4"), []byte("kept"), 0644)
+	os.WriteFile(filepath.Join(OutputDirectory(), "present.mp4"), []byte("video"), 0644)
+
+	bulkDelete := func(body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handleBulkDeleteTasks(rec, httptest.NewRequest(http.MethodPost, "/api/tasks-bulk-delete", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := bulkDelete(fmt.Sprintf(`{"ids":[%d,%d,9999]}`, ids[1], ids[2]))
+	if code != http.StatusOK || resp["deleted"] != float64(2) {
+		t.Fatalf("bulk delete: status %d, %v", code, resp)
+	}
+	results := resp["results"].([]interface{})
+	if missing := results[2].(map[string]interface{}); missing["success"] != false || missing["error"] != "task not found" {
+		t.Errorf("unknown ID result = %v", missing)
+	}
+	if task, _ := GetTask(ids[1]); task != nil {
+		t.Error("task still in the database")
+	}
+	if _, err := os.Stat(filepath.Join(OutputDirectory(), "present.mp4")); !os.IsNotExist(err) {
+		t.Error("video file not removed")
+	}
+	if task, _ := GetTask(ids[0]); task == nil {
+		t.Error("unselected task deleted")
+	}
+
+	tooMany := make([]string, MaxBulkTaskIDs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint(i + 1)
+	}
+	for _, body := range []string{`{"ids":["abc"]}`, `{"ids":[1.5]}`, `{"ids":[]}`, `{"ids":[` + strings.Join(tooMany, ",") + `]}`} {
+		if code, _ := bulkDelete(body); code != http.StatusBadRequest {
+			t.Errorf("%.40s: status %d, want 400", body, code)
+		}
+	}
+}
+
+// TestTaskPriorityOrdersQueue checks pending tasks are queued by priority then age, and that only
+// pending tasks can be re-prioritized
+func TestTaskPriorityOrdersQueue(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "priority.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	ids := make(map[string]int64)
+	for _, task := range []struct {
+		prompt   string
+		priority int
+	}{{"old", 0}, {"urgent", 5}, {"new", 0}, {"running", 0}} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: task.prompt, Priority: task.priority, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		ids[task.prompt] = created.ID
+	}
+	DB.Exec("UPDATE tasks SET status = ?, task_id = 'video_1' WHERE id = ?", StatusProcessing, ids["running"])
+
+	queue := func() string {
+		tasks, err := GetPendingTasks()
+		if err != nil {
+			t.Fatalf("GetPendingTasks failed: %v", err)
+		}
+		var prompts []string
+		for _, task := range tasks {
+			prompts = append(prompts, task.Prompt)
+		}
+		return strings.Join(prompts, ",")
+	}
+	if got := queue(); got != "urgent,old,new,running" {
+		t.Errorf("queue = %s", got)
+	}
+
+	setPriority := func(id int64, body string) (int, Task) {
+		rec := httptest.NewRecorder()
+		handleTaskPriority(rec, httptest.NewRequest(http.MethodPost, "/api/tasks/priority", strings.NewReader(body)), id)
+		var task Task
+		json.Unmarshal(rec.Body.Bytes(), &task)
+		return rec.Code, task
+	}
+	if code, task := setPriority(ids["new"], ""); code != http.StatusOK || task.Priority != 6 {
+		t.Errorf("bump: status %d, priority %d", code, task.Priority)
+	}
+	if got := queue(); got != "new,urgent,old,running" {
+		t.Errorf("queue after bump = %s", got)
+	}
+	if code, task := setPriority(ids["old"], `{"priority":-3}`); code != http.StatusOK || task.Priority != -3 {
+		t.Errorf("set: status %d, priority %d", code, task.Priority)
+	}
+	if code, _ := setPriority(ids["old"], `{"priority":1000}`); code != http.StatusBadRequest {
+		t.Errorf("out of range priority: status %d, want 400", code)
+	}
+	if code, _ := setPriority(ids["running"], `{"priority":9}`); code != http.StatusConflict {
+		t.Errorf("processing task: status %d, want 409", code)
+	}
+	if task, _ := GetTask(ids["running"]); task.Priority != 0 {
+		t.Errorf("processing task priority changed to %d", task.Priority)
+	}
+}
+
+// TestCreateTaskCount checks counts up to max_task_count are honored and share a batch, and that
+// out-of-range counts are rejected instead of clamped
+func TestCreateTaskCount(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "count.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{MaxTaskCount: 5})
+
+	create := func(body string) (int, []CreateTaskResponse) {
+		rec := httptest.NewRecorder()
+		handleCreateTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body)))
+		var created []CreateTaskResponse
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		return rec.Code, created
+	}
+
+	code, created := create(`{"prompt":"three cats","count":3}`)
+	if code != http.StatusCreated || len(created) != 3 {
+		t.Fatalf("count 3: status %d, %d tasks", code, len(created))
+	}
+	batchID := created[0].BatchID
+	if batchID == "" || created[2].BatchID != batchID {
+		t.Errorf("tasks don't share a batch: %+v", created)
+	}
+	if _, resp := getTasks(t, "batch_id="+batchID); len(resp.Tasks) != 3 {
+		t.Errorf("batch_id filter returned %d tasks, want 3", len(resp.Tasks))
+	}
+
+	if code, created := create(`{"prompt":"one cat"}`); code != http.StatusCreated || len(created) != 1 || created[0].BatchID != "" {
+		t.Errorf("default count: status %d, %+v", code, created)
+	}
+	for _, count := range []int{-1, 6} {
+		if code, _ := create(fmt.Sprintf(`{"prompt":"cats","count":%d}`, count)); code != http.StatusBadRequest {
+			t.Errorf("count %d: status %d, want 400", count, code)
+		}
+	}
+}
+
+func TestBatchCreateTasks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "batch.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{})
+	if _, err := DB.Exec(`INSERT INTO characters (api_character_id, custom_name, source_type, source_value, timestamps, status)
+		VALUES ('char_1', 'mimi', 'task', 'video_1', '0,2', 'completed')`); err != nil {
+		t.Fatalf("insert character: %v", err)
+	}
+
+	batch := func(body string) (int, map[string]json.RawMessage) {
+		rec := httptest.NewRecorder()
+		handleBatchCreateTasks(rec, httptest.NewRequest(http.MethodPost, "/api/tasks/batch", strings.NewReader(body)))
+		var resp map[string]json.RawMessage
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := batch(`{"prompts":["@{char_1} dances", "  ", "@{char_9} sings"],"orientation":"portrait"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("status %d, want 201", code)
+	}
+	var results []BatchCreateResult
+	json.Unmarshal(resp["results"], &results)
+	if len(results) != 3 || results[0].ID == 0 || results[1].Error == "" || results[1].ID != 0 || results[2].ID == 0 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if len(results[2].Warnings) != 1 {
+		t.Errorf("unknown character not reported: %+v", results[2])
+	}
+
+	task, err := GetTask(results[0].ID)
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	var batchID string
+	json.Unmarshal(resp["batch_id"], &batchID)
+	if task.BatchID != batchID || task.Orientation != OrientationPortrait || task.Duration != Duration10s {
+		t.Errorf("task doesn't carry the batch options: %+v", task)
+	}
+	var links int
+	DB.QueryRow("SELECT COUNT(*) FROM task_characters WHERE task_id = ?", results[0].ID).Scan(&links)
+	if links != 1 {
+		t.Errorf("task has %d character links, want 1", links)
+	}
+
+	tooMany := `{"prompts":[` + strings.TrimSuffix(strings.Repeat(`"cat",`, MaxBatchPrompts+1), ",") + `]}`
+	for _, body := range []string{`{"prompts":[]}`, `{"prompts":[" "]}`, `{"prompts":["cat"],"duration":"20s"}`, tooMany} {
+		if code, _ := batch(body); code != http.StatusBadRequest {
+			t.Errorf("%.40s: status %d, want 400", body, code)
+		}
+	}
+}
+
+// TestServeTaskVideo serves a video by task ID with range requests and checks /api/videos only
+// accepts plain .mp4 names
+func TestServeTaskVideo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "video.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	os.MkdirAll(OutputDirectory(), 0755)
+	os.WriteFile(filepath.Join(OutputDirectory(), "clip.mp4"), []byte("0123456789"), 0644)
+
+	completed, _ := CreateTask(&CreateTaskRequest{Prompt: "done", Duration: Duration10s, Orientation: OrientationLandscape})
+	DB.Exec("UPDATE tasks SET status = ?, local_path = ? WHERE id = ?", StatusCompleted, "clip.mp4", completed.ID)
+	pending, _ := CreateTask(&CreateTaskRequest{Prompt: "waiting", Duration: Duration10s, Orientation: OrientationLandscape})
+
+	get := func(handler http.HandlerFunc, path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := get(handleTaskByID, fmt.Sprintf("/api/tasks/%d/video", completed.ID), map[string]string{"Range": "bytes=2-5"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "2345" {
+		t.Errorf("range request: status %d, body %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(handleTaskByID, fmt.Sprintf("/api/tasks/%d/video", pending.ID), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("pending task: status %d, want 404", rec.Code)
+	}
+
+	cases := map[string]int{
+		"/api/videos/clip.mp4":     http.StatusOK,
+		"/api/videos/sub/clip.mp4": http.StatusBadRequest,
+		"/api/videos/..\\clip.mp4": http.StatusBadRequest,
+		"/api/videos/videogen.db":  http.StatusBadRequest,
+		"/api/videos/missing.mp4":  http.StatusNotFound,
+	}
+	for path, want := range cases {
+		if rec := get(handleVideos, path, nil); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
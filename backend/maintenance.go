@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DefaultVacuumMaxProcessing is the number of processing tasks above which a vacuum is refused,
+// VACUUM blocks writes until it finishes so progress updates would stall behind it
+const DefaultVacuumMaxProcessing = 0
+
+// DatabaseStats describes the size of the database file and its tables
+type DatabaseStats struct {
+	Path          string           `json:"path"`
+	SizeBytes     int64            `json:"size_bytes"` // page_count * page_size
+	PageCount     int64            `json:"page_count"`
+	PageSize      int64            `json:"page_size"`
+	FreelistPages int64            `json:"freelist_pages"` // Unused pages that VACUUM would reclaim
+	FreeBytes     int64            `json:"free_bytes"`
+	WALBytes      int64            `json:"wal_bytes"`
+	Tables        map[string]int64 `json:"tables"` // Row counts by table
+}
+
+// VacuumResult is the result of a vacuum job
+type VacuumResult struct {
+	Before         DatabaseStats `json:"before"`
+	After          DatabaseStats `json:"after"`
+	ReclaimedBytes int64         `json:"reclaimed_bytes"` // Database and WAL bytes freed on disk
+}
+
+// databaseFilePath returns the path of the main database file, as opened
+func databaseFilePath() (string, error) {
+	rows, err := ReadDB.Query("PRAGMA database_list")
+	if err != nil {
+		return "", fmt.Errorf("failed to list databases: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return "", fmt.Errorf("failed to scan database: %w", err)
+		}
+		if name == "main" {
+			return file, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("main database not found")
+}
+
+// GetDatabaseStats reads the page counts of the database, the size of its WAL file and the
+// number of rows of each table; the internal sqlite and full-text search tables are left out
+func GetDatabaseStats() (*DatabaseStats, error) {
+	path, err := databaseFilePath()
+	if err != nil {
+		return nil, err
+	}
+	stats := &DatabaseStats{Path: path, Tables: make(map[string]int64)}
+	for pragma, dst := range map[string]*int64{
+		"page_count":     &stats.PageCount,
+		"page_size":      &stats.PageSize,
+		"freelist_count": &stats.FreelistPages,
+	} {
+		if err := ReadDB.QueryRow("PRAGMA " + pragma).Scan(dst); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pragma, err)
+		}
+	}
+	stats.SizeBytes = stats.PageCount * stats.PageSize
+	stats.FreeBytes = stats.FreelistPages * stats.PageSize
+	if info, err := os.Stat(path + "-wal"); err == nil {
+		stats.WALBytes = info.Size()
+	}
+
+	rows, err := ReadDB.Query(`SELECT name FROM sqlite_master WHERE type = 'table'
+		AND name NOT LIKE 'sqlite_%' AND name NOT LIKE ? ORDER BY name`, taskSearchTable+"%")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		var count int64
+		if err := ReadDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %q", table)).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", table, err)
+		}
+		stats.Tables[table] = count
+	}
+	return stats, nil
+}
+
+// VacuumDatabase rebuilds the database file without its free pages and truncates the WAL
+// Returns the stats before and after with the bytes reclaimed on disk
+func VacuumDatabase() (*VacuumResult, error) {
+	before, err := GetDatabaseStats()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := DB.Exec("VACUUM"); err != nil {
+		return nil, fmt.Errorf("failed to vacuum: %w", err)
+	}
+	if _, err := DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return nil, fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	after, err := GetDatabaseStats()
+	if err != nil {
+		return nil, err
+	}
+	return &VacuumResult{
+		Before:         *before,
+		After:          *after,
+		ReclaimedBytes: before.SizeBytes + before.WALBytes - after.SizeBytes - after.WALBytes,
+	}, nil
+}
+
+// handleDatabaseStats handles GET /api/maintenance/db
+func handleDatabaseStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats, err := GetDatabaseStats()
+	if err != nil {
+		log.Printf("Failed to get database stats: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get database stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleVacuum handles POST /api/maintenance/vacuum?max_processing=N
+// Runs VACUUM and a WAL checkpoint as a background job; refused with 409 while more than
+// max_processing tasks are processing (DefaultVacuumMaxProcessing by default)
+func handleVacuum(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	maxProcessing := DefaultVacuumMaxProcessing
+	if value := r.URL.Query().Get("max_processing"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "max_processing must be a non-negative integer")
+			return
+		}
+		maxProcessing = n
+	}
+
+	counts, err := countGrouped("SELECT status, COUNT(*) FROM tasks WHERE status = ? GROUP BY status", StatusProcessing)
+	if err != nil {
+		log.Printf("Failed to count processing tasks: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to count processing tasks")
+		return
+	}
+	if processing := counts[StatusProcessing]; processing > int64(maxProcessing) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("%d tasks are processing, vacuum blocks writes until it finishes (max_processing=%d)", processing, maxProcessing))
+		return
+	}
+
+	job := StartJob("vacuum", func(job *JobHandle) (interface{}, error) {
+		result, err := VacuumDatabase()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Database vacuum done: %d bytes reclaimed", result.ReclaimedBytes)
+		return result, nil
+	})
+
+	writeJSON(w, http.StatusAccepted, job)
+}
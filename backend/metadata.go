@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// probeTaskMetadata reads the duration, dimensions and size of the downloaded video of a task
+// into the task fields
+func probeTaskMetadata(task *Task) error {
+	path := ResolveVideoPath(task.LocalPath)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	info, err := VideoMetadata(path)
+	if err != nil {
+		return err
+	}
+	task.DurationSeconds = info.DurationSeconds
+	task.Width = info.Width
+	task.Height = info.Height
+	task.FileSizeBytes = stat.Size()
+	return nil
+}
+
+// MetadataBackfillResult is the result of a metadata backfill job
+type MetadataBackfillResult struct {
+	Probed int      `json:"probed"`
+	Failed int      `json:"failed"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// backfillMetadata probes the downloaded videos of completed tasks that have no metadata yet
+func backfillMetadata(job *JobHandle) (*MetadataBackfillResult, error) {
+	tasks, err := GetTasksWithoutMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MetadataBackfillResult{}
+	for i, task := range tasks {
+		job.SetProgress(i*100/len(tasks), fmt.Sprintf("%d/%d", i, len(tasks)))
+		if err := probeTaskMetadata(&task); err != nil {
+			log.Printf("Warning: failed to probe video of task %d: %v", task.ID, err)
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("task %d: %v", task.ID, err))
+			continue
+		}
+		if err := SetTaskMetadata(task.ID, &task); err != nil {
+			return nil, err
+		}
+		result.Probed++
+	}
+	log.Printf("Metadata backfill done: %d probed, %d failed", result.Probed, result.Failed)
+	return result, nil
+}
+
+// handleMetadataBackfill handles POST /api/maintenance/metadata
+// Probes the existing downloaded videos as a background job
+func handleMetadataBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	job := StartJob("metadata_backfill", func(job *JobHandle) (interface{}, error) {
+		return backfillMetadata(job)
+	})
+
+	writeJSON(w, http.StatusAccepted, job)
+}
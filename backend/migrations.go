@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// migration is a numbered schema change, applied once in a transaction and recorded in schema_migrations
+// Databases created before schema_migrations existed already have some of these changes, so every
+// migration checks the current schema instead of assuming it
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in the order it is applied
+// Append new migrations at the end, never renumber or edit one that has shipped
+var migrations = []migration{
+	{1, "create tasks table", execStatements(`
+	CREATE TABLE IF NOT EXISTS tasks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id TEXT,
+		prompt TEXT NOT NULL,
+		image_url TEXT,
+		duration TEXT NOT NULL,
+		orientation TEXT NOT NULL,
+		model TEXT DEFAULT 'sora-2',
+		status TEXT DEFAULT 'pending',
+		progress INTEGER DEFAULT 0,
+		video_url TEXT,
+		local_path TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)},
+	{2, "remove UNIQUE constraint from tasks.task_id", dropTaskIDUnique},
+	{3, "remove probe rows left by old migrations", execStatements("DELETE FROM tasks WHERE " + junkTaskRowsCondition)},
+	{4, "add tasks.model", addColumns("tasks", "model TEXT DEFAULT 'sora-2'")},
+	// Second image of Veo3 tasks
+	{5, "add tasks.image_url2", addColumns("tasks", "image_url2 TEXT")},
+	{6, "add tasks.fail_reason", addColumns("tasks", "fail_reason TEXT")},
+	// Opt-out flag of prompt decoration and the final prompt sent upstream
+	{7, "add prompt decoration columns", addColumns("tasks", "no_decorate INTEGER DEFAULT 0", "submitted_prompt TEXT")},
+	// Warning of completed tasks whose result needs attention
+	{8, "add task warning columns", addColumns("tasks", "warning TEXT", "warning_message TEXT")},
+	// Count of failed submission attempts
+	{9, "add tasks.retries", addColumns("tasks", "retries INTEGER DEFAULT 0")},
+	// Starred flag and queue priority
+	{10, "add task curation columns", addColumns("tasks", "starred INTEGER DEFAULT 0", "priority INTEGER DEFAULT 0")},
+	// Bitmask of the webhook progress milestones already sent
+	{11, "add tasks.milestones_fired", addColumns("tasks", "milestones_fired INTEGER DEFAULT 0")},
+	// Fingerprint of the API key a task was submitted with, used to poll it with the same key
+	{12, "add tasks.api_key_fingerprint", addColumns("tasks", "api_key_fingerprint TEXT DEFAULT ''")},
+	// Task a duplicate was created from
+	{13, "add tasks.parent_task_id", addColumns("tasks", "parent_task_id INTEGER")},
+	// Time before which a pending task is not submitted
+	{14, "add tasks.scheduled_at", addColumns("tasks", "scheduled_at DATETIME")},
+	// Batch shared by the tasks created by one request
+	{15, "add tasks.batch_id", addColumns("tasks", "batch_id TEXT")},
+	// Watermark option, existing tasks were submitted without one
+	{16, "add tasks.watermark", addColumns("tasks", "watermark INTEGER DEFAULT 0")},
+	// Upstream model that accepted the task, which differs from model after a fallback
+	{17, "add tasks.model_used", addColumns("tasks", "model_used TEXT DEFAULT ''")},
+	// Raw upstream response of the last failure, not part of taskColumns
+	{18, "add tasks.last_api_response", addColumns("tasks", "last_api_response TEXT DEFAULT ''")},
+	// Thumbnail file name under output/thumbs, generated after the download
+	{19, "add tasks.thumbnail", addColumns("tasks", "thumbnail TEXT DEFAULT ''")},
+	// Metadata of the downloaded video, probed after the download
+	{20, "add task video metadata columns", addColumns("tasks", "duration_seconds REAL DEFAULT 0",
+		"width INTEGER DEFAULT 0", "height INTEGER DEFAULT 0", "file_size_bytes INTEGER DEFAULT 0")},
+	// Percentage of the video downloaded while the task is downloading
+	{21, "add tasks.download_progress", addColumns("tasks", "download_progress INTEGER DEFAULT 0")},
+	// Prompt as written, prompt holds it with character references converted
+	{22, "add tasks.original_prompt", addColumns("tasks", "original_prompt TEXT DEFAULT ''")},
+	{23, "create characters table", execStatements(`
+	CREATE TABLE IF NOT EXISTS characters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		api_character_id TEXT,
+		username TEXT,
+		custom_name TEXT NOT NULL,
+		description TEXT,
+		source_type TEXT NOT NULL,
+		source_value TEXT NOT NULL,
+		timestamps TEXT NOT NULL,
+		status TEXT DEFAULT 'pending',
+		progress INTEGER DEFAULT 0,
+		fail_reason TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)},
+	{24, "migrate characters to the training API schema", migrateLegacyCharacters},
+	{25, "add character username and avatar", addColumns("characters", "username TEXT", "avatar_url TEXT")},
+	// Pinning and usage tracking for character ordering
+	{26, "add character ordering columns", addColumns("characters", "pinned INTEGER DEFAULT 0", "last_used_at DATETIME")},
+	// Key/value store for small pieces of application state
+	{27, "create app_settings table", execStatements(`
+	CREATE TABLE IF NOT EXISTS app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`)},
+	// Link table between tasks and the characters their prompts referenced
+	{28, "create task_characters table", execStatements(`
+	CREATE TABLE IF NOT EXISTS task_characters (
+		task_id INTEGER NOT NULL,
+		character_id INTEGER NOT NULL,
+		PRIMARY KEY (task_id, character_id)
+	)`)},
+	// Free-form tags attached to tasks
+	{29, "create task_tags table", execStatements(`
+	CREATE TABLE IF NOT EXISTS task_tags (
+		task_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (task_id, tag)
+	)`)},
+	// Audit log of bulk and administrative operations
+	{30, "create audit_log table", execStatements(`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT NOT NULL,
+		detail TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)},
+	// History of submissions, fallbacks, downloads and retries of each task
+	{31, "create task_events table", execStatements(`
+	CREATE TABLE IF NOT EXISTS task_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		detail TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+		"CREATE INDEX IF NOT EXISTS idx_task_events_task ON task_events(task_id)")},
+	// Reusable prompt skeletons with {{placeholder}} markers and default task options
+	{32, "create templates table", execStatements(`
+	CREATE TABLE IF NOT EXISTS templates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		body TEXT NOT NULL,
+		duration TEXT,
+		orientation TEXT,
+		model TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)},
+	{33, "create task indexes", execStatements(
+		// Sorting by creation date (ORDER BY created_at DESC)
+		"CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at DESC)",
+		// Filtering by status (WHERE status IN ...)
+		"CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status)",
+		// Common query pattern of status + created_at
+		"CREATE INDEX IF NOT EXISTS idx_tasks_status_created ON tasks(status, created_at DESC)",
+		// Listing recently finished tasks first
+		"CREATE INDEX IF NOT EXISTS idx_tasks_updated_at ON tasks(updated_at DESC)",
+		// Per-model statistics without reading the task rows
+		"CREATE INDEX IF NOT EXISTS idx_tasks_model ON tasks(model)",
+		// Fetching the tasks of a batch
+		"CREATE INDEX IF NOT EXISTS idx_tasks_batch ON tasks(batch_id)",
+	)},
+	// Sorting characters by creation date and name
+	{34, "create character indexes", execStatements(
+		"CREATE INDEX IF NOT EXISTS idx_characters_created_at ON characters(created_at DESC)",
+		"CREATE INDEX IF NOT EXISTS idx_characters_custom_name ON characters(custom_name)",
+	)},
+	// IDs of deleted tasks, for clients polling with updated_since
+	{35, "create task_tombstones table", execStatements(`
+	CREATE TABLE IF NOT EXISTS task_tombstones (
+		task_id INTEGER PRIMARY KEY,
+		deleted_at DATETIME NOT NULL
+	)`,
+		"CREATE INDEX IF NOT EXISTS idx_task_tombstones_deleted_at ON task_tombstones(deleted_at)")},
+	// Kind of the failure of failed tasks, auth failures are re-queued when the API key changes
+	{36, "add tasks.failure_code", addFailureCode},
+	{37, "add template prompt decoration", addColumns("templates", "prompt_prefix TEXT DEFAULT ''", "prompt_suffix TEXT DEFAULT ''")},
+	{38, "add task prompt decoration overrides", addColumns("tasks", "prompt_prefix TEXT DEFAULT ''", "prompt_suffix TEXT DEFAULT ''")},
+	{39, "key tasks.milestones_fired by percent", keyMilestonesByPercent},
+	// Full-text index of task prompts, searches use LIKE when SQLite lacks FTS5
+	{40, "create the prompt search index", createTaskSearch},
+}
+
+// SchemaVersion is the version of the last migration, the schema this build creates and understands
+// It is stored in PRAGMA user_version so older builds refuse to migrate a newer database
+var SchemaVersion = migrations[len(migrations)-1].version
+
+// MigrationError is returned by InitDB when a migration fails, the database keeps the previous schema
+type MigrationError struct {
+	Version int
+	Name    string
+	Err     error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("database migration %d (%s) failed: %v", e.Version, e.Name, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// runMigrations applies the migrations missing from schema_migrations, each in its own transaction
+func runMigrations() error {
+	_, err := DB.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := applyMigration(m); err != nil {
+			return &MigrationError{Version: m.version, Name: m.name, Err: err}
+		}
+		log.Printf("Applied database migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations
+func appliedMigrations() (map[int]bool, error) {
+	rows, err := DB.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// applyMigration runs a migration and records it in the same transaction
+func applyMigration(m migration) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// execStatements returns a migration running the statements in order
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns returns a migration adding the columns, given as "name definition", that the table lacks
+func addColumns(table string, columns ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, column := range columns {
+			name, _, _ := strings.Cut(column, " ")
+			exists, err := hasColumn(tx, table, name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+				return fmt.Errorf("failed to add %s.%s: %w", table, name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// hasColumn reports whether the table has the column
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	return count > 0, nil
+}
+
+// taskIDUniquePattern matches the UNIQUE constraint early builds declared on tasks.task_id
+var taskIDUniquePattern = regexp.MustCompile(`(?i)(\btask_id\s+TEXT)\s+UNIQUE\b`)
+
+// tableNamePattern matches the table name of a CREATE TABLE statement
+var tableNamePattern = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+("?tasks"?)`)
+
+// dropTaskIDUnique recreates tasks without the UNIQUE constraint on task_id, which rejected the
+// empty task_id of every pending task after the first
+// SQLite can't drop a constraint, the table is rebuilt from its own SQL minus the constraint
+func dropTaskIDUnique(tx *sql.Tx) error {
+	var createSQL string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'tasks'").Scan(&createSQL); err != nil {
+		return fmt.Errorf("failed to read tasks table SQL: %w", err)
+	}
+	if !taskIDUniquePattern.MatchString(createSQL) {
+		return nil
+	}
+
+	log.Println("Migrating tasks table to remove UNIQUE constraint on task_id...")
+	createSQL = taskIDUniquePattern.ReplaceAllString(createSQL, "$1")
+	createSQL = tableNamePattern.ReplaceAllString(createSQL, "CREATE TABLE tasks_new")
+	return execStatements(
+		createSQL,
+		// Same columns in the same order, so rows are copied as they are
+		"INSERT INTO tasks_new SELECT * FROM tasks",
+		"DROP TABLE tasks",
+		"ALTER TABLE tasks_new RENAME TO tasks",
+	)(tx)
+}
+
+// addFailureCode adds tasks.failure_code and classifies the tasks that already failed from their
+// fail_reason, the only trace of the error they kept
+func addFailureCode(tx *sql.Tx) error {
+	if err := addColumns("tasks", "failure_code TEXT DEFAULT ''")(tx); err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, fail_reason FROM tasks WHERE status = ? AND COALESCE(fail_reason, '') != ''", StatusFailed)
+	if err != nil {
+		return fmt.Errorf("failed to query failed tasks: %w", err)
+	}
+	codes := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var failReason string
+		if err := rows.Scan(&id, &failReason); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan task: %w", err)
+		}
+		if code := legacyFailureCode(failReason); code != "" {
+			codes[id] = code
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, code := range codes {
+		if _, err := tx.Exec("UPDATE tasks SET failure_code = ? WHERE id = ?", code, id); err != nil {
+			return fmt.Errorf("failed to classify task %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// keyMilestonesByPercent adds tasks.milestones_fired_high and rewrites the milestone masks, which
+// recorded positions in webhook_milestones, as percent values
+// Progress only grows, so a processing task has fired every milestone up to its progress; the
+// masks of the other tasks are no longer read
+func keyMilestonesByPercent(tx *sql.Tx) error {
+	if err := addColumns("tasks", "milestones_fired_high INTEGER DEFAULT 0")(tx); err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, COALESCE(progress, 0) FROM tasks WHERE status = ?", StatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to query processing tasks: %w", err)
+	}
+	progress := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var percent int
+		if err := rows.Scan(&id, &percent); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan task: %w", err)
+		}
+		progress[id] = percent
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE tasks SET milestones_fired = 0 WHERE status != ?", StatusProcessing); err != nil {
+		return fmt.Errorf("failed to clear milestones: %w", err)
+	}
+	for id, percent := range progress {
+		fired := milestonesUpTo(percent)
+		if _, err := tx.Exec("UPDATE tasks SET milestones_fired = ?, milestones_fired_high = ? WHERE id = ?", fired[0], fired[1], id); err != nil {
+			return fmt.Errorf("failed to rewrite milestones of task %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyCharacters migrates the characters table from the old schema to the training API one
+// Old schema: api_id, api_username, profile_picture_url, permalink, from_task_id, local_picture_path
+// New schema: api_character_id, source_type, source_value, status, progress, fail_reason
+func migrateLegacyCharacters(tx *sql.Tx) error {
+	legacy, err := hasColumn(tx, "characters", "api_id")
+	if err != nil || !legacy {
+		return err
+	}
+
+	log.Println("Migrating characters table to new schema...")
+	return execStatements(`
+		CREATE TABLE characters_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			api_character_id TEXT,
+			custom_name TEXT NOT NULL,
+			description TEXT,
+			source_type TEXT NOT NULL,
+			source_value TEXT NOT NULL,
+			timestamps TEXT NOT NULL,
+			status TEXT DEFAULT 'pending',
+			progress INTEGER DEFAULT 0,
+			fail_reason TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Existing characters were already trained: api_id becomes api_character_id and from_task_id
+		// the source_value of a task source
+		`INSERT INTO characters_new (id, api_character_id, custom_name, description, source_type, source_value, timestamps, status, progress, created_at)
+		SELECT id, api_id, custom_name, description, 'task', COALESCE(from_task_id, ''), timestamps, 'completed', 100, created_at
+		FROM characters`,
+		"DROP TABLE characters",
+		"ALTER TABLE characters_new RENAME TO characters",
+	)(tx)
+}
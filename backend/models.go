@@ -6,21 +6,49 @@ import (
 
 // Task represents a video generation task stored in the database
 type Task struct {
-	ID          int64     `json:"id"`
-	TaskID      string    `json:"task_id"`
-	Prompt      string    `json:"prompt"`
-	ImageURL    string    `json:"image_url,omitempty"`
-	ImageURL2   string    `json:"image_url2,omitempty"` // Second image for Veo3
-	Duration    string    `json:"duration"`
-	Orientation string    `json:"orientation"`
-	Model       string    `json:"model"`
-	Status      string    `json:"status"`
-	Progress    int       `json:"progress"`
-	VideoURL    string    `json:"video_url,omitempty"`
-	LocalPath   string    `json:"local_path,omitempty"`
-	FailReason  string    `json:"fail_reason,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                int64        `json:"id"`
+	TaskID            string       `json:"task_id"`
+	Prompt            string       `json:"prompt"`                    // Prompt sent upstream, character references converted
+	OriginalPrompt    string       `json:"original_prompt,omitempty"` // Prompt as written, empty for tasks created before it was kept
+	ImageURL          string       `json:"image_url,omitempty"`
+	ImageURL2         string       `json:"image_url2,omitempty"` // Second image for Veo3
+	Duration          string       `json:"duration"`
+	Orientation       string       `json:"orientation"`
+	Model             string       `json:"model"`
+	Status            string       `json:"status"`
+	Progress          int          `json:"progress"`
+	VideoURL          string       `json:"video_url,omitempty"`
+	LocalPath         string       `json:"local_path,omitempty"`
+	FailReason        string       `json:"fail_reason,omitempty"`
+	FailureCode       string       `json:"failure_code,omitempty"`     // Kind of the failure, e.g. auth or content_policy, see failureCode
+	NoDecorate        bool         `json:"no_decorate,omitempty"`      // Skip the global prompt prefix/suffix
+	PromptPrefix      string       `json:"prompt_prefix,omitempty"`    // Replaces the global prompt_prefix, from the template of the task
+	PromptSuffix      string       `json:"prompt_suffix,omitempty"`    // Replaces the global prompt_suffix, from the template of the task
+	SubmittedPrompt   string       `json:"submitted_prompt,omitempty"` // Final prompt sent upstream, including prefix/suffix
+	Warning           string       `json:"warning,omitempty"`          // Warning code, e.g. orientation_mismatch
+	WarningMessage    string       `json:"warning_message,omitempty"`  // Human readable details of the warning
+	Retries           int          `json:"retries"`                    // Failed submission attempts so far
+	Starred           bool         `json:"starred"`
+	Priority          int          `json:"priority"` // Higher priority pending tasks are submitted first
+	Tags              []string     `json:"tags,omitempty"`
+	ParentTaskID      int64        `json:"parent_task_id,omitempty"` // Task this one was duplicated from
+	ScheduledAt       *time.Time   `json:"scheduled_at,omitempty"`   // Pending tasks are not submitted before this time
+	QueuePosition     *int         `json:"queue_position,omitempty"` // Pending tasks submitted before this one, computed per request
+	ETASeconds        *int64       `json:"eta_seconds,omitempty"`    // Estimated wait until submission, computed per request
+	BatchID           string       `json:"batch_id,omitempty"`       // Shared by the tasks created by one request
+	Watermark         bool         `json:"watermark"`                // Ask the provider to watermark the video
+	ModelUsed         string       `json:"model_used,omitempty"`     // Upstream model that accepted the task, after any fallback
+	Thumbnail         string       `json:"thumbnail,omitempty"`      // File name of the thumbnail under output/thumbs
+	MilestonesFired   milestoneSet `json:"-"`                        // Webhook progress milestones already sent
+	APIKeyFingerprint string       `json:"-"`                        // Fingerprint of the API key the task was submitted with
+	DurationSeconds   float64      `json:"duration_seconds,omitempty"`
+	Width             int          `json:"width,omitempty"`
+	Height            int          `json:"height,omitempty"`
+	FileSizeBytes     int64        `json:"file_size_bytes,omitempty"`
+	DownloadProgress  int          `json:"download_progress,omitempty"`
+	FileExists        *bool        `json:"file_exists,omitempty"` // Whether local_path is on disk, computed per request
+	CreatedAt         time.Time    `json:"created_at"`
+	UpdatedAt         time.Time    `json:"updated_at"`
 }
 
 // CreateTaskRequest represents the request body for creating a new task
@@ -28,10 +56,118 @@ type CreateTaskRequest struct {
 	Prompt      string `json:"prompt"`
 	ImageURL    string `json:"image_url,omitempty"`
 	ImageURL2   string `json:"image_url2,omitempty"` // Second image for Veo3 (last frame)
+	ImageRef    string `json:"image_ref,omitempty"`  // ID of an image from POST /api/uploads, instead of image_url
 	Duration    string `json:"duration"`
 	Orientation string `json:"orientation"`
 	Model       string `json:"model"`
-	Count       int    `json:"count,omitempty"` // Number of videos to generate: 1, 2, or 4
+	Count       int    `json:"count,omitempty"`       // Number of videos to generate, 1 to max_task_count
+	NoDecorate  bool   `json:"no_decorate,omitempty"` // Skip the global prompt prefix/suffix
+	Priority    int    `json:"priority,omitempty"`    // Higher priority pending tasks are submitted first
+	Watermark   bool   `json:"watermark,omitempty"`   // Ask the provider to watermark the video
+
+	ScheduledAt  *time.Time `json:"scheduled_at,omitempty"` // Don't submit before this time, RFC 3339
+	ParentTaskID int64      `json:"-"`                      // Source task of a duplicate
+	BatchID      string     `json:"-"`                      // Set when count creates several tasks
+	Tags         []string   `json:"-"`                      // Set by the CSV import
+
+	// OriginalPrompt is Prompt as written, before character references were converted
+	OriginalPrompt string `json:"-"`
+
+	// TemplateID renders the prompt from a template with Variables, its defaults fill unset options
+	TemplateID int64             `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+
+	// PromptPrefix and PromptSuffix replace the global ones when set, copied from the template
+	PromptPrefix string `json:"-"`
+	PromptSuffix string `json:"-"`
+}
+
+// UpdateTaskRequest represents the partial body of PATCH /api/tasks/:id
+// Only non-nil fields are applied
+type UpdateTaskRequest struct {
+	Prompt      *string `json:"prompt,omitempty"`
+	ImageURL    *string `json:"image_url,omitempty"`
+	Duration    *string `json:"duration,omitempty"`
+	Orientation *string `json:"orientation,omitempty"`
+	Model       *string `json:"model,omitempty"`
+	Priority    *int    `json:"priority,omitempty"`
+	// ScheduledAt reschedules the task (RFC 3339), "" clears the schedule
+	ScheduledAt *string `json:"scheduled_at,omitempty"`
+}
+
+// RetryOverrides are options changed on a task when it is retried, e.g. the other orientation after
+// a content-size error; only non-nil fields are applied
+type RetryOverrides struct {
+	Duration    *string `json:"duration,omitempty"`
+	Orientation *string `json:"orientation,omitempty"`
+	Model       *string `json:"model,omitempty"`
+	Watermark   *bool   `json:"watermark,omitempty"`
+}
+
+// TaskStats holds task counts for GET /api/stats
+type TaskStats struct {
+	Total           int64            `json:"total"`
+	ByStatus        map[string]int64 `json:"by_status"`
+	ByModel         map[string]int64 `json:"by_model"`
+	CreatedToday    int64            `json:"created_today"`
+	CreatedThisWeek int64            `json:"created_this_week"` // Since Monday 00:00 local time
+}
+
+// TaskCounts represents the response of GET /api/tasks/counts
+type TaskCounts struct {
+	Total        int64            `json:"total"`
+	ByStatus     map[string]int64 `json:"by_status"`
+	CreatedToday int64            `json:"created_today"`
+}
+
+// BulkUpdateTasksRequest represents the request body for POST /api/tasks/bulk-update
+// At least one of the operations must be set
+type BulkUpdateTasksRequest struct {
+	IDs        []int64  `json:"ids"`
+	SetStarred *bool    `json:"set_starred,omitempty"`
+	AddTags    []string `json:"add_tags,omitempty"`
+	RemoveTags []string `json:"remove_tags,omitempty"`
+	Priority   *int     `json:"priority,omitempty"`
+}
+
+// BulkTaskIDsRequest represents the request body of bulk operations that only take task IDs
+type BulkTaskIDsRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// BulkSkippedTask is a task a bulk operation left untouched, with the reason
+type BulkSkippedTask struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// BulkUpdateResult is the outcome of a bulk update for a single task
+type BulkUpdateResult struct {
+	ID      int64  `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchCreateTasksRequest is the body of POST /api/tasks/batch, one task is created per prompt
+// with the shared options
+type BatchCreateTasksRequest struct {
+	Prompts     []string   `json:"prompts"`
+	Duration    string     `json:"duration"`
+	Orientation string     `json:"orientation"`
+	Model       string     `json:"model"`
+	NoDecorate  bool       `json:"no_decorate,omitempty"`
+	Priority    int        `json:"priority,omitempty"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+}
+
+// BatchCreateResult is the outcome of a prompt of a batch creation: the created task or why the
+// prompt was rejected
+type BatchCreateResult struct {
+	Index    int      `json:"index"`
+	Prompt   string   `json:"prompt"`
+	ID       int64    `json:"id,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"` // e.g. unknown @{id} character references
 }
 
 // CreateTaskResponse represents the response after creating a task
@@ -45,6 +181,10 @@ type CreateTaskResponse struct {
 	Status      string    `json:"status"`
 	Progress    int       `json:"progress"`
 	CreatedAt   time.Time `json:"created_at"`
+	Warnings    []string  `json:"warnings,omitempty"` // e.g. unknown @{id} character references
+
+	ParentTaskID int64  `json:"parent_task_id,omitempty"` // Source task of a duplicate
+	BatchID      string `json:"batch_id,omitempty"`
 }
 
 // TaskListResponse represents the response for listing all tasks
@@ -65,7 +205,11 @@ type ErrorResponse struct {
 
 // VectorEngineCreateResponse represents the response from VectorEngine API when creating a task
 type VectorEngineCreateResponse struct {
-	ID string `json:"id"`
+	ID             string `json:"id"`
+	KeyIndex       int    `json:"-"` // 1-based index of the API key that accepted the task
+	KeyFingerprint string `json:"-"`
+	Model          string `json:"-"` // Upstream model name the task was created with
+	FallbackFrom   string `json:"-"` // Model that was tried first when the fallback chain was walked
 }
 
 // VectorEngineError represents an error from VectorEngine API
@@ -89,6 +233,7 @@ type VectorEngineQueryResponse struct {
 	Data       *VectorEngineQueryData `json:"data,omitempty"`
 	TokenGroup string                 `json:"token_group,omitempty"`
 	FailReason string                 `json:"fail_reason,omitempty"`
+	RawBody    string                 `json:"-"` // Response body as received, kept when the task fails
 }
 
 // VectorEngineQueryData represents the nested data object in API response
@@ -107,10 +252,21 @@ type VectorEngineQueryDetail struct {
 
 // Task status constants
 const (
-	StatusPending    = "pending"
-	StatusProcessing = "processing"
-	StatusCompleted  = "completed"
-	StatusFailed     = "failed"
+	StatusPending     = "pending"
+	StatusSubmitting  = "submitting" // Claimed by the processor while the create request is in flight
+	StatusProcessing  = "processing"
+	StatusDownloading = "downloading" // Generation finished, the video is being downloaded
+	StatusCompleted   = "completed"
+	StatusFailed      = "failed"
+	StatusCancelled   = "cancelled"
+)
+
+// CancelledFailReason is recorded on pending tasks cancelled before submission
+const CancelledFailReason = "cancelled by user"
+
+// Task warning codes
+const (
+	WarningOrientationMismatch = "orientation_mismatch"
 )
 
 // Duration constants
@@ -135,25 +291,62 @@ const (
 // Model constants
 const (
 	ModelSora2 = "sora-2"
+	// ModelCompose marks the tasks whose video was concatenated locally from other tasks, they are
+	// never submitted upstream
+	ModelCompose = "compose"
 )
 
+// PromptTemplate is a reusable prompt with {{placeholder}} markers and the default options of its tasks
+type PromptTemplate struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Body         string    `json:"body"`
+	Duration     string    `json:"duration,omitempty"`
+	Orientation  string    `json:"orientation,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	PromptPrefix string    `json:"prompt_prefix,omitempty"` // Replaces the global prompt_prefix for tasks created from the template
+	PromptSuffix string    `json:"prompt_suffix,omitempty"` // Replaces the global prompt_suffix for tasks created from the template
+	Placeholders []string  `json:"placeholders"`            // Distinct placeholders of the body, in order of appearance
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// UpdateTemplateRequest represents the partial body of PATCH /api/templates/:id
+// Only non-nil fields are applied
+type UpdateTemplateRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Body        *string `json:"body,omitempty"`
+	Duration    *string `json:"duration,omitempty"`
+	Orientation *string `json:"orientation,omitempty"`
+	Model       *string `json:"model,omitempty"`
+	// PromptPrefix and PromptSuffix override the global decoration, "" falls back to it
+	PromptPrefix *string `json:"prompt_prefix,omitempty"`
+	PromptSuffix *string `json:"prompt_suffix,omitempty"`
+}
+
 // Character represents a character stored in the database
 type Character struct {
-	ID             int64     `json:"id"`
-	ApiCharacterID string    `json:"api_character_id,omitempty"` // char_xxx 格式的 ID
-	Username       string    `json:"username,omitempty"`         // 用于引用角色 @username
-	AvatarURL      string    `json:"avatar_url,omitempty"`       // 角色头像URL
-	CustomName     string    `json:"custom_name"`
-	Description    string    `json:"description,omitempty"`
-	SourceType     string    `json:"source_type"`  // "task" or "url"
-	SourceValue    string    `json:"source_value"` // task_id or video URL
-	Timestamps     string    `json:"timestamps"`
-	Status         string    `json:"status"` // pending, processing, completed, failed
-	Progress       int       `json:"progress"`
-	FailReason     string    `json:"fail_reason,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             int64      `json:"id"`
+	ApiCharacterID string     `json:"api_character_id,omitempty"` // char_xxx 格式的 ID
+	Username       string     `json:"username,omitempty"`         // 用于引用角色 @username
+	AvatarURL      string     `json:"avatar_url,omitempty"`       // 角色头像URL
+	CustomName     string     `json:"custom_name"`
+	Description    string     `json:"description,omitempty"`
+	SourceType     string     `json:"source_type"`  // "task", "url", "upload" or "import"
+	SourceValue    string     `json:"source_value"` // task_id, video URL, uploaded file name or the imported character ID
+	Timestamps     string     `json:"timestamps"`
+	Status         string     `json:"status"` // pending, processing, completed, failed
+	Progress       int        `json:"progress"`
+	FailReason     string     `json:"fail_reason,omitempty"`
+	Pinned         bool       `json:"pinned"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"` // 最近一次在提示词中被引用的时间
+	CreatedAt      time.Time  `json:"created_at"`
+	UsageCount     int        `json:"usage_count"` // 提示词引用该角色的待处理/处理中任务数，按请求计算
 }
 
+// SourceTypeImport marks characters imported from an existing provider character ID
+const SourceTypeImport = "import"
+
 // CreateCharacterRequest represents the request body for creating a character
 type CreateCharacterRequest struct {
 	CustomName  string `json:"custom_name"`
@@ -163,6 +356,13 @@ type CreateCharacterRequest struct {
 	Timestamps  string `json:"timestamps"`
 }
 
+// UpdateCharacterRequest represents the request body for PUT /api/characters/:id
+// Omitted fields are left unchanged
+type UpdateCharacterRequest struct {
+	CustomName  *string `json:"custom_name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
 // Sora2CharacterRequest represents the request body for Sora2 Character Training API
 type Sora2CharacterRequest struct {
 	Character  string `json:"character,omitempty"` // task_id when source_type is "task"
@@ -199,3 +399,9 @@ type DeleteCharacterResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 }
+
+// CharacterInUseResponse is returned when deleting a character still referenced by queued tasks
+type CharacterInUseResponse struct {
+	Error   string  `json:"error"`
+	TaskIDs []int64 `json:"task_ids"`
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errBoxNotFound is returned when an MP4 box can't be located
+var errBoxNotFound = errors.New("box not found")
+
+// findMP4Box scans the boxes in [start, end) of r and returns the payload offset and size of the first box of the given type
+func findMP4Box(r io.ReaderAt, start, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// Box extends to the end of the enclosing range
+			size = end - offset
+		case 1:
+			// 64-bit size follows the type
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return 0, 0, fmt.Errorf("invalid %s box size %d", string(header[4:8]), size)
+		}
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, size - headerSize, nil
+		}
+		offset += size
+	}
+	return 0, 0, errBoxNotFound
+}
+
+// MP4Duration reads the duration in seconds from the moov/mvhd box of an MP4 file
+// Used when ffprobe is not installed
+func MP4Duration(path string) (float64, error) {
+	info, err := MP4Info(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.DurationSeconds, nil
+}
+
+// MP4Info reads the duration from the moov/mvhd box of an MP4 file and the dimensions from the
+// tkhd box of its first video track
+// Used when ffprobe is not installed; the dimensions stay zero when no track declares any
+func MP4Info(path string) (*VideoInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	moovStart, moovSize, err := findMP4Box(file, 0, stat.Size(), "moov")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find moov box: %w", err)
+	}
+	mvhdStart, mvhdSize, err := findMP4Box(file, moovStart, moovStart+moovSize, "mvhd")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mvhd box: %w", err)
+	}
+
+	// version(1) flags(3), then creation/modification times, timescale and duration
+	// whose widths depend on the version
+	buf := make([]byte, 32)
+	if mvhdSize < 20 {
+		return nil, fmt.Errorf("mvhd box too small")
+	}
+	n := int64(len(buf))
+	if mvhdSize < n {
+		n = mvhdSize
+	}
+	if _, err := file.ReadAt(buf[:n], mvhdStart); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var timescale uint32
+	var duration uint64
+	if buf[0] == 1 {
+		if n < 32 {
+			return nil, fmt.Errorf("mvhd box too small")
+		}
+		timescale = binary.BigEndian.Uint32(buf[20:24])
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(buf[12:16])
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale == 0 {
+		return nil, fmt.Errorf("invalid mvhd timescale")
+	}
+
+	info := &VideoInfo{DurationSeconds: float64(duration) / float64(timescale)}
+	moovEnd := moovStart + moovSize
+	for offset := moovStart; ; {
+		trakStart, trakSize, err := findMP4Box(file, offset, moovEnd, "trak")
+		if err != nil {
+			break
+		}
+		offset = trakStart + trakSize
+		width, height, err := mp4TrackDimensions(file, trakStart, trakSize)
+		if err == nil && width > 0 && height > 0 {
+			info.Width, info.Height = width, height
+			break
+		}
+	}
+	return info, nil
+}
+
+// mp4TrackDimensions reads the presentation width and height from the tkhd box of a track
+// Audio tracks have zero dimensions
+func mp4TrackDimensions(r io.ReaderAt, trakStart, trakSize int64) (int, int, error) {
+	tkhdStart, tkhdSize, err := findMP4Box(r, trakStart, trakStart+trakSize, "tkhd")
+	if err != nil {
+		return 0, 0, err
+	}
+	// width and height are the last two 16.16 fixed-point fields, after 64-bit times in version 1
+	dimensions := int64(76)
+	version := make([]byte, 1)
+	if _, err := r.ReadAt(version, tkhdStart); err != nil {
+		return 0, 0, err
+	}
+	if version[0] == 1 {
+		dimensions = 88
+	}
+	if tkhdSize < dimensions+8 {
+		return 0, 0, fmt.Errorf("tkhd box too small")
+	}
+	buf := make([]byte, 8)
+	if _, err := r.ReadAt(buf, tkhdStart+dimensions); err != nil {
+		return 0, 0, err
+	}
+	return int(binary.BigEndian.Uint32(buf[:4]) >> 16), int(binary.BigEndian.Uint32(buf[4:]) >> 16), nil
+}
+
+// VideoDuration returns the duration of a video in seconds, using ffprobe when available
+// and falling back to reading the MP4 header
+func VideoDuration(path string) (float64, error) {
+	if FFmpegAvailable() {
+		info, err := ProbeVideo(path)
+		if err == nil && info.DurationSeconds > 0 {
+			return info.DurationSeconds, nil
+		}
+	}
+	return MP4Duration(path)
+}
+
+// VideoMetadata returns the duration and dimensions of a video, using ffprobe when available
+// and falling back to reading the MP4 header
+func VideoMetadata(path string) (*VideoInfo, error) {
+	if FFmpegAvailable() {
+		info, err := ProbeVideo(path)
+		if err == nil && info.DurationSeconds > 0 {
+			return info, nil
+		}
+	}
+	return MP4Info(path)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mp4Box builds a box with the given type and payload
+func mp4Box(boxType string, payload []byte) []byte {
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box[:4], uint32(8+len(payload)))
+	copy(box[4:8], boxType)
+	return append(box, payload...)
+}
+
+// mvhdV0 builds a version 0 mvhd payload
+func mvhdV0(timescale, duration uint32) []byte {
+	payload := make([]byte, 100)
+	binary.BigEndian.PutUint32(payload[12:16], timescale)
+	binary.BigEndian.PutUint32(payload[16:20], duration)
+	return payload
+}
+
+// tkhdV0 builds a version 0 tkhd payload with the given presentation size
+func tkhdV0(width, height uint16) []byte {
+	payload := make([]byte, 84)
+	binary.BigEndian.PutUint32(payload[76:80], uint32(width)<<16)
+	binary.BigEndian.PutUint32(payload[80:84], uint32(height)<<16)
+	return payload
+}
+
+// TestMP4Duration reads the duration with moov before and after the media data
+func TestMP4Duration(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	moov := mp4Box("moov", mp4Box("mvhd", mvhdV0(1000, 10500)))
+	mdat := mp4Box("mdat", make([]byte, 4096))
+
+	cases := map[string][]byte{
+		"faststart": append(append(append([]byte{}, ftyp...), moov...), mdat...),
+		"moov last": append(append(append([]byte{}, ftyp...), mdat...), moov...),
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			duration, err := MP4Duration(path)
+			if err != nil {
+				t.Fatalf("MP4Duration failed: %v", err)
+			}
+			if duration != 10.5 {
+				t.Errorf("duration = %v, want 10.5", duration)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "page.html")
+	os.WriteFile(path, []byte("<html>not a video</html>"), 0644)
+	if _, err := MP4Duration(path); err == nil {
+		t.Errorf("expected error for non-MP4 file")
+	}
+}
+
+// TestVideoMetadataBackfill probes an existing download, skipping the audio track for the dimensions,
+// and filters tasks on the probed values
+func TestVideoMetadataBackfill(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "metadata.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	audio := mp4Box("trak", mp4Box("tkhd", tkhdV0(0, 0)))
+	video := mp4Box("trak", mp4Box("tkhd", tkhdV0(1280, 720)))
+	moov := mp4Box("moov", append(append(mp4Box("mvhd", mvhdV0(600, 7200)), audio...), video...))
+	data := append(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2")), moov...)
+	os.MkdirAll(OutputDirectory(), 0755)
+	os.WriteFile(filepath.Join(OutputDirectory(), "clip.mp4"), data, 0644)
+
+	var ids []int64
+	for _, localPath := range []string{"clip.mp4", "missing.mp4"} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: localPath, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		task.Status = StatusCompleted
+		task.LocalPath = localPath
+		UpdateTask(task)
+		ids = append(ids, task.ID)
+	}
+
+	result, err := backfillMetadata(&JobHandle{})
+	if err != nil {
+		t.Fatalf("backfillMetadata failed: %v", err)
+	}
+	if result.Probed != 1 || result.Failed != 1 {
+		t.Errorf("backfill result %+v, want 1 probed and 1 failed", result)
+	}
+	task, _ := GetTask(ids[0])
+	if task.DurationSeconds != 12 || task.Width != 1280 || task.Height != 720 || task.FileSizeBytes != int64(len(data)) {
+		t.Errorf("metadata = %vs %dx%d %d bytes, want 12s 1280x720 %d bytes",
+			task.DurationSeconds, task.Width, task.Height, task.FileSizeBytes, len(data))
+	}
+
+	for query, want := range map[string]int{
+		"min_duration=10":                1,
+		"min_duration=10&min_width=1920": 0,
+		"max_duration=10":                0,
+		"min_height=720":                 1,
+	} {
+		values, _ := url.ParseQuery(query)
+		filter, err := parseTaskFilter(values)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		tasks, err := GetTasks(filter)
+		if err != nil {
+			t.Fatalf("GetTasks failed: %v", err)
+		}
+		if len(tasks) != want {
+			t.Errorf("%s: %d tasks, want %d", query, len(tasks), want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultProbeThumbnails is the number of thumbnails in a probe strip when not specified
+	DefaultProbeThumbnails = 8
+	// MaxProbeThumbnails caps the thumbnails requested per probe
+	MaxProbeThumbnails = 20
+)
+
+// probeThumbnailDirectory holds the thumbnail strips, one sub-directory per task
+var probeThumbnailDirectory = filepath.Join(os.TempDir(), "videogen-probe")
+
+// ProbeThumbnail is a frame of the probe strip
+type ProbeThumbnail struct {
+	Time float64 `json:"time"`
+	URL  string  `json:"url"`
+}
+
+// ProbeResponse represents the response of GET /api/tasks/:id/probe
+type ProbeResponse struct {
+	TaskID          int64            `json:"task_id"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Width           int              `json:"width,omitempty"`
+	Height          int              `json:"height,omitempty"`
+	Thumbnails      []ProbeThumbnail `json:"thumbnails"`
+}
+
+// handleProbeTask handles GET /api/tasks/:id/probe and GET /api/tasks/:id/probe/thumbnails/:n.jpg
+// Returns the video duration and, when ffmpeg is available, a strip of thumbnails at even intervals
+// for picking the character training window (?thumbnails=N, default 8)
+func handleProbeTask(w http.ResponseWriter, r *http.Request, id int64, rest []string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if len(rest) == 2 && rest[0] == "thumbnails" {
+		filePath := filepath.Join(probeThumbnailDirectory, strconv.FormatInt(id, 10), filepath.Base(rest[1]))
+		if _, err := os.Stat(filePath); err != nil {
+			writeError(w, http.StatusNotFound, "Thumbnail not found")
+			return
+		}
+		http.ServeFile(w, r, filePath)
+		return
+	}
+	if len(rest) != 0 {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	count := DefaultProbeThumbnails
+	if countStr := r.URL.Query().Get("thumbnails"); countStr != "" {
+		n, err := strconv.Atoi(countStr)
+		if err != nil || n < 0 || n > MaxProbeThumbnails {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("thumbnails must be between 0 and %d", MaxProbeThumbnails))
+			return
+		}
+		count = n
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for probe: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.LocalPath == "" {
+		writeError(w, http.StatusConflict, "Task has no downloaded video")
+		return
+	}
+
+	srcPath := ResolveVideoPath(task.LocalPath)
+	if _, err := os.Stat(srcPath); err != nil {
+		writeError(w, http.StatusNotFound, "Video file not found")
+		return
+	}
+
+	resp := ProbeResponse{TaskID: id, Thumbnails: []ProbeThumbnail{}}
+	if FFmpegAvailable() {
+		if info, err := ProbeVideo(srcPath); err == nil {
+			resp.DurationSeconds = info.DurationSeconds
+			resp.Width = info.Width
+			resp.Height = info.Height
+		}
+	}
+	if resp.DurationSeconds == 0 {
+		duration, err := MP4Duration(srcPath)
+		if err != nil {
+			log.Printf("Failed to read duration of task %d: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "Failed to read video duration")
+			return
+		}
+		resp.DurationSeconds = duration
+	}
+
+	if count > 0 && FFmpegAvailable() {
+		thumbnails, err := probeThumbnails(id, srcPath, resp.DurationSeconds, count)
+		if err != nil {
+			log.Printf("Failed to extract thumbnails for task %d: %v", id, err)
+		} else {
+			resp.Thumbnails = thumbnails
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// probeThumbnails extracts count frames at the middle of count even intervals
+// Frames already extracted for the same count are reused
+func probeThumbnails(id int64, srcPath string, duration float64, count int) ([]ProbeThumbnail, error) {
+	dir := filepath.Join(probeThumbnailDirectory, strconv.FormatInt(id, 10))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	thumbnails := make([]ProbeThumbnail, 0, count)
+	step := duration / float64(count)
+	for i := 0; i < count; i++ {
+		at := step*float64(i) + step/2
+		name := fmt.Sprintf("%d_%d.jpg", count, i)
+		dst := filepath.Join(dir, name)
+		if _, err := os.Stat(dst); err != nil {
+			if err := ExtractFrame(srcPath, dst, at); err != nil {
+				return nil, err
+			}
+		}
+		thumbnails = append(thumbnails, ProbeThumbnail{
+			Time: at,
+			URL:  fmt.Sprintf("/api/tasks/%d/probe/thumbnails/%s", id, name),
+		})
+	}
+	return thumbnails, nil
+}
+
+// ValidateTimestampsWithin checks that the end of a "start,end" window doesn't exceed the video duration
+func ValidateTimestampsWithin(timestamps string, duration float64) error {
+	parts := strings.Split(timestamps, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("timestamps must be in format 'start,end', got '%s'", timestamps)
+	}
+	end, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return fmt.Errorf("invalid end timestamp: %s", parts[1])
+	}
+	if end > duration {
+		return fmt.Errorf("timestamp range ends at %vs but the video is only %.2fs long", end, duration)
+	}
+	return nil
+}
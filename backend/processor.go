@@ -1,30 +1,100 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	// PollInterval is the interval between polling for task status updates
+	// PollInterval is the default interval between polling for task status updates
 	PollInterval = 3 * time.Second
+	// DownloadRetryDelay is the wait between attempts to download a completed video
+	DownloadRetryDelay = 5 * time.Second
+	// DefaultDownloadWorkers is the number of concurrent video downloads when download_workers is not set
+	DefaultDownloadWorkers = 2
+	// MaxDownloadWorkers bounds download_workers
+	MaxDownloadWorkers = 16
+	// MaxQueuedDownloads is the capacity of the download queue
+	MaxQueuedDownloads = 100
 )
 
+// downloadWorkers returns the configured number of concurrent video downloads
+func downloadWorkers(config *Config) int {
+	if config.DownloadWorkers > 0 {
+		return config.DownloadWorkers
+	}
+	return DefaultDownloadWorkers
+}
+
+// pollInterval returns the configured polling interval
+func pollInterval(config *Config) time.Duration {
+	if config.PollInterval > 0 {
+		return time.Duration(config.PollInterval) * time.Second
+	}
+	return PollInterval
+}
+
 // TaskProcessor handles background processing of video generation tasks
 type TaskProcessor struct {
-	client   *VectorEngineClient
-	stopChan chan struct{}
-	wg       sync.WaitGroup
-	running  bool
-	mu       sync.Mutex
+	client    *VectorEngineClient // Dyu API client, used for characters and by providers without their own settings
+	providers *ProviderRegistry   // Rebuilt by ApplyConfig, read through provider
+	config    *Config             // Replaced as a whole by ApplyConfig, read through currentConfig
+	stopChan  chan struct{}
+	ctx       context.Context // Passed to every client call, cancelled by Stop to abort in-flight requests
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	running   bool
+	paused    bool // When paused, pending tasks are not submitted but processing tasks are still polled
+	// pauseReason is set when submissions were paused by an API key failure, which a key change lifts
+	pauseReason string
+	// rateLimitedAt and rateLimitedUntil hold submissions after a 429: for the rest of the cycle, and
+	// until the Retry-After window passes
+	rateLimitedAt    time.Time
+	rateLimitedUntil time.Time
+	rateLimitedCount int64 // Submissions answered with 429 since startup
+	diskLow          bool  // Downloads are held back because free space is below min_free_space_mb
+	mu               sync.Mutex
+
+	// downloadQueue feeds the download workers; downloading holds the tasks queued or being
+	// downloaded so none is enqueued twice, downloadsPending counts them
+	downloadQueue    chan *Task
+	downloading      map[int64]bool
+	downloadsPending sync.WaitGroup
+
+	downloadRetryDelay time.Duration    // DownloadRetryDelay, shortened by tests
+	now                func() time.Time // Clock of rate limits, schedules and the watchdog, set by tests
+
+	// stalls holds the last progress of each processing task and since when it hasn't changed,
+	// only used by processLoop
+	stalls map[int64]progressMark
 }
 
-// NewTaskProcessor creates a new task processor with the given API key
-func NewTaskProcessor(dyuAPIKey string) *TaskProcessor {
+// NewTaskProcessor creates a new task processor using the given configuration
+func NewTaskProcessor(config *Config) *TaskProcessor {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewConfiguredClient(config)
 	return &TaskProcessor{
-		client:   NewVectorEngineClient(dyuAPIKey),
-		stopChan: make(chan struct{}),
+		client:    client,
+		providers: newProviderRegistry(config, client),
+		config:    config,
+		stopChan:  make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+
+		downloadQueue: make(chan *Task, MaxQueuedDownloads),
+		downloading:   make(map[int64]bool),
+
+		downloadRetryDelay: DownloadRetryDelay,
+		now:                time.Now,
+
+		stalls: make(map[int64]progressMark),
 	}
 }
 
@@ -38,8 +108,29 @@ func (p *TaskProcessor) Start() {
 	p.running = true
 	p.mu.Unlock()
 
-	p.wg.Add(1)
+	// Submissions interrupted by a previous shutdown are retried
+	if count, err := ResetInterruptedSubmissions(); err != nil {
+		log.Printf("Failed to reset interrupted submissions: %v", err)
+	} else if count > 0 {
+		log.Printf("Reset %d interrupted submissions to pending", count)
+	}
+	// Downloads interrupted by a previous shutdown are picked up by the next poll
+	if count, err := ResetInterruptedDownloads(); err != nil {
+		log.Printf("Failed to reset interrupted downloads: %v", err)
+	} else if count > 0 {
+		log.Printf("Reset %d interrupted downloads to processing", count)
+	}
+	if count, err := FailInterruptedComposes(); err != nil {
+		log.Printf("Failed to fail interrupted compose tasks: %v", err)
+	} else if count > 0 {
+		log.Printf("Failed %d compose tasks interrupted by the restart", count)
+	}
+
+	p.startDownloadWorkers()
+	p.wg.Add(3)
 	go p.processLoop()
+	go p.reconcileLoop()
+	go p.retentionLoop()
 	log.Println("Task processor started")
 }
 
@@ -54,15 +145,148 @@ func (p *TaskProcessor) Stop() {
 	p.mu.Unlock()
 
 	close(p.stopChan)
+	p.cancel()
 	p.wg.Wait()
 	log.Println("Task processor stopped")
 }
 
+// currentConfig returns the configuration in effect
+func (p *TaskProcessor) currentConfig() *Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+// ApplyConfig switches the running processor to a new configuration
+// The API keys are swapped into the client, the providers are rebuilt and the poll interval takes
+// effect on the next tick
+func (p *TaskProcessor) ApplyConfig(config *Config) {
+	keysChanged := !slices.Equal(p.currentConfig().apiKeys(), config.apiKeys())
+	p.client.SetAPIKeys(config.apiKeys(), apiKeyCooldown(config))
+	p.client.SetRequestTimeout(requestTimeout(config))
+	p.client.SetBaseURL(dyuBaseURL(config))
+	p.client.SetModelFallbacks(config.ModelFallbacks)
+	p.client.SetMaxImageDimension(maxImageDimension(config))
+	p.client.SetDownloadOptions(downloadOptions(config))
+	providers := newProviderRegistry(config, p.client)
+	p.mu.Lock()
+	p.config = config
+	p.providers = providers
+	if keysChanged && p.pauseReason != "" {
+		p.paused = false
+		p.pauseReason = ""
+		log.Println("API keys changed, task processor resumed")
+	}
+	p.mu.Unlock()
+}
+
+// provider returns the provider serving a task model
+func (p *TaskProcessor) provider(model string) (*registeredProvider, error) {
+	p.mu.Lock()
+	providers := p.providers
+	p.mu.Unlock()
+	return providers.Resolve(model)
+}
+
+// Pause stops new submissions; tasks already processing keep being polled and downloaded
+func (p *TaskProcessor) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.paused = true
+		log.Println("Task processor paused")
+	}
+}
+
+// pauseForKeyFailure pauses submissions after an authentication or quota error
+// Changing the API keys or resuming manually lifts the pause
+func (p *TaskProcessor) pauseForKeyFailure(reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.paused = true
+		p.pauseReason = reason
+		log.Printf("Task processor paused: %s", reason)
+	}
+}
+
+// holdSubmissions holds the remaining submissions of the cycle after a 429, and until wait has
+// passed when the response asked for it
+func (p *TaskProcessor) holdSubmissions(wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	p.rateLimitedAt = now
+	if until := now.Add(wait); until.After(p.rateLimitedUntil) {
+		p.rateLimitedUntil = until
+	}
+	p.rateLimitedCount++
+	log.Printf("[RateLimit] 提交被上游限流 (累计 %d 次)，等待 %s", p.rateLimitedCount, wait)
+}
+
+// submissionsHeld reports whether submissions are held by a rate limit at the cycle started at
+// cycleStart, and until when
+func (p *TaskProcessor) submissionsHeld(cycleStart time.Time) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if now.Before(p.rateLimitedUntil) {
+		return p.rateLimitedUntil, true
+	}
+	// Without a Retry-After window the rest of the cycle is skipped
+	return now, !p.rateLimitedAt.Before(cycleStart)
+}
+
+// RateLimitStats returns the number of rate limited submissions and when held submissions resume,
+// nil when they aren't held
+func (p *TaskProcessor) RateLimitStats() (int64, *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.now().Before(p.rateLimitedUntil) {
+		until := p.rateLimitedUntil
+		return p.rateLimitedCount, &until
+	}
+	return p.rateLimitedCount, nil
+}
+
+// Resume re-enables submissions of pending tasks
+func (p *TaskProcessor) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		p.paused = false
+		p.pauseReason = ""
+		log.Println("Task processor resumed")
+	}
+}
+
+// IsPaused reports whether submissions are paused
+func (p *TaskProcessor) IsPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// PauseReason returns why submissions were paused automatically, "" when paused by the user
+func (p *TaskProcessor) PauseReason() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pauseReason
+}
+
+// IsRunning reports whether the processing loop is running
+func (p *TaskProcessor) IsRunning() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
 // processLoop is the main processing loop that polls for pending tasks
 func (p *TaskProcessor) processLoop() {
 	defer p.wg.Done()
 
-	ticker := time.NewTicker(PollInterval)
+	interval := pollInterval(p.currentConfig())
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	// Process immediately on start
@@ -74,24 +298,104 @@ func (p *TaskProcessor) processLoop() {
 			return
 		case <-ticker.C:
 			p.processPendingTasks()
+			if next := pollInterval(p.currentConfig()); next != interval {
+				interval = next
+				ticker.Reset(interval)
+				log.Printf("Poll interval changed to %v", interval)
+			}
 		}
 	}
 }
 
 // processPendingTasks processes all pending and processing tasks
+// This is one tick of processLoop; tests call it directly to drive the processor step by step
 func (p *TaskProcessor) processPendingTasks() {
+	p.pollCharacters()
+
 	tasks, err := GetPendingTasks()
 	if err != nil {
 		log.Printf("Error getting pending tasks: %v", err)
 		return
 	}
 
+	// Count in-flight tasks so submissions stay under max_concurrent_tasks
+	limit := p.currentConfig().MaxConcurrentTasks
+	inFlight := 0
+	for _, task := range tasks {
+		if task.Status == StatusProcessing {
+			inFlight++
+		}
+	}
+
+	p.pruneStalls(tasks)
+
+	now := p.now()
+	limitLogged := false
+	heldLogged := false
 	for _, task := range tasks {
 		select {
 		case <-p.stopChan:
 			return
 		default:
+			if task.Status == StatusPending {
+				// While paused, pending tasks stay pending; a key failure may pause within this cycle
+				if p.IsPaused() {
+					continue
+				}
+				// Scheduled tasks wait for their time
+				if task.ScheduledAt != nil && task.ScheduledAt.After(now) {
+					continue
+				}
+				// After a 429 the remaining submissions wait for the rate limit window
+				if until, held := p.submissionsHeld(now); held {
+					if !heldLogged {
+						log.Printf("[RateLimit] 提交被限流，剩余待处理任务将在 %s 之后提交", until.Format("15:04:05"))
+						heldLogged = true
+					}
+					continue
+				}
+				// Tasks are ordered by priority then created_at, so urgent and then the oldest pending
+				// tasks are submitted first
+				if limit > 0 && inFlight >= limit {
+					if !limitLogged {
+						log.Printf("已达到最大并发任务数 %d，剩余待处理任务将在之后提交", limit)
+						limitLogged = true
+					}
+					continue
+				}
+			}
+			before := task.Status
 			p.processTask(&task)
+			// Keep the count current as tasks are submitted or finish within this cycle
+			if before == StatusPending && task.Status == StatusProcessing {
+				inFlight++
+			} else if before == StatusProcessing && task.Status != StatusProcessing {
+				inFlight--
+			}
+		}
+	}
+}
+
+// pollCharacters refreshes the training status of the unfinished characters
+// Runs every cycle so characters complete, and get their avatar, without a client asking for them
+func (p *TaskProcessor) pollCharacters() {
+	characters, _, err := GetCharactersFiltered(CharacterFilter{Statuses: []string{StatusPending, StatusProcessing}}, 0, 0)
+	if err != nil {
+		log.Printf("Error getting unfinished characters: %v", err)
+		return
+	}
+	for i := range characters {
+		select {
+		case <-p.stopChan:
+			return
+		default:
+		}
+		char := &characters[i]
+		if char.ApiCharacterID == "" {
+			continue
+		}
+		if _, _, err := refreshCharacterStatus(p.ctx, p.client, char); err != nil {
+			log.Printf("[Character] 查询状态失败: %s - %v", char.CustomName, err)
 		}
 	}
 }
@@ -103,36 +407,145 @@ func (p *TaskProcessor) processTask(task *Task) {
 		p.submitTask(task)
 	case StatusProcessing:
 		p.pollTaskStatus(task)
+		p.checkStalled(task)
 	}
 }
 
 // submitTask submits a pending task to the API
 func (p *TaskProcessor) submitTask(task *Task) {
+	// Claim the task so it can't be submitted twice; fails when it was cancelled or claimed elsewhere
+	claimed, err := ClaimTask(task.ID, StatusPending, StatusSubmitting)
+	if err != nil {
+		log.Printf("认领任务 %d 失败: %v", task.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	task.Status = StatusSubmitting
+
+	// The images aren't loaded with the queue, they're only needed here
+	task.ImageURL, task.ImageURL2, err = GetTaskImages(task.ID)
+	if err != nil {
+		log.Printf("读取任务 %d 图片失败: %v", task.ID, err)
+		task.Status = StatusPending
+		p.saveTransition(task, StatusSubmitting, fieldsStatus)
+		return
+	}
+
 	log.Printf("提交视频任务 %d", task.ID)
 
+	config := p.currentConfig()
 	model := task.Model
 	if model == "" {
 		model = ModelSora2
 	}
 
-	resp, err := p.client.CreateVideoTask(task.Prompt, task.ImageURL, task.ImageURL2, task.Duration, task.Orientation, model)
+	// Apply the prefix/suffix to the submitted prompt only, task.Prompt stays as entered
+	prompt := task.Prompt
+	var prefix, suffix string
+	if !task.NoDecorate {
+		prefix, suffix = promptDecoration(config, task.PromptPrefix, task.PromptSuffix)
+		prompt = DecoratePrompt(prompt, prefix, suffix)
+		if prompt != task.Prompt {
+			log.Printf("任务 %d 应用提示词前缀/后缀: prefix=%q suffix=%q", task.ID, prefix, suffix)
+		}
+	}
+	task.SubmittedPrompt = prompt
+
+	provider, err := p.provider(model)
+	if err == nil && !provider.Enabled {
+		err = fmt.Errorf("provider %s is disabled", provider.Name)
+	}
 	if err != nil {
-		log.Printf("任务 %d 提交失败: %v", task.ID, err)
+		// Retrying can't help until the configuration changes
+		log.Printf("任务 %d 无法提交: %v", task.ID, err)
 		task.Status = StatusFailed
 		task.FailReason = err.Error()
-		if err := UpdateTask(task); err != nil {
-			log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
+		task.FailureCode = failureCode(ClassifyError(err))
+		p.saveTransition(task, StatusSubmitting, fieldsSubmission)
+		RecordTaskEvent(task.ID, HistorySubmitFailed, err.Error())
+		return
+	}
+
+	resp, err := provider.Provider.CreateTask(p.ctx, &VideoProviderRequest{
+		Prompt:      prompt,
+		ImageURL:    task.ImageURL,
+		ImageURL2:   task.ImageURL2,
+		Duration:    task.Duration,
+		Orientation: task.Orientation,
+		Model:       model,
+		Watermark:   task.Watermark,
+	})
+	if err != nil && p.ctx.Err() != nil {
+		// Interrupted by Stop, the task stays submitting and is reset to pending on the next start
+		log.Printf("任务 %d 提交被停止中断", task.ID)
+		return
+	}
+	if err != nil {
+		task.FailReason = err.Error()
+		var exhausted *KeysExhaustedError
+		kind := ClassifyError(err)
+		task.FailureCode = failureCode(kind)
+		wait, rateLimited := RateLimitWait(err)
+		switch {
+		case rateLimited:
+			// Not the task's fault, it keeps its retries and waits for the window to pass
+			task.Status = StatusPending
+			p.holdSubmissions(wait)
+		case errors.As(err, &exhausted):
+			// Retrying can't help until a key's cooldown ends
+			log.Printf("任务 %d 提交失败: %v", task.ID, err)
+			task.Retries++
+			task.Status = StatusFailed
+		case kind == ErrAuth || kind == ErrQuota:
+			// Every submission would fail the same way, hold them until the key is fixed; the task
+			// keeps its place in the queue
+			log.Printf("任务 %d 提交失败，API密钥不可用，暂停提交: %v", task.ID, err)
+			task.FailReason = friendlyFailReason(kind, err)
+			task.Status = StatusPending
+			p.pauseForKeyFailure(task.FailReason)
+		case kind == ErrContentPolicy || kind == nil:
+			// The same request would be rejected again
+			log.Printf("任务 %d 提交被拒绝，不再重试: %v", task.ID, err)
+			task.FailReason = friendlyFailReason(kind, err)
+			task.Retries++
+			task.Status = StatusFailed
+		default:
+			// Keep the task pending until max_retries is exceeded, fail_reason records the last error
+			task.Retries++
+			if task.Retries > config.MaxRetries {
+				log.Printf("任务 %d 提交失败 (已重试 %d 次): %v", task.ID, task.Retries-1, err)
+				task.Status = StatusFailed
+			} else {
+				log.Printf("任务 %d 提交失败，将重试 (%d/%d): %v", task.ID, task.Retries, config.MaxRetries, err)
+				task.Status = StatusPending
+			}
+		}
+		if p.saveTransition(task, StatusSubmitting, fieldsSubmission) && task.Status == StatusFailed {
+			p.saveAPIResponse(task.ID, apiResponseOf(err))
 		}
+		RecordTaskEvent(task.ID, HistorySubmitFailed, fmt.Sprintf("attempt %d: %v", task.Retries, err))
 		return
 	}
 
 	// Update task with task ID and set status to processing
 	task.TaskID = resp.ID
+	task.APIKeyFingerprint = resp.KeyFingerprint
+	task.ModelUsed = resp.Model
 	task.Status = StatusProcessing
-	if err := UpdateTask(task); err != nil {
-		log.Printf("更新任务 %d 失败: %v", task.ID, err)
+	task.FailReason = ""
+	task.FailureCode = ""
+	p.saveTransition(task, StatusSubmitting, fieldsSubmission)
+	log.Printf("视频任务 %d 提交成功，任务ID: %s，使用API密钥 #%d", task.ID, resp.ID, resp.KeyIndex)
+	if resp.FallbackFrom != "" {
+		RecordTaskEvent(task.ID, HistoryFallback, fmt.Sprintf("%s has no available channel, fell back to %s", resp.FallbackFrom, resp.Model))
 	}
-	log.Printf("视频任务 %d 提交成功，任务ID: %s", task.ID, resp.ID)
+	detail := fmt.Sprintf("remote task %s, model %s, API key #%d", resp.ID, resp.Model, resp.KeyIndex)
+	if prompt != task.Prompt {
+		detail += fmt.Sprintf(", prompt prefix %q and suffix %q applied", prefix, suffix)
+	}
+	RecordTaskEvent(task.ID, HistorySubmitted, detail)
 }
 
 // pollTaskStatus polls the API for task status updates
@@ -141,16 +554,40 @@ func (p *TaskProcessor) pollTaskStatus(task *Task) {
 		log.Printf("任务 %d 没有任务ID，标记为失败", task.ID)
 		task.Status = StatusFailed
 		task.FailReason = "任务ID为空"
-		if err := UpdateTask(task); err != nil {
-			log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
-		}
+		p.saveTransition(task, StatusProcessing, fieldsStatus)
 		return
 	}
 
-	resp, err := p.client.QueryTaskStatus(task.TaskID)
+	// The task may have been cancelled since it was loaded
+	if p.isCancelled(task) {
+		return
+	}
+
+	resp, err := p.queryTaskStatus(p.ctx, task)
 	if err != nil {
-		log.Printf("查询任务 %d 状态失败: %v (将重试)", task.ID, err)
-		// Don't mark as failed immediately, just log and retry on next poll
+		switch kind := ClassifyError(err); kind {
+		case ErrContentPolicy:
+			log.Printf("任务 %d 未通过审核: %v", task.ID, err)
+			task.Status = StatusFailed
+			task.FailReason = friendlyFailReason(kind, err)
+			task.FailureCode = failureCode(kind)
+			if p.saveTransition(task, StatusProcessing, fieldsStatus) {
+				p.saveAPIResponse(task.ID, apiResponseOf(err))
+				RecordTaskEvent(task.ID, HistoryRemoteFailed, err.Error())
+			}
+		case ErrAuth, ErrQuota:
+			// The task is kept polling, new submissions would fail with the same key
+			log.Printf("查询任务 %d 状态失败: %v (将重试)", task.ID, err)
+			p.pauseForKeyFailure(friendlyFailReason(kind, err))
+		default:
+			// Don't mark as failed immediately, just log and retry on next poll
+			log.Printf("查询任务 %d 状态失败: %v (将重试)", task.ID, err)
+		}
+		return
+	}
+
+	// A cancel that raced with the query must not be overwritten back to processing
+	if p.isCancelled(task) {
 		return
 	}
 
@@ -159,8 +596,10 @@ func (p *TaskProcessor) pollTaskStatus(task *Task) {
 		log.Printf("任务 %d API错误: %s", task.ID, resp.Error.Message)
 		task.Status = StatusFailed
 		task.FailReason = resp.Error.Message
-		if err := UpdateTask(task); err != nil {
-			log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
+		task.FailureCode = remoteFailureCode(task.FailReason)
+		if p.saveTransition(task, StatusProcessing, fieldsStatus) {
+			p.saveAPIResponse(task.ID, resp.RawBody)
+			RecordTaskEvent(task.ID, HistoryRemoteFailed, task.FailReason)
 		}
 		return
 	}
@@ -170,8 +609,10 @@ func (p *TaskProcessor) pollTaskStatus(task *Task) {
 		log.Printf("任务 %d 失败: %s", task.ID, resp.FailReason)
 		task.Status = StatusFailed
 		task.FailReason = resp.FailReason
-		if err := UpdateTask(task); err != nil {
-			log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
+		task.FailureCode = remoteFailureCode(task.FailReason)
+		if p.saveTransition(task, StatusProcessing, fieldsStatus) {
+			p.saveAPIResponse(task.ID, resp.RawBody)
+			RecordTaskEvent(task.ID, HistoryRemoteFailed, task.FailReason)
 		}
 		return
 	}
@@ -188,60 +629,528 @@ func (p *TaskProcessor) pollTaskStatus(task *Task) {
 		if resp.FailReason != "" {
 			task.FailReason = resp.FailReason
 		}
-		if err := UpdateTask(task); err != nil {
-			log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
+		task.FailureCode = remoteFailureCode(task.FailReason)
+		if p.saveTransition(task, StatusProcessing, fieldsStatus) {
+			log.Printf("任务 %d 失败", task.ID)
+			p.saveAPIResponse(task.ID, resp.RawBody)
+			RecordTaskEvent(task.ID, HistoryRemoteFailed, "status "+resp.Status)
 		}
-		log.Printf("任务 %d 失败", task.ID)
 	default:
-		// Still processing, just update progress
-		if err := UpdateTask(task); err != nil {
+		// Still processing, just update progress unless the task was cancelled or reset meanwhile
+		p.fireProgressMilestones(task)
+		saved, err := UpdateProcessingProgress(task.ID, task.Progress, task.MilestonesFired)
+		if err != nil {
 			log.Printf("更新任务 %d 进度失败: %v", task.ID, err)
+			return
+		}
+		if !saved {
+			log.Printf("任务 %d 已不是 %s 状态，跳过进度更新", task.ID, StatusProcessing)
+			return
+		}
+		PublishTaskUpdate(task)
+	}
+}
+
+// friendlyFailReason explains a submission or query error of the given kind in the fail_reason,
+// the upstream error is kept after it
+func friendlyFailReason(kind error, err error) string {
+	switch kind {
+	case ErrContentPolicy:
+		return "内容未通过审核，请修改提示词或图片后重新提交: " + err.Error()
+	case ErrAuth:
+		return "API密钥无效或已过期，已暂停提交，请检查dyu_api_key: " + err.Error()
+	case ErrQuota:
+		return "API额度不足，已暂停提交，请充值或更换密钥: " + err.Error()
+	case nil:
+		return "请求被拒绝: " + err.Error()
+	}
+	return err.Error()
+}
+
+// queryTaskStatus queries the status of a submitted task from the provider of its model
+func (p *TaskProcessor) queryTaskStatus(ctx context.Context, task *Task) (*VectorEngineQueryResponse, error) {
+	provider, err := p.provider(task.Model)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Provider.QueryStatus(ctx, task.Model, task.TaskID, task.APIKeyFingerprint)
+}
+
+// downloadVideo downloads the video of a completed task through the provider of its model
+func (p *TaskProcessor) downloadVideo(ctx context.Context, task *Task) (string, error) {
+	provider, err := p.provider(task.Model)
+	if err != nil {
+		return "", err
+	}
+	return provider.Provider.Download(ctx, task.VideoURL, task.TaskID)
+}
+
+// downloadProgressInterval is how often the download progress of a task is saved
+const downloadProgressInterval = 2 * time.Second
+
+// trackDownloadProgress returns the progress callback of the download of a task, it saves and
+// publishes task.DownloadProgress at most every downloadProgressInterval
+func (p *TaskProcessor) trackDownloadProgress(task *Task) DownloadProgressFunc {
+	var mu sync.Mutex
+	var saved time.Time
+	return func(written, total int64) {
+		percent := int(written * 100 / total)
+		mu.Lock()
+		defer mu.Unlock()
+		if percent == task.DownloadProgress || time.Since(saved) < downloadProgressInterval {
+			return
 		}
+		saved = time.Now()
+		task.DownloadProgress = percent
+		if err := SetTaskDownloadProgress(task.ID, percent); err != nil {
+			log.Printf("Failed to save download progress of task %d: %v", task.ID, err)
+			return
+		}
+		PublishTaskUpdate(task)
 	}
 }
 
+// saveAPIResponse keeps the raw upstream response that made a task fail, for GET /api/tasks/:id/debug
+func (p *TaskProcessor) saveAPIResponse(id int64, response string) {
+	if err := SetTaskAPIResponse(id, response); err != nil {
+		log.Printf("保存任务 %d 的API响应失败: %v", id, err)
+	}
+}
+
+// taskFields selects the columns the processor saves besides status, progress and fail_reason,
+// so a transition only writes what it changed
+type taskFields int
+
+const (
+	fieldsStatus     taskFields = 0         // Only status, progress and fail_reason
+	fieldsSubmission taskFields = 1 << iota // Remote task ID, submitted prompt, API key, model used and retries
+	fieldsDownload                          // Video URL, local file and download progress
+	fieldsVideo                             // Probed metadata and thumbnail of the downloaded video
+	fieldsWarning                           // Warning code and message
+	fieldsMilestones                        // Webhook progress milestones fired
+)
+
+// updateTask saves the status and the selected fields of the task, then publishes the change to
+// event subscribers
+func (p *TaskProcessor) updateTask(task *Task, fields taskFields) error {
+	if err := UpdateTaskStatus(task.ID, task.Status, task.Progress, task.FailReason, task.FailureCode); err != nil {
+		return err
+	}
+	saves := []struct {
+		fields taskFields
+		save   func() error
+	}{
+		{fieldsSubmission, func() error {
+			return UpdateTaskSubmission(task.ID, task.TaskID, task.SubmittedPrompt, task.APIKeyFingerprint, task.ModelUsed, task.Retries)
+		}},
+		{fieldsDownload, func() error {
+			return UpdateTaskCompletion(task.ID, task.VideoURL, task.LocalPath, task.DownloadProgress)
+		}},
+		{fieldsVideo, func() error {
+			if err := SetTaskMetadata(task.ID, task); err != nil {
+				return err
+			}
+			return SetTaskThumbnail(task.ID, task.Thumbnail)
+		}},
+		{fieldsWarning, func() error { return SetTaskWarning(task.ID, task.Warning, task.WarningMessage) }},
+		{fieldsMilestones, func() error { return SetTaskMilestones(task.ID, task.MilestonesFired) }},
+	}
+	for _, s := range saves {
+		if fields&s.fields == 0 {
+			continue
+		}
+		if err := s.save(); err != nil {
+			return err
+		}
+	}
+	PublishTaskUpdate(task)
+	p.notifyWebhook(task)
+	return nil
+}
+
+// saveTransition atomically claims the move from fromStatus to task.Status, then saves the task
+// with the selected fields
+// Returns false without saving when the task has left fromStatus, e.g. it was cancelled or
+// already handled by another code path
+func (p *TaskProcessor) saveTransition(task *Task, fromStatus string, fields taskFields) bool {
+	claimed, err := ClaimTask(task.ID, fromStatus, task.Status)
+	if err != nil {
+		log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
+		return false
+	}
+	if !claimed {
+		log.Printf("任务 %d 已不是 %s 状态，跳过更新为 %s", task.ID, fromStatus, task.Status)
+		return false
+	}
+	if err := p.updateTask(task, fields); err != nil {
+		log.Printf("更新任务 %d 状态失败: %v", task.ID, err)
+	}
+	return true
+}
+
+// isCancelled reports whether the task has been cancelled by the user
+func (p *TaskProcessor) isCancelled(task *Task) bool {
+	status, err := GetTaskStatus(task.ID)
+	if err != nil {
+		log.Printf("获取任务 %d 状态失败: %v", task.ID, err)
+		return false
+	}
+	if status == StatusCancelled {
+		log.Printf("任务 %d 已取消，停止轮询", task.ID)
+		return true
+	}
+	return false
+}
+
 // handleTaskCompletion handles a completed task by downloading the video
 func (p *TaskProcessor) handleTaskCompletion(task *Task, resp *VectorEngineQueryResponse) {
-	log.Printf("Task %d completed, downloading video", task.ID)
+	if resp.VideoURL != "" && !p.checkDownloadSpace() {
+		return
+	}
 
 	task.VideoURL = resp.VideoURL
 	task.Progress = 100
 
-	if resp.VideoURL != "" {
-		// Download the video with retry until success
-		maxRetries := 10
-		retryDelay := 5 * time.Second
-
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			filename, err := p.client.DownloadVideo(resp.VideoURL, task.TaskID)
-			if err == nil {
-				task.LocalPath = filename
-				log.Printf("Video downloaded for task %d: %s", task.ID, filename)
-				break
-			}
+	if resp.VideoURL == "" {
+		p.completeTask(task, StatusProcessing)
+		return
+	}
+	p.enqueueDownload(task)
+}
 
-			log.Printf("Failed to download video for task %d (attempt %d/%d): %v", task.ID, attempt, maxRetries, err)
+// enqueueDownload marks the task downloading and hands it to the download workers
+// Tasks already queued or being downloaded are skipped; when the queue is full the task stays
+// processing and is enqueued by a later poll
+func (p *TaskProcessor) enqueueDownload(task *Task) {
+	p.mu.Lock()
+	if p.downloading[task.ID] {
+		p.mu.Unlock()
+		return
+	}
+	p.downloading[task.ID] = true
+	p.downloadsPending.Add(1)
+	p.mu.Unlock()
 
-			if attempt < maxRetries {
-				log.Printf("Retrying download for task %d in %v...", task.ID, retryDelay)
-				time.Sleep(retryDelay)
-			}
+	// Generation is over, the downloading status lets the UI show the download instead of a frozen 100%
+	task.Status = StatusDownloading
+	task.DownloadProgress = 0
+	if !p.saveTransition(task, StatusProcessing, fieldsDownload) {
+		p.finishDownload(task.ID)
+		return
+	}
+
+	// The workers get their own copy, the polling loop keeps reading task
+	job := *task
+	select {
+	case p.downloadQueue <- &job:
+		log.Printf("Task %d completed, video download queued", task.ID)
+	default:
+		log.Printf("Download queue is full, task %d is downloaded after a later poll", task.ID)
+		task.Status = StatusProcessing
+		p.saveTransition(task, StatusDownloading, fieldsStatus)
+		p.finishDownload(task.ID)
+	}
+}
+
+// finishDownload releases a task from the download queue bookkeeping
+func (p *TaskProcessor) finishDownload(id int64) {
+	p.mu.Lock()
+	delete(p.downloading, id)
+	p.mu.Unlock()
+	p.downloadsPending.Done()
+}
+
+// startDownloadWorkers starts the configured number of download workers
+func (p *TaskProcessor) startDownloadWorkers() {
+	workers := downloadWorkers(p.currentConfig())
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.downloadWorker()
+	}
+}
+
+// downloadWorker downloads the queued videos until the processor stops
+func (p *TaskProcessor) downloadWorker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stopChan:
+			p.drainDownloads()
+			return
+		case task := <-p.downloadQueue:
+			p.runDownload(task)
+		}
+	}
+}
+
+// drainDownloads hands the downloads still queued at shutdown back to processing,
+// they are downloaded after the next start
+func (p *TaskProcessor) drainDownloads() {
+	for {
+		select {
+		case task := <-p.downloadQueue:
+			p.abandonDownload(task)
+		default:
+			return
+		}
+	}
+}
+
+// abandonDownload moves a downloading task back to processing so a later poll downloads it again
+func (p *TaskProcessor) abandonDownload(task *Task) {
+	task.Status = StatusProcessing
+	p.saveTransition(task, StatusDownloading, fieldsStatus)
+	p.finishDownload(task.ID)
+}
+
+// waitForDownloads blocks until the queued and running downloads are done
+func (p *TaskProcessor) waitForDownloads() {
+	p.downloadsPending.Wait()
+}
+
+// runDownload downloads the video of a task, retrying with a delay, then completes the task
+func (p *TaskProcessor) runDownload(task *Task) {
+	ctx := WithDownloadProgress(p.ctx, p.trackDownloadProgress(task))
+
+	// Download the video with retry until success
+	maxRetries := 10
+	retryDelay := p.downloadRetryDelay
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		filename, err := p.downloadVideo(ctx, task)
+		if err == nil {
+			task.LocalPath = filename
+			log.Printf("Video downloaded for task %d: %s", task.ID, filename)
+			RecordTaskEvent(task.ID, HistoryDownloaded, filename)
+			break
+		}
+
+		if p.ctx.Err() != nil {
+			// Shutting down, the task is downloaded after the next start, resuming its .part file
+			log.Printf("Download of task %d interrupted by shutdown", task.ID)
+			p.abandonDownload(task)
+			return
 		}
+		log.Printf("Failed to download video for task %d (attempt %d/%d): %v", task.ID, attempt, maxRetries, err)
+		RecordTaskEvent(task.ID, HistoryDownloadFailed, fmt.Sprintf("attempt %d/%d: %v", attempt, maxRetries, err))
 
-		// If still no local path after all retries, keep task in processing state to retry later
-		if task.LocalPath == "" {
-			log.Printf("Task %d: video download failed after %d attempts, will retry on next poll", task.ID, maxRetries)
-			// Don't mark as completed, keep processing so it will be retried
-			if err := UpdateTask(task); err != nil {
-				log.Printf("Failed to update task %d: %v", task.ID, err)
+		// The video doesn't fit on disk, retrying would only fail the same way
+		var spaceErr *DiskSpaceError
+		if errors.As(err, &spaceErr) {
+			task.Status = StatusFailed
+			task.FailReason = spaceErr.Error()
+			if p.saveTransition(task, StatusDownloading, fieldsStatus) {
+				RecordTaskEvent(task.ID, HistoryFailed, task.FailReason)
 			}
+			p.finishDownload(task.ID)
 			return
 		}
+
+		// The CDN served an error page instead of the video, the signed URL has most likely expired
+		if isExpiredVideo(err) {
+			p.refreshVideoURL(task)
+		}
+
+		if attempt < maxRetries {
+			log.Printf("Retrying download for task %d in %v...", task.ID, retryDelay)
+			select {
+			case <-p.ctx.Done():
+				p.abandonDownload(task)
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+
+	// If still no local path after all retries, move the task back to processing to retry later
+	if task.LocalPath == "" {
+		log.Printf("Task %d: video download failed after %d attempts, will retry on next poll", task.ID, maxRetries)
+		p.abandonDownload(task)
+		return
 	}
+	task.DownloadProgress = 100
+	p.completeTask(task, StatusDownloading)
+	p.finishDownload(task.ID)
+}
 
+// completeTask marks a task whose video is on disk (or that has no video) completed
+func (p *TaskProcessor) completeTask(task *Task, fromStatus string) {
 	task.Status = StatusCompleted
-	if err := UpdateTask(task); err != nil {
-		log.Printf("Failed to update task %d to completed: %v", task.ID, err)
+	if task.LocalPath != "" {
+		if err := probeTaskMetadata(task); err != nil {
+			log.Printf("Warning: failed to probe video of task %d: %v", task.ID, err)
+		}
+	}
+	p.checkOrientation(task)
+	if err := generateTaskThumbnail(p.currentConfig(), task); err != nil {
+		log.Printf("Failed to generate thumbnail for task %d: %v", task.ID, err)
+	}
+	if !p.saveTransition(task, fromStatus, fieldsDownload|fieldsVideo|fieldsWarning) {
+		return
+	}
+	if task.Status == StatusCompleted {
+		log.Printf("Task %d completed successfully", task.ID)
+		RecordTaskEvent(task.ID, HistoryCompleted, "")
+		p.runPostDownloadHook(*task)
+	} else {
+		RecordTaskEvent(task.ID, HistoryFailed, task.FailReason)
+	}
+}
+
+// checkDownloadSpace reports whether downloads may proceed under the min_free_space_mb threshold
+// While space is low, completed tasks stay processing and are downloaded on a later poll
+func (p *TaskProcessor) checkDownloadSpace() bool {
+	config := p.currentConfig()
+	ok := hasDownloadSpace(config)
+
+	p.mu.Lock()
+	changed := p.diskLow == ok
+	p.diskLow = !ok
+	p.mu.Unlock()
+
+	if changed && ok {
+		log.Println("Disk space available again, downloads resumed")
+	} else if changed {
+		log.Printf("Free space in %s is below %d MB, downloads paused", OutputDirectory(), config.MinFreeSpaceMB)
+	}
+	return ok
+}
+
+// checkOrientation compares the dimensions stored by probeTaskMetadata with the requested orientation
+// A mismatch is recorded as a warning, or fails the task when strict_orientation is enabled
+// Skipped when the dimensions couldn't be read
+func (p *TaskProcessor) checkOrientation(task *Task) {
+	if task.LocalPath == "" || task.Orientation == "" || task.Width == 0 || task.Height == 0 {
+		return
+	}
+	info := &VideoInfo{Width: task.Width, Height: task.Height}
+	if info.Orientation() == task.Orientation {
+		return
+	}
+
+	message := fmt.Sprintf("expected %s, got %dx%d (%s)", task.Orientation, info.Width, info.Height, info.Orientation())
+	log.Printf("Task %d orientation mismatch: %s", task.ID, message)
+	task.Warning = WarningOrientationMismatch
+	task.WarningMessage = message
+	if p.currentConfig().StrictOrientation {
+		task.Status = StatusFailed
+		task.FailReason = "orientation mismatch: " + message
+	}
+}
+
+// refreshVideoURL re-queries the task status to obtain a fresh video URL
+// Used when the previous URL returned an error page instead of the video
+func (p *TaskProcessor) refreshVideoURL(task *Task) {
+	resp, err := p.queryTaskStatus(p.ctx, task)
+	if err != nil {
+		log.Printf("Failed to refresh video URL for task %d: %v", task.ID, err)
+		return
+	}
+	if resp.VideoURL != "" && resp.VideoURL != task.VideoURL {
+		log.Printf("Refreshed video URL for task %d", task.ID)
+		RecordTaskEvent(task.ID, HistoryURLRefreshed, "")
+		task.VideoURL = resp.VideoURL
+	}
+}
+
+// isExpiredVideo reports whether a download failed because the video URL no longer serves the video
+func isExpiredVideo(err error) bool {
+	var invalidErr *InvalidVideoError
+	var expiredErr *VideoExpiredError
+	return errors.As(err, &invalidErr) || errors.As(err, &expiredErr)
+}
+
+// Redownload downloads again the video of a completed task and sets task.LocalPath, the video
+// URL is refreshed from the upstream task when it has expired
+// The caller saves the task
+func (p *TaskProcessor) Redownload(ctx context.Context, task *Task) error {
+	filename, err := p.downloadVideo(ctx, task)
+	if isExpiredVideo(err) {
+		p.refreshVideoURL(task)
+		filename, err = p.downloadVideo(ctx, task)
+	}
+	if err != nil {
+		RecordTaskEvent(task.ID, HistoryDownloadFailed, err.Error())
+		return err
+	}
+	task.LocalPath = filename
+	log.Printf("Video downloaded again for task %d: %s", task.ID, filename)
+	RecordTaskEvent(task.ID, HistoryDownloaded, filename)
+
+	if err := probeTaskMetadata(task); err != nil {
+		log.Printf("Warning: failed to probe video of task %d: %v", task.ID, err)
+	}
+	if err := generateTaskThumbnail(p.currentConfig(), task); err != nil {
+		log.Printf("Failed to generate thumbnail for task %d: %v", task.ID, err)
+	}
+	return nil
+}
+
+// promptDecoration returns the prefix and suffix added to the prompt of a task: the overrides
+// copied from its template when set, the global prompt_prefix and prompt_suffix otherwise
+func promptDecoration(config *Config, prefix, suffix string) (string, string) {
+	if strings.TrimSpace(prefix) == "" {
+		prefix = config.PromptPrefix
+	}
+	if strings.TrimSpace(suffix) == "" {
+		suffix = config.PromptSuffix
+	}
+	return prefix, suffix
+}
+
+// DecoratePrompt surrounds a prompt with the configured prefix and suffix
+// Empty parts are skipped so an unset prefix/suffix leaves the prompt unchanged
+func DecoratePrompt(prompt, prefix, suffix string) string {
+	var parts []string
+	for _, part := range []string{prefix, prompt, suffix} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ProcessorStatusResponse represents the response of the processor control endpoints
+type ProcessorStatusResponse struct {
+	Running     bool   `json:"running"`
+	Paused      bool   `json:"paused"`
+	PauseReason string `json:"pause_reason,omitempty"` // Set when an API key failure paused submissions
+}
+
+// handleProcessorStatus handles GET /api/processor/status
+func handleProcessorStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeProcessorStatus(w)
+}
+
+// handleProcessorPause handles POST /api/processor/pause
+func handleProcessorPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
 	}
-	log.Printf("Task %d completed successfully", task.ID)
+	taskProcessor.Pause()
+	writeProcessorStatus(w)
+}
+
+// handleProcessorResume handles POST /api/processor/resume
+func handleProcessorResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	taskProcessor.Resume()
+	writeProcessorStatus(w)
+}
+
+// writeProcessorStatus writes the current processor state
+func writeProcessorStatus(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, ProcessorStatusResponse{
+		Running:     taskProcessor.IsRunning(),
+		Paused:      taskProcessor.IsPaused(),
+		PauseReason: taskProcessor.PauseReason(),
+	})
 }
@@ -0,0 +1,953 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePoll is one status response of the fake Dyu API
+type fakePoll struct {
+	Status     string
+	Progress   int
+	FailReason string
+}
+
+// fakeScenario scripts how the fake Dyu API treats the tasks created with a prompt
+type fakeScenario struct {
+	createFailures   []int      // HTTP statuses answered to the first create requests, e.g. 429
+	retryAfter       string     // Retry-After header sent with the create failures
+	noTestChannel    bool       // -test models answer "暂无渠道", forcing the fallback to the plain model
+	noChannel        []string   // Further models answering "暂无渠道"
+	polls            []fakePoll // Successive status responses, the last one repeats
+	expiredDownloads int        // Downloads answered with an HTML error page before the video is served
+}
+
+// fakeDyuServer is an httptest server implementing the parts of the Dyu API used by the processor
+type fakeDyuServer struct {
+	*httptest.Server
+	payload []byte
+
+	mu        sync.Mutex
+	scenarios map[string]*fakeScenario // prompt -> scenario
+	tasks     map[string]*fakeScenario // remote task ID -> scenario
+	pollIndex map[string]int
+	models    []string // Models of all create requests, in order
+	images    []int    // Number of images sent with each create request, in order
+	downloads int
+	// downloadGate, when set, holds video downloads until it is closed
+	downloadGate chan struct{}
+}
+
+func newFakeDyuServer(t *testing.T, scenarios map[string]*fakeScenario) *fakeDyuServer {
+	t.Helper()
+	f := &fakeDyuServer{
+		payload:   fakeMP4(4096),
+		scenarios: scenarios,
+		tasks:     make(map[string]*fakeScenario),
+		pollIndex: make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeDyuServer) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/videos":
+		var req struct {
+			Prompt string `json:"prompt"`
+			Model  string `json:"model"`
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.ParseMultipartForm(1 << 20)
+			req.Prompt, req.Model = r.FormValue("prompt"), r.FormValue("model")
+			f.images = append(f.images, len(r.MultipartForm.File["input_reference"]))
+		} else {
+			json.NewDecoder(r.Body).Decode(&req)
+			f.images = append(f.images, 0)
+		}
+		f.models = append(f.models, req.Model)
+		sc := f.scenarios[req.Prompt]
+		if sc == nil {
+			http.Error(w, `{"error":{"message":"unknown scenario"}}`, http.StatusBadRequest)
+			return
+		}
+		if len(sc.createFailures) > 0 {
+			status := sc.createFailures[0]
+			sc.createFailures = sc.createFailures[1:]
+			if sc.retryAfter != "" {
+				w.Header().Set("Retry-After", sc.retryAfter)
+			}
+			http.Error(w, `{"error":{"message":"rate limited"}}`, status)
+			return
+		}
+		if (sc.noTestChannel && strings.HasSuffix(req.Model, "-test")) || slices.Contains(sc.noChannel, req.Model) {
+			http.Error(w, `{"error":{"message":"当前分组下对于模型 `+req.Model+` 暂无渠道"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		id := fmt.Sprintf("video_%d", len(f.tasks)+1)
+		f.tasks[id] = sc
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/videos/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/videos/")
+		sc := f.tasks[id]
+		if sc == nil {
+			http.Error(w, `{"error":{"message":"task not found"}}`, http.StatusNotFound)
+			return
+		}
+		poll := sc.polls[len(sc.polls)-1]
+		if i := f.pollIndex[id]; i < len(sc.polls) {
+			poll = sc.polls[i]
+			f.pollIndex[id] = i + 1
+		}
+		resp := map[string]interface{}{"id": id, "status": poll.Status, "progress": poll.Progress}
+		if poll.FailReason != "" {
+			resp["fail_reason"] = poll.FailReason
+		}
+		if poll.Status == "completed" {
+			resp["video_url"] = f.URL + "/files/" + id + ".mp4"
+			resp["username"] = "user." + id
+			resp["avatar_url"] = f.URL + "/avatars/" + id + ".jpg"
+		}
+		json.NewEncoder(w).Encode(resp)
+
+	case strings.HasPrefix(r.URL.Path, "/files/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/files/"), ".mp4")
+		sc := f.tasks[id]
+		if sc != nil && sc.expiredDownloads > 0 {
+			if r.Method == http.MethodGet {
+				sc.expiredDownloads--
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>AccessDenied: Request has expired</body></html>"))
+			return
+		}
+		if gate := f.downloadGate; gate != nil && r.Method == http.MethodGet {
+			f.mu.Unlock()
+			<-gate
+			f.mu.Lock()
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		if r.Method == http.MethodGet {
+			f.downloads++
+			w.Write(f.payload)
+		}
+
+	case strings.HasPrefix(r.URL.Path, "/avatars/"):
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("avatar"))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestProcessor creates a processor, not started apart from its download workers, backed by a
+// temporary database and output directory and talking to the fake server; tests drive it with tick
+func newTestProcessor(t *testing.T, server *fakeDyuServer) *TaskProcessor {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "processor.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	t.Cleanup(func() { CloseDB() })
+
+	config := &Config{DyuAPIKey: "test-key", MaxRetries: 3}
+	useTestConfig(t, config)
+
+	p := NewTaskProcessor(config)
+	p.client.SetBaseURL(server.URL)
+	p.downloadRetryDelay = 0
+	p.startDownloadWorkers()
+	t.Cleanup(func() {
+		close(p.stopChan)
+		p.cancel()
+		p.wg.Wait()
+	})
+	return p
+}
+
+// tick runs one processing cycle and waits for the downloads it queued
+func tick(p *TaskProcessor) {
+	p.processPendingTasks()
+	p.waitForDownloads()
+}
+
+// TestProcessorScenarios drives tasks through the fake API tick by tick and checks the final
+// task state and the files on disk
+func TestProcessorScenarios(t *testing.T) {
+	completed := []fakePoll{{"processing", 30, ""}, {"processing", 70, ""}, {"completed", 100, ""}}
+
+	cases := []struct {
+		name       string
+		scenario   *fakeScenario
+		ticks      int
+		status     string
+		failReason string
+		models     []string
+		downloaded bool
+	}{
+		{
+			name:       "progress then complete",
+			scenario:   &fakeScenario{polls: completed},
+			ticks:      4,
+			status:     StatusCompleted,
+			models:     []string{"sora2-landscape-test"},
+			downloaded: true,
+		},
+		{
+			name:       "remote failure",
+			scenario:   &fakeScenario{polls: []fakePoll{{"processing", 10, ""}, {"FAILURE", 0, "content policy violation"}}},
+			ticks:      3,
+			status:     StatusFailed,
+			failReason: "content policy violation",
+			models:     []string{"sora2-landscape-test"},
+		},
+		{
+			name:       "no channel falls back to the plain model",
+			scenario:   &fakeScenario{noTestChannel: true, polls: completed},
+			ticks:      4,
+			status:     StatusCompleted,
+			models:     []string{"sora2-landscape-test", "sora2-landscape"},
+			downloaded: true,
+		},
+		{
+			name:       "rate limited then accepted",
+			scenario:   &fakeScenario{createFailures: []int{http.StatusTooManyRequests}, polls: completed},
+			ticks:      5,
+			status:     StatusCompleted,
+			models:     []string{"sora2-landscape-test", "sora2-landscape-test"},
+			downloaded: true,
+		},
+		{
+			name:       "expired download URL is refreshed",
+			scenario:   &fakeScenario{expiredDownloads: 1, polls: []fakePoll{{"completed", 100, ""}}},
+			ticks:      2,
+			status:     StatusCompleted,
+			models:     []string{"sora2-landscape-test"},
+			downloaded: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeDyuServer(t, map[string]*fakeScenario{"a cat": tc.scenario})
+			p := newTestProcessor(t, server)
+
+			created, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+			if err != nil {
+				t.Fatalf("CreateTask failed: %v", err)
+			}
+			for i := 0; i < tc.ticks; i++ {
+				tick(p)
+			}
+
+			task, err := GetTask(created.ID)
+			if err != nil || task == nil {
+				t.Fatalf("GetTask failed: %v", err)
+			}
+			if task.Status != tc.status {
+				t.Fatalf("status = %q (fail_reason %q), want %q", task.Status, task.FailReason, tc.status)
+			}
+			if tc.failReason != "" && task.FailReason != tc.failReason {
+				t.Errorf("fail_reason = %q, want %q", task.FailReason, tc.failReason)
+			}
+			if strings.Join(server.models, ",") != strings.Join(tc.models, ",") {
+				t.Errorf("models submitted = %v, want %v", server.models, tc.models)
+			}
+
+			if !tc.downloaded {
+				if task.LocalPath != "" {
+					t.Errorf("unexpected local_path %q", task.LocalPath)
+				}
+				return
+			}
+			data, err := os.ReadFile(filepath.Join(OutputDirectory(), task.LocalPath))
+			if err != nil {
+				t.Fatalf("downloaded video missing: %v", err)
+			}
+			if len(data) != len(server.payload) {
+				t.Errorf("downloaded %d bytes, want %d", len(data), len(server.payload))
+			}
+		})
+	}
+}
+
+// TestProcessorDownloadingStatus checks a finished generation goes through downloading before
+// completed, and that the download progress is saved at most every downloadProgressInterval
+func TestProcessorDownloadingStatus(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{"a cat": {polls: []fakePoll{{"completed", 100, ""}}}})
+	p := newTestProcessor(t, server)
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	ch := events.Subscribe()
+	defer events.Unsubscribe(ch)
+	tick(p)
+	tick(p)
+
+	var statuses []string
+	for len(ch) > 0 {
+		if event := <-ch; event.Task.ID == created.ID {
+			statuses = append(statuses, event.Task.Status)
+		}
+	}
+	if got := strings.Join(statuses, ","); got != "processing,downloading,completed" {
+		t.Errorf("published statuses %s, want processing,downloading,completed", got)
+	}
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusCompleted || task.DownloadProgress != 100 {
+		t.Errorf("status %s, download_progress %d, want completed at 100", task.Status, task.DownloadProgress)
+	}
+
+	track := p.trackDownloadProgress(task)
+	task.DownloadProgress = 0
+	track(25, 100)
+	track(50, 100)
+	if stored, _ := GetTask(created.ID); stored.DownloadProgress != 25 {
+		t.Errorf("download_progress = %d, want 25 until the interval passes", stored.DownloadProgress)
+	}
+}
+
+// TestProcessorDownloadQueue checks a slow download doesn't hold up the polling loop and that a task
+// being downloaded is not enqueued again
+func TestProcessorDownloadQueue(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{"a cat": {polls: []fakePoll{{"completed", 100, ""}}}})
+	server.downloadGate = make(chan struct{})
+	p := newTestProcessor(t, server)
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	p.processPendingTasks()
+	// The download is held by the server, polling returns anyway
+	p.processPendingTasks()
+	if status, _ := GetTaskStatus(created.ID); status != StatusDownloading {
+		t.Fatalf("status = %q while the download is held, want downloading", status)
+	}
+
+	again, _ := GetTask(created.ID)
+	again.Status = StatusProcessing
+	p.enqueueDownload(again)
+	if len(p.downloadQueue) != 0 {
+		t.Errorf("task enqueued twice")
+	}
+
+	close(server.downloadGate)
+	p.waitForDownloads()
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusCompleted || task.LocalPath == "" {
+		t.Errorf("status %q, local_path %q after the download, want completed", task.Status, task.LocalPath)
+	}
+	if server.downloads != 1 {
+		t.Errorf("video downloaded %d times, want once", server.downloads)
+	}
+}
+
+// TestProcessorPollsCharacters checks unfinished characters are polled every cycle and keep a local
+// copy of their avatar once trained
+func TestProcessorPollsCharacters(t *testing.T) {
+	server := newFakeDyuServer(t, nil)
+	server.tasks["char_1"] = &fakeScenario{polls: []fakePoll{{"processing", 40, ""}, {"completed", 100, ""}}}
+	server.tasks["char_2"] = &fakeScenario{polls: []fakePoll{{"failed", 0, "bad video"}}}
+	p := newTestProcessor(t, server)
+
+	trained, _ := CreateCharacter(&Character{ApiCharacterID: "char_1", CustomName: "alice", SourceType: "url"})
+	failed, _ := CreateCharacter(&Character{ApiCharacterID: "char_2", CustomName: "bob", SourceType: "url"})
+
+	tick(p)
+	if char, _ := GetCharacter(trained.ID); char.Status != StatusProcessing || char.Progress != 40 {
+		t.Errorf("after one cycle: status %q, progress %d, want processing at 40", char.Status, char.Progress)
+	}
+	if char, _ := GetCharacter(failed.ID); char.Status != StatusFailed || char.FailReason != "bad video" {
+		t.Errorf("failed character: status %q, fail_reason %q", char.Status, char.FailReason)
+	}
+
+	tick(p)
+	char, _ := GetCharacter(trained.ID)
+	if char.Status != StatusCompleted || char.Username != "user.char_1" {
+		t.Fatalf("after two cycles: status %q, username %q, want completed", char.Status, char.Username)
+	}
+	if !strings.HasPrefix(char.AvatarURL, characterPictureURLPrefix) {
+		t.Fatalf("avatar_url %q is not a local picture", char.AvatarURL)
+	}
+	data, err := os.ReadFile(filepath.Join(CharacterPictureDirectory, strings.TrimPrefix(char.AvatarURL, characterPictureURLPrefix)))
+	if err != nil || string(data) != "avatar" {
+		t.Errorf("local avatar: %q, %v", data, err)
+	}
+
+	// Finished characters are read from the database
+	server.tasks["char_1"] = nil
+	rec := httptest.NewRecorder()
+	handleGetCharacterStatus(rec, httptest.NewRequest(http.MethodGet, "/api/characters/1/status", nil), trained.ID)
+	var status CharacterStatusResponse
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.Status != StatusCompleted || status.AvatarURL != char.AvatarURL {
+		t.Errorf("status handler: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestRedownloadTask deletes the video of a completed task, checks file_exists reports it and
+// downloads it again, answering 409 while the URL is expired
+func TestRedownloadTask(t *testing.T) {
+	scenario := &fakeScenario{polls: []fakePoll{{"completed", 100, ""}}}
+	server := newFakeDyuServer(t, map[string]*fakeScenario{"a cat": scenario})
+	p := newTestProcessor(t, server)
+	taskProcessor = p
+	t.Cleanup(func() { taskProcessor = nil })
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	tick(p)
+	tick(p)
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusCompleted {
+		t.Fatalf("status = %q, want completed", task.Status)
+	}
+
+	redownload := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleTaskByID(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/tasks/%d/redownload", task.ID), nil))
+		return rec
+	}
+	if rec := redownload(); rec.Code != http.StatusConflict {
+		t.Errorf("redownload with the file on disk: status %d, want 409", rec.Code)
+	}
+
+	os.Remove(ResolveVideoPath(task.LocalPath))
+	tasks := []Task{*task}
+	attachFileExists(tasks)
+	if tasks[0].FileExists == nil || *tasks[0].FileExists {
+		t.Errorf("file_exists = %v after deleting the video, want false", tasks[0].FileExists)
+	}
+
+	server.mu.Lock()
+	scenario.expiredDownloads = 2
+	server.mu.Unlock()
+	if rec := redownload(); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "expired") {
+		t.Errorf("redownload with an expired URL: status %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	rec := redownload()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redownload: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var got Task
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.FileExists == nil || !*got.FileExists {
+		t.Errorf("file_exists = %v after the redownload, want true", got.FileExists)
+	}
+	stored, _ := GetTask(task.ID)
+	if data, err := os.ReadFile(ResolveVideoPath(stored.LocalPath)); err != nil || !bytes.Equal(data, server.payload) {
+		t.Errorf("video not downloaded again to %q: %v", stored.LocalPath, err)
+	}
+}
+
+// TestProcessorRetriesRateLimitedSubmission checks the task stays pending between attempts
+func TestProcessorRetriesRateLimitedSubmission(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a dog": {createFailures: []int{http.StatusTooManyRequests}, polls: []fakePoll{{"processing", 5, ""}}},
+	})
+	p := newTestProcessor(t, server)
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a dog", Duration: Duration10s, Orientation: OrientationPortrait})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+
+	tick(p)
+	task, _ := GetTask(created.ID)
+	// A 429 doesn't count against max_retries
+	if task.Status != StatusPending || task.Retries != 0 || !strings.Contains(task.FailReason, "429") {
+		t.Fatalf("after rate limit: status=%q retries=%d fail_reason=%q", task.Status, task.Retries, task.FailReason)
+	}
+
+	tick(p)
+	task, _ = GetTask(created.ID)
+	if task.Status != StatusProcessing || task.TaskID == "" || task.FailReason != "" {
+		t.Fatalf("after retry: status=%q task_id=%q fail_reason=%q", task.Status, task.TaskID, task.FailReason)
+	}
+}
+
+// TestProcessorHonorsRetryAfter checks a 429 with Retry-After holds every submission until the
+// window passes and is counted in the stats
+func TestProcessorHonorsRetryAfter(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a hare":  {createFailures: []int{http.StatusTooManyRequests}, retryAfter: "120", polls: []fakePoll{{"processing", 5, ""}}},
+		"a trout": {polls: []fakePoll{{"processing", 5, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	taskProcessor = p
+	t.Cleanup(func() { taskProcessor = nil })
+
+	hare, _ := CreateTask(&CreateTaskRequest{Prompt: "a hare", Duration: Duration10s, Orientation: OrientationPortrait, Priority: 1})
+	trout, _ := CreateTask(&CreateTaskRequest{Prompt: "a trout", Duration: Duration10s, Orientation: OrientationPortrait})
+	tick(p)
+	tick(p)
+
+	for _, id := range []int64{hare.ID, trout.ID} {
+		if task, _ := GetTask(id); task.Status != StatusPending || task.Retries != 0 {
+			t.Errorf("task %d: status=%q retries=%d, want pending", id, task.Status, task.Retries)
+		}
+	}
+	if len(server.models) != 1 {
+		t.Errorf("%d submissions during the Retry-After window, want 1", len(server.models))
+	}
+
+	rec := httptest.NewRecorder()
+	handleStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	var stats StatsResponse
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.RateLimitedSubmissions != 1 || stats.RateLimitedUntil == nil || !stats.RateLimitedUntil.Equal(now.Add(120*time.Second)) {
+		t.Errorf("stats: rate_limited_submissions=%d rate_limited_until=%v", stats.RateLimitedSubmissions, stats.RateLimitedUntil)
+	}
+
+	// Once the window has passed both are submitted
+	now = now.Add(121 * time.Second)
+	tick(p)
+	for _, id := range []int64{hare.ID, trout.ID} {
+		if task, _ := GetTask(id); task.Status != StatusProcessing {
+			t.Errorf("task %d after the Retry-After window: status=%q, want processing", id, task.Status)
+		}
+	}
+	if count, until := p.RateLimitStats(); count != 1 || until != nil {
+		t.Errorf("after the window: rate limited submissions %d, held until %v", count, until)
+	}
+}
+
+// TestProcessorKeepsFailureResponse checks the raw body of a failed status query is served by the
+// debug endpoint, capped at MaxAPIResponseBytes
+func TestProcessorKeepsFailureResponse(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a crab": {polls: []fakePoll{{"FAILURE", 0, "content policy violation"}}},
+	})
+	p := newTestProcessor(t, server)
+
+	created, _ := CreateTask(&CreateTaskRequest{Prompt: "a crab", Duration: Duration10s, Orientation: OrientationPortrait})
+	tick(p)
+	tick(p)
+
+	debug := func() TaskDebugResponse {
+		rec := httptest.NewRecorder()
+		handleTaskByID(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/tasks/%d/debug", created.ID), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("debug: status %d %s", rec.Code, rec.Body.String())
+		}
+		var resp TaskDebugResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+	resp := debug()
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.LastAPIResponse), &body); err != nil || body["fail_reason"] != "content policy violation" {
+		t.Errorf("last_api_response = %q", resp.LastAPIResponse)
+	}
+	if resp.Status != StatusFailed || resp.FailReason != "content policy violation" {
+		t.Errorf("debug: status=%q fail_reason=%q", resp.Status, resp.FailReason)
+	}
+
+	SetTaskAPIResponse(created.ID, strings.Repeat("错", MaxAPIResponseBytes))
+	if resp := debug(); len(resp.LastAPIResponse) > MaxAPIResponseBytes || !strings.HasPrefix(resp.LastAPIResponse, "错错") {
+		t.Errorf("capped response: %d bytes", len(resp.LastAPIResponse))
+	}
+}
+
+// TestProcessorPausesOnKeyFailure checks an authentication error holds the task and pauses
+// submissions until the API key changes, while a rejected request fails without retries
+func TestProcessorPausesOnKeyFailure(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"an owl":  {createFailures: []int{http.StatusUnauthorized}, polls: []fakePoll{{"processing", 5, ""}}},
+		"a moose": {createFailures: []int{http.StatusBadRequest}, polls: []fakePoll{{"processing", 5, ""}}},
+	})
+	p := newTestProcessor(t, server)
+
+	owl, _ := CreateTask(&CreateTaskRequest{Prompt: "an owl", Duration: Duration10s, Orientation: OrientationPortrait, Priority: 1})
+	moose, _ := CreateTask(&CreateTaskRequest{Prompt: "a moose", Duration: Duration10s, Orientation: OrientationPortrait})
+	tick(p)
+
+	task, _ := GetTask(owl.ID)
+	if task.Status != StatusPending || task.Retries != 0 || !strings.Contains(task.FailReason, "API密钥") {
+		t.Fatalf("after 401: status=%q retries=%d fail_reason=%q", task.Status, task.Retries, task.FailReason)
+	}
+	if !p.IsPaused() || p.PauseReason() == "" {
+		t.Fatalf("submissions not paused after 401")
+	}
+	if task, _ := GetTask(moose.ID); task.Status != StatusPending {
+		t.Fatalf("task submitted while paused: status %q", task.Status)
+	}
+
+	config := &Config{DyuAPIKey: "new-key", DyuBaseURL: server.URL, MaxRetries: 3}
+	useTestConfig(t, config)
+	p.ApplyConfig(config)
+	if p.IsPaused() {
+		t.Fatalf("still paused after the API key changed")
+	}
+	tick(p)
+
+	if task, _ := GetTask(owl.ID); task.Status != StatusProcessing {
+		t.Errorf("after key change: status=%q fail_reason=%q", task.Status, task.FailReason)
+	}
+	if task, _ := GetTask(moose.ID); task.Status != StatusFailed || task.Retries != 1 {
+		t.Errorf("rejected request: status=%q retries=%d", task.Status, task.Retries)
+	}
+}
+
+// TestProcessorWalksModelFallbacks checks the configured chain is tried in order and the model that
+// accepted the task is recorded
+func TestProcessorWalksModelFallbacks(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a fox": {noChannel: []string{"sora2-landscape-test", "sora2-landscape"}, polls: []fakePoll{{"processing", 5, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	config := &Config{DyuAPIKey: "test-key", MaxRetries: 3, ModelFallbacks: map[string][]string{
+		"sora2-landscape-test": {"sora2-landscape", "sora2-landscape-backup"},
+	}}
+	useTestConfig(t, config)
+	p.ApplyConfig(config)
+	p.client.SetBaseURL(server.URL)
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a fox", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	tick(p)
+
+	want := []string{"sora2-landscape-test", "sora2-landscape", "sora2-landscape-backup"}
+	if strings.Join(server.models, ",") != strings.Join(want, ",") {
+		t.Errorf("models submitted = %v, want %v", server.models, want)
+	}
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusProcessing || task.ModelUsed != "sora2-landscape-backup" || task.Model != ModelSora2 {
+		t.Errorf("status=%q model=%q model_used=%q", task.Status, task.Model, task.ModelUsed)
+	}
+}
+
+// TestProcessorRecordsTaskHistory checks the history written along the way and its removal with the task
+func TestProcessorRecordsTaskHistory(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a bird": {noTestChannel: true, expiredDownloads: 1, polls: []fakePoll{{"completed", 100, ""}}},
+	})
+	p := newTestProcessor(t, server)
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a bird", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	tick(p)
+	tick(p)
+
+	events, err := GetTaskEvents(created.ID)
+	if err != nil {
+		t.Fatalf("GetTaskEvents failed: %v", err)
+	}
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	want := []string{HistoryFallback, HistorySubmitted, HistoryDownloadFailed, HistoryDownloaded, HistoryCompleted}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+
+	if err := DeleteTask(created.ID); err != nil {
+		t.Fatalf("DeleteTask failed: %v", err)
+	}
+	if events, _ := GetTaskEvents(created.ID); len(events) != 0 {
+		t.Errorf("%d events left after deleting the task", len(events))
+	}
+}
+
+// TestProcessorDecoratesPrompt checks the template override replaces the global suffix in the
+// submitted prompt only, and the applied values are recorded in the history
+func TestProcessorDecoratesPrompt(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"cinematic a bird brand style": {polls: []fakePoll{{"completed", 100, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	p.currentConfig().PromptPrefix = "cinematic"
+	p.currentConfig().PromptSuffix = "4k"
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a bird", Duration: Duration10s, Orientation: OrientationLandscape, PromptSuffix: "brand style"})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	tick(p)
+	tick(p)
+
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusCompleted || task.Prompt != "a bird" || task.SubmittedPrompt != "cinematic a bird brand style" {
+		t.Fatalf("status=%q prompt=%q submitted_prompt=%q", task.Status, task.Prompt, task.SubmittedPrompt)
+	}
+	events, _ := GetTaskEvents(created.ID)
+	for _, event := range events {
+		if event.Type == HistorySubmitted && !strings.Contains(event.Detail, `prompt prefix "cinematic" and suffix "brand style" applied`) {
+			t.Errorf("submitted event detail = %q", event.Detail)
+		}
+	}
+}
+
+// TestProcessorChecksOrientation checks the dimensions read from the downloaded file are compared
+// with the requested orientation, without ffprobe
+func TestProcessorChecksOrientation(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a bird": {polls: []fakePoll{{"completed", 100, ""}}},
+	})
+	video := mp4Box("trak", mp4Box("tkhd", tkhdV0(1280, 720)))
+	moov := mp4Box("moov", append(mp4Box("mvhd", mvhdV0(600, 6000)), video...))
+	server.payload = append(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2")), moov...)
+	p := newTestProcessor(t, server)
+	t.Setenv("PATH", t.TempDir())
+
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "a bird", Duration: Duration10s, Orientation: OrientationPortrait})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	tick(p)
+	tick(p)
+
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusCompleted || task.Width != 1280 || task.Warning != WarningOrientationMismatch {
+		t.Errorf("status=%q %dx%d warning=%q", task.Status, task.Width, task.Height, task.Warning)
+	}
+}
+
+// TestProgressMilestonesKeyedByPercent checks editing webhook_milestones neither re-fires a
+// milestone already sent nor skips a new one
+func TestProgressMilestonesKeyedByPercent(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a bird": {polls: []fakePoll{{"processing", 30, ""}, {"processing", 60, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	milestones := make(chan int, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Event == WebhookEventProgress {
+			milestones <- payload.Milestone
+		}
+	}))
+	defer webhook.Close()
+	p.currentConfig().WebhookURL = webhook.URL
+	p.currentConfig().WebhookMilestones = []int{25, 75}
+
+	if _, err := CreateTask(&CreateTaskRequest{Prompt: "a bird", Duration: Duration10s, Orientation: OrientationLandscape}); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	tick(p)
+	tick(p)
+	p.currentConfig().WebhookMilestones = []int{10, 25, 50, 75}
+	tick(p)
+
+	var fired []int
+	timeout := time.After(5 * time.Second)
+	for len(fired) < 3 {
+		select {
+		case milestone := <-milestones:
+			fired = append(fired, milestone)
+		case <-timeout:
+			t.Fatalf("milestones fired: %v", fired)
+		}
+	}
+	select {
+	case milestone := <-milestones:
+		fired = append(fired, milestone)
+	case <-time.After(200 * time.Millisecond):
+	}
+	slices.Sort(fired)
+	if !slices.Equal(fired, []int{10, 25, 50}) {
+		t.Errorf("milestones fired = %v, want [10 25 50]", fired)
+	}
+}
+
+// TestProcessorWaitsForScheduledTasks checks a task scheduled in the future stays pending until its
+// schedule is cleared
+func TestProcessorWaitsForScheduledTasks(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"at night": {polls: []fakePoll{{"processing", 5, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	later := now.Add(time.Hour)
+	created, err := CreateTask(&CreateTaskRequest{Prompt: "at night", ScheduledAt: &later, Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+
+	tick(p)
+	task, _ := GetTask(created.ID)
+	if task.Status != StatusPending || len(server.models) != 0 {
+		t.Fatalf("scheduled task submitted early: status=%q", task.Status)
+	}
+	if task.ScheduledAt == nil || !task.ScheduledAt.Equal(later) {
+		t.Errorf("scheduled_at = %v, want %v", task.ScheduledAt, later)
+	}
+
+	// Still pending a minute before its time
+	now = later.Add(-time.Minute)
+	tick(p)
+	if status, _ := GetTaskStatus(created.ID); status != StatusPending {
+		t.Fatalf("scheduled task submitted a minute early: status=%q", status)
+	}
+
+	rec := httptest.NewRecorder()
+	handleUpdateTask(rec, httptest.NewRequest(http.MethodPatch, "/api/tasks/1", strings.NewReader(`{"scheduled_at":""}`)), created.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clearing the schedule: status %d %s", rec.Code, rec.Body.String())
+	}
+
+	tick(p)
+	task, _ = GetTask(created.ID)
+	if task.Status != StatusProcessing || task.ScheduledAt != nil {
+		t.Errorf("after clearing the schedule: status=%q scheduled_at=%v", task.Status, task.ScheduledAt)
+	}
+}
+
+// TestProcessorWatchdog checks processing tasks whose progress doesn't change for
+// stuck_after_minutes are failed, or requeued with auto_retry_stuck
+func TestProcessorWatchdog(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"stuck": {polls: []fakePoll{{"processing", 40, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	config := p.currentConfig()
+	config.StuckAfterMinutes = 30
+
+	var now time.Time
+	p.now = func() time.Time { return now }
+
+	stall := func() *Task {
+		now = time.Now()
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "stuck", Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		tick(p)
+		tick(p)
+		if task, _ = GetTask(task.ID); task.Status != StatusProcessing || task.Progress != 40 {
+			t.Fatalf("task %d: status %q, progress %d, want processing at 40", task.ID, task.Status, task.Progress)
+		}
+		// Progress stays at 40% for 20 minutes, then for 31
+		start := now
+		now = start.Add(20 * time.Minute)
+		tick(p)
+		if status, _ := GetTaskStatus(task.ID); status != StatusProcessing {
+			t.Fatalf("task %d is %q before stuck_after_minutes", task.ID, status)
+		}
+		now = start.Add(31 * time.Minute)
+		tick(p)
+		task, _ = GetTask(task.ID)
+		return task
+	}
+
+	if task := stall(); task.Status != StatusFailed || task.FailReason != StalledFailReason {
+		t.Errorf("stalled task: status %q, fail_reason %q", task.Status, task.FailReason)
+	}
+
+	config.AutoRetryStuck = true
+	task := stall()
+	if task.Status != StatusPending || task.TaskID != "" || task.Progress != 0 {
+		t.Errorf("stalled task with auto_retry_stuck: status %q, task_id %q, progress %d", task.Status, task.TaskID, task.Progress)
+	}
+	events, _ := GetTaskEvents(task.ID)
+	if !slices.ContainsFunc(events, func(e TaskHistoryEvent) bool { return e.Type == HistoryStalled }) {
+		t.Errorf("no stalled event in the history: %+v", events)
+	}
+}
+
+// TestProcessorLoadsImagesOnSubmit submits an image-to-video task listed without its image, the
+// image is loaded for the submission and kept in the database while the task is polled
+func TestProcessorLoadsImagesOnSubmit(t *testing.T) {
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"image": {polls: []fakePoll{{"processing", 40, ""}}},
+	})
+	p := newTestProcessor(t, server)
+
+	image := "data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG(t, 64, 64))
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "image", ImageURL: image, Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if pending, _ := GetPendingTasks(); len(pending) != 1 || pending[0].ImageURL != "" {
+		t.Fatalf("pending tasks loaded with their images: %+v", pending)
+	}
+
+	tick(p)
+	tick(p)
+	if !slices.Equal(server.images, []int{1}) {
+		t.Errorf("images sent = %v, want [1]", server.images)
+	}
+	if got, _ := GetTask(task.ID); got.Status != StatusProcessing || got.Progress != 40 || got.ImageURL != image {
+		t.Errorf("task after polling: status %s, progress %d, image kept %v", got.Status, got.Progress, got.ImageURL == image)
+	}
+}
+
+// TestPostDownloadHookRunsInWorker checks the post-download command finishes before the download
+// worker moves on, and that stopping the processor kills a running command
+func TestPostDownloadHookRunsInWorker(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	server := newFakeDyuServer(t, map[string]*fakeScenario{
+		"a quick hook": {polls: []fakePoll{{"completed", 100, ""}}},
+		"a slow hook":  {polls: []fakePoll{{"completed", 100, ""}}},
+	})
+	p := newTestProcessor(t, server)
+	config := p.currentConfig()
+	config.PostDownloadCommand = []string{"sleep", "0"}
+
+	quick, _ := CreateTask(&CreateTaskRequest{Prompt: "a quick hook", Duration: Duration10s, Orientation: OrientationLandscape})
+	tick(p)
+	tick(p)
+	events, _ := GetTaskEvents(quick.ID)
+	if len(events) == 0 || events[len(events)-1].Type != HistoryHookFinished {
+		t.Fatalf("hook not finished when the download was done: %+v", events)
+	}
+
+	config.PostDownloadCommand = []string{"sleep", "30"}
+	slow, _ := CreateTask(&CreateTaskRequest{Prompt: "a slow hook", Duration: Duration10s, Orientation: OrientationLandscape})
+	tick(p)
+	p.processPendingTasks()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if task, _ := GetTask(slow.ID); task.Status == StatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("task not completed")
+		}
+	}
+
+	start := time.Now()
+	p.cancel()
+	p.waitForDownloads()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stopping waited %v for the hook", elapsed)
+	}
+	if task, _ := GetTask(slow.ID); task.Status != StatusCompleted || task.Warning != "" {
+		t.Errorf("task after an interrupted hook: %s, warning %q", task.Status, task.Warning)
+	}
+}
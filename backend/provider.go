@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Provider names, also the keys of the providers config
+const (
+	ProviderSora = "sora"
+	ProviderVeo3 = "veo3"
+)
+
+// providerPrefixes maps the model prefixes to the built-in provider serving them
+var providerPrefixes = map[string]string{
+	"sora":    ProviderSora,
+	ModelVeo3: ProviderVeo3,
+}
+
+// ProviderConfig configures a video provider, unset values fall back to the global Dyu settings
+type ProviderConfig struct {
+	// Enabled set to false rejects new tasks of the provider's models; tasks already submitted are
+	// still polled and downloaded
+	Enabled *bool    `json:"enabled,omitempty"`
+	BaseURL string   `json:"base_url,omitempty"`
+	APIKeys []string `json:"api_keys,omitempty"`
+}
+
+// enabled reports whether the provider accepts new tasks, the default
+func (c ProviderConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// VideoProviderRequest is a generation request as handed to a provider
+type VideoProviderRequest struct {
+	Prompt      string
+	ImageURL    string
+	ImageURL2   string // Last frame, for models that support it
+	Duration    string
+	Orientation string
+	Model       string
+	Watermark   bool
+}
+
+// VideoProvider submits, polls and downloads the videos of a family of models
+type VideoProvider interface {
+	// CreateTask submits a generation task, the response ID is passed to QueryStatus
+	CreateTask(ctx context.Context, req *VideoProviderRequest) (*VectorEngineCreateResponse, error)
+	// QueryStatus returns the status of a submitted task; keyFingerprint identifies the API key
+	// the task was submitted with, "" uses the active one
+	QueryStatus(ctx context.Context, model, taskID, keyFingerprint string) (*VectorEngineQueryResponse, error)
+	// Download saves the video at videoURL into the output directory and returns its file name
+	Download(ctx context.Context, videoURL, taskID string) (string, error)
+}
+
+// dyuProvider serves models through the Dyu API client, which selects the sora or Veo3 endpoints
+// from the model
+type dyuProvider struct {
+	client *VectorEngineClient
+}
+
+func (d *dyuProvider) CreateTask(ctx context.Context, req *VideoProviderRequest) (*VectorEngineCreateResponse, error) {
+	return d.client.CreateVideoTask(ctx, req.Prompt, req.ImageURL, req.ImageURL2, req.Duration, req.Orientation, req.Model, req.Watermark)
+}
+
+func (d *dyuProvider) QueryStatus(ctx context.Context, model, taskID, keyFingerprint string) (*VectorEngineQueryResponse, error) {
+	return d.client.QueryTaskStatus(ctx, model, taskID, keyFingerprint)
+}
+
+func (d *dyuProvider) Download(ctx context.Context, videoURL, taskID string) (string, error) {
+	return d.client.DownloadVideo(ctx, videoURL, taskID)
+}
+
+// registeredProvider is a provider in the registry
+type registeredProvider struct {
+	Name     string
+	Provider VideoProvider
+	Enabled  bool
+}
+
+// ProviderRegistry resolves the provider of a task from its model, by the longest matching prefix
+type ProviderRegistry struct {
+	byPrefix map[string]*registeredProvider
+}
+
+// NewProviderRegistry creates an empty registry
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{byPrefix: make(map[string]*registeredProvider)}
+}
+
+// Register serves the models starting with prefix by provider
+func (r *ProviderRegistry) Register(prefix, name string, provider VideoProvider, enabled bool) {
+	r.byPrefix[strings.ToLower(prefix)] = &registeredProvider{Name: name, Provider: provider, Enabled: enabled}
+}
+
+// Resolve returns the provider of a model, an empty model is the default sora-2
+func (r *ProviderRegistry) Resolve(model string) (*registeredProvider, error) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if model == "" {
+		model = ModelSora2
+	}
+	var match *registeredProvider
+	matchLength := -1
+	for prefix, provider := range r.byPrefix {
+		if strings.HasPrefix(model, prefix) && len(prefix) > matchLength {
+			match, matchLength = provider, len(prefix)
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no provider for model %s", model)
+	}
+	return match, nil
+}
+
+// newProviderRegistry registers the built-in providers as configured
+// Providers without their own base URL or keys share client, the others get a client of their own
+func newProviderRegistry(config *Config, client *VectorEngineClient) *ProviderRegistry {
+	registry := NewProviderRegistry()
+	for prefix, name := range providerPrefixes {
+		providerConfig := config.Providers[name]
+		providerClient := client
+		if providerConfig.BaseURL != "" || len(providerConfig.APIKeys) > 0 {
+			providerClient = NewConfiguredClient(config)
+			if providerConfig.BaseURL != "" {
+				providerClient.SetBaseURL(providerConfig.BaseURL)
+			}
+			if keys := (&Config{DyuAPIKeys: providerConfig.APIKeys}).apiKeys(); len(keys) > 0 {
+				providerClient.SetAPIKeys(keys, apiKeyCooldown(config))
+			}
+		}
+		registry.Register(prefix, name, &dyuProvider{client: providerClient}, providerConfig.enabled())
+	}
+	return registry
+}
+
+// validateTaskModel checks that a model is served by a configured provider that accepts new tasks
+func validateTaskModel(config *Config, model string) error {
+	registry := NewProviderRegistry()
+	for prefix, name := range providerPrefixes {
+		registry.Register(prefix, name, nil, config.Providers[name].enabled())
+	}
+	provider, err := registry.Resolve(model)
+	if err != nil {
+		return fmt.Errorf("model %s is not supported", model)
+	}
+	if !provider.Enabled {
+		return fmt.Errorf("provider %s of model %s is disabled", provider.Name, model)
+	}
+	return nil
+}
+
+// validateProviders checks the providers section of the config
+func validateProviders(providers map[string]ProviderConfig) error {
+	known := make(map[string]bool)
+	var names []string
+	for _, name := range providerPrefixes {
+		known[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for name, provider := range providers {
+		if !known[name] {
+			return fmt.Errorf("unknown provider %q, must be one of %s", name, strings.Join(names, ", "))
+		}
+		if provider.BaseURL != "" {
+			if err := validateBaseURL(provider.BaseURL); err != nil {
+				return fmt.Errorf("base_url of provider %s %v", name, err)
+			}
+		}
+	}
+	return nil
+}
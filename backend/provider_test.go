@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// mockProvider is a VideoProvider completing each task after a number of polls
+type mockProvider struct {
+	mu      sync.Mutex
+	polls   map[string]int
+	created []VideoProviderRequest
+	fail    string // Fail reason reported by every poll when set
+}
+
+func (m *mockProvider) CreateTask(ctx context.Context, req *VideoProviderRequest) (*VectorEngineCreateResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.created = append(m.created, *req)
+	id := fmt.Sprintf("mock_%d", len(m.created))
+	m.polls[id] = 0
+	return &VectorEngineCreateResponse{ID: id, Model: req.Model}, nil
+}
+
+func (m *mockProvider) QueryStatus(ctx context.Context, model, taskID, keyFingerprint string) (*VectorEngineQueryResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != "" {
+		return &VectorEngineQueryResponse{Status: "failed", FailReason: m.fail}, nil
+	}
+	m.polls[taskID]++
+	if m.polls[taskID] < 2 {
+		return &VectorEngineQueryResponse{Status: "processing", Progress: 50}, nil
+	}
+	return &VectorEngineQueryResponse{Status: "completed", Progress: 100, VideoURL: "mock://" + taskID}, nil
+}
+
+func (m *mockProvider) Download(ctx context.Context, videoURL, taskID string) (string, error) {
+	if err := EnsureOutputDirectory(); err != nil {
+		return "", err
+	}
+	filename := taskID + ".mp4"
+	return filename, os.WriteFile(filepath.Join(OutputDirectory(), filename), fakeMP4(1024), 0644)
+}
+
+func TestProviderRegistryResolve(t *testing.T) {
+	registry := NewProviderRegistry()
+	registry.Register("sora", "sora", nil, true)
+	registry.Register("sora2-pro", "pro", nil, false)
+	registry.Register(ModelVeo3, ProviderVeo3, nil, true)
+
+	for model, want := range map[string]string{"": "sora", "sora-2": "sora", "SORA2-PRO-x": "pro", "veo3-fast": ProviderVeo3} {
+		provider, err := registry.Resolve(model)
+		if err != nil || provider.Name != want {
+			t.Errorf("Resolve(%q) = %v, %v, want %s", model, provider, err, want)
+		}
+	}
+	if _, err := registry.Resolve("kling-1"); err == nil {
+		t.Error("unknown model resolved")
+	}
+
+	disabled := false
+	config := &Config{Providers: map[string]ProviderConfig{ProviderVeo3: {Enabled: &disabled}}}
+	if err := validateTaskModel(config, "veo3"); err == nil {
+		t.Error("model of a disabled provider accepted")
+	}
+	if err := validateTaskModel(config, "sora-2"); err != nil {
+		t.Errorf("sora-2 rejected: %v", err)
+	}
+	if err := validateProviders(map[string]ProviderConfig{"runway": {}}); err == nil {
+		t.Error("unknown provider accepted in config")
+	}
+}
+
+// TestProcessorWithMockProvider drives tasks through a provider registered for the mock models
+func TestProcessorWithMockProvider(t *testing.T) {
+	p := newTestProcessor(t, newFakeDyuServer(t, nil))
+	mock := &mockProvider{polls: make(map[string]int)}
+	p.providers.Register("mock", "mock", mock, true)
+	p.providers.Register("off", "off", mock, false)
+
+	done, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Model: "mock-1", Duration: Duration10s, Orientation: OrientationPortrait, Watermark: true})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	disabled, err := CreateTask(&CreateTaskRequest{Prompt: "a dog", Model: "off-1", Duration: Duration10s, Orientation: OrientationPortrait})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		tick(p)
+	}
+
+	task, _ := GetTask(done.ID)
+	if task.Status != StatusCompleted || task.LocalPath != "mock_1.mp4" || task.TaskID != "mock_1" {
+		t.Errorf("mock task: status %q, task_id %q, local_path %q (%s)", task.Status, task.TaskID, task.LocalPath, task.FailReason)
+	}
+	if len(mock.created) != 1 || !mock.created[0].Watermark || mock.created[0].Orientation != OrientationPortrait {
+		t.Errorf("submitted %+v", mock.created)
+	}
+	if task, _ := GetTask(disabled.ID); task.Status != StatusFailed || task.FailReason != "provider off is disabled" {
+		t.Errorf("task of a disabled provider: status %q, fail_reason %q", task.Status, task.FailReason)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultGenerationEstimate is the assumed generation time before any task has completed
+	DefaultGenerationEstimate = 5 * time.Minute
+
+	// generationSampleSize is the number of recently completed tasks averaged for queue estimates
+	generationSampleSize = 20
+)
+
+// QueueState is the submission queue as seen by the processor, used to estimate when pending tasks start
+type QueueState struct {
+	Order          []int64             // Pending tasks in submission order
+	ScheduledAt    map[int64]time.Time // Pending tasks waiting for their scheduled time
+	InFlight       int                 // Tasks submitting or processing at the provider
+	Slots          int                 // max_concurrent_tasks, 0 is unlimited
+	AverageRuntime time.Duration       // Average generation time of recently completed tasks
+}
+
+// GetQueueState reads the pending queue in the order GetPendingTasks returns it, the in-flight
+// count and the average generation time
+func GetQueueState(slots int) (*QueueState, error) {
+	state := &QueueState{ScheduledAt: make(map[int64]time.Time), Slots: slots}
+
+	rows, err := ReadDB.Query(`SELECT id, scheduled_at FROM tasks WHERE status = ?
+		ORDER BY COALESCE(priority, 0) DESC, created_at ASC`, StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending tasks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var scheduledAt sql.NullTime
+		if err := rows.Scan(&id, &scheduledAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pending task: %w", err)
+		}
+		state.Order = append(state.Order, id)
+		if scheduledAt.Valid {
+			state.ScheduledAt[id] = scheduledAt.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = ReadDB.QueryRow("SELECT COUNT(*) FROM tasks WHERE status IN (?, ?)", StatusSubmitting, StatusProcessing).
+		Scan(&state.InFlight)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count in-flight tasks: %w", err)
+	}
+
+	if state.AverageRuntime, err = averageGenerationTime(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// averageGenerationTime averages the time from submission to completion of the last completed
+// tasks, read from their history; DefaultGenerationEstimate when there is none
+func averageGenerationTime() (time.Duration, error) {
+	rows, err := ReadDB.Query(`SELECT c.task_id, c.created_at, s.created_at
+		FROM task_events c JOIN task_events s ON s.task_id = c.task_id AND s.event_type = ? AND s.id < c.id
+		WHERE c.event_type = ? ORDER BY c.id DESC, s.id DESC LIMIT ?`,
+		HistorySubmitted, HistoryCompleted, generationSampleSize*4)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query generation times: %w", err)
+	}
+	defer rows.Close()
+
+	// Rows come newest submission first per completion, so the first row of a task is its last run
+	seen := make(map[int64]bool)
+	var total time.Duration
+	var count int
+	for rows.Next() && count < generationSampleSize {
+		var taskID int64
+		var completedAt, submittedAt time.Time
+		if err := rows.Scan(&taskID, &completedAt, &submittedAt); err != nil {
+			return 0, fmt.Errorf("failed to scan generation time: %w", err)
+		}
+		if seen[taskID] {
+			continue
+		}
+		seen[taskID] = true
+		total += completedAt.Sub(submittedAt)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return DefaultGenerationEstimate, nil
+	}
+	return total / time.Duration(count), nil
+}
+
+// QueueEstimate is the queue position and estimated wait of a pending task
+type QueueEstimate struct {
+	Position int           // Pending tasks submitted before this one
+	Wait     time.Duration // Estimated wait until the task is submitted
+}
+
+// Estimates returns the estimate of every pending task
+// Tasks ahead that wait for their schedule don't count, a scheduled task waits at least until its time
+func (q *QueueState) Estimates(now time.Time) map[int64]QueueEstimate {
+	estimates := make(map[int64]QueueEstimate, len(q.Order))
+	position := 0
+	for _, id := range q.Order {
+		var wait time.Duration
+		// With n slots the tasks ahead and in flight leave in waves of n, each taking about the average runtime
+		if q.Slots > 0 && q.InFlight+position >= q.Slots {
+			waves := (q.InFlight+position-q.Slots)/q.Slots + 1
+			wait = time.Duration(waves) * q.AverageRuntime
+		}
+
+		scheduledAt, scheduled := q.ScheduledAt[id]
+		if scheduled && scheduledAt.After(now) {
+			if scheduledAt.Sub(now) > wait {
+				wait = scheduledAt.Sub(now)
+			}
+		}
+		estimates[id] = QueueEstimate{Position: position, Wait: wait}
+		if !scheduled || !scheduledAt.After(now) {
+			position++
+		}
+	}
+	return estimates
+}
+
+// attachQueueEstimates sets queue_position and eta_seconds on the pending tasks
+// Estimates are informational, a failure is logged and leaves the tasks without them
+func attachQueueEstimates(tasks []Task) {
+	pending := false
+	for i := range tasks {
+		if tasks[i].Status == StatusPending {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return
+	}
+
+	state, err := GetQueueState(CurrentConfig().MaxConcurrentTasks)
+	if err != nil {
+		log.Printf("Failed to estimate queue positions: %v", err)
+		return
+	}
+	estimates := state.Estimates(time.Now())
+	for i := range tasks {
+		estimate, ok := estimates[tasks[i].ID]
+		if !ok || tasks[i].Status != StatusPending {
+			continue
+		}
+		eta := int64(estimate.Wait.Seconds())
+		tasks[i].QueuePosition = &estimate.Position
+		tasks[i].ETASeconds = &eta
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueueStateEstimates(t *testing.T) {
+	now := time.Now()
+	queue := &QueueState{
+		Order:          []int64{1, 2, 3, 4, 5},
+		ScheduledAt:    map[int64]time.Time{2: now.Add(2 * time.Hour), 4: now.Add(-time.Minute)},
+		InFlight:       1,
+		Slots:          2,
+		AverageRuntime: 10 * time.Minute,
+	}
+	want := map[int64]QueueEstimate{
+		1: {0, 0},                // A slot is free
+		2: {1, 2 * time.Hour},    // Waits for its schedule, doesn't hold up the others
+		3: {1, 10 * time.Minute}, // Both slots taken, starts after the first wave
+		4: {2, 10 * time.Minute}, // Schedule has passed
+		5: {3, 20 * time.Minute},
+	}
+	estimates := queue.Estimates(now)
+	for id, estimate := range want {
+		if estimates[id] != estimate {
+			t.Errorf("task %d: %+v, want %+v", id, estimates[id], estimate)
+		}
+	}
+
+	queue.Slots = 0
+	if estimate := queue.Estimates(now)[5]; estimate.Wait != 0 {
+		t.Errorf("unlimited concurrency: wait %v, want 0", estimate.Wait)
+	}
+}
+
+// TestListedTasksHaveQueueEstimates checks only pending tasks get a position and an ETA, based on
+// the recorded generation times
+func TestListedTasksHaveQueueEstimates(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "queue.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{MaxConcurrentTasks: 1})
+
+	done, _ := CreateTask(&CreateTaskRequest{Prompt: "done", Duration: Duration10s, Orientation: OrientationLandscape})
+	DB.Exec("UPDATE tasks SET status = ? WHERE id = ?", StatusCompleted, done.ID)
+	submitted := time.Now().Add(-time.Hour)
+	DB.Exec("INSERT INTO task_events (task_id, event_type, created_at) VALUES (?, ?, ?), (?, ?, ?)",
+		done.ID, HistorySubmitted, submitted, done.ID, HistoryCompleted, submitted.Add(4*time.Minute))
+	for _, prompt := range []string{"first", "second"} {
+		CreateTask(&CreateTaskRequest{Prompt: prompt, Duration: Duration10s, Orientation: OrientationLandscape})
+	}
+
+	code, resp := getTasks(t, "sort=created_at&order=asc")
+	if code != http.StatusOK || len(resp.Tasks) != 3 {
+		t.Fatalf("list: status %d, %d tasks", code, len(resp.Tasks))
+	}
+	if resp.Tasks[0].QueuePosition != nil || resp.Tasks[0].ETASeconds != nil {
+		t.Errorf("completed task has a queue estimate")
+	}
+	for i, want := range []struct {
+		position int
+		eta      int64
+	}{{0, 0}, {1, 240}} {
+		task := resp.Tasks[i+1]
+		if task.QueuePosition == nil || *task.QueuePosition != want.position || task.ETASeconds == nil || *task.ETASeconds != want.eta {
+			t.Errorf("%s: position %v eta %v, want %d and %d", task.Prompt, task.QueuePosition, task.ETASeconds, want.position, want.eta)
+		}
+	}
+}
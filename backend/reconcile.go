@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// ReconcileInterval is the interval between automatic reconciliation passes
+	ReconcileInterval = time.Hour
+	// DefaultReconcileLookback is how far back reconciliation looks when reconcile_lookback_hours is not set
+	DefaultReconcileLookback = 48 * time.Hour
+
+	// reconcileStartDelay postpones the first pass so it doesn't compete with startup work
+	reconcileStartDelay = time.Minute
+)
+
+// reconcileLookback returns how far back reconciliation re-queries tasks and characters
+func reconcileLookback(config *Config) time.Duration {
+	if config.ReconcileLookbackHours > 0 {
+		return time.Duration(config.ReconcileLookbackHours) * time.Hour
+	}
+	return DefaultReconcileLookback
+}
+
+// ReconcileResult summarizes a reconciliation pass
+type ReconcileResult struct {
+	TasksChecked        int      `json:"tasks_checked"`
+	TasksCorrected      int      `json:"tasks_corrected"`
+	CharactersChecked   int      `json:"characters_checked"`
+	CharactersCorrected int      `json:"characters_corrected"`
+	Errors              int      `json:"errors"`
+	Corrections         []string `json:"corrections,omitempty"`
+}
+
+// reconcileLoop runs a reconciliation pass every ReconcileInterval until the processor stops
+func (p *TaskProcessor) reconcileLoop() {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(reconcileStartDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-timer.C:
+			result := p.Reconcile(p.ctx)
+			if result.TasksCorrected > 0 || result.CharactersCorrected > 0 || result.Errors > 0 {
+				log.Printf("Reconciliation: corrected %d/%d tasks and %d/%d characters, %d errors",
+					result.TasksCorrected, result.TasksChecked, result.CharactersCorrected, result.CharactersChecked, result.Errors)
+			}
+			timer.Reset(ReconcileInterval)
+		}
+	}
+}
+
+// Reconcile re-queries the provider for recently failed tasks and unfinished characters and
+// corrects local state where the provider disagrees, e.g. a task marked failed after transient
+// poll errors whose remote job completed
+// Tasks the provider reports completed or still running go back to processing, so the regular
+// polling picks them up and downloads the video
+func (p *TaskProcessor) Reconcile(ctx context.Context) *ReconcileResult {
+	result := &ReconcileResult{}
+	since := time.Now().Add(-reconcileLookback(p.currentConfig()))
+
+	correct := func(action, detail string) {
+		result.Corrections = append(result.Corrections, detail)
+		if err := RecordAudit(action, detail); err != nil {
+			log.Printf("Failed to record reconciliation: %v", err)
+		}
+	}
+
+	tasks, err := GetFailedTasksSince(since)
+	if err != nil {
+		log.Printf("Reconciliation: failed to load failed tasks: %v", err)
+		result.Errors++
+	}
+	for i := range tasks {
+		if ctx.Err() != nil {
+			return result
+		}
+		task := &tasks[i]
+		result.TasksChecked++
+
+		resp, err := p.queryTaskStatus(ctx, task)
+		if err != nil {
+			log.Printf("Reconciliation: failed to query task %d: %v", task.ID, err)
+			result.Errors++
+			continue
+		}
+
+		remoteFailReason := resp.FailReason
+		if resp.Error != nil && remoteFailReason == "" {
+			remoteFailReason = resp.Error.Message
+		}
+		previousReason := task.FailReason
+
+		switch {
+		case remoteFailReason == "" && resp.Status != "failed" && resp.Status != "error" && resp.Status != "FAILURE":
+			// Completed or still running remotely, hand the task back to polling
+			task.Status = StatusProcessing
+			task.FailReason = ""
+			task.FailureCode = ""
+			task.Progress = resp.Progress
+			if resp.VideoURL != "" {
+				task.VideoURL = resp.VideoURL
+			}
+			if p.saveTransition(task, StatusFailed, fieldsDownload) {
+				result.TasksCorrected++
+				RecordTaskEvent(task.ID, HistoryReconciled, fmt.Sprintf("failed -> processing, provider status %q", resp.Status))
+				correct("tasks.reconcile", fmt.Sprintf("task %d (%s): failed -> processing, provider status %q (was: %s)",
+					task.ID, task.TaskID, resp.Status, previousReason))
+			}
+		case remoteFailReason != "" && remoteFailReason != task.FailReason:
+			task.FailReason = remoteFailReason
+			task.FailureCode = remoteFailureCode(remoteFailReason)
+			if err := p.updateTask(task, fieldsStatus); err != nil {
+				log.Printf("Reconciliation: failed to update task %d: %v", task.ID, err)
+				result.Errors++
+				continue
+			}
+			result.TasksCorrected++
+			RecordTaskEvent(task.ID, HistoryReconciled, fmt.Sprintf("fail_reason %q -> %q", previousReason, remoteFailReason))
+			correct("tasks.reconcile", fmt.Sprintf("task %d (%s): fail_reason %q -> %q",
+				task.ID, task.TaskID, previousReason, remoteFailReason))
+		}
+	}
+
+	characters, err := GetAllCharacters()
+	if err != nil {
+		log.Printf("Reconciliation: failed to load characters: %v", err)
+		result.Errors++
+	}
+	for i := range characters {
+		if ctx.Err() != nil {
+			return result
+		}
+		char := &characters[i]
+		if char.Status == StatusCompleted || char.Status == StatusFailed || char.ApiCharacterID == "" || char.CreatedAt.Before(since) {
+			continue
+		}
+		result.CharactersChecked++
+
+		status, changed, err := refreshCharacterStatus(ctx, p.client, char)
+		if err != nil {
+			log.Printf("Reconciliation: failed to query character %d: %v", char.ID, err)
+			result.Errors++
+			continue
+		}
+		if changed && status.Status != char.Status {
+			result.CharactersCorrected++
+			correct("characters.reconcile", fmt.Sprintf("character %d (%s): %s -> %s",
+				char.ID, char.ApiCharacterID, char.Status, status.Status))
+		}
+	}
+
+	return result
+}
+
+// handleReconcile handles POST /api/maintenance/reconcile
+// Starts a reconciliation pass as a background job
+func handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !taskProcessor.IsRunning() {
+		writeError(w, http.StatusServiceUnavailable, "Task processor is not running")
+		return
+	}
+
+	job := StartJob("reconcile", func(job *JobHandle) (interface{}, error) {
+		return taskProcessor.Reconcile(taskProcessor.ctx), nil
+	})
+
+	writeJSON(w, http.StatusAccepted, job)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+)
+
+// attachFileExists sets file_exists on the tasks with a downloaded video
+// A stat per task is cheap enough for a page of tasks
+func attachFileExists(tasks []Task) {
+	for i := range tasks {
+		if tasks[i].LocalPath == "" {
+			continue
+		}
+		_, err := os.Stat(ResolveVideoPath(tasks[i].LocalPath))
+		exists := err == nil
+		tasks[i].FileExists = &exists
+	}
+}
+
+// handleRedownloadTask handles POST /api/tasks/:id/redownload
+// Downloads again the video of a completed task whose file was deleted from the output directory
+func handleRedownloadTask(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if taskProcessor == nil {
+		writeError(w, http.StatusServiceUnavailable, "Task processor not available")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for redownload: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Status != StatusCompleted {
+		writeError(w, http.StatusConflict, "Only completed tasks can be re-downloaded")
+		return
+	}
+	if task.VideoURL == "" {
+		writeError(w, http.StatusConflict, "Task has no video URL to download from")
+		return
+	}
+	if task.LocalPath != "" {
+		if _, err := os.Stat(ResolveVideoPath(task.LocalPath)); err == nil {
+			writeError(w, http.StatusConflict, "The video file still exists")
+			return
+		}
+	}
+
+	if err := taskProcessor.Redownload(r.Context(), task); err != nil {
+		log.Printf("Failed to download video of task %d again: %v", id, err)
+		var spaceErr *DiskSpaceError
+		switch {
+		case isExpiredVideo(err):
+			writeError(w, http.StatusConflict, "The video URL has expired and the provider no longer serves this video, it can't be downloaded again")
+		case errors.As(err, &spaceErr):
+			writeError(w, http.StatusInsufficientStorage, spaceErr.Error())
+		default:
+			writeError(w, http.StatusBadGateway, "Failed to download video: "+err.Error())
+		}
+		return
+	}
+	if err := UpdateTask(task); err != nil {
+		log.Printf("Failed to update task %d after redownload: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to update task")
+		return
+	}
+	PublishTaskUpdate(task)
+
+	tasks := []Task{*task}
+	attachFileExists(tasks)
+	writeJSON(w, http.StatusOK, tasks[0])
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schemaColumn describes a column as reported by pragma_table_info
+type schemaColumn struct {
+	Name    string
+	Type    string
+	NotNull bool
+	Default sql.NullString
+}
+
+// schemaTable describes a table and the SQL that creates it
+type schemaTable struct {
+	Name    string
+	SQL     string
+	Columns []schemaColumn
+}
+
+// column returns the column with the given name, or nil
+func (t *schemaTable) column(name string) *schemaColumn {
+	for i := range t.Columns {
+		if strings.EqualFold(t.Columns[i].Name, name) {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// dbSchema is a snapshot of the tables and indexes of a database
+type dbSchema struct {
+	Tables  map[string]*schemaTable
+	Indexes map[string]string // index name -> CREATE INDEX statement
+}
+
+// RepairAction is one fix applied by the repair mode
+type RepairAction struct {
+	Description string
+	Statements  []string
+}
+
+// readSchema inspects the tables, columns and indexes of db
+func readSchema(db *sql.DB) (*dbSchema, error) {
+	schema := &dbSchema{Tables: make(map[string]*schemaTable), Indexes: make(map[string]string)}
+
+	// The search index and its shadow tables are managed by its migration and setupTaskSearch, not by repair
+	rows, err := db.Query(`SELECT type, name, COALESCE(sql, '') FROM sqlite_master
+		WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%' AND name NOT LIKE '` + taskSearchTable + `%'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	for rows.Next() {
+		var objType, name, createSQL string
+		if err := rows.Scan(&objType, &name, &createSQL); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan schema: %w", err)
+		}
+		if objType == "table" {
+			schema.Tables[name] = &schemaTable{Name: name, SQL: createSQL}
+		} else if createSQL != "" {
+			schema.Indexes[name] = createSQL
+		}
+	}
+	rows.Close()
+
+	for name, table := range schema.Tables {
+		colRows, err := db.Query("SELECT name, type, \"notnull\", dflt_value FROM pragma_table_info(?)", name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+		}
+		for colRows.Next() {
+			var col schemaColumn
+			if err := colRows.Scan(&col.Name, &col.Type, &col.NotNull, &col.Default); err != nil {
+				colRows.Close()
+				return nil, fmt.Errorf("failed to scan columns of %s: %w", name, err)
+			}
+			table.Columns = append(table.Columns, col)
+		}
+		colRows.Close()
+	}
+	return schema, nil
+}
+
+// CanonicalSchema returns the schema a fresh database gets from InitDB
+func CanonicalSchema() (*dbSchema, error) {
+	dir, err := os.MkdirTemp("", "videogen-schema")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := InitDB(filepath.Join(dir, "canonical.db")); err != nil {
+		return nil, err
+	}
+	defer CloseDB()
+	return readSchema(DB)
+}
+
+// sortedTableNames returns the table names of a schema in a stable order
+func sortedTableNames(schema *dbSchema) []string {
+	names := make([]string, 0, len(schema.Tables))
+	for name := range schema.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// columnDefinition renders a column for ALTER TABLE ADD COLUMN
+func columnDefinition(col schemaColumn) string {
+	def := col.Name + " " + col.Type
+	if col.NotNull {
+		def += " NOT NULL"
+	}
+	if col.Default.Valid {
+		def += " DEFAULT " + col.Default.String
+	}
+	return def
+}
+
+// rebuildTableStatements recreates a table with the canonical definition, copying the columns both share
+// sources maps canonical columns to SQL expressions over the old table for columns that were renamed
+func rebuildTableStatements(canonical *schemaTable, actual *schemaTable, sources map[string]string) []string {
+	tmpName := canonical.Name + "_repair"
+	createSQL := strings.Replace(canonical.SQL, canonical.Name, tmpName, 1)
+	createSQL = strings.Replace(createSQL, "IF NOT EXISTS ", "", 1)
+
+	var columns, values []string
+	for _, col := range canonical.Columns {
+		expr, ok := sources[col.Name]
+		if !ok {
+			if actual.column(col.Name) == nil {
+				continue
+			}
+			expr = col.Name
+		}
+		// NOT NULL columns without a default must get a value even when the old data has none
+		if col.NotNull && !col.Default.Valid {
+			if strings.Contains(strings.ToUpper(col.Type), "INT") {
+				expr = "COALESCE(" + expr + ", 0)"
+			} else {
+				expr = "COALESCE(" + expr + ", '')"
+			}
+		}
+		columns = append(columns, col.Name)
+		values = append(values, expr)
+	}
+
+	return []string{
+		createSQL,
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmpName, strings.Join(columns, ", "), strings.Join(values, ", "), canonical.Name),
+		"DROP TABLE " + canonical.Name,
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmpName, canonical.Name),
+	}
+}
+
+// junkTaskRowsCondition matches the probe rows inserted by the UNIQUE constraint check of old builds
+// Tasks created with the same prompt are kept: the probes left image_url NULL and the created_at
+// default of SQLite, while tasks are inserted with an image_url and a timestamp written by the driver
+const junkTaskRowsCondition = `prompt IN ('test', 'test2') AND COALESCE(task_id, '') = '' AND duration = '10s'
+	AND orientation = 'landscape' AND COALESCE(status, 'pending') = 'pending' AND COALESCE(progress, 0) = 0
+	AND COALESCE(video_url, '') = '' AND COALESCE(local_path, '') = '' AND image_url IS NULL
+	AND created_at = updated_at AND created_at GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9] [0-9][0-9]:[0-9][0-9]:[0-9][0-9]'`
+
+// PlanRepair compares the actual schema of db with the canonical one and returns the fixes to apply
+// Discrepancies that are left alone (e.g. unknown extra columns) are returned as notes
+func PlanRepair(db *sql.DB, canonical *dbSchema) ([]RepairAction, []string, error) {
+	actual, err := readSchema(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var actions []RepairAction
+	var notes []string
+	rebuilt := false // Rebuilding a table drops its indexes
+
+	// Tables left behind by an interrupted rebuild migration
+	for _, name := range sortedTableNames(canonical) {
+		leftover, ok := actual.Tables[name+"_new"]
+		if !ok {
+			continue
+		}
+		if _, exists := actual.Tables[name]; exists {
+			actions = append(actions, RepairAction{
+				Description: fmt.Sprintf("drop %s left over from an interrupted migration", leftover.Name),
+				Statements:  []string{"DROP TABLE " + leftover.Name},
+			})
+		} else {
+			actions = append(actions, RepairAction{
+				Description: fmt.Sprintf("rename %s left over from an interrupted migration to %s", leftover.Name, name),
+				Statements:  []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", leftover.Name, name)},
+			})
+			leftover.Name = name
+			actual.Tables[name] = leftover
+		}
+		delete(actual.Tables, name+"_new")
+	}
+
+	for _, name := range sortedTableNames(canonical) {
+		want := canonical.Tables[name]
+		have, ok := actual.Tables[name]
+		if !ok {
+			actions = append(actions, RepairAction{
+				Description: "create missing table " + name,
+				Statements:  []string{want.SQL},
+			})
+			continue
+		}
+
+		// Characters table stuck on the pre-training schema (api_id instead of api_character_id)
+		if name == "characters" && have.column("api_id") != nil && have.column("api_character_id") == nil {
+			sources := map[string]string{
+				"api_character_id": "api_id",
+				"source_type":      "'task'",
+				"status":           "'completed'",
+				"progress":         "100",
+			}
+			if have.column("from_task_id") != nil {
+				sources["source_value"] = "from_task_id"
+			}
+			actions = append(actions, RepairAction{
+				Description: "migrate characters table from the legacy schema",
+				Statements:  rebuildTableStatements(want, have, sources),
+			})
+			rebuilt = true
+			continue
+		}
+
+		var missing []schemaColumn
+		needsRebuild := false
+		for _, col := range want.Columns {
+			if have.column(col.Name) == nil {
+				missing = append(missing, col)
+				if col.NotNull && !col.Default.Valid {
+					needsRebuild = true
+				}
+			}
+		}
+		for _, col := range have.Columns {
+			if want.column(col.Name) == nil {
+				notes = append(notes, fmt.Sprintf("%s.%s is not part of the expected schema, left untouched", name, col.Name))
+			}
+		}
+
+		if needsRebuild {
+			actions = append(actions, RepairAction{
+				Description: fmt.Sprintf("rebuild table %s to add required columns", name),
+				Statements:  rebuildTableStatements(want, have, nil),
+			})
+			rebuilt = true
+			continue
+		}
+		for _, col := range missing {
+			actions = append(actions, RepairAction{
+				Description: fmt.Sprintf("add missing column %s.%s", name, col.Name),
+				Statements:  []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", name, columnDefinition(col))},
+			})
+		}
+	}
+
+	// Probe rows left by the old migration check
+	if _, ok := actual.Tables["tasks"]; ok {
+		var junk int
+		if err := db.QueryRow("SELECT COUNT(*) FROM tasks WHERE " + junkTaskRowsCondition).Scan(&junk); err == nil && junk > 0 {
+			actions = append(actions, RepairAction{
+				Description: fmt.Sprintf("remove %d leftover migration test rows from tasks", junk),
+				Statements:  []string{"DELETE FROM tasks WHERE " + junkTaskRowsCondition},
+			})
+		}
+	}
+
+	// Indexes are recreated after the tables they belong to have been fixed
+	indexNames := make([]string, 0, len(canonical.Indexes))
+	for name := range canonical.Indexes {
+		indexNames = append(indexNames, name)
+	}
+	sort.Strings(indexNames)
+	var indexStatements []string
+	for _, name := range indexNames {
+		if rebuilt || actual.Indexes[name] != canonical.Indexes[name] {
+			indexStatements = append(indexStatements, "DROP INDEX IF EXISTS "+name, canonical.Indexes[name])
+		}
+	}
+	if len(actions) > 0 || len(indexStatements) > 0 {
+		actions = append(actions, RepairAction{
+			Description: "rebuild indexes",
+			Statements:  append(indexStatements, "REINDEX"),
+		})
+	}
+
+	return actions, notes, nil
+}
+
+// ApplyRepair runs the repair actions in a single transaction
+func ApplyRepair(db *sql.DB, actions []RepairAction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, action := range actions {
+		for _, stmt := range action.Statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("%s: %w", action.Description, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// BackupDatabase writes a consistent copy of db next to dbPath and returns its path
+func BackupDatabase(db *sql.DB, dbPath string) (string, error) {
+	backupPath := fmt.Sprintf("%s.repair-%s.bak", dbPath, time.Now().Format("20060102-150405"))
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+	return backupPath, nil
+}
+
+// RunRepair inspects the database at dbPath, prints the discrepancies and, unless dryRun is set,
+// backs the database up and applies the fixes
+func RunRepair(dbPath string, dryRun bool, out io.Writer) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database not found: %w", err)
+	}
+
+	canonical, err := CanonicalSchema()
+	if err != nil {
+		return fmt.Errorf("failed to build expected schema: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return &SchemaTooNewError{Version: version, Supported: SchemaVersion}
+	}
+
+	actions, notes, err := PlanRepair(db, canonical)
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		fmt.Fprintf(out, "note: %s\n", note)
+	}
+	if len(actions) == 0 {
+		fmt.Fprintln(out, "Database schema is healthy, nothing to repair")
+		return nil
+	}
+	fmt.Fprintf(out, "Found %d problems:\n", len(actions))
+	for i, action := range actions {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, action.Description)
+		for _, stmt := range action.Statements {
+			fmt.Fprintf(out, "       %s\n", strings.Join(strings.Fields(stmt), " "))
+		}
+	}
+	if dryRun {
+		fmt.Fprintln(out, "Dry run, no changes made")
+		return nil
+	}
+
+	backupPath, err := BackupDatabase(db, dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Backup written to %s\n", backupPath)
+
+	if err := ApplyRepair(db, actions); err != nil {
+		return fmt.Errorf("repair failed, database unchanged: %w", err)
+	}
+	fmt.Fprintln(out, "Repair completed")
+	return nil
+}
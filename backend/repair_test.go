@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Known-bad databases produced by the old probe-based migrations
+var brokenDatabaseFixtures = map[string][]string{
+	// Early tasks table without image_url2/fail_reason, with the UNIQUE probe rows left behind
+	"old tasks table with junk rows": {
+		`CREATE TABLE tasks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, task_id TEXT UNIQUE, prompt TEXT NOT NULL, image_url TEXT,
+			duration TEXT NOT NULL, orientation TEXT NOT NULL, status TEXT DEFAULT 'pending',
+			progress INTEGER DEFAULT 0, video_url TEXT, local_path TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO tasks (task_id, prompt, duration, orientation, status, local_path) VALUES ('abc', 'keep me', '15s', 'portrait', 'completed', 'a.mp4')`,
+		`INSERT INTO tasks (task_id, prompt, duration, orientation) VALUES ('', 'test', '10s', 'landscape')`,
+		`INSERT INTO tasks (task_id, prompt, duration, orientation) VALUES (NULL, 'test2', '10s', 'landscape')`,
+		// A pending task the user created with the same prompt
+		`INSERT INTO tasks (prompt, image_url, duration, orientation, created_at, updated_at)
+			VALUES ('test', '', '10s', 'landscape', '2025-03-01 10:00:00.123456789+08:00', '2025-03-01 10:00:00.123456789+08:00')`,
+	},
+	// Characters migration failed half way: old schema with the username/avatar columns added on top
+	"characters stuck between schemas": {
+		`CREATE TABLE characters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, api_id TEXT, api_username TEXT, profile_picture_url TEXT,
+			permalink TEXT, from_task_id TEXT, local_picture_path TEXT, custom_name TEXT NOT NULL,
+			description TEXT, timestamps TEXT NOT NULL, created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			username TEXT, avatar_url TEXT)`,
+		`INSERT INTO characters (api_id, from_task_id, custom_name, timestamps) VALUES ('char_1', 'task_1', '小明', '1,3')`,
+		`INSERT INTO characters (api_id, custom_name, timestamps) VALUES ('char_2', 'orphan', '0,2')`,
+	},
+	// Interrupted UNIQUE removal: tasks was dropped but tasks_new never renamed
+	"leftover tasks_new": {
+		`CREATE TABLE tasks_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, task_id TEXT, prompt TEXT NOT NULL, image_url TEXT,
+			duration TEXT NOT NULL, orientation TEXT NOT NULL, model TEXT DEFAULT 'sora-2',
+			status TEXT DEFAULT 'pending', progress INTEGER DEFAULT 0, video_url TEXT, local_path TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, image_url2 TEXT)`,
+		`INSERT INTO tasks_new (prompt, duration, orientation) VALUES ('keep me', '10s', 'portrait')`,
+	},
+}
+
+// columnSignature summarizes the columns of every table for comparison
+func columnSignature(schema *dbSchema) map[string]string {
+	signature := make(map[string]string)
+	for name, table := range schema.Tables {
+		var cols []string
+		for _, col := range table.Columns {
+			cols = append(cols, strings.ToLower(col.Name)+" "+strings.ToUpper(col.Type))
+		}
+		sort.Strings(cols)
+		signature[name] = strings.Join(cols, ", ")
+	}
+	return signature
+}
+
+// TestRunRepairFixtures repairs each known-bad database and checks that it ends up with the canonical schema
+func TestRunRepairFixtures(t *testing.T) {
+	canonical, err := CanonicalSchema()
+	if err != nil {
+		t.Fatalf("CanonicalSchema failed: %v", err)
+	}
+	want := columnSignature(canonical)
+
+	for name, statements := range brokenDatabaseFixtures {
+		t.Run(name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "broken.db")
+			db, err := sql.Open("sqlite", dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, stmt := range statements {
+				if _, err := db.Exec(stmt); err != nil {
+					t.Fatalf("failed to build fixture: %v", err)
+				}
+			}
+			db.Close()
+
+			// Dry run reports problems without changing anything
+			var out bytes.Buffer
+			if err := RunRepair(dbPath, true, &out); err != nil {
+				t.Fatalf("dry run failed: %v", err)
+			}
+			if !strings.Contains(out.String(), "Dry run") {
+				t.Fatalf("unexpected dry run output:\n%s", out.String())
+			}
+
+			out.Reset()
+			if err := RunRepair(dbPath, false, &out); err != nil {
+				t.Fatalf("repair failed: %v\n%s", err, out.String())
+			}
+			matches, _ := filepath.Glob(dbPath + ".repair-*.bak")
+			if len(matches) != 1 {
+				t.Errorf("expected a backup file, found %v", matches)
+			}
+
+			db, err = sql.Open("sqlite", dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			got, err := readSchema(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotSignature := columnSignature(got)
+			for table, cols := range want {
+				if gotSignature[table] != cols {
+					t.Errorf("table %s:\n got  %s\n want %s", table, gotSignature[table], cols)
+				}
+			}
+			for index := range canonical.Indexes {
+				if _, ok := got.Indexes[index]; !ok {
+					t.Errorf("missing index %s", index)
+				}
+			}
+
+			var junk int
+			db.QueryRow("SELECT COUNT(*) FROM tasks WHERE prompt IN ('test', 'test2') AND image_url IS NULL").Scan(&junk)
+			if junk != 0 {
+				t.Errorf("junk rows were not removed")
+			}
+
+			// A second run finds nothing left to do
+			out.Reset()
+			if err := RunRepair(dbPath, true, &out); err != nil || !strings.Contains(out.String(), "nothing to repair") {
+				t.Errorf("second run: err=%v output:\n%s", err, out.String())
+			}
+		})
+	}
+}
+
+// TestRepairKeepsData checks that rebuilt tables keep their rows
+func TestRepairKeepsData(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "broken.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range brokenDatabaseFixtures["characters stuck between schemas"] {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	if err := RunRepair(dbPath, false, &bytes.Buffer{}); err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if err := InitDB(dbPath); err != nil {
+		t.Fatalf("InitDB after repair failed: %v", err)
+	}
+	defer CloseDB()
+
+	characters, err := GetAllCharactersSorted(CharacterSortName)
+	if err != nil {
+		t.Fatalf("failed to read characters: %v", err)
+	}
+	if len(characters) != 2 {
+		t.Fatalf("expected 2 characters, got %d", len(characters))
+	}
+	for _, char := range characters {
+		if char.CustomName == "小明" && (char.ApiCharacterID != "char_1" || char.SourceValue != "task_1" || char.Status != StatusCompleted) {
+			t.Errorf("character not migrated correctly: %+v", char)
+		}
+	}
+}
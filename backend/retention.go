@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// RetentionSweepInterval is the interval between automatic retention sweeps
+	RetentionSweepInterval = 24 * time.Hour
+
+	// retentionStartDelay postpones the first sweep so it doesn't compete with startup work
+	retentionStartDelay = 5 * time.Minute
+)
+
+// CleanupTask is a task removed, or to be removed on a dry run, by a retention sweep
+type CleanupTask struct {
+	ID        int64     `json:"id"`
+	Status    string    `json:"status"`
+	Prompt    string    `json:"prompt"`
+	LocalPath string    `json:"local_path,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CleanupResult summarizes a retention sweep
+type CleanupResult struct {
+	DryRun         bool          `json:"dry_run"`
+	Tasks          []CleanupTask `json:"tasks"`
+	Deleted        int           `json:"deleted"`
+	BytesReclaimed int64         `json:"bytes_reclaimed"`
+	SkippedStarred int           `json:"skipped_starred"`
+}
+
+// retentionPolicies returns the statuses with a retention period and how old their tasks may get
+// Cancelled tasks are kept as long as failed ones, a period of 0 keeps tasks forever
+func retentionPolicies(config *Config) map[string]time.Duration {
+	policies := make(map[string]time.Duration)
+	if config.FailedTaskRetentionDays > 0 {
+		policies[StatusFailed] = time.Duration(config.FailedTaskRetentionDays) * 24 * time.Hour
+		policies[StatusCancelled] = policies[StatusFailed]
+	}
+	if config.CompletedTaskRetentionDays > 0 {
+		policies[StatusCompleted] = time.Duration(config.CompletedTaskRetentionDays) * 24 * time.Hour
+	}
+	return policies
+}
+
+// videoFileSize returns the disk space used by a task video, including the untrimmed original
+func videoFileSize(localPath string) int64 {
+	var size int64
+	for _, file := range []string{ResolveVideoPath(localPath), ResolveVideoPath(localPath + OriginalVideoSuffix)} {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// RunRetentionSweep deletes the tasks, and their videos, not updated within the retention period of
+// their status; starred tasks are kept unless force is set
+// With dryRun nothing is deleted and the result lists what would be removed
+func RunRetentionSweep(config *Config, dryRun, force bool) (*CleanupResult, error) {
+	result := &CleanupResult{DryRun: dryRun, Tasks: []CleanupTask{}}
+	policies := retentionPolicies(config)
+	if len(policies) == 0 {
+		return result, nil
+	}
+
+	statuses := make([]string, 0, len(policies))
+	for status := range policies {
+		statuses = append(statuses, status)
+	}
+	tasks, err := GetTasks(TaskFilter{Statuses: statuses, SortBy: "updated_at", Ascending: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// updated_at is compared in Go, the stored time strings don't compare reliably in SQL
+	now := time.Now()
+	var expired []Task
+	for _, task := range tasks {
+		if now.Sub(task.UpdatedAt) > policies[task.Status] {
+			expired = append(expired, task)
+		}
+	}
+	expired, result.SkippedStarred = excludeStarred(expired, force)
+
+	var ids []int64
+	for _, task := range expired {
+		var size int64
+		if task.LocalPath != "" {
+			size = videoFileSize(task.LocalPath)
+		}
+		result.Tasks = append(result.Tasks, CleanupTask{
+			ID:        task.ID,
+			Status:    task.Status,
+			Prompt:    task.Prompt,
+			LocalPath: task.LocalPath,
+			Bytes:     size,
+			UpdatedAt: task.UpdatedAt,
+		})
+		result.BytesReclaimed += size
+		ids = append(ids, task.ID)
+	}
+	if dryRun || len(ids) == 0 {
+		return result, nil
+	}
+
+	results, localPaths, err := BulkDeleteTasks(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, localPath := range localPaths {
+		if err := DeleteVideoFile(localPath); err != nil {
+			log.Printf("Warning: failed to delete video file: %v", err)
+		}
+	}
+	for _, deleted := range results {
+		if deleted.Success {
+			result.Deleted++
+		}
+	}
+	return result, nil
+}
+
+// retentionLoop runs a retention sweep every RetentionSweepInterval until the processor stops
+func (p *TaskProcessor) retentionLoop() {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(retentionStartDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-timer.C:
+			result, err := RunRetentionSweep(p.currentConfig(), false, false)
+			if err != nil {
+				log.Printf("Retention sweep failed: %v", err)
+			} else if result.Deleted > 0 {
+				log.Printf("Retention sweep: deleted %d tasks, reclaimed %.1f MB",
+					result.Deleted, float64(result.BytesReclaimed)/(1024*1024))
+			}
+			timer.Reset(RetentionSweepInterval)
+		}
+	}
+}
+
+// handleCleanup handles POST /api/cleanup
+// Runs a retention sweep now; dry_run=true returns what would be removed, force=true also removes
+// starred tasks
+func handleCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	result, err := RunRetentionSweep(CurrentConfig(), query.Get("dry_run") == "true", query.Get("force") == "true")
+	if err != nil {
+		log.Printf("Cleanup failed: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to clean up tasks")
+		return
+	}
+	if result.Deleted > 0 {
+		log.Printf("Cleanup: deleted %d tasks, reclaimed %.1f MB", result.Deleted, float64(result.BytesReclaimed)/(1024*1024))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRunRetentionSweep checks that only expired, unstarred tasks are removed with their videos
+func TestRunRetentionSweep(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "retention.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	old := time.Now().AddDate(0, 0, -10)
+	ids := make(map[string]int64)
+	for _, task := range []struct {
+		name, status, localPath string
+		updatedAt               time.Time
+		starred                 bool
+	}{
+		{"old failed", StatusFailed, "", old, false},
+		{"old starred", StatusFailed, "", old, true},
+		{"recent failed", StatusFailed, "", time.Now(), false},
+		{"old completed", StatusCompleted, "old.mp4", old, false},
+		{"old pending", StatusPending, "", old, false},
+	} {
+		created, err := CreateTask(&CreateTaskRequest{Prompt: task.name, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, local_path = ?, updated_at = ?, starred = ? WHERE id = ?",
+			task.status, task.localPath, task.updatedAt, task.starred, created.ID)
+		ids[task.name] = created.ID
+	}
+	video := filepath.Join(OutputDirectory(), "old.mp4")
+	os.WriteFile(video, make([]byte, 2048), 0644)
+
+	if result, err := RunRetentionSweep(&Config{}, false, false); err != nil || len(result.Tasks) != 0 {
+		t.Fatalf("sweep without retention settings: %+v, %v", result, err)
+	}
+
+	config := &Config{FailedTaskRetentionDays: 7, CompletedTaskRetentionDays: 7}
+	result, err := RunRetentionSweep(config, true, false)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(result.Tasks) != 2 || result.Deleted != 0 || result.BytesReclaimed != 2048 || result.SkippedStarred != 1 {
+		t.Fatalf("dry run = %+v", result)
+	}
+	if task, _ := GetTask(ids["old failed"]); task == nil {
+		t.Fatal("dry run deleted a task")
+	}
+
+	result, err = RunRetentionSweep(config, false, false)
+	if err != nil || result.Deleted != 2 {
+		t.Fatalf("sweep = %+v, %v", result, err)
+	}
+	for name, id := range ids {
+		task, _ := GetTask(id)
+		if deleted := name == "old failed" || name == "old completed"; deleted != (task == nil) {
+			t.Errorf("%s: deleted = %v, want %v", name, task == nil, deleted)
+		}
+	}
+	if _, err := os.Stat(video); !os.IsNotExist(err) {
+		t.Error("video of the deleted task is still on disk")
+	}
+}
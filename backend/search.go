@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// taskSearchTable is the FTS5 index over task prompts, kept in sync with tasks by triggers
+// The trigram tokenizer matches substrings, so CJK prompts without spaces are searchable too
+const taskSearchTable = "tasks_fts"
+
+// minTrigramTermLength is the shortest term the trigram index can match, shorter terms use LIKE
+const minTrigramTermLength = 3
+
+// taskSearchAvailable reports whether the FTS5 index exists; searches fall back to LIKE otherwise
+var taskSearchAvailable bool
+
+// taskSearchTriggers keep the external content index in sync on insert, delete and prompt updates
+var taskSearchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS tasks_fts_ai AFTER INSERT ON tasks BEGIN
+		INSERT INTO tasks_fts (rowid, prompt) VALUES (new.id, COALESCE(new.prompt, ''));
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS tasks_fts_ad AFTER DELETE ON tasks BEGIN
+		INSERT INTO tasks_fts (tasks_fts, rowid, prompt) VALUES ('delete', old.id, COALESCE(old.prompt, ''));
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS tasks_fts_au AFTER UPDATE OF prompt ON tasks BEGIN
+		INSERT INTO tasks_fts (tasks_fts, rowid, prompt) VALUES ('delete', old.id, COALESCE(old.prompt, ''));
+		INSERT INTO tasks_fts (rowid, prompt) VALUES (new.id, COALESCE(new.prompt, ''));
+	END`,
+}
+
+// taskSearchFallbackSetting is the app_settings key recording why the search index migration fell
+// back to LIKE, set when SQLite lacks FTS5 or its trigram tokenizer
+const taskSearchFallbackSetting = "task_search_fallback"
+
+// createTaskSearch creates the prompt search index and its triggers, and indexes the existing prompts
+// Without FTS5 the migration still applies, recording the fallback to LIKE searches
+func createTaskSearch(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(
+		prompt, content='tasks', content_rowid='id', tokenize='trigram')`)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module") && !strings.Contains(err.Error(), "no such tokenizer") {
+			return fmt.Errorf("failed to create %s: %w", taskSearchTable, err)
+		}
+		log.Printf("Full-text search unavailable, searching prompts with LIKE: %v", err)
+		_, err = tx.Exec(`
+			INSERT INTO app_settings (key, value) VALUES (?, ?)
+			ON CONFLICT(key) DO UPDATE SET value = excluded.value`, taskSearchFallbackSetting, err.Error())
+		return err
+	}
+	return rebuildTaskSearch(tx)
+}
+
+// rebuildTaskSearch creates the missing triggers of the search index and reindexes every prompt
+func rebuildTaskSearch(tx *sql.Tx) error {
+	if err := execStatements(taskSearchTriggers...)(tx); err != nil {
+		return fmt.Errorf("failed to create search triggers: %w", err)
+	}
+	if _, err := tx.Exec("INSERT INTO tasks_fts (tasks_fts) VALUES ('rebuild')"); err != nil {
+		return fmt.Errorf("failed to build search index: %w", err)
+	}
+	return nil
+}
+
+// setupTaskSearch enables the prompt search index once the migrations have run
+// Rebuilding the tasks table with --repair drops its triggers, they're recreated and the index
+// rebuilt when missing
+func setupTaskSearch() error {
+	taskSearchAvailable = false
+	var tables, triggers int
+	err := DB.QueryRow(`SELECT COUNT(*) FILTER (WHERE type = 'table' AND name = ?),
+		COUNT(*) FILTER (WHERE type = 'trigger' AND name LIKE 'tasks_fts_%')
+		FROM sqlite_master`, taskSearchTable).Scan(&tables, &triggers)
+	if err != nil {
+		return fmt.Errorf("failed to check the search index: %w", err)
+	}
+	if tables == 0 {
+		log.Println("Full-text search unavailable, searching prompts with LIKE")
+		return nil
+	}
+
+	if triggers < len(taskSearchTriggers) {
+		tx, err := DB.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to start transaction: %w", err)
+		}
+		defer tx.Rollback()
+		if err := rebuildTaskSearch(tx); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit search index: %w", err)
+		}
+		log.Println("Prompt search index rebuilt")
+	}
+	taskSearchAvailable = true
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards of s for use with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// taskSearchCondition returns a WHERE condition matching tasks whose prompt contains every
+// whitespace separated term of query, case-insensitively
+func taskSearchCondition(query string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, term := range strings.Fields(query) {
+		if taskSearchAvailable && utf8.RuneCountInString(term) >= minTrigramTermLength {
+			// Quoted as an FTS5 string so operators and punctuation in the term are literal
+			conditions = append(conditions, "id IN (SELECT rowid FROM tasks_fts WHERE tasks_fts MATCH ?)")
+			args = append(args, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+		} else {
+			conditions = append(conditions, `prompt LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
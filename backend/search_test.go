@@ -0,0 +1,130 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSearchTasks(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "search.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	if !taskSearchAvailable {
+		t.Log("FTS5 unavailable, testing the LIKE fallback")
+	}
+
+	ids := make(map[string]int64)
+	for _, prompt := range []string{
+		"A red dragon flying over the castle",
+		"一条红色的巨龙在城堡上空飞翔",
+		"Blue whale, 100% real",
+		"red panda eating bamboo",
+	} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: prompt, Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		ids[prompt] = task.ID
+	}
+
+	search := func(q string) []int64 {
+		t.Helper()
+		tasks, total, err := GetTasksPaginated(TaskFilter{Search: q}, 10, 0)
+		if err != nil {
+			t.Fatalf("search %q failed: %v", q, err)
+		}
+		if total != len(tasks) {
+			t.Errorf("search %q: total %d, got %d tasks", q, total, len(tasks))
+		}
+		var found []int64
+		for _, task := range tasks {
+			found = append(found, task.ID)
+		}
+		return found
+	}
+
+	cases := []struct {
+		q    string
+		want int
+	}{
+		{"red dragon", 1},
+		{"RED", 2},
+		{"巨龙", 1},   // Two CJK characters, shorter than a trigram
+		{"城堡上空", 1}, // CJK substring
+		{"100%", 1}, // LIKE wildcard taken literally
+		{"dragon panda", 0},
+		{`"castle`, 0},
+	}
+	for _, tc := range cases {
+		if got := search(tc.q); len(got) != tc.want {
+			t.Errorf("search %q found %d tasks, want %d", tc.q, len(got), tc.want)
+		}
+	}
+
+	// The index follows prompt edits and deletions
+	dragonID := ids["A red dragon flying over the castle"]
+	if _, err := UpdateTaskFields(dragonID, map[string]interface{}{"prompt": "A green serpent"}); err != nil {
+		t.Fatalf("UpdateTaskFields failed: %v", err)
+	}
+	if got := search("dragon"); len(got) != 0 {
+		t.Errorf("edited prompt still found: %v", got)
+	}
+	if got := search("serpent"); len(got) != 1 || got[0] != dragonID {
+		t.Errorf("search serpent = %v, want [%d]", got, dragonID)
+	}
+	if err := DeleteTask(ids["red panda eating bamboo"]); err != nil {
+		t.Fatalf("DeleteTask failed: %v", err)
+	}
+	if got := search("bamboo"); len(got) != 0 {
+		t.Errorf("deleted task still found: %v", got)
+	}
+
+	// Pagination applies to the matches
+	page, total, err := GetTasksPaginated(TaskFilter{Search: "a"}, 1, 1)
+	if err != nil || len(page) != 1 || total != 2 {
+		t.Errorf("paginated search: %d tasks, total %d, err %v", len(page), total, err)
+	}
+}
+
+// TestTaskSearchRestoredAfterRebuild checks the index is created by its migration, and its triggers
+// recreated with the index rebuilt on the next start when a rebuild of tasks dropped them
+func TestTaskSearchRestoredAfterRebuild(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "search.db")
+	if err := InitDB(dbPath); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	if !taskSearchAvailable {
+		CloseDB()
+		t.Skip("FTS5 unavailable")
+	}
+	var applied int
+	DB.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = 40").Scan(&applied)
+	if applied != 1 {
+		t.Errorf("search index migration not recorded")
+	}
+
+	// Dropped triggers miss the prompts written meanwhile
+	for _, trigger := range []string{"tasks_fts_ai", "tasks_fts_ad", "tasks_fts_au"} {
+		DB.Exec("DROP TRIGGER " + trigger)
+	}
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "a lighthouse at dusk", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	CloseDB()
+
+	if err := InitDB(dbPath); err != nil {
+		t.Fatalf("second InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	var triggers int
+	DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'tasks_fts_%'").Scan(&triggers)
+	if triggers != len(taskSearchTriggers) {
+		t.Errorf("%d search triggers after restart, want %d", triggers, len(taskSearchTriggers))
+	}
+	tasks, _, err := GetTasksPaginated(TaskFilter{Search: "lighthouse"}, 10, 0)
+	if err != nil || len(tasks) != 1 || tasks[0].ID != task.ID {
+		t.Errorf("search after the index rebuild = %+v, %v", tasks, err)
+	}
+}
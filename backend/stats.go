@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// StatsResponse represents the response of GET /api/stats
+type StatsResponse struct {
+	Tasks              *TaskStats       `json:"tasks"`
+	CharactersByStatus map[string]int64 `json:"characters_by_status"`
+	OutputBytes        int64            `json:"output_bytes"` // Size of everything in the output directory
+	// RateLimitedSubmissions counts the submissions answered with 429 since startup, submissions are
+	// held until RateLimitedUntil when the API asked to wait
+	RateLimitedSubmissions int64      `json:"rate_limited_submissions"`
+	RateLimitedUntil       *time.Time `json:"rate_limited_until,omitempty"`
+	// FreeDiskBytes is the free space of the output volume, LowDiskSpace is set while it is below
+	// min_free_space_mb and downloads are paused
+	FreeDiskBytes uint64 `json:"free_disk_bytes"`
+	LowDiskSpace  bool   `json:"low_disk_space"`
+}
+
+// startOfDay returns midnight of the day of t
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns midnight of the Monday of the week of t
+func startOfWeek(t time.Time) time.Time {
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -daysSinceMonday)
+}
+
+// directorySize returns the total size of the files below dir
+func directorySize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// handleStats handles GET /api/stats
+// Returns task counts by status and model, tasks created today and this week, characters by
+// status, the disk usage of the output directory and the free space of its volume, computed with
+// grouped queries
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	now := time.Now()
+	taskStats, err := GetTaskStats(now.Format("2006-01-02"), startOfWeek(now).Format("2006-01-02"))
+	if err != nil {
+		log.Printf("Failed to get task stats: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get stats")
+		return
+	}
+	characters, err := GetCharacterStatusCounts()
+	if err != nil {
+		log.Printf("Failed to get character stats: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get stats")
+		return
+	}
+
+	resp := StatsResponse{
+		Tasks:              taskStats,
+		CharactersByStatus: characters,
+		OutputBytes:        directorySize(OutputDirectory()),
+	}
+	if info, err := GetStorageInfo(); err != nil {
+		log.Printf("Failed to get storage info: %v", err)
+	} else {
+		resp.FreeDiskBytes = info.FreeBytes
+		resp.LowDiskSpace = info.LowSpace
+	}
+	if taskProcessor != nil {
+		resp.RateLimitedSubmissions, resp.RateLimitedUntil = taskProcessor.RateLimitStats()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleTaskCounts handles GET /api/tasks/counts
+// Returns the task counts by status and the tasks created today, for badges polled every few seconds
+func handleTaskCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	counts, err := GetTaskCounts(time.Now().Format("2006-01-02"))
+	if err != nil {
+		log.Printf("Failed to get task counts: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task counts")
+		return
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
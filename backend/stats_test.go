@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetTaskStats(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "stats.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	now := time.Now()
+	create := func(status, model string, createdAt time.Time) {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "p", Duration: Duration10s, Orientation: OrientationLandscape, Model: model})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, created_at = ? WHERE id = ?", status, createdAt, task.ID)
+	}
+	create(StatusCompleted, "", now)
+	create(StatusCompleted, "veo3", now.AddDate(0, 0, -30))
+	create(StatusFailed, ModelSora2, now)
+	create(StatusFailed, ModelSora2, startOfWeek(now).Add(time.Minute))
+
+	stats, err := GetTaskStats(now.Format("2006-01-02"), startOfWeek(now).Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("GetTaskStats failed: %v", err)
+	}
+	if stats.Total != 4 || stats.ByStatus[StatusCompleted] != 2 || stats.ByStatus[StatusFailed] != 2 {
+		t.Errorf("status counts = %v (total %d)", stats.ByStatus, stats.Total)
+	}
+	if stats.ByModel[ModelSora2] != 3 || stats.ByModel["veo3"] != 1 {
+		t.Errorf("model counts = %v", stats.ByModel)
+	}
+	// The task created early on Monday is from today on Mondays
+	wantToday := int64(2)
+	if startOfWeek(now).Equal(startOfDay(now)) {
+		wantToday = 3
+	}
+	if stats.CreatedToday != wantToday || stats.CreatedThisWeek != 3 {
+		t.Errorf("created today = %d, this week = %d, want %d and 3", stats.CreatedToday, stats.CreatedThisWeek, wantToday)
+	}
+}
+
+func TestStartOfWeek(t *testing.T) {
+	sunday := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
+	if got, want := startOfWeek(sunday), time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("startOfWeek(Sunday) = %v, want %v", got, want)
+	}
+	monday := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	if got := startOfWeek(monday); !got.Equal(monday) {
+		t.Errorf("startOfWeek(Monday) = %v, want %v", got, monday)
+	}
+}
+
+// TestGetTaskCounts counts tasks by status and those created today through the status index,
+// without scanning the table
+func TestGetTaskCounts(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "counts.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+
+	now := time.Now()
+	for _, tc := range []struct {
+		status    string
+		createdAt time.Time
+	}{
+		{StatusProcessing, now},
+		{StatusProcessing, now.AddDate(0, 0, -1)},
+		{StatusFailed, now},
+		{StatusCompleted, now.AddDate(0, 0, -3)},
+	} {
+		task, err := CreateTask(&CreateTaskRequest{Prompt: "p", Duration: Duration10s, Orientation: OrientationLandscape})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		DB.Exec("UPDATE tasks SET status = ?, created_at = ? WHERE id = ?", tc.status, tc.createdAt, task.ID)
+	}
+
+	counts, err := GetTaskCounts(now.Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("GetTaskCounts failed: %v", err)
+	}
+	if counts.Total != 4 || counts.ByStatus[StatusProcessing] != 2 || counts.ByStatus[StatusFailed] != 1 || counts.ByStatus[StatusCompleted] != 1 {
+		t.Errorf("counts = %v (total %d)", counts.ByStatus, counts.Total)
+	}
+	if counts.CreatedToday != 2 {
+		t.Errorf("created today = %d, want 2", counts.CreatedToday)
+	}
+
+	var plan strings.Builder
+	rows, err := DB.Query("EXPLAIN QUERY PLAN SELECT status, COUNT(*), SUM(created_at >= ?) FROM tasks GROUP BY status", "2026-01-01")
+	if err != nil {
+		t.Fatalf("EXPLAIN failed: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		rows.Scan(&id, &parent, &unused, &detail)
+		plan.WriteString(detail + "\n")
+	}
+	if !strings.Contains(plan.String(), "COVERING INDEX idx_tasks_status") {
+		t.Errorf("query plan doesn't use a status index:\n%s", plan.String())
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultVideoSizeEstimate is the assumed size of a video before any has been downloaded
+const DefaultVideoSizeEstimate = 20 * 1024 * 1024
+
+// StorageInfo describes the disk usage of the output directory
+type StorageInfo struct {
+	OutputDir         string `json:"output_dir"`
+	FreeBytes         uint64 `json:"free_bytes"`
+	TotalBytes        uint64 `json:"total_bytes"`
+	VideoCount        int    `json:"video_count"`
+	VideoBytes        int64  `json:"video_bytes"`
+	AverageVideoBytes int64  `json:"average_video_bytes"`
+	MinFreeBytes      uint64 `json:"min_free_bytes"`
+	LowSpace          bool   `json:"low_space"` // Free space is below min_free_space_mb, downloads are paused
+}
+
+// HealthResponse represents the response of GET /api/health
+type HealthResponse struct {
+	Status           string `json:"status"` // ok, or degraded when something needs attention
+	Database         bool   `json:"database"`
+	ProcessorRunning bool   `json:"processor_running"`
+	ProcessorPaused  bool   `json:"processor_paused"`
+	FreeBytes        uint64 `json:"free_bytes"`
+	LowDiskSpace     bool   `json:"low_disk_space"`
+}
+
+// minFreeBytes returns the configured minimum free space, 0 when disabled
+func minFreeBytes(config *Config) uint64 {
+	if config == nil || config.MinFreeSpaceMB <= 0 {
+		return 0
+	}
+	return uint64(config.MinFreeSpaceMB) * 1024 * 1024
+}
+
+// formatMB renders a byte count in megabytes
+func formatMB(bytes uint64) string {
+	return fmt.Sprintf("%.0f MB", float64(bytes)/1024/1024)
+}
+
+// formatSize renders a byte count in gigabytes, or megabytes below 1 GB, for fail reasons
+func formatSize(bytes uint64) string {
+	if bytes >= 1<<30 {
+		return fmt.Sprintf("%.1fGB", float64(bytes)/(1<<30))
+	}
+	return fmt.Sprintf("%.0fMB", float64(bytes)/(1<<20))
+}
+
+// DiskSpaceError is returned when a video doesn't fit in the free space of the output volume
+// Retrying doesn't help, the task fails until space is freed
+type DiskSpaceError struct {
+	Needed uint64 // Size of the video still to download plus min_free_space_mb
+	Free   uint64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("磁盘空间不足 (需要 %s, 剩余 %s)", formatSize(e.Needed), formatSize(e.Free))
+}
+
+// checkDiskSpace returns a *DiskSpaceError when size more bytes would leave less than
+// min_free_space_mb free on the output volume, nil when they fit or the free space can't be read
+func checkDiskSpace(size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	free, _, err := diskSpace(OutputDirectory())
+	if err != nil {
+		return nil
+	}
+	needed := uint64(size) + minFreeBytes(CurrentConfig())
+	if needed > free {
+		return &DiskSpaceError{Needed: needed, Free: free}
+	}
+	return nil
+}
+
+// outputVideoSizes returns the number and total size of the videos in the output directory
+func outputVideoSizes() (int, int64) {
+	entries, err := os.ReadDir(OutputDirectory())
+	if err != nil {
+		return 0, 0
+	}
+	count := 0
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".mp4") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		count++
+		total += info.Size()
+	}
+	return count, total
+}
+
+// averageVideoSize estimates the size of a new video from the videos already downloaded
+func averageVideoSize(count int, total int64) int64 {
+	if count == 0 || total == 0 {
+		return DefaultVideoSizeEstimate
+	}
+	return total / int64(count)
+}
+
+// GetStorageInfo reports the free space of the output volume and the size of the downloaded videos
+func GetStorageInfo() (*StorageInfo, error) {
+	free, total, err := diskSpace(OutputDirectory())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read free space of %s: %w", OutputDirectory(), err)
+	}
+	count, videoBytes := outputVideoSizes()
+	minFree := minFreeBytes(CurrentConfig())
+	return &StorageInfo{
+		OutputDir:         OutputDirectory(),
+		FreeBytes:         free,
+		TotalBytes:        total,
+		VideoCount:        count,
+		VideoBytes:        videoBytes,
+		AverageVideoBytes: averageVideoSize(count, videoBytes),
+		MinFreeBytes:      minFree,
+		LowSpace:          minFree > 0 && free < minFree,
+	}, nil
+}
+
+// BatchSpaceWarning returns a warning when count new videos of the average size likely don't fit
+// in the free space left above min_free_space_mb, or "" when they do or the space can't be read
+// The estimate is rough, it only has to catch batches that are clearly too big
+func BatchSpaceWarning(count int) string {
+	info, err := GetStorageInfo()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return ""
+	}
+	needed := uint64(info.AverageVideoBytes) * uint64(count)
+	if needed+info.MinFreeBytes <= info.FreeBytes {
+		return ""
+	}
+	return fmt.Sprintf("low disk space: %d videos need about %s but only %s is free in %s",
+		count, formatMB(needed), formatMB(info.FreeBytes), info.OutputDir)
+}
+
+// hasDownloadSpace reports whether downloads may proceed under the min_free_space_mb threshold
+func hasDownloadSpace(config *Config) bool {
+	minFree := minFreeBytes(config)
+	if minFree == 0 {
+		return true
+	}
+	free, _, err := diskSpace(OutputDirectory())
+	if err != nil {
+		// Don't block downloads because the free space can't be read
+		return true
+	}
+	return free >= minFree
+}
+
+// handleStorage handles GET /api/storage
+func handleStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	info, err := GetStorageInfo()
+	if err != nil {
+		log.Printf("Failed to get storage info: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get storage info")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleHealth handles GET /api/health
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	resp := HealthResponse{Status: "ok", Database: DB != nil && DB.Ping() == nil}
+	if taskProcessor != nil {
+		resp.ProcessorRunning = taskProcessor.IsRunning()
+		resp.ProcessorPaused = taskProcessor.IsPaused()
+	}
+	if info, err := GetStorageInfo(); err != nil {
+		log.Printf("Failed to get storage info: %v", err)
+	} else {
+		resp.FreeBytes = info.FreeBytes
+		resp.LowDiskSpace = info.LowSpace
+	}
+	if !resp.Database || resp.LowDiskSpace {
+		resp.Status = "degraded"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
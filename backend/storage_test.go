@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBatchSpaceWarning checks the batch estimate and the download threshold against the real volume
+func TestBatchSpaceWarning(t *testing.T) {
+	previousDir, previousConfig := OutputDirectory(), CurrentConfig()
+	defer func() {
+		setOutputDirectory(previousDir)
+		setCurrentConfig(previousConfig)
+	}()
+	setOutputDirectory(t.TempDir())
+	os.WriteFile(filepath.Join(OutputDirectory(), "a.mp4"), make([]byte, 1000), 0644)
+	os.WriteFile(filepath.Join(OutputDirectory(), "b.mp4"), make([]byte, 3000), 0644)
+
+	config := DefaultConfig()
+	setCurrentConfig(config)
+	info, err := GetStorageInfo()
+	if err != nil {
+		t.Fatalf("GetStorageInfo failed: %v", err)
+	}
+	if info.VideoCount != 2 || info.AverageVideoBytes != 2000 || info.FreeBytes == 0 {
+		t.Errorf("unexpected storage info: %+v", info)
+	}
+	if warning := BatchSpaceWarning(4); warning != "" {
+		t.Errorf("unexpected warning for a small batch: %s", warning)
+	}
+	if !hasDownloadSpace(config) {
+		t.Errorf("downloads must not be blocked without a threshold")
+	}
+
+	// A threshold larger than any disk
+	config.MinFreeSpaceMB = 1 << 40
+	if warning := BatchSpaceWarning(1); warning == "" {
+		t.Errorf("expected a warning when free space is below the threshold")
+	}
+	if hasDownloadSpace(config) {
+		t.Errorf("downloads must pause below the threshold")
+	}
+}
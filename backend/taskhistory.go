@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Task history event types stored in task_events
+const (
+	HistorySubmitted      = "submitted"
+	HistorySubmitFailed   = "submit_failed"
+	HistoryFallback       = "fallback"
+	HistoryRemoteFailed   = "remote_failed"
+	HistoryCompleted      = "completed"
+	HistoryFailed         = "failed" // Failed locally after the download, e.g. strict orientation check
+	HistoryDownloadFailed = "download_failed"
+	HistoryURLRefreshed   = "url_refreshed"
+	HistoryDownloaded     = "downloaded"
+	HistoryHookFinished   = "hook_finished"
+	HistoryHookFailed     = "hook_failed"
+	HistoryCancelled      = "cancelled"
+	HistoryRetried        = "retried"
+	HistoryReconciled     = "reconciled"
+	HistoryStalled        = "stalled" // No progress for stuck_after_minutes, failed or requeued by the watchdog
+)
+
+// TaskHistoryEvent is an entry of the persisted history of a task
+// Unlike TaskEvent, which is pushed to live subscribers, history events are kept with the task
+type TaskHistoryEvent struct {
+	ID        int64     `json:"id"`
+	TaskID    int64     `json:"task_id"`
+	Type      string    `json:"event_type"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"timestamp"`
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordTaskEvent appends an event to the history of a task through db or a transaction
+// History is informational, a failed write is logged and never fails the operation recorded
+func recordTaskEvent(db execer, taskID int64, eventType, detail string) {
+	_, err := db.Exec("INSERT INTO task_events (task_id, event_type, detail, created_at) VALUES (?, ?, ?, ?)",
+		taskID, eventType, detail, time.Now())
+	if err != nil {
+		log.Printf("Failed to record %s event for task %d: %v", eventType, taskID, err)
+	}
+}
+
+// RecordTaskEvent appends an event to the history of a task
+func RecordTaskEvent(taskID int64, eventType, detail string) {
+	recordTaskEvent(DB, taskID, eventType, detail)
+}
+
+// GetTaskEvents returns the history of a task, oldest first
+func GetTaskEvents(taskID int64) ([]TaskHistoryEvent, error) {
+	rows, err := ReadDB.Query(`SELECT id, task_id, event_type, COALESCE(detail, ''), created_at
+		FROM task_events WHERE task_id = ? ORDER BY id`, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []TaskHistoryEvent{}
+	for rows.Next() {
+		var event TaskHistoryEvent
+		if err := rows.Scan(&event.ID, &event.TaskID, &event.Type, &event.Detail, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan task event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// handleTaskEvents handles GET /api/tasks/:id/events
+func handleTaskEvents(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for events: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task events")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	events, err := GetTaskEvents(id)
+	if err != nil {
+		log.Printf("Failed to get events of task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task events")
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// TaskDebugResponse represents the response of GET /api/tasks/:id/debug
+type TaskDebugResponse struct {
+	ID              int64  `json:"id"`
+	TaskID          string `json:"task_id,omitempty"`
+	Status          string `json:"status"`
+	Model           string `json:"model"`
+	ModelUsed       string `json:"model_used,omitempty"`
+	FailReason      string `json:"fail_reason,omitempty"`
+	LastAPIResponse string `json:"last_api_response"` // Raw upstream body of the last failure, capped at MaxAPIResponseBytes
+}
+
+// handleTaskDebug handles GET /api/tasks/:id/debug
+// Returns the raw upstream response stored when the task failed, alongside its parsed fail_reason
+func handleTaskDebug(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for debug: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	response, err := GetTaskAPIResponse(id)
+	if err != nil {
+		log.Printf("Failed to get API response of task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	writeJSON(w, http.StatusOK, TaskDebugResponse{
+		ID:              task.ID,
+		TaskID:          task.TaskID,
+		Status:          task.Status,
+		Model:           task.Model,
+		ModelUsed:       task.ModelUsed,
+		FailReason:      task.FailReason,
+		LastAPIResponse: response,
+	})
+}
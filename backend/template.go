@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxTemplateNameLength is the maximum number of characters of a template name
+const MaxTemplateNameLength = 50
+
+// templatePlaceholderPattern matches {{name}} markers, spaces around the name are allowed
+var templatePlaceholderPattern = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// TemplatePlaceholders returns the distinct placeholder names of a template body in order of appearance
+func TemplatePlaceholders(body string) []string {
+	placeholders := []string{}
+	seen := make(map[string]bool)
+	for _, match := range templatePlaceholderPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			placeholders = append(placeholders, match[1])
+		}
+	}
+	return placeholders
+}
+
+// RenderTemplate replaces the placeholders of body with their variables
+// Returns the rendered text and the placeholders without a variable, the text is only usable when
+// none is missing
+func RenderTemplate(body string, variables map[string]string) (string, []string) {
+	var missing []string
+	for _, name := range TemplatePlaceholders(body) {
+		if _, ok := variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	rendered := templatePlaceholderPattern.ReplaceAllStringFunc(body, func(marker string) string {
+		name := templatePlaceholderPattern.FindStringSubmatch(marker)[1]
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		return marker
+	})
+	return rendered, missing
+}
+
+// validateTemplate checks the name, body and default options of a template
+func validateTemplate(tmpl *PromptTemplate) error {
+	tmpl.Name = strings.TrimSpace(tmpl.Name)
+	if length := len([]rune(tmpl.Name)); length < 1 || length > MaxTemplateNameLength {
+		return fmt.Errorf("name must be 1-%d characters", MaxTemplateNameLength)
+	}
+	if strings.TrimSpace(tmpl.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	return validateTaskOptions(tmpl.Duration, tmpl.Orientation)
+}
+
+// applyTemplate renders the template of a create request into its prompt, fills the options the
+// request leaves unset with the template defaults and copies its prompt decoration; writes the error response and returns false when
+// the template doesn't exist or variables are missing
+func applyTemplate(w http.ResponseWriter, req *CreateTaskRequest) bool {
+	tmpl, err := GetTemplate(req.TemplateID)
+	if err != nil {
+		log.Printf("Failed to get template %d: %v", req.TemplateID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to create task")
+		return false
+	}
+	if tmpl == nil {
+		writeError(w, http.StatusBadRequest, "Template not found")
+		return false
+	}
+
+	prompt, missing := RenderTemplate(tmpl.Body, req.Variables)
+	if len(missing) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":   fmt.Sprintf("Unresolved template placeholders: %s", strings.Join(missing, ", ")),
+			"missing": missing,
+		})
+		return false
+	}
+	req.Prompt = prompt
+	if req.Duration == "" {
+		req.Duration = tmpl.Duration
+	}
+	if req.Orientation == "" {
+		req.Orientation = tmpl.Orientation
+	}
+	if req.Model == "" {
+		req.Model = tmpl.Model
+	}
+	req.PromptPrefix = tmpl.PromptPrefix
+	req.PromptSuffix = tmpl.PromptSuffix
+	return true
+}
+
+// handleTemplates handles GET (list) and POST (create) requests to /api/templates
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		templates, err := GetAllTemplates()
+		if err != nil {
+			log.Printf("Failed to get templates: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to get templates")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"templates": templates})
+	case http.MethodPost:
+		var tmpl PromptTemplate
+		if err := json.NewDecoder(r.Body).Decode(&tmpl); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := validateTemplate(&tmpl); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		created, err := CreateTemplate(&tmpl)
+		if err != nil {
+			log.Printf("Failed to create template: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to create template")
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleTemplateByID handles GET, PATCH and DELETE requests to /api/templates/:id
+func handleTemplateByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/templates/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tmpl, err := GetTemplate(id)
+		if err != nil {
+			log.Printf("Failed to get template: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to get template")
+			return
+		}
+		if tmpl == nil {
+			writeError(w, http.StatusNotFound, "Template not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, tmpl)
+	case http.MethodPatch:
+		handleUpdateTemplate(w, r, id)
+	case http.MethodDelete:
+		if err := DeleteTemplate(id); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				writeError(w, http.StatusNotFound, "Template not found")
+				return
+			}
+			log.Printf("Failed to delete template: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to delete template")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleUpdateTemplate handles PATCH /api/templates/:id
+// Only the fields present in the body change, "" clears a default option
+func handleUpdateTemplate(w http.ResponseWriter, r *http.Request, id int64) {
+	var req UpdateTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	tmpl, err := GetTemplate(id)
+	if err != nil {
+		log.Printf("Failed to get template for update: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to update template")
+		return
+	}
+	if tmpl == nil {
+		writeError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+
+	if req.Name != nil {
+		tmpl.Name = *req.Name
+	}
+	if req.Body != nil {
+		tmpl.Body = *req.Body
+	}
+	if req.Duration != nil {
+		tmpl.Duration = *req.Duration
+	}
+	if req.Orientation != nil {
+		tmpl.Orientation = *req.Orientation
+	}
+	if req.Model != nil {
+		tmpl.Model = strings.TrimSpace(*req.Model)
+	}
+	if req.PromptPrefix != nil {
+		tmpl.PromptPrefix = *req.PromptPrefix
+	}
+	if req.PromptSuffix != nil {
+		tmpl.PromptSuffix = *req.PromptSuffix
+	}
+	if err := validateTemplate(tmpl); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := UpdateTemplate(tmpl); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			writeError(w, http.StatusNotFound, "Template not found")
+			return
+		}
+		log.Printf("Failed to update template: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to update template")
+		return
+	}
+
+	updated, err := GetTemplate(id)
+	if err != nil || updated == nil {
+		log.Printf("Failed to get updated template: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to update template")
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
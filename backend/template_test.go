@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	body := "{{subject}} walks through {{ place }} at dusk, {{subject}} smiles"
+	if got := TemplatePlaceholders(body); !reflect.DeepEqual(got, []string{"subject", "place"}) {
+		t.Errorf("placeholders = %v", got)
+	}
+
+	rendered, missing := RenderTemplate(body, map[string]string{"subject": "@小明", "place": "a market"})
+	if len(missing) != 0 || rendered != "@小明 walks through a market at dusk, @小明 smiles" {
+		t.Errorf("rendered %q, missing %v", rendered, missing)
+	}
+	if _, missing := RenderTemplate(body, map[string]string{"subject": "a cat"}); !reflect.DeepEqual(missing, []string{"place"}) {
+		t.Errorf("missing = %v, want [place]", missing)
+	}
+}
+
+// TestTemplateTasks walks a template through create, update and task creation
+func TestTemplateTasks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "templates.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{PromptPrefix: "cinematic", PromptSuffix: "4k"})
+
+	send := func(handler http.HandlerFunc, method, path, body string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec.Code, rec.Body.Bytes()
+	}
+
+	code, body := send(handleTemplates, http.MethodPost, "/api/templates",
+		`{"name":"daily","body":"{{subject}} in the rain","orientation":"portrait","duration":"15s"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", code, body)
+	}
+	var tmpl PromptTemplate
+	json.Unmarshal(body, &tmpl)
+	if code, _ := send(handleTemplates, http.MethodPost, "/api/templates", `{"name":"bad","body":"x","duration":"7s"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid duration: status %d, want 400", code)
+	}
+
+	path := "/api/templates/" + strconv.FormatInt(tmpl.ID, 10)
+	if code, body := send(handleTemplateByID, http.MethodPatch, path, `{"body":"{{subject}} under {{sky}}","prompt_suffix":"brand style"}`); code != http.StatusOK {
+		t.Fatalf("update: status %d: %s", code, body)
+	}
+	if stored, _ := GetTemplate(tmpl.ID); stored.PromptSuffix != "brand style" {
+		t.Errorf("prompt_suffix not saved: %+v", stored)
+	}
+
+	code, body = send(handleCreateTask, http.MethodPost, "/api/tasks",
+		`{"template_id":`+strconv.FormatInt(tmpl.ID, 10)+`,"variables":{"subject":"a fox"}}`)
+	if code != http.StatusBadRequest || !strings.Contains(string(body), "sky") {
+		t.Errorf("missing variable: status %d: %s", code, body)
+	}
+
+	// The template suffix replaces the global one, the global prefix is kept
+	code, body = send(handleCreateTask, http.MethodPost, "/api/tasks?dry_run=true",
+		`{"template_id":`+strconv.FormatInt(tmpl.ID, 10)+`,"variables":{"subject":"a fox","sky":"stars"}}`)
+	var preview map[string]interface{}
+	json.Unmarshal(body, &preview)
+	if code != http.StatusOK || preview["submitted_prompt"] != "cinematic a fox under stars brand style" {
+		t.Errorf("dry run: status %d: %s", code, body)
+	}
+
+	code, body = send(handleCreateTask, http.MethodPost, "/api/tasks",
+		`{"template_id":`+strconv.FormatInt(tmpl.ID, 10)+`,"variables":{"subject":"a fox","sky":"stars"},"duration":"10s"}`)
+	var created []CreateTaskResponse
+	json.Unmarshal(body, &created)
+	if code != http.StatusCreated || len(created) != 1 {
+		t.Fatalf("create task: status %d: %s", code, body)
+	}
+	if c := created[0]; c.Prompt != "a fox under stars" || c.Orientation != OrientationPortrait || c.Duration != Duration10s {
+		t.Errorf("unexpected task: %+v", c)
+	}
+	if task, _ := GetTask(created[0].ID); task.PromptPrefix != "" || task.PromptSuffix != "brand style" {
+		t.Errorf("template decoration not copied: prefix %q suffix %q", task.PromptPrefix, task.PromptSuffix)
+	}
+
+	if code, _ := send(handleTemplateByID, http.MethodDelete, path, ""); code != http.StatusOK {
+		t.Errorf("delete: status %d", code)
+	}
+	if code, _ := send(handleTemplateByID, http.MethodGet, path, ""); code != http.StatusNotFound {
+		t.Errorf("get deleted: status %d, want 404", code)
+	}
+}
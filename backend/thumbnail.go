@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ThumbnailSubdirectory is the directory of the thumbnails under the output directory
+	ThumbnailSubdirectory = "thumbs"
+	// ThumbnailOffsetSeconds is where the thumbnail frame is taken, past any fade-in
+	ThumbnailOffsetSeconds = 1.0
+	// thumbnailWidth is the width of generated thumbnails, the height keeps the aspect ratio
+	thumbnailWidth = 480
+	// thumbnailCacheControl lets browsers keep thumbnails, they don't change once generated
+	thumbnailCacheControl = "public, max-age=31536000"
+)
+
+// errFFmpegNotFound is returned when the configured ffmpeg binary can't be found
+var errFFmpegNotFound = errors.New("ffmpeg not found")
+
+// ThumbnailDirectory returns the directory where video thumbnails are stored
+func ThumbnailDirectory() string {
+	return filepath.Join(OutputDirectory(), ThumbnailSubdirectory)
+}
+
+// ffmpegPath returns the configured ffmpeg binary
+func ffmpegPath(config *Config) string {
+	if config.FFmpegPath != "" {
+		return config.FFmpegPath
+	}
+	return "ffmpeg"
+}
+
+// LogThumbnailConfig logs whether thumbnails are generated at startup
+func LogThumbnailConfig(config *Config) {
+	if _, err := exec.LookPath(ffmpegPath(config)); err != nil {
+		log.Printf("%s not found, video thumbnails are disabled", ffmpegPath(config))
+	}
+}
+
+// GenerateThumbnail extracts a frame of the video of a task into output/thumbs/<id>.jpg
+// Returns the thumbnail file name, or errFFmpegNotFound when ffmpeg is missing
+func GenerateThumbnail(ffmpeg, videoPath string, taskID int64) (string, error) {
+	bin, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		return "", errFFmpegNotFound
+	}
+	if err := os.MkdirAll(ThumbnailDirectory(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	name := strconv.FormatInt(taskID, 10) + ".jpg"
+	dst := filepath.Join(ThumbnailDirectory(), name)
+	// Videos shorter than the offset produce no frame, fall back to the first one
+	for _, at := range []float64{ThumbnailOffsetSeconds, 0} {
+		os.Remove(dst)
+		_, err = runCommand(bin, "-y", "-v", "error",
+			"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+			"-i", videoPath,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+			dst)
+		if info, statErr := os.Stat(dst); err == nil && statErr == nil && info.Size() > 0 {
+			return name, nil
+		}
+	}
+	os.Remove(dst)
+	if err == nil {
+		err = fmt.Errorf("ffmpeg produced no frame")
+	}
+	return "", err
+}
+
+// generateTaskThumbnail generates the thumbnail of a downloaded task and sets task.Thumbnail
+// Missing ffmpeg is not an error, the task simply has no thumbnail
+func generateTaskThumbnail(config *Config, task *Task) error {
+	if task.LocalPath == "" {
+		return nil
+	}
+	name, err := GenerateThumbnail(ffmpegPath(config), ResolveVideoPath(task.LocalPath), task.ID)
+	if errors.Is(err, errFFmpegNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	task.Thumbnail = name
+	return nil
+}
+
+// ThumbnailBackfillResult is the result of a thumbnail backfill job
+type ThumbnailBackfillResult struct {
+	Generated int      `json:"generated"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// backfillThumbnails generates the missing thumbnails of completed tasks
+func backfillThumbnails(job *JobHandle, config *Config) (*ThumbnailBackfillResult, error) {
+	tasks, err := GetTasksWithoutThumbnail()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ThumbnailBackfillResult{}
+	for i, task := range tasks {
+		job.SetProgress(i*100/len(tasks), fmt.Sprintf("%d/%d", i, len(tasks)))
+		if err := generateTaskThumbnail(config, &task); err != nil {
+			log.Printf("Failed to generate thumbnail for task %d: %v", task.ID, err)
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("task %d: %v", task.ID, err))
+			continue
+		}
+		if task.Thumbnail == "" {
+			return nil, errFFmpegNotFound
+		}
+		if err := SetTaskThumbnail(task.ID, task.Thumbnail); err != nil {
+			return nil, err
+		}
+		result.Generated++
+	}
+	log.Printf("Thumbnail backfill done: %d generated, %d failed", result.Generated, result.Failed)
+	return result, nil
+}
+
+// handleThumbnailBackfill handles POST /api/thumbnails/backfill
+// Generates the thumbnails of existing completed tasks as a background job
+func handleThumbnailBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	config := CurrentConfig()
+	if _, err := exec.LookPath(ffmpegPath(config)); err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("%s not found, thumbnails are disabled", ffmpegPath(config)))
+		return
+	}
+
+	job := StartJob("thumbnail_backfill", func(job *JobHandle) (interface{}, error) {
+		return backfillThumbnails(job, config)
+	})
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleThumbnails handles GET /api/thumbnails/:taskID
+func handleThumbnails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/thumbnails/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid task ID")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for thumbnail: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if task == nil || task.Thumbnail == "" {
+		writeError(w, http.StatusNotFound, "Thumbnail not found")
+		return
+	}
+	filePath := filepath.Join(ThumbnailDirectory(), filepath.Base(task.Thumbnail))
+	if _, err := os.Stat(filePath); err != nil {
+		writeError(w, http.StatusNotFound, "Thumbnail not found")
+		return
+	}
+
+	w.Header().Set("Cache-Control", thumbnailCacheControl)
+	http.ServeFile(w, r, filePath)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// fakeFFmpeg writes a shell script standing in for ffmpeg, it writes a few bytes to its last argument
+func fakeFFmpeg(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nfor last; do :; done\nprintf 'jpeg' > \"$last\"\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake ffmpeg: %v", err)
+	}
+	return path
+}
+
+// TestThumbnails backfills the thumbnail of a completed task and serves it with cache headers
+func TestThumbnails(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "thumbs.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	config := &Config{FFmpegPath: fakeFFmpeg(t)}
+	useTestConfig(t, config)
+
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	os.MkdirAll(OutputDirectory(), 0755)
+	os.WriteFile(filepath.Join(OutputDirectory(), "cat.mp4"), fakeMP4(1024), 0644)
+	task.Status = StatusCompleted
+	task.LocalPath = "cat.mp4"
+	if err := UpdateTask(task); err != nil {
+		t.Fatalf("UpdateTask failed: %v", err)
+	}
+
+	get := func(id int64) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleThumbnails(rec, httptest.NewRequest(http.MethodGet, "/api/thumbnails/"+strconv.FormatInt(id, 10), nil))
+		return rec
+	}
+	if rec := get(task.ID); rec.Code != http.StatusNotFound {
+		t.Errorf("before backfill: status %d, want 404", rec.Code)
+	}
+
+	// Without ffmpeg the task is left without thumbnail and no error is reported
+	if err := generateTaskThumbnail(&Config{FFmpegPath: filepath.Join(t.TempDir(), "missing")}, task); err != nil || task.Thumbnail != "" {
+		t.Errorf("missing ffmpeg: thumbnail %q, err %v", task.Thumbnail, err)
+	}
+
+	result, err := backfillThumbnails(&JobHandle{}, config)
+	if err != nil {
+		t.Fatalf("backfillThumbnails failed: %v", err)
+	}
+	if result.Generated != 1 || result.Failed != 0 {
+		t.Errorf("backfill result %+v, want 1 generated", result)
+	}
+	stored, _ := GetTask(task.ID)
+	if want := strconv.FormatInt(task.ID, 10) + ".jpg"; stored.Thumbnail != want {
+		t.Errorf("thumbnail = %q, want %q", stored.Thumbnail, want)
+	}
+
+	rec := get(task.ID)
+	if rec.Code != http.StatusOK || rec.Body.String() != "jpeg" {
+		t.Fatalf("thumbnail: status %d, body %q", rec.Code, rec.Body.String())
+	}
+	if cache := rec.Header().Get("Cache-Control"); cache != thumbnailCacheControl {
+		t.Errorf("Cache-Control = %q, want %q", cache, thumbnailCacheControl)
+	}
+
+	// Tasks with a thumbnail are not backfilled again
+	if result, _ := backfillThumbnails(&JobHandle{}, config); result.Generated != 0 {
+		t.Errorf("second backfill generated %d thumbnails", result.Generated)
+	}
+}
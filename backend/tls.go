@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// SelfSignedCertFile and SelfSignedKeyFile are where the certificate generated for self_signed is kept
+	SelfSignedCertFile = "self-signed-cert.pem"
+	SelfSignedKeyFile  = "self-signed-key.pem"
+	// SelfSignedValidity is how long a generated certificate is valid, it's regenerated once expired
+	SelfSignedValidity = 825 * 24 * time.Hour
+)
+
+// validateTLSOptions checks that the certificate and key files are set together, and not along with self_signed
+func validateTLSOptions(config *Config) error {
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if config.SelfSigned && config.TLSCertFile != "" {
+		return fmt.Errorf("self_signed can't be used with tls_cert_file and tls_key_file")
+	}
+	return nil
+}
+
+// tlsSettings describes the TLS options of a configuration, changing them requires a restart
+func tlsSettings(config *Config) string {
+	if config.SelfSigned {
+		return "self-signed"
+	}
+	if config.TLSCertFile == "" {
+		return ""
+	}
+	return config.TLSCertFile + "\n" + config.TLSKeyFile
+}
+
+// serverTLSFiles returns the certificate and key files to serve HTTPS with, empty to serve HTTP
+// With self_signed a certificate for localhost is generated on first run and reused afterwards
+func serverTLSFiles(config *Config) (string, string, error) {
+	if err := validateTLSOptions(config); err != nil {
+		return "", "", err
+	}
+	if config.SelfSigned {
+		if err := ensureSelfSignedCert(SelfSignedCertFile, SelfSignedKeyFile); err != nil {
+			return "", "", err
+		}
+		return SelfSignedCertFile, SelfSignedKeyFile, nil
+	}
+	if config.TLSCertFile == "" {
+		return "", "", nil
+	}
+	// Load the pair now, ListenAndServeTLS would only report a bad file once the server starts
+	if _, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile); err != nil {
+		return "", "", fmt.Errorf("failed to load tls_cert_file and tls_key_file: %w", err)
+	}
+	return config.TLSCertFile, config.TLSKeyFile, nil
+}
+
+// ensureSelfSignedCert generates a self-signed certificate unless a valid one is already stored
+func ensureSelfSignedCert(certFile, keyFile string) error {
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && time.Now().Before(pair.Leaf.NotAfter) {
+		return nil
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write self-signed key: %w", err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write self-signed certificate: %w", err)
+	}
+	log.Printf("Generated a self-signed certificate in %s, browsers will ask to trust it on first visit", certFile)
+	return nil
+}
+
+// generateSelfSignedCert creates a certificate for localhost, the loopback addresses and the host name
+// Returns the PEM encoded certificate and private key
+func generateSelfSignedCert(now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"videogen"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SelfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSelfSignedCert checks the generated certificate covers localhost and is reused on the next run
+func TestSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatalf("ensureSelfSignedCert: %v", err)
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("generated pair doesn't load: %v", err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		if err := pair.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("certificate doesn't cover %s: %v", host, err)
+		}
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Errorf("key file readable by others: %v %v", info.Mode(), err)
+	}
+
+	generated, _ := os.ReadFile(certFile)
+	if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatalf("second ensureSelfSignedCert: %v", err)
+	}
+	if reused, _ := os.ReadFile(certFile); !bytes.Equal(reused, generated) {
+		t.Error("certificate regenerated on the second run")
+	}
+
+	// A pair that doesn't load is replaced
+	os.WriteFile(certFile, []byte("garbage"), 0644)
+	if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatalf("ensureSelfSignedCert over a broken file: %v", err)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Errorf("broken certificate not replaced: %v", err)
+	}
+}
+
+// TestServerTLSFiles checks the TLS options are validated and the configured pair is loaded up front
+func TestServerTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatalf("ensureSelfSignedCert: %v", err)
+	}
+
+	if cert, key, err := serverTLSFiles(&Config{}); cert != "" || key != "" || err != nil {
+		t.Errorf("without TLS options: %q %q %v", cert, key, err)
+	}
+	if cert, key, err := serverTLSFiles(&Config{TLSCertFile: certFile, TLSKeyFile: keyFile}); cert != certFile || key != keyFile || err != nil {
+		t.Errorf("with a valid pair: %q %q %v", cert, key, err)
+	}
+	for name, config := range map[string]*Config{
+		"cert only":         {TLSCertFile: certFile},
+		"self_signed too":   {TLSCertFile: certFile, TLSKeyFile: keyFile, SelfSigned: true},
+		"missing key file":  {TLSCertFile: certFile, TLSKeyFile: filepath.Join(dir, "missing.pem")},
+		"swapped cert, key": {TLSCertFile: keyFile, TLSKeyFile: certFile},
+	} {
+		if _, _, err := serverTLSFiles(config); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OriginalVideoSuffix is appended to the filename of the trimmed clip when an in-place trim keeps
+// the original
+const OriginalVideoSuffix = ".orig"
+
+// TrimTaskRequest represents the request body for POST /api/tasks/:id/trim
+type TrimTaskRequest struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Replace bool    `json:"replace,omitempty"` // true: replace the task's video, false: create a derived task
+}
+
+// TrimResult is stored as the result of a finished trim job
+type TrimResult struct {
+	TaskID    int64  `json:"task_id"`
+	LocalPath string `json:"local_path"`
+	Reencoded bool   `json:"reencoded"`
+}
+
+// handleTrimTask handles POST /api/tasks/:id/trim
+// Validates the range against the probed duration and trims the video in a background job
+func handleTrimTask(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req TrimTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	task, err := GetTask(id)
+	if err != nil {
+		log.Printf("Failed to get task for trim: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	if task == nil {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Status != StatusCompleted || task.LocalPath == "" {
+		writeError(w, http.StatusConflict, "Only completed tasks with a downloaded video can be trimmed")
+		return
+	}
+
+	srcPath := ResolveVideoPath(task.LocalPath)
+	if _, err := os.Stat(srcPath); err != nil {
+		writeError(w, http.StatusNotFound, "Video file not found")
+		return
+	}
+
+	if !FFmpegAvailable() {
+		writeError(w, http.StatusServiceUnavailable, "ffmpeg/ffprobe not found in PATH")
+		return
+	}
+
+	info, err := ProbeVideo(srcPath)
+	if err != nil {
+		log.Printf("Failed to probe video for task %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to read video duration")
+		return
+	}
+
+	if req.Start < 0 || req.End <= req.Start || req.End > info.DurationSeconds {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid range: start and end must satisfy 0 <= start < end <= %.2f", info.DurationSeconds))
+		return
+	}
+
+	job := StartJob("trim", func(job *JobHandle) (interface{}, error) {
+		return trimTaskVideo(job, task, req)
+	})
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// describeTrimmedVideo probes the trimmed clip of a task and generates its thumbnail
+// Failures are logged, the clip is usable without them
+func describeTrimmedVideo(task *Task) {
+	if err := probeTaskMetadata(task); err != nil {
+		log.Printf("Warning: failed to probe video of task %d: %v", task.ID, err)
+	} else if err := SetTaskMetadata(task.ID, task); err != nil {
+		log.Printf("Failed to save metadata of task %d: %v", task.ID, err)
+	}
+	if err := generateTaskThumbnail(CurrentConfig(), task); err != nil {
+		log.Printf("Failed to generate thumbnail for task %d: %v", task.ID, err)
+	} else if task.Thumbnail != "" {
+		if err := SetTaskThumbnail(task.ID, task.Thumbnail); err != nil {
+			log.Printf("Failed to save thumbnail of task %d: %v", task.ID, err)
+		}
+	}
+}
+
+// trimTaskVideo performs the actual trim for handleTrimTask
+func trimTaskVideo(job *JobHandle, task *Task, req TrimTaskRequest) (*TrimResult, error) {
+	srcPath := ResolveVideoPath(task.LocalPath)
+	stem := strings.TrimSuffix(task.LocalPath, filepath.Ext(task.LocalPath))
+	trimmedName := fmt.Sprintf("%s_trim_%d.mp4", stem, time.Now().UnixNano())
+	trimmedPath := filepath.Join(OutputDirectory(), trimmedName)
+
+	job.SetProgress(10, "trimming")
+	reencoded, err := TrimVideo(srcPath, trimmedPath, req.Start, req.End)
+	if err != nil {
+		os.Remove(trimmedPath)
+		return nil, err
+	}
+	job.SetProgress(90, "saving")
+
+	if !req.Replace {
+		derived, err := CreateDerivedTask(task, trimmedName)
+		if err != nil {
+			os.Remove(trimmedPath)
+			return nil, err
+		}
+		log.Printf("[Trim] 任务 %d 裁剪为新任务 %d (%.2fs-%.2fs)", task.ID, derived.ID, req.Start, req.End)
+		describeTrimmedVideo(derived)
+		return &TrimResult{TaskID: derived.ID, LocalPath: trimmedName, Reencoded: reencoded}, nil
+	}
+
+	// In-place: keep the original under the name of the trimmed clip, where deleting the task finds
+	// it, and point the task at the clip
+	keptPath := filepath.Join(filepath.Dir(srcPath), trimmedName+OriginalVideoSuffix)
+	if err := os.Rename(srcPath, keptPath); err != nil {
+		os.Remove(trimmedPath)
+		return nil, fmt.Errorf("failed to keep original video: %w", err)
+	}
+	if err := SetTaskLocalPath(task.ID, trimmedName); err != nil {
+		os.Rename(keptPath, srcPath)
+		os.Remove(trimmedPath)
+		return nil, err
+	}
+	task.LocalPath = trimmedName
+	describeTrimmedVideo(task)
+	log.Printf("[Trim] 任务 %d 已原地裁剪 (%.2fs-%.2fs)", task.ID, req.Start, req.End)
+	return &TrimResult{TaskID: task.ID, LocalPath: trimmedName, Reencoded: reencoded}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTrimTask checks the range is validated against the probed duration, a trim creates a derived
+// task with its own thumbnail leaving the original video untouched, and an in-place trim keeps the
+// original until the video is deleted
+func TestTrimTask(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "trim.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{})
+	fakeProbeTools(t)
+	os.MkdirAll(OutputDirectory(), 0755)
+
+	task, err := CreateTask(&CreateTaskRequest{Prompt: "a cat", Duration: Duration10s, Orientation: OrientationLandscape})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	original := probeJSON(1280, 720, 10)
+	os.WriteFile(filepath.Join(OutputDirectory(), "cat.mp4"), []byte(original), 0644)
+	task.Status = StatusCompleted
+	task.LocalPath = "cat.mp4"
+	UpdateTask(task)
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"start": 2, "end": 12}`)
+	handleTaskByID(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/tasks/%d/trim", task.ID), body))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("end past the duration: status %d, want 400", rec.Code)
+	}
+
+	result, err := trimTaskVideo(&JobHandle{}, task, TrimTaskRequest{Start: 2, End: 7})
+	if err != nil {
+		t.Fatalf("trimTaskVideo failed: %v", err)
+	}
+	derived, _ := GetTask(result.TaskID)
+	if derived.ID == task.ID || derived.ParentTaskID != task.ID || derived.Status != StatusCompleted {
+		t.Errorf("derived task %d: parent %d, status %q", derived.ID, derived.ParentTaskID, derived.Status)
+	}
+	if derived.Thumbnail == "" || derived.Width != 1280 {
+		t.Errorf("derived task thumbnail %q, width %d", derived.Thumbnail, derived.Width)
+	}
+	if data, _ := os.ReadFile(filepath.Join(OutputDirectory(), "cat.mp4")); string(data) != original {
+		t.Errorf("original video changed")
+	}
+
+	// In place, only local_path is written: a change made meanwhile is kept
+	DB.Exec("UPDATE tasks SET starred = 1 WHERE id = ?", task.ID)
+	result, err = trimTaskVideo(&JobHandle{}, task, TrimTaskRequest{Start: 2, End: 7, Replace: true})
+	if err != nil {
+		t.Fatalf("in-place trimTaskVideo failed: %v", err)
+	}
+	trimmed, _ := GetTask(task.ID)
+	if trimmed.LocalPath != result.LocalPath || !trimmed.Starred {
+		t.Errorf("in-place trim: local_path %q, starred %v", trimmed.LocalPath, trimmed.Starred)
+	}
+	kept := filepath.Join(OutputDirectory(), result.LocalPath+OriginalVideoSuffix)
+	if data, _ := os.ReadFile(kept); string(data) != original {
+		t.Errorf("original not kept as %s", kept)
+	}
+	if err := DeleteVideoFile(trimmed.LocalPath); err != nil {
+		t.Fatalf("DeleteVideoFile failed: %v", err)
+	}
+	if _, err := os.Stat(kept); !os.IsNotExist(err) {
+		t.Errorf("original left after deleting the video: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxUploadBytes is the largest image accepted by POST /api/uploads
+	MaxUploadBytes = 20 << 20
+	// UploadImagePrefix marks an image_url referencing an upload, e.g. upload:3f9a0c1d2e4b5a69.png
+	UploadImagePrefix = "upload:"
+)
+
+// uploadExtensions maps the accepted image types, sniffed from the content, to their extension
+var uploadExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// uploadIDPattern matches the generated upload IDs, which are also their file names
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{16}\.(png|jpg|gif|webp)$`)
+
+// UploadDirectory returns the directory where uploaded images are stored
+func UploadDirectory() string {
+	return filepath.Join(OutputDirectory(), "uploads")
+}
+
+// UploadResponse represents the response of POST /api/uploads
+type UploadResponse struct {
+	ID          string `json:"id"`   // Passed as image_ref when creating tasks
+	Path        string `json:"path"` // URL of the image, for previews
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// uploadPath returns the file of an upload ID, or an error when the ID is malformed or unknown
+func uploadPath(id string) (string, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid image_ref %q", id)
+	}
+	path := filepath.Join(UploadDirectory(), id)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("upload %s not found", id)
+	}
+	return path, nil
+}
+
+// resolveImageRef turns image_ref into the image_url stored with the task
+// A task references its upload instead of embedding the image, the file is read at submission
+func resolveImageRef(req *CreateTaskRequest) error {
+	if req.ImageRef == "" {
+		// A reference may also be passed as image_url, e.g. by a duplicated task
+		if id, ok := strings.CutPrefix(req.ImageURL, UploadImagePrefix); ok {
+			_, err := uploadPath(id)
+			return err
+		}
+		return nil
+	}
+	if req.ImageURL != "" {
+		return fmt.Errorf("image_url and image_ref can't both be set")
+	}
+	if _, err := uploadPath(req.ImageRef); err != nil {
+		return err
+	}
+	req.ImageURL = UploadImagePrefix + req.ImageRef
+	return nil
+}
+
+// loadImage returns the bytes and type of a task image: a base64 data URL or an upload reference
+// Other URLs are returned as nil data
+func loadImage(imageURL string) ([]byte, string, error) {
+	if id, ok := strings.CutPrefix(imageURL, UploadImagePrefix); ok {
+		path, err := uploadPath(id)
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read upload: %w", err)
+		}
+		return data, http.DetectContentType(data), nil
+	}
+	if !strings.HasPrefix(imageURL, "data:image/") {
+		return nil, "", nil
+	}
+
+	// Parse data URL: data:image/png;base64,xxxxx
+	parts := strings.SplitN(imageURL, ",", 2)
+	if len(parts) != 2 {
+		return nil, "", nil
+	}
+	// Get mime type from the first part
+	mimeType := "image/png"
+	for candidate := range uploadExtensions {
+		if strings.Contains(parts[0], candidate) {
+			mimeType = candidate
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode base64 image: %w", err)
+	}
+	return data, mimeType, nil
+}
+
+// handleUploads handles POST /api/uploads
+// Stores the image uploaded as the multipart field "file" under output/uploads with a generated name;
+// the type is sniffed from the content, only PNG, JPEG, GIF and WebP images are accepted
+func handleUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("An image of at most %d MB is required in the file field", MaxUploadBytes>>20))
+		return
+	}
+	defer file.Close()
+	if header.Size > MaxUploadBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Image exceeds %d MB", MaxUploadBytes>>20))
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	contentType := http.DetectContentType(sniff[:n])
+	ext, ok := uploadExtensions[contentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported image type %s, use PNG, JPEG, GIF or WebP", contentType))
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read the image")
+		return
+	}
+
+	if err := os.MkdirAll(UploadDirectory(), 0755); err != nil {
+		log.Printf("Failed to create upload directory: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to store the image")
+		return
+	}
+	id := newBatchID() + ext
+	path := filepath.Join(UploadDirectory(), id)
+	out, err := os.Create(path)
+	if err != nil {
+		log.Printf("Failed to create upload %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to store the image")
+		return
+	}
+	size, err := io.Copy(out, file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		log.Printf("Failed to write upload %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to store the image")
+		return
+	}
+
+	log.Printf("Stored upload %s (%s, %d bytes)", id, contentType, size)
+	writeJSON(w, http.StatusCreated, UploadResponse{
+		ID:          id,
+		Path:        "/api/uploads/" + id,
+		ContentType: contentType,
+		Size:        size,
+	})
+}
+
+// handleUploadByID handles GET /api/uploads/:id
+func handleUploadByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	path, err := uploadPath(strings.TrimPrefix(r.URL.Path, "/api/uploads/"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	http.ServeFile(w, r, path)
+}
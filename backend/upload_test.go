@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPNG encodes a width x height PNG image
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestUploadImageRef uploads an image, creates a task referencing it and checks the file is sent
+// in the multipart submission
+func TestUploadImageRef(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "upload.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{})
+
+	upload := func(content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "photo.bin")
+		part.Write(content)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/uploads", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		handleUploads(rec, req)
+		return rec
+	}
+
+	if rec := upload([]byte("just some text")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text upload: status %d, want 415", rec.Code)
+	}
+
+	picture := testPNG(t, 64, 48)
+	rec := upload(picture)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload failed: %d %s", rec.Code, rec.Body.String())
+	}
+	var uploaded UploadResponse
+	json.Unmarshal(rec.Body.Bytes(), &uploaded)
+	if uploaded.ContentType != "image/png" || uploaded.Size != int64(len(picture)) || !strings.HasSuffix(uploaded.ID, ".png") {
+		t.Fatalf("upload response %+v", uploaded)
+	}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleCreateTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body)))
+		return rec
+	}
+	if rec := create(`{"prompt":"a cat","image_ref":"0123456789abcdef.png"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown image_ref: status %d, want 400", rec.Code)
+	}
+	if rec := create(`{"prompt":"a cat","image_ref":"../config.json"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed image_ref: status %d, want 400", rec.Code)
+	}
+	rec = create(`{"prompt":"a cat","image_ref":"` + uploaded.ID + `"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create with image_ref failed: %d %s", rec.Code, rec.Body.String())
+	}
+	tasks, _ := GetPendingTasks()
+	if len(tasks) != 1 || tasks[0].ImageURL != "" {
+		t.Fatalf("pending tasks = %+v, want one task without its image", tasks)
+	}
+	imageURL, _, err := GetTaskImages(tasks[0].ID)
+	if err != nil || imageURL != UploadImagePrefix+uploaded.ID {
+		t.Fatalf("stored image_url = %q, err %v", imageURL, err)
+	}
+
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if file, _, err := r.FormFile("input_reference"); err == nil {
+			received, _ = io.ReadAll(file)
+		}
+		w.Write([]byte(`{"id":"video_1","status":"queued"}`))
+	}))
+	defer server.Close()
+	client := NewVectorEngineClient("key")
+	client.SetBaseURL(server.URL)
+	if _, err := client.CreateVideoTask(context.Background(), "a cat", imageURL, "", Duration10s, OrientationLandscape, ModelSora2, false); err != nil {
+		t.Fatalf("CreateVideoTask failed: %v", err)
+	}
+	if !bytes.Equal(received, picture) {
+		t.Errorf("submitted %d image bytes, want the %d uploaded", len(received), len(picture))
+	}
+}
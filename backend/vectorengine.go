@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,27 +28,246 @@ const (
 	VectorEngineBaseURL = "https://api.vectorengine.ai"
 	// DyuAPIBaseURL is the base URL for the Dyu API (sora2-alt)
 	DyuAPIBaseURL = "https://api.dyuapi.com"
-	// OutputDirectory is the directory where downloaded videos are saved
-	OutputDirectory = "output"
+	// DefaultOutputDirectory is used when output_dir is not configured
+	DefaultOutputDirectory = "output"
 )
 
+// outputDirs holds the directory where downloaded videos are saved and the ones used before it
+// PUT /api/config changes them while downloads and handlers read them
+var outputDirs = struct {
+	sync.RWMutex
+	current  string
+	previous []string // Earlier output directories, most recent first
+}{current: DefaultOutputDirectory}
+
+// OutputDirectory returns the directory where downloaded videos are saved
+// Set from output_dir by SetupOutputDirectory
+func OutputDirectory() string {
+	outputDirs.RLock()
+	defer outputDirs.RUnlock()
+	return outputDirs.current
+}
+
+// setOutputDirectory changes the directory where downloaded videos are saved
+func setOutputDirectory(dir string) {
+	outputDirs.Lock()
+	outputDirs.current = dir
+	outputDirs.Unlock()
+}
+
+// previousOutputDirectories returns the earlier output directories, most recent first
+func previousOutputDirectories() []string {
+	outputDirs.RLock()
+	defer outputDirs.RUnlock()
+	return outputDirs.previous
+}
+
+// DefaultRequestTimeout bounds API calls when request_timeout is not set
+const DefaultRequestTimeout = 60 * time.Second
+
+// requestTimeout returns the configured API call timeout
+func requestTimeout(config *Config) time.Duration {
+	if config.RequestTimeout > 0 {
+		return time.Duration(config.RequestTimeout) * time.Second
+	}
+	return DefaultRequestTimeout
+}
+
+// dyuBaseURL returns the configured Dyu API endpoint
+func dyuBaseURL(config *Config) string {
+	if baseURL := strings.TrimSpace(config.DyuBaseURL); baseURL != "" {
+		return baseURL
+	}
+	return DyuAPIBaseURL
+}
+
+// apiProxy selects the proxy for requests to the API and the video CDN, set by SetupProxy
+var apiProxy = http.ProxyFromEnvironment
+
+// activeProxyURL is the proxy_url in effect, changing it requires a restart
+var activeProxyURL string
+
+// parseProxyURL validates a proxy_url value, http, https and socks5 proxies are supported
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy_url %q: scheme must be http, https or socks5", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url %q: missing host", raw)
+	}
+	return u, nil
+}
+
+// SetupProxy routes API requests and downloads through proxyURL, or the HTTP_PROXY/HTTPS_PROXY
+// environment variables when it is empty
+// Clients created before the call keep their previous proxy
+func SetupProxy(proxyURL string) error {
+	proxyURL = strings.TrimSpace(proxyURL)
+	if proxyURL == "" {
+		apiProxy = http.ProxyFromEnvironment
+		activeProxyURL = ""
+		return nil
+	}
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		return err
+	}
+	apiProxy = http.ProxyURL(u)
+	activeProxyURL = proxyURL
+	log.Printf("Using proxy %s", u.Redacted())
+	return nil
+}
+
+// newAPITransport returns a transport using the configured proxy
+func newAPITransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = apiProxy
+	return transport
+}
+
 // VectorEngineClient handles communication with the VectorEngine API
 type VectorEngineClient struct {
-	httpClient *http.Client
-	baseURL    string
-	dyuAPIKey  string
+	httpClient        *http.Client
+	baseURL           atomic.Pointer[string]              // Dyu API endpoint, tests point it at a fake server
+	keys              *apiKeyPool                         // Can be replaced at runtime via PUT /api/config
+	requestTimeout    atomic.Int64                        // Deadline of API calls in nanoseconds, downloads are not bounded
+	fallbacks         atomic.Pointer[map[string][]string] // Configured model fallback chains, see modelChain
+	maxImageDimension atomic.Int64                        // Longest edge of submitted images, larger ones are downscaled
+	downloads         atomic.Pointer[DownloadOptions]     // Threads, chunk size and bandwidth of video downloads
 }
 
 // NewVectorEngineClient creates a new VectorEngine API client
 func NewVectorEngineClient(dyuAPIKey string) *VectorEngineClient {
-	return &VectorEngineClient{
+	client := &VectorEngineClient{
 		httpClient: &http.Client{
-			// No timeout - let requests complete naturally
-			// Errors will be displayed to the user
+			// No client timeout - downloads of large videos may take long,
+			// API calls are bounded by requestTimeout through their context instead
+			Transport: newAPITransport(),
 		},
-		baseURL:   VectorEngineBaseURL,
-		dyuAPIKey: dyuAPIKey,
+		keys: newAPIKeyPool((&Config{DyuAPIKey: dyuAPIKey}).apiKeys(), DefaultAPIKeyCooldown),
+	}
+	client.SetBaseURL(DyuAPIBaseURL)
+	client.SetRequestTimeout(DefaultRequestTimeout)
+	client.SetMaxImageDimension(DefaultMaxImageDimension)
+	client.SetDownloadOptions(downloadOptions(&Config{}))
+	return client
+}
+
+// NewConfiguredClient creates a client using all API keys and the request timeout of the configuration
+func NewConfiguredClient(config *Config) *VectorEngineClient {
+	client := NewVectorEngineClient("")
+	client.SetAPIKeys(config.apiKeys(), apiKeyCooldown(config))
+	client.SetRequestTimeout(requestTimeout(config))
+	client.SetBaseURL(dyuBaseURL(config))
+	client.SetModelFallbacks(config.ModelFallbacks)
+	client.SetMaxImageDimension(maxImageDimension(config))
+	client.SetDownloadOptions(downloadOptions(config))
+	return client
+}
+
+// SetDownloadOptions changes how subsequent video downloads are made
+func (c *VectorEngineClient) SetDownloadOptions(options DownloadOptions) {
+	c.downloads.Store(&options)
+}
+
+// SetMaxImageDimension changes the longest edge of subsequently submitted images
+func (c *VectorEngineClient) SetMaxImageDimension(dimension int) {
+	c.maxImageDimension.Store(int64(dimension))
+}
+
+// submissionImage returns the bytes and type of a task image as submitted, nil for remote URLs
+// Images above the max dimension are downscaled and re-encoded as JPEG, the stored original is kept
+func (c *VectorEngineClient) submissionImage(imageURL string) ([]byte, string, error) {
+	data, mimeType, err := loadImage(imageURL)
+	if err != nil || data == nil {
+		return data, mimeType, err
 	}
+	if _, _, _, err := imageSize(data); err != nil {
+		// Validated at creation, older tasks are submitted as they are
+		log.Printf("[VideoGen] 无法识别图片，按原样提交: %v", err)
+		return data, mimeType, nil
+	}
+	resized, ok, err := downscaleImage(data, int(c.maxImageDimension.Load()))
+	if err != nil {
+		return nil, "", err
+	}
+	if ok {
+		log.Printf("[VideoGen] 图片已缩小: %d -> %d 字节", len(data), len(resized))
+		return resized, "image/jpeg", nil
+	}
+	return data, mimeType, nil
+}
+
+// SetBaseURL changes the Dyu API endpoint of subsequent requests
+func (c *VectorEngineClient) SetBaseURL(baseURL string) {
+	c.baseURL.Store(&baseURL)
+}
+
+// apiURL returns the URL of an API path on the configured endpoint
+func (c *VectorEngineClient) apiURL(path string) string {
+	return joinAPIURL(*c.baseURL.Load(), path)
+}
+
+// resolveURL returns the URL to fetch a video or picture URL returned by the API from
+// Relative URLs are resolved against the configured endpoint, and URLs on the default Dyu host are
+// rewritten to it so downloads go through the same relay as the API calls
+func (c *VectorEngineClient) resolveURL(rawURL string) string {
+	baseURL := *c.baseURL.Load()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return joinAPIURL(baseURL, rawURL)
+	}
+	defaultURL, _ := url.Parse(DyuAPIBaseURL)
+	if baseURL == DyuAPIBaseURL || !strings.EqualFold(u.Host, defaultURL.Host) {
+		return rawURL
+	}
+	return joinAPIURL(baseURL, u.RequestURI())
+}
+
+// joinAPIURL appends an API path to a base URL, which may end with a slash or have a path of its own
+func joinAPIURL(baseURL, path string) string {
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// validateBaseURL checks an API base URL: http or https with a host, and no query or fragment
+func validateBaseURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http or https URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("must not have a query or fragment")
+	}
+	return nil
+}
+
+// SetRequestTimeout changes the deadline of subsequent API calls
+func (c *VectorEngineClient) SetRequestTimeout(timeout time.Duration) {
+	c.requestTimeout.Store(int64(timeout))
+}
+
+// withRequestTimeout bounds an API call by the request timeout
+func (c *VectorEngineClient) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(c.requestTimeout.Load()))
+}
+
+// SetAPIKeys replaces the API keys used by subsequent requests
+func (c *VectorEngineClient) SetAPIKeys(keys []string, cooldown time.Duration) {
+	c.keys.set(keys, cooldown)
+}
+
+// apiKey returns the API key currently in use
+func (c *VectorEngineClient) apiKey() string {
+	return c.keys.active()
 }
 
 // VectorEngineCreateRequest represents the request body for creating a video task (sora-2)
@@ -57,7 +284,7 @@ type VectorEngineCreateRequest struct {
 // CreateVideoTaskDyuAPI submits a video generation task to Dyu API
 // - Text-to-video (no image): uses application/json format
 // - Image-to-video (with image): uses multipart/form-data format
-func (c *VectorEngineClient) CreateVideoTaskDyuAPI(prompt, imageURL, duration, orientation string) (*VectorEngineCreateResponse, error) {
+func (c *VectorEngineClient) CreateVideoTaskDyuAPI(ctx context.Context, key, prompt, imageURL, imageURL2, duration, orientation string, watermark bool) (*VectorEngineCreateResponse, error) {
 	// Map duration and orientation to model name
 	// sora2-portrait-test, sora2-landscape-test, sora2-portrait-15s-test, sora2-landscape-15s-test
 	var modelName string
@@ -78,42 +305,71 @@ func (c *VectorEngineClient) CreateVideoTaskDyuAPI(prompt, imageURL, duration, o
 
 	log.Printf("[VideoGen] 使用模型: %s, 有图片: %v", modelName, imageURL != "")
 
-	// If no image, use JSON format (text-to-video)
-	if imageURL == "" {
-		result, err := c.createVideoTaskJSON(prompt, modelName)
-		// If -test model shows "暂无渠道", fallback to non-test model
-		if err != nil {
-			errStr := err.Error()
-			log.Printf("[VideoGen] 创建任务失败: %s", errStr)
-			if strings.Contains(errStr, "暂无渠道") && strings.HasSuffix(modelName, "-test") {
-				fallbackModel := strings.TrimSuffix(modelName, "-test")
-				log.Printf("[VideoGen] -test 模型暂无渠道，回退到: %s", fallbackModel)
-				return c.createVideoTaskJSON(prompt, fallbackModel)
-			}
+	create := func(model string) (*VectorEngineCreateResponse, error) {
+		var result *VectorEngineCreateResponse
+		var err error
+		if imageURL == "" {
+			// If no image, use JSON format (text-to-video)
+			result, err = c.createVideoTaskJSON(ctx, key, prompt, model, watermark)
+		} else {
+			// If has image, use multipart/form-data format (image-to-video)
+			result, err = c.createVideoTaskMultipart(ctx, key, prompt, imageURL, imageURL2, model, watermark)
+		}
+		if err == nil {
+			result.Model = model
 		}
 		return result, err
 	}
 
-	// If has image, use multipart/form-data format (image-to-video)
-	result, err := c.createVideoTaskMultipart(prompt, imageURL, modelName)
-	// If -test model shows "暂无渠道", fallback to non-test model
-	if err != nil {
-		errStr := err.Error()
-		log.Printf("[VideoGen] 创建任务失败: %s", errStr)
-		if strings.Contains(errStr, "暂无渠道") && strings.HasSuffix(modelName, "-test") {
-			fallbackModel := strings.TrimSuffix(modelName, "-test")
-			log.Printf("[VideoGen] -test 模型暂无渠道，回退到: %s", fallbackModel)
-			return c.createVideoTaskMultipart(prompt, imageURL, fallbackModel)
+	// Walk the fallback chain while the models have no channel
+	chain := c.modelChain(modelName)
+	var result *VectorEngineCreateResponse
+	var err error
+	for i, model := range chain {
+		if i > 0 {
+			log.Printf("[VideoGen] %s 暂无可用渠道，回退到: %s (%d/%d)", chain[i-1], model, i, len(chain)-1)
+		}
+		result, err = create(model)
+		if err == nil {
+			if i > 0 {
+				result.FallbackFrom = modelName
+			}
+			return result, nil
+		}
+		log.Printf("[VideoGen] 模型 %s 创建任务失败: %v", model, err)
+		if !IsChannelUnavailable(err.Error()) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// SetModelFallbacks replaces the fallback chains of subsequent submissions
+func (c *VectorEngineClient) SetModelFallbacks(fallbacks map[string][]string) {
+	c.fallbacks.Store(&fallbacks)
+}
+
+// modelChain returns the models tried in turn for a submission, starting with model
+// Without a configured chain a -test model falls back to its plain name
+func (c *VectorEngineClient) modelChain(model string) []string {
+	chain := []string{model}
+	if fallbacks := c.fallbacks.Load(); fallbacks != nil {
+		if configured, ok := (*fallbacks)[model]; ok {
+			return append(chain, configured...)
 		}
 	}
-	return result, err
+	if strings.HasSuffix(model, "-test") {
+		chain = append(chain, strings.TrimSuffix(model, "-test"))
+	}
+	return chain
 }
 
 // createVideoTaskJSON creates a video task using JSON format (for text-to-video)
-func (c *VectorEngineClient) createVideoTaskJSON(prompt, modelName string) (*VectorEngineCreateResponse, error) {
+func (c *VectorEngineClient) createVideoTaskJSON(ctx context.Context, key, prompt, modelName string, watermark bool) (*VectorEngineCreateResponse, error) {
 	reqBody := map[string]interface{}{
-		"prompt": prompt,
-		"model":  modelName,
+		"prompt":    prompt,
+		"model":     modelName,
+		"watermark": watermark,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -121,14 +377,16 @@ func (c *VectorEngineClient) createVideoTaskJSON(prompt, modelName string) (*Vec
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", DyuAPIBaseURL+"/v1/videos", bytes.NewBuffer(jsonData))
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/v1/videos"), bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	if c.dyuAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+c.dyuAPIKey)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -140,7 +398,7 @@ func (c *VectorEngineClient) createVideoTaskJSON(prompt, modelName string) (*Vec
 	respBody, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newAPIError(resp, respBody)
 	}
 
 	var result VectorEngineCreateResponse
@@ -152,77 +410,21 @@ func (c *VectorEngineClient) createVideoTaskJSON(prompt, modelName string) (*Vec
 }
 
 // createVideoTaskMultipart creates a video task using multipart/form-data format (for image-to-video)
-func (c *VectorEngineClient) createVideoTaskMultipart(prompt, imageURL, modelName string) (*VectorEngineCreateResponse, error) {
-	boundary := "wL36Yn8afVp8Ag7AmP8qZ0SA4n1v9T"
-	var body bytes.Buffer
-
-	// Helper function to add a text field
-	addField := func(name, value string) {
-		body.WriteString("--" + boundary + "\r\n")
-		body.WriteString(fmt.Sprintf("Content-Disposition: form-data; name=\"%s\"\r\n", name))
-		body.WriteString("Content-Type: text/plain\r\n")
-		body.WriteString("\r\n")
-		body.WriteString(value + "\r\n")
-	}
-
-	// Add model field
-	addField("model", modelName)
-
-	// Add prompt field
-	addField("prompt", prompt)
-
-	// Add input_reference (image)
-	// Check if it's a base64 data URL
-	if strings.HasPrefix(imageURL, "data:image/") {
-		// Parse data URL: data:image/png;base64,xxxxx
-		parts := strings.SplitN(imageURL, ",", 2)
-		if len(parts) == 2 {
-			// Get mime type from the first part
-			mimeType := "image/png"
-			if strings.Contains(parts[0], "image/jpeg") {
-				mimeType = "image/jpeg"
-			} else if strings.Contains(parts[0], "image/gif") {
-				mimeType = "image/gif"
-			} else if strings.Contains(parts[0], "image/webp") {
-				mimeType = "image/webp"
-			}
-
-			// Decode base64
-			imageData, err := base64.StdEncoding.DecodeString(parts[1])
-			if err != nil {
-				return nil, fmt.Errorf("failed to decode base64 image: %w", err)
-			}
-
-			// Determine file extension
-			ext := ".png"
-			if mimeType == "image/jpeg" {
-				ext = ".jpg"
-			} else if mimeType == "image/gif" {
-				ext = ".gif"
-			} else if mimeType == "image/webp" {
-				ext = ".webp"
-			}
-
-			// Add image as file field
-			body.WriteString("--" + boundary + "\r\n")
-			body.WriteString(fmt.Sprintf("Content-Disposition: form-data; name=\"input_reference\"; filename=\"image%s\"\r\n", ext))
-			body.WriteString(fmt.Sprintf("Content-Type: %s\r\n", mimeType))
-			body.WriteString("\r\n")
-			body.Write(imageData)
-			body.WriteString("\r\n")
-		}
+func (c *VectorEngineClient) createVideoTaskMultipart(ctx context.Context, key, prompt, imageURL, imageURL2, modelName string, watermark bool) (*VectorEngineCreateResponse, error) {
+	body, contentType, err := c.videoTaskForm(prompt, imageURL, imageURL2, modelName, watermark)
+	if err != nil {
+		return nil, err
 	}
 
-	// End boundary
-	body.WriteString("--" + boundary + "--\r\n")
-
-	req, err := http.NewRequest("POST", DyuAPIBaseURL+"/v1/videos", &body)
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/v1/videos"), body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
-	if c.dyuAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+c.dyuAPIKey)
+	req.Header.Set("Content-Type", contentType)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -234,7 +436,7 @@ func (c *VectorEngineClient) createVideoTaskMultipart(prompt, imageURL, modelNam
 	respBody, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+		return nil, newAPIError(resp, respBody)
 	}
 
 	var result VectorEngineCreateResponse
@@ -245,24 +447,106 @@ func (c *VectorEngineClient) createVideoTaskMultipart(prompt, imageURL, modelNam
 	return &result, nil
 }
 
-// CreateVideoTask submits a new video generation task to Dyu API
-func (c *VectorEngineClient) CreateVideoTask(prompt, imageURL, imageURL2, duration, orientation, model string) (*VectorEngineCreateResponse, error) {
-	if c.dyuAPIKey == "" {
+// videoTaskForm builds the multipart body of an image-to-video submission with a random boundary
+// The images, a base64 data URL or an uploaded file each, are sent as input_reference file parts in
+// order: the first frame, then image_url2 when present
+func (c *VectorEngineClient) videoTaskForm(prompt, imageURL, imageURL2, modelName string, watermark bool) (*bytes.Buffer, string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, field := range [][2]string{
+		{"model", modelName},
+		{"prompt", prompt},
+		{"watermark", strconv.FormatBool(watermark)},
+	} {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return nil, "", fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+
+	for i, image := range []string{imageURL, imageURL2} {
+		// Downscaled when above the max dimension
+		data, mimeType, err := c.submissionImage(image)
+		if err != nil {
+			return nil, "", err
+		}
+		if data == nil {
+			continue
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="input_reference"; filename="image%d%s"`, i+1, uploadExtensions[mimeType]))
+		header.Set("Content-Type", mimeType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to write form file: %w", err)
+		}
+		if _, err := part.Write(data); err != nil {
+			return nil, "", fmt.Errorf("failed to write form file: %w", err)
+		}
+	}
+
+	if err := form.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to close form: %w", err)
+	}
+	return &body, form.FormDataContentType(), nil
+}
+
+// CreateVideoTask submits a new video generation task to Dyu API, Veo3 models take the Veo3 path
+// With several keys configured, a key that fails with an authentication or quota error is put on
+// cooldown and the next key is tried; resp.KeyIndex and resp.KeyFingerprint identify the key used
+func (c *VectorEngineClient) CreateVideoTask(ctx context.Context, prompt, imageURL, imageURL2, duration, orientation, model string, watermark bool) (*VectorEngineCreateResponse, error) {
+	count := c.keys.size()
+	if count == 0 {
 		return nil, fmt.Errorf("未配置API密钥，请在config.json中配置dyu_api_key")
 	}
-	return c.CreateVideoTaskDyuAPI(prompt, imageURL, duration, orientation)
+
+	var failures []string
+	for attempt := 0; attempt < count; attempt++ {
+		index, key, retryAt, ok := c.keys.next()
+		if !ok {
+			return nil, &KeysExhaustedError{Failures: failures, RetryAt: retryAt}
+		}
+
+		var resp *VectorEngineCreateResponse
+		var err error
+		if IsVeo3Model(model) {
+			resp, err = c.CreateVideoTaskVeo3(ctx, key, prompt, imageURL, imageURL2, orientation, model)
+		} else {
+			resp, err = c.CreateVideoTaskDyuAPI(ctx, key, prompt, imageURL, imageURL2, duration, orientation, watermark)
+		}
+		if err == nil {
+			resp.KeyIndex = index + 1
+			resp.KeyFingerprint = apiKeyFingerprint(key)
+			return resp, nil
+		}
+		// A single key keeps the previous behavior, the error is retried by the processor
+		if count == 1 || !IsKeyFailure(err.Error()) {
+			return nil, err
+		}
+		log.Printf("[APIKey] 密钥 #%d 不可用，冷却后再试，切换到下一个密钥: %v", index+1, err)
+		c.keys.markExhausted(key)
+		failures = append(failures, fmt.Sprintf("#%d: %v", index+1, err))
+	}
+	return nil, &KeysExhaustedError{Failures: failures}
 }
 
 // QueryTaskStatus queries the status of a video generation task from Dyu API
-func (c *VectorEngineClient) QueryTaskStatus(taskID string) (*VectorEngineQueryResponse, error) {
+// model selects the Veo3 path for Veo3 tasks; keyFingerprint selects the key the task was submitted
+// with, "" uses the active key
+func (c *VectorEngineClient) QueryTaskStatus(ctx context.Context, model, taskID, keyFingerprint string) (*VectorEngineQueryResponse, error) {
+	if IsVeo3Model(model) {
+		return c.QueryTaskStatusVeo3(ctx, taskID, keyFingerprint)
+	}
+
 	// Use Dyu API: /v1/videos/{task_id}
-	req, err := http.NewRequest("GET", DyuAPIBaseURL+"/v1/videos/"+taskID, nil)
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL("/v1/videos/"+taskID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Accept", "application/json")
-	if c.dyuAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+c.dyuAPIKey)
+	if key := c.keys.byFingerprint(keyFingerprint); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -278,7 +562,7 @@ func (c *VectorEngineClient) QueryTaskStatus(taskID string) (*VectorEngineQueryR
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, body)
 	}
 
 	var result VectorEngineQueryResponse
@@ -305,10 +589,285 @@ func (c *VectorEngineClient) QueryTaskStatus(taskID string) (*VectorEngineQueryR
 			result.FailReason = dyuResp.FailReason
 		}
 	}
+	result.RawBody = string(body)
 
 	return &result, nil
 }
 
+const (
+	// keyValidationTimeout bounds the API key validation request
+	keyValidationTimeout = 10 * time.Second
+	// keyValidationTaskID is a task ID that never exists, querying it only exercises authentication
+	keyValidationTaskID = "video_videogen_key_check"
+)
+
+// ValidateAPIKey checks a candidate API key by querying the status of a dummy task at baseURL
+// Returns whether the key was accepted and, when it wasn't, the upstream error text
+// An error is returned when the API can't be reached or answers with an unexpected status
+func ValidateAPIKey(ctx context.Context, baseURL, key string) (bool, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", joinAPIURL(baseURL, "/v1/videos/"+keyValidationTaskID), nil)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	client := &http.Client{Timeout: keyValidationTimeout, Transport: newAPITransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	upstream := fmt.Sprintf("API error (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	switch {
+	case IsAuthFailure(upstream):
+		return false, upstream, nil
+	case resp.StatusCode < 500:
+		// Authenticated, the dummy task is simply not found
+		return true, "", nil
+	default:
+		return false, "", fmt.Errorf("%s", upstream)
+	}
+}
+
+// Kinds of upstream errors, matched with errors.Is on the errors returned by the client
+var (
+	// ErrRetryable is a transient failure (connection error, timeout, 429 or 5xx), the same request
+	// may succeed later
+	ErrRetryable = errors.New("temporary upstream error")
+	// ErrContentPolicy is a rejection of the prompt or image by the provider's moderation
+	ErrContentPolicy = errors.New("content policy violation")
+	// ErrAuth is a missing, invalid or expired API key
+	ErrAuth = errors.New("API key rejected")
+	// ErrQuota is an API key without remaining balance or quota
+	ErrQuota = errors.New("API quota exhausted")
+)
+
+// contentPolicyPatterns are error substrings produced when moderation rejects a task
+var contentPolicyPatterns = []string{
+	"提示词违规",
+	"内容违规",
+	"违反",
+	"敏感",
+	"审核",
+	"content policy",
+	"content_policy",
+	"moderation",
+	"safety system",
+	"sensitive",
+}
+
+// APIError is a non-2xx response of the API, its Kind is derived from the status and body
+type APIError struct {
+	StatusCode int
+	Body       string
+	Kind       error         // One of the Err* kinds, nil for a permanent rejection of the request
+	RetryAfter time.Duration // Wait asked for by the Retry-After header, 0 when absent
+}
+
+// newAPIError classifies an error response
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	statusCode := resp.StatusCode
+	e := &APIError{
+		StatusCode: statusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	switch {
+	case containsAnyFold(e.Body, contentPolicyPatterns):
+		e.Kind = ErrContentPolicy
+	case statusCode == http.StatusPaymentRequired || containsAnyFold(e.Body, quotaFailurePatterns):
+		// Checked before auth, quota errors are often answered with 403
+		e.Kind = ErrQuota
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden || IsAuthFailure(e.Body):
+		e.Kind = ErrAuth
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		e.Kind = ErrRetryable
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// apiResponseOf returns the raw response body of an API error, or the error text for errors that
+// never reached the API
+func apiResponseOf(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Body
+	}
+	return err.Error()
+}
+
+// MaxRetryAfter caps the wait asked for by a Retry-After header
+const MaxRetryAfter = 10 * time.Minute
+
+// parseRetryAfter parses a Retry-After header, either seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = at.Sub(now)
+	}
+	return min(max(wait, 0), MaxRetryAfter)
+}
+
+// RateLimitWait reports whether err is a 429 response, and the wait its Retry-After asked for
+func RateLimitWait(err error) (time.Duration, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		return apiErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// ClassifyError returns the kind of an error returned by the client: ErrContentPolicy, ErrAuth,
+// ErrQuota or ErrRetryable, or nil when the request was rejected and resending it can't help
+// Errors that carry no kind, such as connection resets, timeouts and undecodable responses, are
+// retryable unless their message matches a known pattern
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range []error{ErrContentPolicy, ErrAuth, ErrQuota, ErrRetryable} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return nil
+	}
+	message := err.Error()
+	switch {
+	case containsAnyFold(message, contentPolicyPatterns):
+		return ErrContentPolicy
+	case containsAnyFold(message, quotaFailurePatterns):
+		return ErrQuota
+	case IsAuthFailure(message):
+		return ErrAuth
+	}
+	return ErrRetryable
+}
+
+// Failure codes stored with the fail_reason of tasks, from the kind of the error that failed them
+const (
+	FailureAuth          = "auth"
+	FailureQuota         = "quota"
+	FailureContentPolicy = "content_policy"
+	FailureRetryable     = "retryable" // Transient errors, the task failed once max_retries was exceeded
+	FailureRejected      = "rejected"  // Requests the API refused, resending them can't help
+	FailureRemote        = "remote"    // The provider failed the generation
+)
+
+// failureCode returns the failure code of an error kind returned by ClassifyError
+func failureCode(kind error) string {
+	switch kind {
+	case ErrAuth:
+		return FailureAuth
+	case ErrQuota:
+		return FailureQuota
+	case ErrContentPolicy:
+		return FailureContentPolicy
+	case ErrRetryable:
+		return FailureRetryable
+	}
+	return FailureRejected
+}
+
+// remoteFailureCode returns the failure code of a generation the provider failed with reason
+func remoteFailureCode(reason string) string {
+	if containsAnyFold(reason, contentPolicyPatterns) {
+		return FailureContentPolicy
+	}
+	return FailureRemote
+}
+
+// legacyFailureCode classifies the fail_reason of a task that failed before failure codes were
+// stored, empty when it matches no kind
+// Content policy is matched first, its messages may quote an auth-like status such as 403
+func legacyFailureCode(failReason string) string {
+	switch {
+	case containsAnyFold(failReason, contentPolicyPatterns):
+		return FailureContentPolicy
+	case containsAnyFold(failReason, quotaFailurePatterns):
+		return FailureQuota
+	case IsAuthFailure(failReason):
+		return FailureAuth
+	}
+	return ""
+}
+
+// containsAnyFold reports whether message contains one of patterns, ignoring case
+func containsAnyFold(message string, patterns []string) bool {
+	lower := strings.ToLower(message)
+	for _, pattern := range patterns {
+		if strings.Contains(lower, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authFailurePatterns are fail_reason substrings produced by authentication errors
+// Content policy and validation failures never match these
+var authFailurePatterns = []string{
+	"status 401",
+	"status 403",
+	"未配置API密钥",
+	"无效的令牌",
+	"令牌已过期",
+	"invalid api key",
+	"invalid token",
+	"incorrect api key",
+	"unauthorized",
+}
+
+// channelFailurePatterns are error substrings produced when a model has no upstream channel
+var channelFailurePatterns = []string{
+	"暂无渠道",
+	"无可用渠道",
+	"no available channel",
+	"model_not_found",
+	"unavailable",
+}
+
+// IsChannelUnavailable reports whether a submission failed because the model can't be served
+// right now, such errors move on to the next model of the fallback chain
+func IsChannelUnavailable(message string) bool {
+	lower := strings.ToLower(message)
+	for _, pattern := range channelFailurePatterns {
+		if strings.Contains(lower, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAuthFailure reports whether a task's fail_reason was caused by a bad or missing API key
+func IsAuthFailure(failReason string) bool {
+	reason := strings.ToLower(failReason)
+	for _, pattern := range authFailurePatterns {
+		if strings.Contains(reason, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
 // isNumericString checks if a string contains only digits
 func isNumericString(s string) bool {
 	if s == "" {
@@ -334,102 +893,324 @@ func GenerateVideoFilename(taskID string) string {
 
 // EnsureOutputDirectory creates the output directory if it doesn't exist
 func EnsureOutputDirectory() error {
-	if err := os.MkdirAll(OutputDirectory, 0755); err != nil {
+	if err := os.MkdirAll(OutputDirectory(), 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 	return nil
 }
 
+// expandPath expands a leading ~ to the home directory and makes the path absolute
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve home directory: %w", err)
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
+// SetupOutputDirectory resolves the configured output directory to an absolute path,
+// creates it and verifies that it is writable
+func SetupOutputDirectory(dir string) error {
+	if strings.TrimSpace(dir) == "" {
+		dir = DefaultOutputDirectory
+	}
+	resolved, err := expandPath(dir)
+	if err != nil {
+		return fmt.Errorf("invalid output directory %q: %w", dir, err)
+	}
+	if err := os.MkdirAll(resolved, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", resolved, err)
+	}
+
+	probe, err := os.CreateTemp(resolved, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable: %w", resolved, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	setOutputDirectory(resolved)
+	return nil
+}
+
+// Settings recording the output directories, so videos downloaded before output_dir was changed,
+// from PUT /api/config or in config.json while stopped, are still found
+const (
+	outputDirSetting          = "output_dir"           // Output directory of the last run
+	previousOutputDirsSetting = "previous_output_dirs" // JSON list of earlier directories, most recent first
+)
+
+// maxPreviousOutputDirs bounds the earlier output directories ResolveVideoPath searches
+const maxPreviousOutputDirs = 10
+
+// LoadPreviousOutputDirectories reads the earlier output directories recorded in app_settings
+func LoadPreviousOutputDirectories() error {
+	value, err := GetSetting(previousOutputDirsSetting)
+	if err != nil {
+		return err
+	}
+	var previous []string
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &previous); err != nil {
+			return fmt.Errorf("invalid %s setting: %w", previousOutputDirsSetting, err)
+		}
+	}
+	outputDirs.Lock()
+	outputDirs.previous = previous
+	outputDirs.Unlock()
+	return nil
+}
+
+// RecordOutputDirectory stores the current output directory in app_settings, the one recorded
+// before it is added to the earlier directories when it differs
+func RecordOutputDirectory() error {
+	if err := LoadPreviousOutputDirectories(); err != nil {
+		return err
+	}
+	current := OutputDirectory()
+	last, err := GetSetting(outputDirSetting)
+	if err != nil {
+		return err
+	}
+	if last == current {
+		return nil
+	}
+
+	previous := slices.DeleteFunc(slices.Clone(previousOutputDirectories()), func(dir string) bool {
+		return dir == current || dir == last
+	})
+	if last != "" {
+		previous = append([]string{last}, previous...)
+	}
+	if len(previous) > maxPreviousOutputDirs {
+		previous = previous[:maxPreviousOutputDirs]
+	}
+	data, err := json.Marshal(previous)
+	if err != nil {
+		return err
+	}
+	if err := SetSetting(previousOutputDirsSetting, string(data)); err != nil {
+		return err
+	}
+	if err := SetSetting(outputDirSetting, current); err != nil {
+		return err
+	}
+	outputDirs.Lock()
+	outputDirs.previous = previous
+	outputDirs.Unlock()
+	return nil
+}
+
+// ResolveVideoPath returns the full path of a downloaded video from its stored filename
+// Videos downloaded before output_dir was changed are still found in the earlier output
+// directories and the default one
+func ResolveVideoPath(filename string) string {
+	localPath := filepath.Join(OutputDirectory(), filename)
+	if _, err := os.Stat(localPath); err == nil {
+		return localPath
+	}
+	for _, dir := range slices.Concat(previousOutputDirectories(), []string{DefaultOutputDirectory}) {
+		path := filepath.Join(dir, filename)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return localPath
+}
+
 // DownloadVideo downloads a video from the given URL and saves it to the output directory
 // Uses multi-threaded download for faster speeds
+// The video is written to a .part file, resumed by the next attempt after a failure, and only
+// renamed to its final name once complete
 // Returns the local filename (not full path) of the saved video
-func (c *VectorEngineClient) DownloadVideo(videoURL, taskID string) (string, error) {
+func (c *VectorEngineClient) DownloadVideo(ctx context.Context, videoURL, taskID string) (string, error) {
 	// Ensure output directory exists
 	if err := EnsureOutputDirectory(); err != nil {
 		return "", err
 	}
+	cleanStaleParts()
+
+	videoURL = c.resolveURL(videoURL)
 
 	// Generate unique filename
 	filename := GenerateVideoFilename(taskID)
-	localPath := filepath.Join(OutputDirectory, filename)
+	localPath := filepath.Join(OutputDirectory(), filename)
+	partPath := partFilePath(taskID)
+	options := *c.downloads.Load()
+	bucket := newTokenBucket(options.BytesPerSecond)
+	log.Printf("[Download] 开始下载 %s (%s)", filename, options)
 
 	// First, get the file size with a HEAD request
-	headResp, err := c.httpClient.Head(videoURL)
+	headReq, err := http.NewRequestWithContext(ctx, "HEAD", videoURL, nil)
 	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	headResp, err := c.httpClient.Do(headReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		// Fallback to simple download if HEAD fails
-		return c.downloadVideoSimple(videoURL, localPath, filename)
+		return c.downloadVideoSimple(ctx, videoURL, partPath, localPath, filename, bucket)
 	}
 	headResp.Body.Close()
 
 	contentLength := headResp.ContentLength
 	acceptRanges := headResp.Header.Get("Accept-Ranges")
 
-	// If server doesn't support range requests or file is small, use simple download
-	if acceptRanges != "bytes" || contentLength <= 0 || contentLength < 1024*1024 {
-		return c.downloadVideoSimple(videoURL, localPath, filename)
+	// If server doesn't support range requests, the file is small or a single thread is configured, use simple download
+	// Text responses (e.g. an HTML error page) also go through the simple path, which rejects them with a body excerpt
+	numThreads := options.threads(contentLength)
+	if acceptRanges != "bytes" || contentLength <= 0 || numThreads <= 1 || isTextContentType(headResp.Header.Get("Content-Type")) {
+		return c.downloadVideoSimple(ctx, videoURL, partPath, localPath, filename, bucket)
 	}
 
-	log.Printf("[Download] 使用多线程下载, 文件大小: %.2f MB", float64(contentLength)/1024/1024)
+	log.Printf("[Download] 使用多线程下载, 文件大小: %.2f MB, %d 线程", float64(contentLength)/1024/1024, numThreads)
 
 	// Use multi-threaded download
-	numThreads := 8
-	if contentLength < 10*1024*1024 { // Less than 10MB
-		numThreads = 4
-	}
-
-	return c.downloadVideoMultiThread(videoURL, localPath, filename, contentLength, numThreads)
+	return c.downloadVideoMultiThread(ctx, videoURL, partPath, localPath, filename, contentLength, numThreads, bucket)
 }
 
 // downloadVideoSimple downloads video using simple single-thread method
-func (c *VectorEngineClient) downloadVideoSimple(videoURL, localPath, filename string) (string, error) {
-	resp, err := c.httpClient.Get(videoURL)
+// A .part file left by an earlier attempt is continued with a Range request when the server supports it
+func (c *VectorEngineClient) downloadVideoSimple(ctx context.Context, videoURL, partPath, localPath, filename string, bucket *tokenBucket) (string, error) {
+	state := loadPartState(partPath, 0)
+	offset := state.prefix()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", videoURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to download video: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp) == offset:
+		log.Printf("[Download] 从 %.2f MB 处继续下载: %s", float64(offset)/1024/1024, filename)
+	case resp.StatusCode == http.StatusOK:
+		// Range not supported, or nothing to resume: start over
+		offset = 0
+		state.Done = nil
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return "", &VideoExpiredError{StatusCode: resp.StatusCode}
+	default:
 		return "", fmt.Errorf("failed to download video: status %d", resp.StatusCode)
 	}
+	if err := checkDiskSpace(resp.ContentLength); err != nil {
+		return "", err
+	}
 
-	// Create the output file
-	outFile, err := os.Create(localPath)
+	// Inspect the first bytes before anything is written to disk
+	reader := bufio.NewReaderSize(limitReader(ctx, resp.Body, bucket), videoSniffLength)
+	head, _ := reader.Peek(videoSniffLength)
+	if err := checkVideoContent(resp.Header.Get("Content-Type"), head); err != nil {
+		return "", err
+	}
+
+	// Open the .part file, keeping only the part being resumed
+	outFile, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer outFile.Close()
+	if err := outFile.Truncate(offset); err == nil {
+		_, err = outFile.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		outFile.Close()
+		removePart(partPath)
+		return "", fmt.Errorf("failed to create output file: %w", err)
+	}
 
 	// Copy the response body to the file
-	_, err = io.Copy(outFile, resp.Body)
+	var total int64
+	if resp.ContentLength > 0 {
+		total = offset + resp.ContentLength
+	}
+	written, err := io.Copy(io.MultiWriter(outFile, newDownloadCounter(ctx, offset, total)), reader)
+	outFile.Close()
+	if written > 0 {
+		state.add(offset, offset+written-1)
+	}
 	if err != nil {
-		os.Remove(localPath)
+		state.save()
 		return "", fmt.Errorf("failed to save video: %w", err)
 	}
 
+	// A short body means the connection was cut off mid-transfer
+	if resp.ContentLength > 0 && written != resp.ContentLength {
+		state.save()
+		return "", fmt.Errorf("incomplete video download: got %d of %d bytes", written, resp.ContentLength)
+	}
+
+	if offset > 0 {
+		// The resumed body started mid-file, check the beginning of the complete file
+		if err := checkVideoFile(partPath); err != nil {
+			removePart(partPath)
+			return "", err
+		}
+	}
+	if err := finishPart(partPath, localPath); err != nil {
+		return "", fmt.Errorf("failed to save video: %w", err)
+	}
 	return filename, nil
 }
 
+// contentRangeStart returns the first byte of a 206 response from its Content-Range header, -1 when missing
+func contentRangeStart(resp *http.Response) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d", &start, &end); err != nil {
+		return -1
+	}
+	return start
+}
+
 // downloadVideoMultiThread downloads video using multiple threads
-func (c *VectorEngineClient) downloadVideoMultiThread(videoURL, localPath, filename string, contentLength int64, numThreads int) (string, error) {
-	// Create the output file
-	outFile, err := os.Create(localPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create output file: %w", err)
+// Completed ranges are recorded next to the .part file, so a retry only requests the missing ones
+func (c *VectorEngineClient) downloadVideoMultiThread(ctx context.Context, videoURL, partPath, localPath, filename string, contentLength int64, numThreads int, bucket *tokenBucket) (string, error) {
+	state := loadPartState(partPath, contentLength)
+	if err := checkDiskSpace(contentLength - state.written()); err != nil {
+		return "", err
 	}
+	if len(state.Done) > 0 {
+		log.Printf("[Download] 继续未完成的下载, 还需 %.2f MB: %s", float64(contentLength-state.written())/1024/1024, filename)
+	} else {
+		// Create the output file
+		outFile, err := os.Create(partPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to create output file: %w", err)
+		}
 
-	// Pre-allocate file size
-	if err := outFile.Truncate(contentLength); err != nil {
+		// Pre-allocate file size
+		if err := outFile.Truncate(contentLength); err != nil {
+			outFile.Close()
+			removePart(partPath)
+			return "", fmt.Errorf("failed to allocate file: %w", err)
+		}
 		outFile.Close()
-		os.Remove(localPath)
-		return "", fmt.Errorf("failed to allocate file: %w", err)
 	}
-	outFile.Close()
 
 	// Calculate chunk size
 	chunkSize := contentLength / int64(numThreads)
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	errChan := make(chan error, numThreads)
+	counter := newDownloadCounter(ctx, state.written(), contentLength)
+	record := func(start, end int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		state.add(start, end)
+		state.save()
+	}
 
 	for i := 0; i < numThreads; i++ {
 		start := int64(i) * chunkSize
@@ -438,62 +1219,196 @@ func (c *VectorEngineClient) downloadVideoMultiThread(videoURL, localPath, filen
 			end = contentLength - 1 // Last chunk gets the remainder
 		}
 
-		wg.Add(1)
-		go func(threadID int, start, end int64) {
-			defer wg.Done()
-			err := c.downloadChunk(videoURL, localPath, start, end)
-			if err != nil {
-				errChan <- fmt.Errorf("thread %d failed: %w", threadID, err)
-			}
-		}(i, start, end)
+		for _, gap := range state.missing(start, end) {
+			wg.Add(1)
+			go func(threadID int, start, end int64) {
+				defer wg.Done()
+				err := c.downloadChunkWithRetry(ctx, videoURL, partPath, start, end, counter, bucket, record)
+				if err != nil {
+					errChan <- fmt.Errorf("thread %d failed: %w", threadID, err)
+				}
+			}(i, gap[0], gap[1])
+		}
 	}
 
 	wg.Wait()
 	close(errChan)
 
-	// Check for errors
+	// Check for errors, the .part file and its completed ranges are kept for the next attempt
 	for err := range errChan {
-		os.Remove(localPath)
 		return "", err
 	}
 
+	// Every chunk reported its full length, the file must still have exactly the announced size
+	if info, err := os.Stat(partPath); err != nil || info.Size() != contentLength || len(state.missing(0, contentLength-1)) > 0 {
+		removePart(partPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat downloaded file: %w", err)
+		}
+		return "", fmt.Errorf("incomplete video download: got %d of %d bytes", info.Size(), contentLength)
+	}
+
+	// The range requests may still have returned an error page instead of video data
+	if err := checkVideoFile(partPath); err != nil {
+		removePart(partPath)
+		return "", err
+	}
+
+	if err := finishPart(partPath, localPath); err != nil {
+		return "", fmt.Errorf("failed to save video: %w", err)
+	}
 	log.Printf("[Download] 多线程下载完成: %s", filename)
 	return filename, nil
 }
 
-// downloadChunk downloads a specific byte range of the file
-func (c *VectorEngineClient) downloadChunk(videoURL, localPath string, start, end int64) error {
-	req, err := http.NewRequest("GET", videoURL, nil)
+// chunkAttempts is how many times a single chunk is requested before the download fails
+const chunkAttempts = 3
+
+// downloadChunkWithRetry downloads a byte range, requesting the rest again when it comes back short
+// Every written range is passed to record
+func (c *VectorEngineClient) downloadChunkWithRetry(ctx context.Context, videoURL, localPath string, start, end int64, progress io.Writer, bucket *tokenBucket, record func(start, end int64)) error {
+	var err error
+	for attempt := 1; attempt <= chunkAttempts; attempt++ {
+		var written int64
+		written, err = c.downloadChunk(ctx, videoURL, localPath, start, end, progress, bucket)
+		if written > 0 {
+			record(start, start+written-1)
+			start += written
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if attempt < chunkAttempts {
+			log.Printf("[Download] 分块 %d-%d 下载失败 (第 %d/%d 次), 重试: %v", start, end, attempt, chunkAttempts, err)
+		}
+	}
+	return err
+}
+
+// downloadChunk downloads a specific byte range of the file and returns the number of bytes written
+// Fails unless exactly end-start+1 bytes were written, so a cut-off response never leaves a hole
+// Written bytes are also counted by progress, the body is read through bucket when set
+func (c *VectorEngineClient) downloadChunk(ctx context.Context, videoURL, localPath string, start, end int64, progress io.Writer, bucket *tokenBucket) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", videoURL, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		return 0, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	// A 200 carries the whole file from its first byte, only usable for the first chunk
+	if resp.StatusCode == http.StatusOK && start != 0 {
+		return 0, fmt.Errorf("range request for bytes %d-%d was ignored", start, end)
 	}
 
 	// Open file for writing at specific position
 	file, err := os.OpenFile(localPath, os.O_WRONLY, 0644)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer file.Close()
 
 	// Seek to the correct position
 	if _, err := file.Seek(start, 0); err != nil {
-		return err
+		return 0, err
 	}
 
 	// Copy data
-	_, err = io.Copy(file, resp.Body)
-	return err
+	want := end - start + 1
+	written, err := io.CopyN(io.MultiWriter(file, progress), limitReader(ctx, resp.Body, bucket), want)
+	if written != want {
+		return written, fmt.Errorf("short chunk: got %d of %d bytes: %w", written, want, err)
+	}
+	return written, nil
+}
+
+// videoSniffLength is the number of leading bytes inspected to recognize a video file
+const videoSniffLength = 512
+
+// InvalidVideoError is returned when a download produced something other than a video,
+// typically an HTML or JSON error page served by the CDN after the signed URL expired
+type InvalidVideoError struct {
+	ContentType string
+	Excerpt     string
+}
+
+func (e *InvalidVideoError) Error() string {
+	return fmt.Sprintf("downloaded content is not a video (content-type %q): %s", e.ContentType, e.Excerpt)
+}
+
+// VideoExpiredError is returned when the video URL answers 403, 404 or 410, the signed URL has
+// expired or the upstream deleted the video
+type VideoExpiredError struct {
+	StatusCode int
+}
+
+func (e *VideoExpiredError) Error() string {
+	return fmt.Sprintf("video URL has expired: status %d", e.StatusCode)
+}
+
+// isTextContentType reports whether a Content-Type header describes HTML, JSON or plain text
+func isTextContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") || strings.Contains(contentType, "html")
+}
+
+// hasVideoSignature reports whether the data starts with an MP4/MOV or WEBM/MKV signature
+func hasVideoSignature(head []byte) bool {
+	// MP4/MOV: a box size followed by a box type such as "ftyp" at offset 4
+	if len(head) >= 8 {
+		switch string(head[4:8]) {
+		case "ftyp", "moov", "mdat", "free", "skip", "wide":
+			return true
+		}
+	}
+	// WEBM/MKV: EBML header
+	return bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3})
+}
+
+// checkVideoContent validates the Content-Type header and the leading bytes of a download
+// Returns an *InvalidVideoError when the content looks like HTML/JSON instead of a video
+func checkVideoContent(contentType string, head []byte) error {
+	if hasVideoSignature(head) {
+		return nil
+	}
+
+	trimmed := bytes.TrimSpace(head)
+	looksLikeText := strings.HasPrefix(http.DetectContentType(head), "text/") ||
+		bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("["))
+	if !looksLikeText && !isTextContentType(contentType) {
+		// Unknown binary format, let the player decide
+		return nil
+	}
+
+	excerpt := string(trimmed)
+	if len([]rune(excerpt)) > 200 {
+		excerpt = string([]rune(excerpt)[:200]) + "..."
+	}
+	return &InvalidVideoError{ContentType: contentType, Excerpt: excerpt}
+}
+
+// checkVideoFile sniffs the beginning of a downloaded file
+func checkVideoFile(localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify video file: %w", err)
+	}
+	defer file.Close()
+
+	head := make([]byte, videoSniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to verify video file: %w", err)
+	}
+	return checkVideoContent("", head[:n])
 }
 
 // DeleteVideoFile removes a video file from the output directory
@@ -501,11 +1416,13 @@ func DeleteVideoFile(filename string) error {
 	if filename == "" {
 		return nil
 	}
-	localPath := filepath.Join(OutputDirectory, filename)
+	localPath := ResolveVideoPath(filename)
 	err := os.Remove(localPath)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete video file: %w", err)
 	}
+	// Also remove the untrimmed original kept by an in-place trim
+	os.Remove(ResolveVideoPath(filename + OriginalVideoSuffix))
 	return nil
 }
 
@@ -513,7 +1430,7 @@ func DeleteVideoFile(filename string) error {
 // API: POST https://api.dyuapi.com/v1/videos
 // Supports both task ID (character param) and URL (url param)
 // Sets model="character-training", prompt="角色创建"
-func (c *VectorEngineClient) CreateCharacterSora2(sourceType, sourceValue, timestamps string) (*Sora2CharacterResponse, error) {
+func (c *VectorEngineClient) CreateCharacterSora2(ctx context.Context, sourceType, sourceValue, timestamps string) (*Sora2CharacterResponse, error) {
 	reqBody := Sora2CharacterRequest{
 		Prompt:     "角色创建",
 		Model:      "character-training",
@@ -534,14 +1451,16 @@ func (c *VectorEngineClient) CreateCharacterSora2(sourceType, sourceValue, times
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", DyuAPIBaseURL+"/v1/videos", bytes.NewBuffer(jsonData))
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/v1/videos"), bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	if c.dyuAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+c.dyuAPIKey)
+	if key := c.apiKey(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -553,7 +1472,7 @@ func (c *VectorEngineClient) CreateCharacterSora2(sourceType, sourceValue, times
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, body)
 	}
 
 	var result Sora2CharacterResponse
@@ -567,14 +1486,16 @@ func (c *VectorEngineClient) CreateCharacterSora2(sourceType, sourceValue, times
 // QueryCharacterStatus queries the training status of a character from Sora2 API
 // API: GET https://api.dyuapi.com/v1/videos/{id}
 // Returns status, progress, and fail_reason
-func (c *VectorEngineClient) QueryCharacterStatus(characterID string) (*Sora2CharacterResponse, error) {
-	req, err := http.NewRequest("GET", DyuAPIBaseURL+"/v1/videos/"+characterID, nil)
+func (c *VectorEngineClient) QueryCharacterStatus(ctx context.Context, characterID string) (*Sora2CharacterResponse, error) {
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL("/v1/videos/"+characterID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Accept", "application/json")
-	if c.dyuAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+c.dyuAPIKey)
+	if key := c.apiKey(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -589,7 +1510,7 @@ func (c *VectorEngineClient) QueryCharacterStatus(characterID string) (*Sora2Cha
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, body)
 	}
 
 	var result Sora2CharacterResponse
@@ -613,7 +1534,7 @@ func EnsureCharacterPictureDirectory() error {
 
 // DownloadCharacterPicture downloads a character profile picture and saves it locally
 // Returns the local filename (not full path) of the saved picture
-func (c *VectorEngineClient) DownloadCharacterPicture(pictureURL, characterID string) (string, error) {
+func (c *VectorEngineClient) DownloadCharacterPicture(ctx context.Context, pictureURL, characterID string) (string, error) {
 	if pictureURL == "" {
 		return "", nil
 	}
@@ -630,7 +1551,11 @@ func (c *VectorEngineClient) DownloadCharacterPicture(pictureURL, characterID st
 	localPath := filepath.Join(CharacterPictureDirectory, filename)
 
 	// Download the picture
-	resp, err := c.httpClient.Get(pictureURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.resolveURL(pictureURL), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to download picture: %w", err)
 	}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeMP4 returns a minimal byte slice starting with an MP4 ftyp box
+func fakeMP4(size int) []byte {
+	data := make([]byte, size)
+	copy(data, []byte{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p', 'i', 's', 'o', 'm'})
+	return data
+}
+
+// TestDownloadVideoRejectsHTML verifies that an HTML error page served by the CDN
+// is reported as an InvalidVideoError and never left behind in the output directory
+func TestDownloadVideoRejectsHTML(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cases := []struct {
+		name        string
+		contentType string
+	}{
+		{"html content type", "text/html; charset=utf-8"},
+		{"mislabeled as video", "video/mp4"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.Write([]byte("<!DOCTYPE html><html><body>AccessDenied: Request has expired</body></html>"))
+			}))
+			defer server.Close()
+
+			client := NewVectorEngineClient("test-key")
+			filename, err := client.DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_123")
+
+			var invalidErr *InvalidVideoError
+			if !errors.As(err, &invalidErr) {
+				t.Fatalf("expected InvalidVideoError, got filename=%q err=%v", filename, err)
+			}
+			if invalidErr.Excerpt == "" {
+				t.Errorf("expected body excerpt in error")
+			}
+
+			entries, _ := os.ReadDir(OutputDirectory())
+			if len(entries) != 0 {
+				t.Errorf("expected no files in output directory, found %d", len(entries))
+			}
+		})
+	}
+}
+
+// TestDownloadVideoAcceptsMP4 verifies that a real MP4 payload is saved
+func TestDownloadVideoAcceptsMP4(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	payload := fakeMP4(4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	client := NewVectorEngineClient("test-key")
+	filename, err := client.DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_123")
+	if err != nil {
+		t.Fatalf("DownloadVideo failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(OutputDirectory(), filename))
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	if len(data) != len(payload) {
+		t.Errorf("expected %d bytes, got %d", len(payload), len(data))
+	}
+}
+
+// rangeServer serves payload with range support, fail decides per requested range how many bytes
+// of the response to send (-1 for all of them, or a 500 when it returns 0)
+// The requested ranges are appended to requests
+func rangeServer(t *testing.T, payload []byte, acceptRanges bool, fail func(start, end int) int, requests *[][2]int) *httptest.Server {
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		if acceptRanges {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			return
+		}
+		start, end := 0, len(payload)-1
+		status := http.StatusOK
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(payload)))
+		}
+		mu.Lock()
+		*requests = append(*requests, [2]int{start, end})
+		send := fail(start, end)
+		mu.Unlock()
+		if send == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		chunk := payload[start : end+1]
+		w.Header().Set("Content-Length", strconv.Itoa(len(chunk)))
+		w.WriteHeader(status)
+		if send > 0 {
+			// Cut the body off mid-transfer like a dropped proxy connection
+			w.Write(chunk[:send])
+			return
+		}
+		w.Write(chunk)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testPayload returns an MP4-looking payload of the given size with varying content
+func testPayload(size int) []byte {
+	payload := fakeMP4(size)
+	for i := 12; i < len(payload); i++ {
+		payload[i] = byte(i % 251)
+	}
+	return payload
+}
+
+// TestDownloadVideoMultiThreadShortChunk cuts off a range response and checks only the rest of
+// the chunk is requested again
+func TestDownloadVideoMultiThreadShortChunk(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	payload := testPayload(2 << 20)
+	var requests [][2]int
+	cut := false
+	server := rangeServer(t, payload, true, func(start, end int) int {
+		if start == 1<<20 && !cut {
+			cut = true
+			return 1000
+		}
+		return -1
+	}, &requests)
+
+	client := NewVectorEngineClient("test-key")
+	filename, err := client.DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_short")
+	if err != nil {
+		t.Fatalf("DownloadVideo failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(OutputDirectory(), filename))
+	if !bytes.Equal(data, payload) {
+		t.Errorf("downloaded file differs from the payload (%d of %d bytes)", len(data), len(payload))
+	}
+	found := false
+	for _, r := range requests {
+		found = found || r[0] == 1<<20+1000
+	}
+	if !found {
+		t.Errorf("the cut-off chunk was not resumed at its missing part, requests %v", requests)
+	}
+}
+
+// TestDownloadVideoDiskSpace checks a video larger than the free space fails with a DiskSpaceError
+// before anything is written
+func TestDownloadVideoDiskSpace(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Length", strconv.FormatInt(1<<60, 10))
+		if r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	_, err := NewVectorEngineClient("test-key").DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_huge")
+	var spaceErr *DiskSpaceError
+	if !errors.As(err, &spaceErr) {
+		t.Fatalf("DownloadVideo error = %v, want a DiskSpaceError", err)
+	}
+	if !strings.HasPrefix(err.Error(), "磁盘空间不足 (需要 ") {
+		t.Errorf("error = %q", err.Error())
+	}
+	if _, err := os.Stat(partFilePath("video_huge")); !os.IsNotExist(err) {
+		t.Errorf("a .part file was created: %v", err)
+	}
+
+	if got := (&DiskSpaceError{Needed: 1288490189, Free: 300 << 20}).Error(); got != "磁盘空间不足 (需要 1.2GB, 剩余 300MB)" {
+		t.Errorf("message = %q", got)
+	}
+}
+
+// TestDownloadVideoResumesPart fails part of a download, then checks the next attempt only requests
+// the missing ranges and no partial file is ever visible under a .mp4 name
+func TestDownloadVideoResumesPart(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		acceptRanges bool
+		size         int
+		fail         func(start, end int) int
+	}{
+		{"multi-thread", true, 2 << 20, func(start, end int) int {
+			if start >= 1<<20 {
+				return 0
+			}
+			return -1
+		}},
+		{"simple", false, 64 << 10, func(start, end int) int {
+			if start == 0 {
+				return 40 << 10
+			}
+			return -1
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			os.MkdirAll(OutputDirectory(), 0755)
+			stale := filepath.Join(OutputDirectory(), "old_task.mp4"+PartSuffix)
+			os.WriteFile(stale, []byte("abandoned"), 0644)
+			old := time.Now().Add(-2 * StalePartAge)
+			os.Chtimes(stale, old, old)
+
+			payload := testPayload(tc.size)
+			failing := true
+			var requests [][2]int
+			server := rangeServer(t, payload, tc.acceptRanges, func(start, end int) int {
+				if failing {
+					return tc.fail(start, end)
+				}
+				return -1
+			}, &requests)
+
+			client := NewVectorEngineClient("test-key")
+			if _, err := client.DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_resume"); err == nil {
+				t.Fatal("expected the first attempt to fail")
+			}
+			if _, err := os.Stat(stale); !os.IsNotExist(err) {
+				t.Errorf("stale .part file was not removed")
+			}
+			entries, _ := os.ReadDir(OutputDirectory())
+			for _, entry := range entries {
+				if filepath.Ext(entry.Name()) == ".mp4" {
+					t.Errorf("partial download visible as %s", entry.Name())
+				}
+			}
+
+			failing = false
+			requests = nil
+			filename, err := client.DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_resume")
+			if err != nil {
+				t.Fatalf("resumed DownloadVideo failed: %v", err)
+			}
+			data, _ := os.ReadFile(filepath.Join(OutputDirectory(), filename))
+			if !bytes.Equal(data, payload) {
+				t.Errorf("downloaded file differs from the payload (%d of %d bytes)", len(data), len(payload))
+			}
+			for _, r := range requests {
+				if r[0] == 0 {
+					t.Errorf("resumed download requested the beginning again: %v", requests)
+				}
+			}
+			if _, err := os.Stat(partFilePath("video_resume")); !os.IsNotExist(err) {
+				t.Errorf(".part file left after a complete download")
+			}
+		})
+	}
+}
+
+// TestCheckVideoContent covers the signature sniffing rules
+func TestCheckVideoContent(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		head        []byte
+		wantErr     bool
+	}{
+		{"mp4", "video/mp4", fakeMP4(64), false},
+		{"webm", "video/webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x01}, false},
+		{"json error", "application/json", []byte(`{"error":"expired"}`), true},
+		{"json mislabeled", "application/octet-stream", []byte(`  {"error":"expired"}`), true},
+		{"unknown binary", "application/octet-stream", []byte{0x00, 0x01, 0x02, 0x03, 0xFF, 0xFE}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkVideoContent(tc.contentType, tc.head)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("checkVideoContent() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestIsAuthFailure verifies that only authentication errors are classified as auth failures
+func TestIsAuthFailure(t *testing.T) {
+	cases := []struct {
+		reason string
+		want   bool
+	}{
+		{`API error (status 401): {"error":{"message":"无效的令牌"}}`, true},
+		{"未配置API密钥，请在config.json中配置dyu_api_key", true},
+		{`API error (status 403): {"message":"Invalid API key"}`, true},
+		{"提示词违规，请修改后重试", false},
+		{`API error (status 400): {"message":"invalid duration"}`, false},
+		{"API error (status 502): bad gateway", false},
+		{"", false},
+	}
+
+	for _, tc := range cases {
+		if got := IsAuthFailure(tc.reason); got != tc.want {
+			t.Errorf("IsAuthFailure(%q) = %v, want %v", tc.reason, got, tc.want)
+		}
+	}
+}
+
+// TestValidateAPIKey verifies that a rejected key is reported with the upstream error
+// and that a not-found answer for the dummy task counts as authenticated
+func TestValidateAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer good-key":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"task not found"}}`))
+		case "Bearer broken-upstream":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"无效的令牌"}}`))
+		}
+	}))
+	defer server.Close()
+
+	valid, upstream, err := ValidateAPIKey(context.Background(), server.URL, "good-key")
+	if err != nil || !valid || upstream != "" {
+		t.Errorf("good key: valid=%v upstream=%q err=%v", valid, upstream, err)
+	}
+
+	valid, upstream, err = ValidateAPIKey(context.Background(), server.URL, "bad-key")
+	if err != nil || valid || !strings.Contains(upstream, "无效的令牌") {
+		t.Errorf("bad key: valid=%v upstream=%q err=%v", valid, upstream, err)
+	}
+
+	if _, _, err := ValidateAPIKey(context.Background(), server.URL, "broken-upstream"); err == nil {
+		t.Errorf("expected an error for a 502 answer")
+	}
+}
+
+// TestSetupProxy verifies that requests go through the configured proxy and that invalid URLs are rejected
+func TestSetupProxy(t *testing.T) {
+	defer SetupProxy("")
+
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute URL
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer proxy.Close()
+
+	if err := SetupProxy(proxy.URL); err != nil {
+		t.Fatalf("SetupProxy failed: %v", err)
+	}
+	if valid, _, err := ValidateAPIKey(context.Background(), "http://api.example.invalid", "key"); err != nil || !valid {
+		t.Fatalf("request through proxy: valid=%v err=%v", valid, err)
+	}
+	if len(proxied) != 1 || !strings.HasPrefix(proxied[0], "http://api.example.invalid/") {
+		t.Errorf("request did not go through the proxy: %v", proxied)
+	}
+
+	for _, raw := range []string{"ftp://proxy:21", "socks5://", "://bad"} {
+		if err := SetupProxy(raw); err == nil {
+			t.Errorf("SetupProxy(%q) should fail", raw)
+		}
+	}
+	if err := SetupProxy("socks5://127.0.0.1:1080"); err != nil {
+		t.Errorf("socks5 proxy rejected: %v", err)
+	}
+}
+
+// TestDownloadVideoCancel verifies that cancelling the context aborts a hanging download
+func TestDownloadVideoCancel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewVectorEngineClient("test-key").DownloadVideo(ctx, server.URL+"/video.mp4", "video_123")
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download did not stop after cancel")
+	}
+}
+
+// TestCreateVideoTaskWatermark checks that the watermark option reaches both the JSON and the
+// multipart payload
+func TestCreateVideoTaskWatermark(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			received = append(received, r.FormValue("watermark"))
+		} else {
+			var body struct {
+				Watermark bool `json:"watermark"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			received = append(received, strconv.FormatBool(body.Watermark))
+		}
+		w.Write([]byte(`{"id":"video_1","status":"queued"}`))
+	}))
+	defer server.Close()
+
+	client := NewVectorEngineClient("key")
+	client.SetBaseURL(server.URL)
+	if _, err := client.CreateVideoTask(context.Background(), "a cat", "", "", Duration10s, OrientationLandscape, ModelSora2, true); err != nil {
+		t.Fatalf("text-to-video failed: %v", err)
+	}
+	if _, err := client.CreateVideoTask(context.Background(), "a cat", "data:image/png;base64,AA==", "", Duration10s, OrientationLandscape, ModelSora2, false); err != nil {
+		t.Fatalf("image-to-video failed: %v", err)
+	}
+	if strings.Join(received, ",") != "true,false" {
+		t.Errorf("watermark sent as %v, want [true false]", received)
+	}
+}
+
+func TestConfiguredBaseURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"id":"video_1","status":"queued"}`))
+	}))
+	defer server.Close()
+
+	// A relay with a path prefix and a trailing slash
+	client := NewConfiguredClient(&Config{DyuAPIKey: "key", DyuBaseURL: server.URL + "/dyu/"})
+	if _, err := client.CreateVideoTask(context.Background(), "a cat", "", "", Duration10s, OrientationLandscape, ModelSora2, false); err != nil {
+		t.Fatalf("CreateVideoTask failed: %v", err)
+	}
+	if _, err := client.QueryTaskStatus(context.Background(), ModelSora2, "video_1", ""); err != nil {
+		t.Fatalf("QueryTaskStatus failed: %v", err)
+	}
+	if strings.Join(paths, ",") != "/dyu/v1/videos,/dyu/v1/videos/video_1" {
+		t.Errorf("requested paths %v", paths)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/v1/videos/video_1/content", server.URL + "/dyu/v1/videos/video_1/content"},
+		{DyuAPIBaseURL + "/v1/videos/video_1/content?x=1", server.URL + "/dyu/v1/videos/video_1/content?x=1"},
+		{"https://cdn.example.com/video.mp4", "https://cdn.example.com/video.mp4"},
+	}
+	for _, tt := range tests {
+		if got := client.resolveURL(tt.url); got != tt.want {
+			t.Errorf("resolveURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+	if got := NewVectorEngineClient("").resolveURL(DyuAPIBaseURL + "/v1/videos/a"); got != DyuAPIBaseURL+"/v1/videos/a" {
+		t.Errorf("default endpoint rewrote %q", got)
+	}
+
+	for _, invalid := range []string{"api.dyuapi.com", "ftp://relay.example.com", "https://relay.example.com/?key=1"} {
+		if err := validateConfig(&Config{Port: 8080, DyuBaseURL: invalid}); err == nil {
+			t.Errorf("dyu_base_url %q accepted", invalid)
+		}
+	}
+}
+
+// TestClassifyError classifies error responses captured from the API and transport errors
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"prompt rejected", http.StatusBadRequest,
+			`{"error":{"message":"提示词违规，请修改后重试 (request id: 20250101120000123)","type":"new_api_error"}}`, ErrContentPolicy},
+		{"image moderated", http.StatusBadRequest,
+			`{"error":{"message":"Your request was rejected as a result of our safety system.","type":"invalid_request_error","code":"moderation_blocked"}}`, ErrContentPolicy},
+		{"invalid token", http.StatusUnauthorized,
+			`{"error":{"message":"无效的令牌 (request id: 20250101120000456)","type":"new_api_error"}}`, ErrAuth},
+		{"quota", http.StatusForbidden,
+			`{"error":{"message":"用户额度不足, 剩余额度: ＄0.012000 (request id: 20250101120000789)","type":"new_api_error"}}`, ErrQuota},
+		{"saturated", http.StatusTooManyRequests,
+			`{"error":{"message":"当前分组上游负载已饱和，请稍后再试","type":"new_api_error"}}`, ErrRetryable},
+		{"bad gateway", http.StatusBadGateway,
+			"<html>\r\n<head><title>502 Bad Gateway</title></head>\r\n<body><center><h1>502 Bad Gateway</h1></center></body>\r\n</html>", ErrRetryable},
+		{"invalid parameter", http.StatusBadRequest,
+			`{"error":{"message":"invalid model: sora2-square","type":"invalid_request_error"}}`, nil},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("wrapped: %w", newAPIError(&http.Response{StatusCode: tt.status}, []byte(tt.body)))
+		if got := ClassifyError(err); got != tt.want {
+			t.Errorf("%s: ClassifyError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	reset := fmt.Errorf("failed to send request: %w", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET})
+	if got := ClassifyError(reset); got != ErrRetryable {
+		t.Errorf("connection reset: ClassifyError = %v, want ErrRetryable", got)
+	}
+	if got := ClassifyError(errors.New("未配置API密钥，请在config.json中配置dyu_api_key")); got != ErrAuth {
+		t.Errorf("missing key: ClassifyError = %v, want ErrAuth", got)
+	}
+	if err := newAPIError(&http.Response{StatusCode: http.StatusBadGateway}, []byte("bad gateway")); err.Error() != "API error (status 502): bad gateway" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"30":                            30 * time.Second,
+		"-5":                            0,
+		"86400":                         MaxRetryAfter,
+		"Sun, 01 Jun 2025 12:01:30 GMT": 90 * time.Second,
+		"Sun, 01 Jun 2025 11:00:00 GMT": 0,
+		"soon":                          0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+// TestVideoTaskFormRoundTrip submits images containing the boundary the form used to hardcode and
+// checks the parts parse back intact
+func TestVideoTaskFormRoundTrip(t *testing.T) {
+	oldBoundary := []byte("\r\n--wL36Yn8afVp8Ag7AmP8qZ0SA4n1v9T--\r\n")
+	first := append(testPNG(t, 16, 16), oldBoundary...)
+	last := append(append(testPNG(t, 8, 8), oldBoundary...), 0, 0xff)
+
+	type received struct {
+		fields map[string]string
+		files  [][]byte
+		types  []string
+	}
+	var got received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got.fields = map[string]string{}
+		for name, values := range r.MultipartForm.Value {
+			got.fields[name] = values[0]
+		}
+		for _, header := range r.MultipartForm.File["input_reference"] {
+			file, _ := header.Open()
+			data, _ := io.ReadAll(file)
+			file.Close()
+			got.files = append(got.files, data)
+			got.types = append(got.types, header.Header.Get("Content-Type"))
+		}
+		w.Write([]byte(`{"id":"video_1","status":"queued"}`))
+	}))
+	defer server.Close()
+
+	client := NewVectorEngineClient("key")
+	client.SetBaseURL(server.URL)
+	dataURL := func(data []byte) string { return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data) }
+	prompt := "a \"quoted\" prompt\r\nwith a line break"
+	if _, err := client.createVideoTaskMultipart(context.Background(), "key", prompt, dataURL(first), dataURL(last), "sora2-landscape", true); err != nil {
+		t.Fatalf("createVideoTaskMultipart failed: %v", err)
+	}
+
+	if got.fields["prompt"] != prompt || got.fields["model"] != "sora2-landscape" || got.fields["watermark"] != "true" {
+		t.Errorf("fields = %q", got.fields)
+	}
+	if len(got.files) != 2 || !bytes.Equal(got.files[0], first) || !bytes.Equal(got.files[1], last) {
+		t.Fatalf("received %d files, want the 2 images intact", len(got.files))
+	}
+	if got.types[0] != "image/png" {
+		t.Errorf("file Content-Type = %q", got.types[0])
+	}
+}
+
+// TestDownloadOptions covers the thread split, the single-stream setting and the bandwidth limit
+func TestDownloadOptions(t *testing.T) {
+	cases := []struct {
+		config  Config
+		size    int64
+		threads int
+	}{
+		{Config{}, 512 << 10, 1},
+		{Config{}, 2 << 20, 2},
+		{Config{}, 8 << 20, 4},
+		{Config{}, 100 << 20, 8},
+		{Config{DownloadThreads: 1}, 100 << 20, 1},
+		{Config{DownloadThreads: 3}, 100 << 20, 3},
+		{Config{DownloadChunkMinMB: 20}, 100 << 20, 5},
+	}
+	for _, tc := range cases {
+		if got := downloadOptions(&tc.config).threads(tc.size); got != tc.threads {
+			t.Errorf("%+v, %d bytes: %d threads, want %d", tc.config, tc.size, got, tc.threads)
+		}
+	}
+	for _, invalid := range []Config{{DownloadThreads: -1}, {DownloadThreads: MaxDownloadThreads + 1}, {DownloadChunkMinMB: -1}, {DownloadRateLimitKbps: -1}} {
+		if err := validateDownloadOptions(&invalid); err == nil {
+			t.Errorf("%+v: expected a validation error", invalid)
+		}
+	}
+
+	t.Chdir(t.TempDir())
+	payload := testPayload(2 << 20)
+	var requests [][2]int
+	server := rangeServer(t, payload, true, func(start, end int) int { return -1 }, &requests)
+	client := NewConfiguredClient(&Config{DownloadThreads: 1})
+	if _, err := client.DownloadVideo(context.Background(), server.URL+"/video.mp4", "video_single"); err != nil {
+		t.Fatalf("DownloadVideo failed: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("download_threads 1 made %d requests, want a single stream", len(requests))
+	}
+
+	// The first second of bandwidth passes at once, the rest waits for the bucket to refill
+	bucket := newTokenBucket(200000)
+	start := time.Now()
+	bucket.take(context.Background(), 200000)
+	bucket.take(context.Background(), 20000)
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Errorf("rate limited reads took %v, want about 100ms", elapsed)
+	}
+	if newTokenBucket(0) != nil {
+		t.Errorf("expected no bucket without a rate limit")
+	}
+}
+
+// TestResolveVideoPathAfterOutputDirChange checks videos saved in earlier output directories are
+// still found, also after a restart
+func TestResolveVideoPathAfterOutputDirChange(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(":memory:"); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	defer func() {
+		setOutputDirectory(DefaultOutputDirectory)
+		LoadPreviousOutputDirectories()
+	}()
+
+	first, second, third := t.TempDir(), t.TempDir(), t.TempDir()
+	SetupOutputDirectory(first)
+	if err := RecordOutputDirectory(); err != nil {
+		t.Fatalf("RecordOutputDirectory failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(first, "old.mp4"), []byte("old"), 0644)
+
+	SetupOutputDirectory(second)
+	RecordOutputDirectory()
+	os.WriteFile(filepath.Join(second, "new.mp4"), []byte("new"), 0644)
+	if path := ResolveVideoPath("old.mp4"); path != filepath.Join(first, "old.mp4") {
+		t.Errorf("video of the first directory resolved to %s", path)
+	}
+	if path := ResolveVideoPath("missing.mp4"); path != filepath.Join(second, "missing.mp4") {
+		t.Errorf("missing video resolved to %s, want the current directory", path)
+	}
+
+	// A restart with output_dir changed in config.json while stopped
+	outputDirs.previous = nil
+	SetupOutputDirectory(third)
+	if err := RecordOutputDirectory(); err != nil {
+		t.Fatalf("RecordOutputDirectory failed: %v", err)
+	}
+	if got := previousOutputDirectories(); !slices.Equal(got, []string{second, first}) {
+		t.Errorf("previous directories = %v", got)
+	}
+	for name, dir := range map[string]string{"old.mp4": first, "new.mp4": second} {
+		if path := ResolveVideoPath(name); path != filepath.Join(dir, name) {
+			t.Errorf("%s resolved to %s", name, path)
+		}
+	}
+}
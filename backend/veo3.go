@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ModelVeo3 is the Veo3 model, its variants (e.g. veo3-fast) share the prefix
+const ModelVeo3 = "veo3"
+
+// veo3FramesSuffix selects the upstream model variant that takes first/last frame images
+const veo3FramesSuffix = "-frames"
+
+// Veo3CreateRequest is the body of POST /v1/video/create for Veo3 models
+type Veo3CreateRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Images      []string `json:"images,omitempty"` // First frame, then last frame
+	AspectRatio string   `json:"aspect_ratio"`
+}
+
+// veo3StageProgress estimates the progress of Veo3 stages, the API doesn't report a percentage
+var veo3StageProgress = map[string]int{
+	"pending":           0,
+	"queued":            0,
+	"image_downloading": 10,
+	"processing":        30,
+	"video_generating":  50,
+	"video_upsampling":  80,
+}
+
+// IsVeo3Model reports whether a task model is served by the Veo3 path
+func IsVeo3Model(model string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(model)), ModelVeo3)
+}
+
+// SupportsLastFrame reports whether a model accepts image_url2 as the last frame
+func SupportsLastFrame(model string) bool {
+	return IsVeo3Model(model)
+}
+
+// veo3UpstreamModel returns the upstream model name: the frames variant when images are sent
+func veo3UpstreamModel(model string, withImages bool) string {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if withImages && !strings.HasSuffix(model, veo3FramesSuffix) {
+		return model + veo3FramesSuffix
+	}
+	if !withImages {
+		return strings.TrimSuffix(model, veo3FramesSuffix)
+	}
+	return model
+}
+
+// veo3AspectRatio maps a task orientation to the Veo3 aspect ratio
+func veo3AspectRatio(orientation string) string {
+	if orientation == OrientationPortrait {
+		return "9:16"
+	}
+	return "16:9"
+}
+
+// CreateVideoTaskVeo3 submits a Veo3 task with the given key
+// Veo3 videos have a fixed length, the task duration is not sent
+func (c *VectorEngineClient) CreateVideoTaskVeo3(ctx context.Context, key, prompt, imageURL, imageURL2, orientation, model string) (*VectorEngineCreateResponse, error) {
+	var images []string
+	for _, image := range []string{imageURL, imageURL2} {
+		if image == "" {
+			continue
+		}
+		// Local images are sent inline, downscaled like multipart submissions
+		data, mimeType, err := c.submissionImage(image)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			image = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+		images = append(images, image)
+	}
+	reqBody := Veo3CreateRequest{
+		Model:       veo3UpstreamModel(model, len(images) > 0),
+		Prompt:      prompt,
+		Images:      images,
+		AspectRatio: veo3AspectRatio(orientation),
+	}
+	log.Printf("[VideoGen] 使用模型: %s, 图片数: %d", reqBody.Model, len(images))
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/v1/video/create"), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError(resp, respBody)
+	}
+
+	var result VectorEngineCreateResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.ID == "" {
+		return nil, fmt.Errorf("API returned no task ID: %s", string(respBody))
+	}
+	result.Model = reqBody.Model
+	return &result, nil
+}
+
+// QueryTaskStatusVeo3 queries a Veo3 task and maps its stages onto the statuses the processor
+// understands: completed, failed, or the stage name with an estimated progress while running
+func (c *VectorEngineClient) QueryTaskStatusVeo3(ctx context.Context, taskID, keyFingerprint string) (*VectorEngineQueryResponse, error) {
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL("/v1/video/query?id="+url.QueryEscape(taskID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if key := c.keys.byFingerprint(keyFingerprint); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, body)
+	}
+
+	var veoResp struct {
+		ID         string `json:"id"`
+		Status     string `json:"status"`
+		Progress   int    `json:"progress"`
+		VideoURL   string `json:"video_url"`
+		FailReason string `json:"fail_reason"`
+		Detail     struct {
+			VideoURL string `json:"video_url"`
+			Error    string `json:"error"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &veoResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	result := &VectorEngineQueryResponse{ID: veoResp.ID, Progress: veoResp.Progress, VideoURL: veoResp.VideoURL, RawBody: string(body)}
+	if result.VideoURL == "" {
+		result.VideoURL = veoResp.Detail.VideoURL
+	}
+
+	status := strings.ToLower(veoResp.Status)
+	switch {
+	case status == "completed" || status == "success" || strings.HasSuffix(status, "_completed"):
+		result.Status = "completed"
+		result.Progress = 100
+	case status == "failed" || status == "error" || status == "failure" || strings.HasSuffix(status, "_failed"):
+		result.Status = "failed"
+		result.FailReason = veoResp.FailReason
+		if result.FailReason == "" {
+			result.FailReason = veoResp.Detail.Error
+		}
+		if result.FailReason == "" {
+			result.FailReason = "Veo3 status " + veoResp.Status
+		}
+	default:
+		result.Status = status
+		if result.Progress == 0 {
+			result.Progress = veo3StageProgress[status]
+		}
+	}
+	return result, nil
+}
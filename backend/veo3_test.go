@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVeo3CreateAndQuery checks the Veo3 payload with both frames and the mapping of its stages
+func TestVeo3CreateAndQuery(t *testing.T) {
+	var created Veo3CreateRequest
+	status := "video_generating"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/video/create":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"id":"veo3:123","status":"pending"}`))
+		case "/v1/video/query":
+			if r.URL.Query().Get("id") != "veo3:123" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"id":"veo3:123","status":"` + status + `","video_url":"https://cdn/v.mp4"}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewVectorEngineClient("key")
+	client.SetBaseURL(server.URL)
+	resp, err := client.CreateVideoTask(context.Background(), "a wave", "data:image/png;base64,AA==", "https://img/last.png",
+		Duration10s, OrientationPortrait, ModelVeo3, false)
+	if err != nil {
+		t.Fatalf("CreateVideoTask failed: %v", err)
+	}
+	if resp.ID != "veo3:123" || resp.Model != "veo3-frames" {
+		t.Errorf("response = %+v", resp)
+	}
+	if created.Model != "veo3-frames" || len(created.Images) != 2 || created.Images[1] != "https://img/last.png" || created.AspectRatio != "9:16" {
+		t.Errorf("payload = %+v", created)
+	}
+
+	for _, tc := range []struct {
+		status, want string
+		progress     int
+	}{
+		{"video_generating", "video_generating", 50},
+		{"video_upsampling_completed", "completed", 100},
+		{"video_generation_failed", "failed", 0},
+	} {
+		status = tc.status
+		query, err := client.QueryTaskStatus(context.Background(), ModelVeo3, "veo3:123", "")
+		if err != nil {
+			t.Fatalf("%s: QueryTaskStatus failed: %v", tc.status, err)
+		}
+		if query.Status != tc.want || query.Progress != tc.progress || (tc.want == "failed") != (query.FailReason != "") {
+			t.Errorf("%s: got %+v", tc.status, query)
+		}
+	}
+}
+
+func TestLastFrameValidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := InitDB(filepath.Join(t.TempDir(), "veo3.db")); err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer CloseDB()
+	useTestConfig(t, &Config{})
+
+	for body, want := range map[string]int{
+		`{"prompt":"a","image_url":"https://a","image_url2":"https://b","model":"veo3"}`:   http.StatusCreated,
+		`{"prompt":"a","image_url":"https://a","image_url2":"https://b","model":"sora-2"}`: http.StatusBadRequest,
+		`{"prompt":"a","image_url":"https://a","image_url2":"https://b"}`:                  http.StatusBadRequest,
+		`{"prompt":"a","image_url2":"https://b","model":"veo3-fast"}`:                      http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		handleCreateTask(rec, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// StalledFailReason is the fail_reason of processing tasks failed by the watchdog
+const StalledFailReason = "任务超时无进展"
+
+// progressMark is the progress of a processing task and since when it has had that value
+type progressMark struct {
+	progress int
+	since    time.Time
+}
+
+// checkStalled runs after each poll of a processing task: once its progress hasn't changed for
+// stuck_after_minutes the task is failed, or requeued when auto_retry_stuck is set
+// A task first seen since startup counts from its updated_at, which doesn't advance while polls fail
+func (p *TaskProcessor) checkStalled(task *Task) {
+	if task.Status != StatusProcessing {
+		delete(p.stalls, task.ID)
+		return
+	}
+
+	now := p.now()
+	mark, seen := p.stalls[task.ID]
+	if !seen || mark.progress != task.Progress {
+		mark = progressMark{progress: task.Progress, since: now}
+		if !seen && !task.UpdatedAt.IsZero() && task.UpdatedAt.Before(now) {
+			mark.since = task.UpdatedAt
+		}
+		p.stalls[task.ID] = mark
+	}
+
+	minutes := p.currentConfig().StuckAfterMinutes
+	stalled := now.Sub(mark.since)
+	if minutes <= 0 || stalled < time.Duration(minutes)*time.Minute {
+		return
+	}
+	delete(p.stalls, task.ID)
+	stalled = stalled.Round(time.Second)
+	RecordTaskEvent(task.ID, HistoryStalled, fmt.Sprintf("no progress at %d%% for %s", task.Progress, stalled))
+
+	if p.currentConfig().AutoRetryStuck {
+		reset, localPaths, err := ResetProcessingTask(task.ID)
+		if err != nil {
+			log.Printf("[Watchdog] 重置任务 %d 失败: %v", task.ID, err)
+			return
+		}
+		if !reset {
+			return
+		}
+		deleteRetryLeftovers(localPaths)
+		log.Printf("[Watchdog] 任务 %d 进度 %d%% 已 %s 无变化，重置为待处理", task.ID, task.Progress, stalled)
+		if updated, err := GetTask(task.ID); err == nil && updated != nil {
+			*task = *updated
+			PublishTaskUpdate(task)
+		}
+		return
+	}
+
+	task.Status = StatusFailed
+	task.FailReason = StalledFailReason
+	if p.saveTransition(task, StatusProcessing, fieldsStatus) {
+		log.Printf("[Watchdog] 任务 %d 进度 %d%% 已 %s 无变化，标记为失败", task.ID, task.Progress, stalled)
+	}
+}
+
+// pruneStalls forgets the tasks that are no longer processing, e.g. cancelled or deleted ones
+func (p *TaskProcessor) pruneStalls(tasks []Task) {
+	processing := make(map[int64]bool, len(tasks))
+	for _, task := range tasks {
+		if task.Status == StatusProcessing {
+			processing[task.ID] = true
+		}
+	}
+	for id := range p.stalls {
+		if !processing[id] {
+			delete(p.stalls, id)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// WebhookEventProgress is sent when a task crosses one of the configured progress milestones
+const WebhookEventProgress = "progress"
+
+// webhookTimeout bounds each webhook delivery
+const webhookTimeout = 10 * time.Second
+
+// WebhookPayload is the JSON body posted to the webhook URL
+// Event is progress, task_completed or task_failed
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Milestone int       `json:"milestone,omitempty"`
+	Task      Task      `json:"task"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// normalizeMilestones returns the valid milestones (1-99) sorted and de-duplicated
+func normalizeMilestones(milestones []int) []int {
+	var result []int
+	seen := make(map[int]bool)
+	for _, m := range milestones {
+		if m > 0 && m < 100 && !seen[m] {
+			seen[m] = true
+			result = append(result, m)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// milestoneSet holds the progress milestones fired for a task, one bit per percent value so
+// editing webhook_milestones doesn't change the meaning of what was recorded
+// Stored in milestones_fired (percents 0-63) and milestones_fired_high (64-99)
+type milestoneSet [2]int64
+
+// has reports whether the milestone at percent was fired
+func (s milestoneSet) has(percent int) bool {
+	return s[percent/64]&(int64(1)<<(percent%64)) != 0
+}
+
+// add records the milestone at percent as fired
+func (s *milestoneSet) add(percent int) {
+	s[percent/64] |= int64(1) << (percent % 64)
+}
+
+// milestonesUpTo returns every milestone a task at progress has crossed
+func milestonesUpTo(progress int) milestoneSet {
+	var s milestoneSet
+	for percent := 1; percent <= min(progress, 99); percent++ {
+		s.add(percent)
+	}
+	return s
+}
+
+// LogWebhookConfig reports the configured webhook at startup
+func LogWebhookConfig(config *Config) {
+	if config.WebhookURL == "" {
+		return
+	}
+	log.Printf("Webhook enabled: %s (progress milestones %v)", config.WebhookURL, normalizeMilestones(config.WebhookMilestones))
+}
+
+// sendWebhook posts the payload in the background; failures are only logged
+func sendWebhook(url string, payload WebhookPayload) {
+	go func() {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("[Webhook] Failed to encode payload: %v", err)
+			return
+		}
+		resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[Webhook] Task %d %s delivery failed: %v", payload.Task.ID, payload.Event, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("[Webhook] Task %d %s delivery failed: %s", payload.Task.ID, payload.Event, resp.Status)
+		}
+	}()
+}
+
+// notifyWebhook sends the completion or failure event for a finished task
+func (p *TaskProcessor) notifyWebhook(task *Task) {
+	config := p.currentConfig()
+	if config.WebhookURL == "" {
+		return
+	}
+	var event string
+	switch task.Status {
+	case StatusCompleted:
+		event = EventTaskCompleted
+	case StatusFailed:
+		event = EventTaskFailed
+	default:
+		return
+	}
+	sendWebhook(config.WebhookURL, WebhookPayload{Event: event, Task: eventTask(task), Timestamp: time.Now()})
+}
+
+// fireProgressMilestones sends a progress event for every milestone the task has reached but not yet fired
+// Fired milestones are recorded in task.MilestonesFired, which is persisted with the task so restarts don't re-fire
+func (p *TaskProcessor) fireProgressMilestones(task *Task) {
+	config := p.currentConfig()
+	if config.WebhookURL == "" {
+		return
+	}
+	for _, milestone := range normalizeMilestones(config.WebhookMilestones) {
+		if task.Progress < milestone || task.MilestonesFired.has(milestone) {
+			continue
+		}
+		task.MilestonesFired.add(milestone)
+		log.Printf("[Webhook] Task %d reached %d%%", task.ID, milestone)
+		sendWebhook(config.WebhookURL, WebhookPayload{
+			Event:     WebhookEventProgress,
+			Milestone: milestone,
+			Task:      eventTask(task),
+			Timestamp: time.Now(),
+		})
+	}
+}
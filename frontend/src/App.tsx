@@ -17,10 +17,11 @@ import {
   Calendar,
   User
 } from 'lucide-react';
-import { createTask, getTasks, getTask, getTasksByIds, deleteTask, deleteFailedTasks, deleteTasksByDateRange, getVideoUrl } from './api';
-import type { Task, Duration, Orientation, Count, Model, CreateTaskRequest, Character } from './types';
+import { AUTH_REQUIRED_EVENT, createTask, getTasks, getTask, getTasksByIds, getTaskCounts, deleteTask, deleteFailedTasks, deleteTasksByDateRange, getTaskVideoUrl } from './api';
+import type { Task, TaskCounts, Duration, Orientation, Count, Model, CreateTaskRequest, Character } from './types';
 import CharacterCreationDialog from './CharacterCreationDialog';
 import CharacterList from './CharacterList';
+import LoginScreen from './LoginScreen';
 
 // Maximum number of videos that can auto-play simultaneously
 const MAX_PLAYING_VIDEOS = 4;
@@ -126,10 +127,10 @@ const VideoCard = memo(function VideoCard({
     };
   }, [shouldLoad, task.id]);
   
-  const isProcessing = task.status === 'pending' || task.status === 'processing';
+  const isProcessing = task.status === 'pending' || task.status === 'processing' || task.status === 'downloading';
   const isCompleted = task.status === 'completed';
   const isFailed = task.status === 'failed';
-  const videoSrc = task.local_path ? getVideoUrl(task.local_path) : null;
+  const videoSrc = task.local_path ? getTaskVideoUrl(task.id) : null;
   const aspectLabel = task.orientation === 'portrait' ? '9:16' : '16:9';
   
   return (
@@ -156,7 +157,9 @@ const VideoCard = memo(function VideoCard({
             {isProcessing && (
               <div className="text-center">
                 <Loader2 size={28} className="animate-spin text-white/40 mx-auto mb-2" />
-                <p className="text-white/50 text-xs">{task.progress}%</p>
+                <p className="text-white/50 text-xs">
+                  {task.status === 'downloading' ? `下载中 ${task.download_progress ?? 0}%` : `${task.progress}%`}
+                </p>
               </div>
             )}
             {isFailed && (
@@ -194,7 +197,7 @@ const VideoCard = memo(function VideoCard({
             <RefreshCw size={12} className={isGenerating ? 'animate-spin' : ''} />
           </button>
           {isCompleted && task.local_path && (
-            <a href={getVideoUrl(task.local_path)} download={`video_${task.id}.mp4`} onClick={(e) => e.stopPropagation()} className="w-7 h-7 rounded bg-black/60 hover:bg-white/20 text-white/70 hover:text-white flex items-center justify-center transition-all" title="下载">
+            <a href={getTaskVideoUrl(task.id, true)} download onClick={(e) => e.stopPropagation()} className="w-7 h-7 rounded bg-black/60 hover:bg-white/20 text-white/70 hover:text-white flex items-center justify-center transition-all" title="下载">
               <Download size={12} />
             </a>
           )}
@@ -217,7 +220,7 @@ const VideoCard = memo(function VideoCard({
         )}
         
         <div>
-          <p className="text-xs text-white/90 line-clamp-2 mb-1">{task.prompt || '图生视频'}</p>
+          <p className="text-xs text-white/90 line-clamp-2 mb-1">{task.original_prompt || task.prompt || '图生视频'}</p>
           <div className="flex items-center gap-1 text-[10px] text-white/60">
             <span>{aspectLabel}</span>
             <span>•</span>
@@ -270,6 +273,15 @@ export default function App() {
   const [isLoadingMore, setIsLoadingMore] = useState(false);
   const [hasMore, setHasMore] = useState(true);
 
+  // Login state, set when the backend rejects a request for a missing or wrong API token
+  const [authRequired, setAuthRequired] = useState(false);
+
+  useEffect(() => {
+    const handleAuthRequired = () => setAuthRequired(true);
+    window.addEventListener(AUTH_REQUIRED_EVENT, handleAuthRequired);
+    return () => window.removeEventListener(AUTH_REQUIRED_EVENT, handleAuthRequired);
+  }, []);
+
   // Show toast with auto-dismiss after 5 seconds
   const showToast = useCallback((message: string, type: 'success' | 'error') => {
     setToast({ message, type });
@@ -327,6 +339,18 @@ export default function App() {
     fetchInitialTasks();
   }, []);
 
+  // Status counts of all tasks, the list only holds the loaded pages
+  const [taskCounts, setTaskCounts] = useState<TaskCounts | null>(null);
+
+  // Refresh the counts whenever the loaded tasks change
+  useEffect(() => {
+    getTaskCounts()
+      .then(setTaskCounts)
+      .catch(err => console.error('Failed to fetch task counts:', err));
+  }, [tasks]);
+
+  const failedCount = taskCounts?.by_status.failed ?? tasks.filter(t => t.status === 'failed').length;
+
   // Track page visibility for smart polling
   const [isPageVisible, setIsPageVisible] = useState(true);
   
@@ -340,11 +364,11 @@ export default function App() {
     return () => document.removeEventListener('visibilitychange', handleVisibilityChange);
   }, []);
 
-  // Poll for pending/processing task status updates by ID
+  // Poll for pending/processing/downloading task status updates by ID
   // Smart polling: only poll when there are pending tasks AND page is visible
   useEffect(() => {
     const pendingTaskIds = tasks
-      .filter(t => t.status === 'pending' || t.status === 'processing')
+      .filter(t => t.status === 'pending' || t.status === 'processing' || t.status === 'downloading')
       .map(t => t.id);
     
     // Stop polling if no pending tasks or page is hidden
@@ -489,7 +513,6 @@ export default function App() {
 
   // Delete all failed tasks
   const handleDeleteFailedTasks = useCallback(async () => {
-    const failedCount = tasks.filter(t => t.status === 'failed').length;
     if (failedCount === 0) {
       showToast('没有失败的任务', 'success');
       return;
@@ -504,7 +527,7 @@ export default function App() {
       const errorMessage = err instanceof Error ? err.message : '删除失败任务出错';
       showToast(errorMessage, 'error');
     }
-  }, [tasks, showToast]);
+  }, [failedCount, showToast]);
 
   // Date range delete state
   const [showDateRangeModal, setShowDateRangeModal] = useState(false);
@@ -545,7 +568,7 @@ export default function App() {
 
     try {
       const request: CreateTaskRequest = {
-        prompt: task.prompt,
+        prompt: task.original_prompt || task.prompt,
         image_url: task.image_url || undefined,
         duration: task.duration,
         orientation: task.orientation,
@@ -569,7 +592,7 @@ export default function App() {
   // Edit task - fill input with previous prompt and image (用户可修改后发送)
   // Need to fetch full task data because list API doesn't include image_url for performance
   const handleEditTask = useCallback(async (task: Task) => {
-    setInput(task.prompt || '');
+    setInput(task.original_prompt || task.prompt || '');
     setDuration(task.duration);
     setOrientation(task.orientation);
     setModel(task.model || 'sora-2');
@@ -809,6 +832,9 @@ export default function App() {
     <div 
       className="flex h-screen bg-black text-white font-sans overflow-hidden"
     >
+      {/* Login, reloading restarts every request with the stored token */}
+      {authRequired && <LoginScreen onLogin={() => window.location.reload()} />}
+
       {/* Drag overlay */}
       {isDragging && (
         <div className="absolute inset-0 bg-purple-500/20 backdrop-blur-sm z-50 flex items-center justify-center border-4 border-dashed border-purple-500 pointer-events-none">
@@ -841,7 +867,7 @@ export default function App() {
             <div className="relative rounded-2xl overflow-hidden bg-black flex-shrink-0 w-full flex items-center justify-center">
               <video
                 ref={playerRef}
-                src={getVideoUrl(playingTask.local_path!)}
+                src={getTaskVideoUrl(playingTask.id)}
                 className="max-w-full max-h-[75vh] object-contain"
                 autoPlay
                 controls
@@ -852,7 +878,7 @@ export default function App() {
 
             {/* Video info - scrollable if needed */}
             <div className="mt-3 text-center flex-shrink-0 max-h-[15vh] overflow-y-auto px-2">
-              <p className="text-white/90 text-base leading-relaxed">{playingTask.prompt || '图生视频'}</p>
+              <p className="text-white/90 text-base leading-relaxed">{playingTask.original_prompt || playingTask.prompt || '图生视频'}</p>
               <p className="text-white/50 text-sm mt-1">
                 {getAspectLabel(playingTask)} • {playingTask.duration}
               </p>
@@ -989,13 +1015,13 @@ export default function App() {
               </button>
               
               {/* Delete failed tasks button */}
-              {tasks.some(t => t.status === 'failed') && (
+              {failedCount > 0 && (
                 <button
                   onClick={handleDeleteFailedTasks}
                   className="flex items-center gap-2 px-4 py-2 text-sm text-red-400 hover:text-red-300 bg-red-500/10 hover:bg-red-500/20 rounded-lg transition-all border border-red-500/20"
                 >
                   <Trash2 size={16} />
-                  删除失败 ({tasks.filter(t => t.status === 'failed').length})
+                  删除失败 ({failedCount})
                 </button>
               )}
             </div>
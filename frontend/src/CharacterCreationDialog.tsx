@@ -1,6 +1,6 @@
 import React, { useState, useRef, useEffect } from 'react';
-import { X, Loader2, User, Link, Video } from 'lucide-react';
-import { createCharacter } from './api';
+import { X, Loader2, User, Link, Video, Upload } from 'lucide-react';
+import { createCharacter, createCharacterFromUpload } from './api';
 import type { Character, CharacterSourceType } from './types';
 
 interface CharacterCreationDialogProps {
@@ -31,6 +31,7 @@ export default function CharacterCreationDialog({
   const [endSeconds, setEndSeconds] = useState('2');
   const [sourceType, setSourceType] = useState<CharacterSourceType>('task');
   const [sourceValue, setSourceValue] = useState('');
+  const [sourceFile, setSourceFile] = useState<File | null>(null);
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [errors, setErrors] = useState<FormErrors>({});
   const textareaRef = useRef<HTMLTextAreaElement>(null);
@@ -87,6 +88,12 @@ export default function CharacterCreationDialog({
   };
 
   const validateSourceValue = (type: CharacterSourceType, value: string): string | undefined => {
+    if (type === 'upload') {
+      if (!sourceFile) return '请选择视频文件';
+      if (sourceFile.size > 200 * 1024 * 1024) return '视频不能超过200MB';
+      return undefined;
+    }
+
     if (!value || value.trim().length === 0) {
       return type === 'task' ? '请输入任务ID' : '请输入视频URL';
     }
@@ -133,13 +140,19 @@ export default function CharacterCreationDialog({
     
     try {
       const timestamps = `${startSeconds},${endSeconds}`;
-      const character = await createCharacter({
-        custom_name: customName,
-        description,
-        source_type: sourceType,
-        source_value: sourceValue.trim(),
-        timestamps,
-      });
+      const character = sourceType === 'upload' && sourceFile
+        ? await createCharacterFromUpload(sourceFile, {
+            custom_name: customName,
+            description,
+            timestamps,
+          })
+        : await createCharacter({
+            custom_name: customName,
+            description,
+            source_type: sourceType,
+            source_value: sourceValue.trim(),
+            timestamps,
+          });
       
       onSuccess(character);
       handleClose();
@@ -159,6 +172,7 @@ export default function CharacterCreationDialog({
     setEndSeconds('2');
     setSourceType('task');
     setSourceValue(taskId || '');
+    setSourceFile(null);
     setErrors({});
     onClose();
   };
@@ -172,6 +186,7 @@ export default function CharacterCreationDialog({
     } else {
       setSourceValue('');
     }
+    setSourceFile(null);
     // Clear any source value errors
     if (errors.sourceValue) {
       setErrors(prev => ({ ...prev, sourceValue: undefined }));
@@ -294,35 +309,65 @@ export default function CharacterCreationDialog({
                 <Link size={16} />
                 视频URL
               </button>
+              <button
+                type="button"
+                onClick={() => handleSourceTypeChange('upload')}
+                className={`flex-1 flex items-center justify-center gap-2 px-4 py-2.5 rounded-lg text-sm transition-all ${
+                  sourceType === 'upload'
+                    ? 'bg-purple-500/20 text-purple-400 border border-purple-500/50'
+                    : 'bg-black/30 text-white/60 border border-white/10 hover:border-white/20'
+                }`}
+              >
+                <Upload size={16} />
+                上传视频
+              </button>
             </div>
           </div>
 
           {/* Source Value Input */}
           <div>
             <label className="text-white/60 text-xs mb-2 block">
-              {sourceType === 'task' ? '任务ID' : '视频URL'}
+              {sourceType === 'task' ? '任务ID' : sourceType === 'url' ? '视频URL' : '视频文件'}
             </label>
-            <input
-              type={sourceType === 'url' ? 'url' : 'text'}
-              value={sourceValue}
-              onChange={(e) => {
-                setSourceValue(e.target.value);
-                if (errors.sourceValue) {
-                  setErrors(prev => ({ ...prev, sourceValue: undefined }));
-                }
-              }}
-              placeholder={sourceType === 'task' ? '输入已生成视频的任务ID' : '输入视频URL地址'}
-              className={`w-full bg-black/30 border rounded-lg px-4 py-3 text-white text-sm focus:outline-none transition-colors ${
-                errors.sourceValue ? 'border-red-500/50 focus:border-red-500' : 'border-white/10 focus:border-white/30'
-              }`}
-            />
+            {sourceType === 'upload' ? (
+              <input
+                type="file"
+                accept="video/mp4"
+                onChange={(e) => {
+                  setSourceFile(e.target.files?.[0] ?? null);
+                  if (errors.sourceValue) {
+                    setErrors(prev => ({ ...prev, sourceValue: undefined }));
+                  }
+                }}
+                className={`w-full bg-black/30 border rounded-lg px-4 py-3 text-white/80 text-sm focus:outline-none transition-colors file:mr-3 file:rounded file:border-0 file:bg-white/10 file:px-3 file:py-1 file:text-white/80 ${
+                  errors.sourceValue ? 'border-red-500/50 focus:border-red-500' : 'border-white/10 focus:border-white/30'
+                }`}
+              />
+            ) : (
+              <input
+                type={sourceType === 'url' ? 'url' : 'text'}
+                value={sourceValue}
+                onChange={(e) => {
+                  setSourceValue(e.target.value);
+                  if (errors.sourceValue) {
+                    setErrors(prev => ({ ...prev, sourceValue: undefined }));
+                  }
+                }}
+                placeholder={sourceType === 'task' ? '输入已生成视频的任务ID' : '输入视频URL地址'}
+                className={`w-full bg-black/30 border rounded-lg px-4 py-3 text-white text-sm focus:outline-none transition-colors ${
+                  errors.sourceValue ? 'border-red-500/50 focus:border-red-500' : 'border-white/10 focus:border-white/30'
+                }`}
+              />
+            )}
             {errors.sourceValue && (
               <p className="text-red-400 text-xs mt-1">{errors.sourceValue}</p>
             )}
             <p className="text-white/40 text-xs mt-1">
               {sourceType === 'task' 
                 ? '从已生成的视频任务中提取角色' 
-                : '从外部视频URL中提取角色'}
+                : sourceType === 'url'
+                  ? '从外部视频URL中提取角色'
+                  : '从本地MP4视频中提取角色 (最大200MB, 需配置公网地址)'}
             </p>
           </div>
 
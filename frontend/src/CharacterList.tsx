@@ -1,6 +1,6 @@
 import React, { useState, useEffect, useCallback, useRef } from 'react';
 import { User, Trash2, Loader2, ChevronDown, ChevronUp, Search, X, Copy, Clock, CheckCircle, XCircle, RefreshCw } from 'lucide-react';
-import { getCharacters, deleteCharacter, getCharacterStatus } from './api';
+import { getCharacters, deleteCharacter, getCharacterStatus, ApiError } from './api';
 import type { Character, CharacterStatus } from './types';
 
 interface CharacterListProps {
@@ -148,7 +148,15 @@ export default function CharacterList({
     setIsDeletingId(character.id);
     
     try {
-      await deleteCharacter(character.id);
+      try {
+        await deleteCharacter(character.id);
+      } catch (err) {
+        // Queued tasks still reference the character, delete only once confirmed
+        if (!(err instanceof ApiError && err.status === 409) || !window.confirm(`${err.message}\n\n仍要删除角色「${character.custom_name}」吗？`)) {
+          throw err;
+        }
+        await deleteCharacter(character.id, true);
+      }
       setCharacters(prev => prev.filter(c => c.id !== character.id));
       setSelectedCharacter(null);
       onCharacterDeleted?.(character);
@@ -407,7 +415,7 @@ export default function CharacterList({
               <div className="bg-black/20 rounded-lg p-3">
                 <label className="text-white/40 text-xs block">来源</label>
                 <p className="text-white/80 text-sm">
-                  {selectedCharacter.source_type === 'task' ? '任务 ID: ' : 'URL: '}
+                  {selectedCharacter.source_type === 'task' ? '任务 ID: ' : selectedCharacter.source_type === 'upload' ? '上传视频: ' : 'URL: '}
                   <span className="text-white/60 break-all">{selectedCharacter.source_value}</span>
                 </p>
               </div>
@@ -0,0 +1,92 @@
+import React, { useState } from 'react';
+import { Loader2, Lock } from 'lucide-react';
+import { checkAuthToken, setAuthToken } from './api';
+
+interface LoginScreenProps {
+  onLogin: () => void;
+}
+
+/**
+ * Asks for the API token when the backend has auth_token set, and stores it once the backend accepts it
+ */
+export default function LoginScreen({ onLogin }: LoginScreenProps) {
+  const [token, setToken] = useState('');
+  const [isSubmitting, setIsSubmitting] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    const trimmed = token.trim();
+    if (!trimmed) {
+      setError('请输入访问令牌');
+      return;
+    }
+
+    setIsSubmitting(true);
+    setError(null);
+    try {
+      if (await checkAuthToken(trimmed)) {
+        setAuthToken(trimmed);
+        onLogin();
+      } else {
+        setError('访问令牌无效');
+      }
+    } catch (err) {
+      setError(err instanceof Error ? err.message : '无法连接服务器');
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
+
+  return (
+    <div className="fixed inset-0 bg-black/80 backdrop-blur-sm z-[200] flex items-center justify-center">
+      <div className="bg-[#2a2a2a] rounded-2xl p-6 w-full max-w-sm mx-4 border border-white/10">
+        {/* Header */}
+        <div className="flex items-center gap-3 mb-5">
+          <div className="w-10 h-10 rounded-full bg-purple-500/20 flex items-center justify-center">
+            <Lock size={20} className="text-purple-400" />
+          </div>
+          <h3 className="text-white text-lg font-medium">需要登录</h3>
+        </div>
+
+        <form onSubmit={handleSubmit} className="space-y-4">
+          <div>
+            <label className="text-white/60 text-xs block mb-2">访问令牌</label>
+            <input
+              type="password"
+              value={token}
+              onChange={(e) => {
+                setToken(e.target.value);
+                if (error) setError(null);
+              }}
+              autoFocus
+              autoComplete="current-password"
+              placeholder="输入配置中的 auth_token"
+              className={`w-full bg-black/30 border rounded-lg px-4 py-3 text-white text-sm focus:outline-none transition-colors ${
+                error ? 'border-red-500/50 focus:border-red-500' : 'border-white/10 focus:border-white/30'
+              }`}
+            />
+            {error && (
+              <p className="text-red-400 text-xs mt-1">{error}</p>
+            )}
+          </div>
+
+          <button
+            type="submit"
+            disabled={isSubmitting}
+            className="w-full px-4 py-2.5 text-sm text-white bg-purple-500 hover:bg-purple-600 rounded-lg transition-all disabled:opacity-50 disabled:cursor-not-allowed flex items-center justify-center gap-2"
+          >
+            {isSubmitting ? (
+              <>
+                <Loader2 size={14} className="animate-spin" />
+                验证中...
+              </>
+            ) : (
+              '登录'
+            )}
+          </button>
+        </form>
+      </div>
+    </div>
+  );
+}
@@ -5,6 +5,7 @@
 
 import type {
   Task,
+  TaskCounts,
   CreateTaskRequest,
   CreateTaskResponse,
   DeleteTaskResponse,
@@ -19,6 +20,48 @@ import type {
 // Backend API base URL - use relative path since frontend is served by the same server
 const API_BASE_URL = '/api';
 
+// localStorage key of the API token, required when the backend sets auth_token
+const AUTH_TOKEN_KEY = 'videogen_auth_token';
+
+// Event dispatched on window when the backend rejects the stored token (401)
+export const AUTH_REQUIRED_EVENT = 'videogen:auth-required';
+
+/**
+ * Get the stored API token, empty when none is set
+ */
+export function getAuthToken(): string {
+  return localStorage.getItem(AUTH_TOKEN_KEY) ?? '';
+}
+
+/**
+ * Store the API token sent with every request, an empty token removes it
+ */
+export function setAuthToken(token: string): void {
+  if (token) {
+    localStorage.setItem(AUTH_TOKEN_KEY, token);
+  } else {
+    localStorage.removeItem(AUTH_TOKEN_KEY);
+  }
+}
+
+/**
+ * fetch with the stored API token in the Authorization header
+ */
+function apiFetch(url: string, init: RequestInit = {}, token = getAuthToken()): Promise<Response> {
+  const headers = new Headers(init.headers);
+  if (token) headers.set('Authorization', `Bearer ${token}`);
+  return fetch(url, { ...init, headers });
+}
+
+/**
+ * Add the stored API token to the URL of a file loaded by <video> and <img> tags, which can't send headers
+ */
+function withToken(url: string): string {
+  const token = getAuthToken();
+  if (!token) return url;
+  return `${url}${url.includes('?') ? '&' : '?'}token=${encodeURIComponent(token)}`;
+}
+
 /**
  * Custom error class for API errors
  */
@@ -45,6 +88,9 @@ async function handleResponse<T>(response: Response): Promise<T> {
       // If we can't parse the error response, use status text
       errorMessage = response.statusText || errorMessage;
     }
+    if (response.status === 401) {
+      window.dispatchEvent(new Event(AUTH_REQUIRED_EVENT));
+    }
     throw new ApiError(response.status, errorMessage);
   }
   return response.json();
@@ -61,7 +107,7 @@ async function handleResponse<T>(response: Response): Promise<T> {
  * Requirements: 1.1 - Create new generation task and submit to backend
  */
 export async function createTask(request: CreateTaskRequest): Promise<CreateTaskResponse> {
-  const response = await fetch(`${API_BASE_URL}/tasks`, {
+  const response = await apiFetch(`${API_BASE_URL}/tasks`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -89,7 +135,7 @@ export async function getTasks(limit?: number, offset?: number): Promise<{ tasks
   if (offset !== undefined) params.set('offset', offset.toString());
   if (params.toString()) url += `?${params.toString()}`;
   
-  const response = await fetch(url, {
+  const response = await apiFetch(url, {
     method: 'GET',
     headers: {
       'Content-Type': 'application/json',
@@ -109,7 +155,7 @@ export async function getTasks(limit?: number, offset?: number): Promise<{ tasks
  */
 export async function getTasksByStatus(statuses: string[]): Promise<Task[]> {
   const url = `${API_BASE_URL}/tasks?status=${statuses.join(',')}`;
-  const response = await fetch(url, {
+  const response = await apiFetch(url, {
     method: 'GET',
     headers: {
       'Content-Type': 'application/json',
@@ -130,7 +176,7 @@ export async function getTasksByStatus(statuses: string[]): Promise<Task[]> {
 export async function getTasksByIds(ids: number[]): Promise<Task[]> {
   if (ids.length === 0) return [];
   const url = `${API_BASE_URL}/tasks?ids=${ids.join(',')}`;
-  const response = await fetch(url, {
+  const response = await apiFetch(url, {
     method: 'GET',
     headers: {
       'Content-Type': 'application/json',
@@ -151,7 +197,7 @@ export async function getTasksByIds(ids: number[]): Promise<Task[]> {
  * Requirements: 2.3 - Return current status and progress percentage
  */
 export async function getTask(id: number): Promise<Task> {
-  const response = await fetch(`${API_BASE_URL}/tasks/${id}`, {
+  const response = await apiFetch(`${API_BASE_URL}/tasks/${id}`, {
     method: 'GET',
     headers: {
       'Content-Type': 'application/json',
@@ -171,7 +217,7 @@ export async function getTask(id: number): Promise<Task> {
  * Requirements: 5.1 - Remove video file and database record
  */
 export async function deleteTask(id: number): Promise<DeleteTaskResponse> {
-  const response = await fetch(`${API_BASE_URL}/tasks/${id}`, {
+  const response = await apiFetch(`${API_BASE_URL}/tasks/${id}`, {
     method: 'DELETE',
     headers: {
       'Content-Type': 'application/json',
@@ -189,7 +235,18 @@ export async function deleteTask(id: number): Promise<DeleteTaskResponse> {
  * Requirements: 4.3 - Allow video playback from local file
  */
 export function getVideoUrl(filename: string): string {
-  return `${API_BASE_URL}/videos/${encodeURIComponent(filename)}`;
+  return withToken(`${API_BASE_URL}/videos/${encodeURIComponent(filename)}`);
+}
+
+/**
+ * Get the URL for the video of a completed task
+ * 
+ * @param taskId - The ID of the task
+ * @param download - Whether the video is sent as an attachment named after the task
+ * @returns The full URL to access the video
+ */
+export function getTaskVideoUrl(taskId: number, download = false): string {
+  return withToken(`${API_BASE_URL}/tasks/${taskId}/video${download ? '?download=1' : ''}`);
 }
 
 /**
@@ -199,10 +256,44 @@ export function getVideoUrl(filename: string): string {
  * @returns The full URL to access the picture
  */
 export function getCharacterPictureUrl(filename: string): string {
-  return `${API_BASE_URL}/character-pictures/${encodeURIComponent(filename)}`;
+  return withToken(`${API_BASE_URL}/character-pictures/${encodeURIComponent(filename)}`);
 }
 
 
+/**
+ * Check an API token against the backend
+ * GET /api/health
+ * 
+ * @param token - The token to check
+ * @returns Whether the backend accepts the token
+ * @throws ApiError if the backend fails for another reason
+ */
+export async function checkAuthToken(token: string): Promise<boolean> {
+  const response = await apiFetch(`${API_BASE_URL}/health`, { method: 'GET' }, token);
+  if (response.status === 401) return false;
+  if (!response.ok) {
+    throw new ApiError(response.status, response.statusText || 'An error occurred');
+  }
+  return true;
+}
+
+/**
+ * Get task counts by status, cheap enough to poll
+ * GET /api/tasks/counts
+ * 
+ * @returns Counts by status, the total and the tasks created today
+ * @throws ApiError if the request fails
+ */
+export async function getTaskCounts(): Promise<TaskCounts> {
+  const response = await apiFetch(`${API_BASE_URL}/tasks/counts`, {
+    method: 'GET',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+  });
+  return handleResponse<TaskCounts>(response);
+}
+
 /**
  * Delete all failed tasks
  * DELETE /api/tasks-failed
@@ -211,7 +302,7 @@ export function getCharacterPictureUrl(filename: string): string {
  * @throws ApiError if the request fails
  */
 export async function deleteFailedTasks(): Promise<{ deleted: number }> {
-  const response = await fetch(`${API_BASE_URL}/tasks-failed`, {
+  const response = await apiFetch(`${API_BASE_URL}/tasks-failed`, {
     method: 'DELETE',
     headers: {
       'Content-Type': 'application/json',
@@ -231,7 +322,7 @@ export async function deleteFailedTasks(): Promise<{ deleted: number }> {
  * @throws ApiError if the request fails
  */
 export async function deleteTasksByDateRange(startDate: string, endDate: string): Promise<{ deleted: number; message: string }> {
-  const response = await fetch(`${API_BASE_URL}/tasks-by-date?start=${startDate}&end=${endDate}`, {
+  const response = await apiFetch(`${API_BASE_URL}/tasks-by-date?start=${startDate}&end=${endDate}`, {
     method: 'DELETE',
     headers: {
       'Content-Type': 'application/json',
@@ -256,7 +347,7 @@ export async function deleteTasksByDateRange(startDate: string, endDate: string)
  * Requirements: 1.5 - Call VectorEngine Characters API with from_task ID and timestamps
  */
 export async function createCharacter(request: CreateCharacterRequest): Promise<Character> {
-  const response = await fetch(`${API_BASE_URL}/characters`, {
+  const response = await apiFetch(`${API_BASE_URL}/characters`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -266,6 +357,33 @@ export async function createCharacter(request: CreateCharacterRequest): Promise<
   return handleResponse<Character>(response);
 }
 
+/**
+ * Create a new character from an uploaded MP4 video
+ * POST /api/characters (multipart/form-data)
+ * 
+ * The backend serves the video to the provider from public_base_url until training finishes
+ * 
+ * @param file - The video to train the character from
+ * @param fields - custom_name, description and timestamps of the character
+ * @returns The created character
+ * @throws ApiError if the request fails, with status 413 for videos over 200 MB and 415 for non-MP4 files
+ */
+export async function createCharacterFromUpload(
+  file: File,
+  fields: Omit<CreateCharacterRequest, 'source_type' | 'source_value'>
+): Promise<Character> {
+  const form = new FormData();
+  form.append('custom_name', fields.custom_name);
+  form.append('description', fields.description);
+  form.append('timestamps', fields.timestamps);
+  form.append('file', file);
+  const response = await apiFetch(`${API_BASE_URL}/characters`, {
+    method: 'POST',
+    body: form,
+  });
+  return handleResponse<Character>(response);
+}
+
 /**
  * Get all characters
  * GET /api/characters
@@ -276,7 +394,7 @@ export async function createCharacter(request: CreateCharacterRequest): Promise<
  * Requirements: 3.1 - Fetch and display all saved characters
  */
 export async function getCharacters(): Promise<Character[]> {
-  const response = await fetch(`${API_BASE_URL}/characters`, {
+  const response = await apiFetch(`${API_BASE_URL}/characters`, {
     method: 'GET',
     headers: {
       'Content-Type': 'application/json',
@@ -291,13 +409,14 @@ export async function getCharacters(): Promise<Character[]> {
  * DELETE /api/characters/:id
  * 
  * @param id - The character ID to delete
+ * @param force - Delete even when pending or processing tasks reference the character
  * @returns The delete response
- * @throws ApiError if the request fails
+ * @throws ApiError if the request fails, with status 409 while queued tasks reference the character
  * 
  * Requirements: 3.3 - Remove character record from database
  */
-export async function deleteCharacter(id: number): Promise<DeleteCharacterResponse> {
-  const response = await fetch(`${API_BASE_URL}/characters/${id}`, {
+export async function deleteCharacter(id: number, force = false): Promise<DeleteCharacterResponse> {
+  const response = await apiFetch(`${API_BASE_URL}/characters/${id}${force ? '?force=true' : ''}`, {
     method: 'DELETE',
     headers: {
       'Content-Type': 'application/json',
@@ -317,7 +436,7 @@ export async function deleteCharacter(id: number): Promise<DeleteCharacterRespon
  * Requirements: 3.2 - Poll the API and update the progress percentage
  */
 export async function getCharacterStatus(id: number): Promise<CharacterStatusResponse> {
-  const response = await fetch(`${API_BASE_URL}/characters/${id}/status`, {
+  const response = await apiFetch(`${API_BASE_URL}/characters/${id}/status`, {
     method: 'GET',
     headers: {
       'Content-Type': 'application/json',
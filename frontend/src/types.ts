@@ -4,7 +4,7 @@
  */
 
 // Task status constants
-export type TaskStatus = 'pending' | 'processing' | 'completed' | 'failed';
+export type TaskStatus = 'pending' | 'processing' | 'downloading' | 'completed' | 'failed';
 
 // Duration options
 export type Duration = '10s' | '15s';
@@ -22,7 +22,8 @@ export type Model = 'sora-2';
 export interface Task {
   id: number;
   task_id: string;
-  prompt: string;
+  prompt: string; // 发送给上游的提示词，角色引用已转换为 @{id}
+  original_prompt?: string; // 用户输入的原始提示词
   image_url?: string;
   duration: Duration;
   orientation: Orientation;
@@ -32,6 +33,9 @@ export interface Task {
   video_url?: string;
   local_path?: string;
   fail_reason?: string;
+  failure_code?: string;
+  download_progress?: number;
+  file_exists?: boolean;
   created_at: string;
   updated_at: string;
 }
@@ -76,6 +80,16 @@ export interface TaskListResponse {
   tasks: Task[];
 }
 
+/**
+ * Task counts by status, from GET /api/tasks/counts
+ * Matches the Go TaskCounts struct
+ */
+export interface TaskCounts {
+  total: number;
+  by_status: Partial<Record<TaskStatus, number>>;
+  created_today: number;
+}
+
 /**
  * Response after deleting a task
  * Matches the Go DeleteTaskResponse struct
@@ -98,7 +112,7 @@ export interface ErrorResponse {
 // ============================================
 
 // Character source type options
-export type CharacterSourceType = 'task' | 'url';
+export type CharacterSourceType = 'task' | 'url' | 'upload';
 
 // Character training status options
 export type CharacterStatus = 'pending' | 'processing' | 'completed' | 'failed';
@@ -122,7 +136,9 @@ export interface Character {
   status: CharacterStatus;
   progress: number;
   fail_reason?: string;
+  failure_code?: string;
   created_at: string;
+  usage_count?: number; // 提示词引用该角色的待处理/处理中任务数
 }
 
 /**
@@ -167,4 +183,5 @@ export interface CharacterStatusResponse {
   status: CharacterStatus;
   progress: number;
   fail_reason?: string;
+  failure_code?: string;
 }